@@ -0,0 +1,285 @@
+// Package client is the Go client of events-db. It appends, reads and
+// subscribes to events over gRPC or REST, and provides an in-memory fake
+// for testing consumers without a server.
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ErrNotFound is returned by Get for events that don't exist
+var ErrNotFound = errors.New("event not found")
+
+// Client appends events to and reads events from events-db
+type Client interface {
+	// Append stores a new event and returns its ID
+	Append(ctx context.Context, event NewEvent) (int64, error)
+	// Get returns an event by its ID, ErrNotFound if it doesn't exist
+	Get(ctx context.Context, id int64, upcast bool) (*Event, error)
+	// Query returns a page of the events matching a query in ID order
+	Query(ctx context.Context, query Query) (*Page, error)
+	// Subscribe delivers the stored events matching a subscription and then
+	// the new ones as they are created, until ctx is done or handler returns
+	// an error, which Subscribe returns. Broken connections are reopened,
+	// resuming after the last event handler accepted.
+	Subscribe(ctx context.Context, subscription Subscription, handler func(*Event) error) error
+	// Close releases the connection
+	Close() error
+}
+
+// Event is a stored event
+type Event struct {
+	ID      int64
+	Source  string
+	Type    string
+	Subject string
+	// Time is when the server stored the event, with microsecond precision
+	Time time.Time
+	// OccurredAt is when the event occurred according to its producer, the
+	// zero time if it gave none
+	OccurredAt time.Time
+	Data       []byte
+	// DataContentType is the content type of Data given when the event was created
+	DataContentType string
+	// DataSchema is the URI of the schema version the payload matches
+	DataSchema  string
+	TraceParent string
+	TraceState  string
+	// DataKey is the key the payload is encrypted with in storage
+	DataKey string
+	// Redacted is set when the data key was erased, Data is empty then
+	Redacted bool
+	// Blob is set when the payload is stored as a blob, Data is empty then
+	Blob *Blob
+	// Metadata holds the producer attributes and the ones the server recorded
+	Metadata      map[string]string
+	CorrelationID string
+	CausationID   string
+	// TombstoneID is set when an administrator removed the event, Data and
+	// Metadata are empty then. TombstonedAt is when.
+	TombstoneID  int64
+	TombstonedAt time.Time
+}
+
+// Blob references a payload stored in the blob store
+type Blob struct {
+	SHA256 string
+	Size   int64
+}
+
+// NewEvent is an event to append
+type NewEvent struct {
+	Source  string
+	Type    string
+	Subject string
+	Data    []byte
+	// DataContentType is validated against the schema of Type for JSON types
+	DataContentType string
+	// SchemaVersion pins the schema version to validate against, 0 for the latest
+	SchemaVersion int32
+	// DataKey is the key to encrypt the payload with, defaults to the subject
+	DataKey string
+	// BlobSHA256 references an uploaded blob holding the payload, instead of Data
+	BlobSHA256 string
+	// Metadata holds attributes like the actor, keys starting with eventsdb.
+	// are reserved for the server
+	Metadata map[string]string
+	// CorrelationID defaults to the one of the event named by CausationID
+	CorrelationID string
+	CausationID   string
+	// OccurredAt is when the event occurred, the zero time to leave it unset
+	OccurredAt time.Time
+}
+
+// Query selects stored events across all subjects
+type Query struct {
+	// After returns the events after this ID
+	After int64
+	// Limit is the maximum number of events, 0 for the server default
+	Limit int32
+	// TypePattern and SourcePattern are regular expressions events must match
+	TypePattern   string
+	SourcePattern string
+	// Upcast transforms payloads into the latest schema version of their type
+	Upcast bool
+	// CorrelationID returns the events sharing this correlation ID, it can't
+	// be combined with the patterns
+	CorrelationID string
+}
+
+// validate checks that the query only combines supported filters
+func (q Query) validate() error {
+	if q.CorrelationID != "" && (q.TypePattern != "" || q.SourcePattern != "") {
+		return errors.New("type and source patterns can't be combined with a correlation ID")
+	}
+	return nil
+}
+
+// Page is a page of query results
+type Page struct {
+	Events []*Event
+	// Position is the ID to continue the query after
+	Position int64
+	// End is set when there were no more stored events
+	End bool
+}
+
+// Subscription selects the events to deliver. Without a subject every event
+// is delivered, optionally restricted by TypePattern and SourcePattern.
+type Subscription struct {
+	Subject string
+	// Prefix matches every subject starting with Subject
+	Prefix bool
+	// Types restricts a subject subscription to events of these types
+	Types []string
+	// TypePattern and SourcePattern restrict a subscription without subject
+	TypePattern   string
+	SourcePattern string
+	// After delivers the events after this ID, 0 for every stored event
+	After int64
+	// Upcast transforms payloads into the latest schema version of their type
+	Upcast bool
+}
+
+// validate checks that the subscription only combines supported filters
+func (s Subscription) validate() error {
+	if s.Subject == "" && (s.Prefix || len(s.Types) > 0) {
+		return errors.New("prefix and types require a subject, use TypePattern instead")
+	}
+	if s.Subject != "" && (s.TypePattern != "" || s.SourcePattern != "") {
+		return errors.New("type and source patterns can't be combined with a subject, use Types instead")
+	}
+	return nil
+}
+
+// Option configures a client
+type Option func(*options)
+
+type options struct {
+	token      string
+	tenant     string
+	tls        *tls.Config
+	httpClient *http.Client
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 10 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithToken authenticates requests with a token
+func WithToken(token string) Option {
+	return func(o *options) {
+		o.token = token
+	}
+}
+
+// WithTenant selects the tenant of requests, for tokens valid for several tenants
+func WithTenant(tenant string) Option {
+	return func(o *options) {
+		o.tenant = tenant
+	}
+}
+
+// WithTLS connects with TLS, a nil config uses the system's root certificates.
+// REST clients use TLS for https URLs regardless.
+func WithTLS(config *tls.Config) Option {
+	return func(o *options) {
+		if config == nil {
+			config = &tls.Config{}
+		}
+		o.tls = config
+	}
+}
+
+// WithHTTPClient sets the HTTP client of REST clients, it must not time out streams
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithBackoff sets the delays between reconnect attempts of subscriptions,
+// doubling from min up to max. Defaults to 100ms and 10s.
+func WithBackoff(min time.Duration, max time.Duration) Option {
+	return func(o *options) {
+		o.minBackoff = min
+		o.maxBackoff = max
+	}
+}
+
+// stream opens a subscription delivering events after the given ID. It
+// returns when the connection breaks or deliver fails.
+type stream func(ctx context.Context, subscription Subscription, deliver func(*Event) error) error
+
+// handlerError marks errors returned by the subscription handler
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string {
+	return e.err.Error()
+}
+
+// subscribe runs a subscription over open, reopening it after retryable
+// failures with exponential backoff. Events up to the last one delivered are
+// skipped after reconnecting, so none is delivered twice.
+func subscribe(ctx context.Context, o *options, subscription Subscription, handler func(*Event) error, open stream, retryable func(error) bool) error {
+	if err := subscription.validate(); err != nil {
+		return err
+	}
+
+	last := subscription.After
+	backoff := o.minBackoff
+	for {
+		subscription.After = last
+		err := open(ctx, subscription, func(event *Event) error {
+			if event.ID <= last {
+				return nil
+			}
+			if err := handler(event); err != nil {
+				return &handlerError{err: err}
+			}
+			last = event.ID
+			backoff = o.minBackoff
+			return nil
+		})
+
+		var handlerErr *handlerError
+		switch {
+		case errors.As(err, &handlerErr):
+			return handlerErr.err
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err != nil && !retryable(err):
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, o.maxBackoff)
+	}
+}
+
+// parseTime parses the time of an event, the zero time if it is invalid
+func parseTime(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, value)
+	return t
+}
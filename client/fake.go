@@ -0,0 +1,265 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultQueryLimit is the page size of Fake queries without a limit
+const defaultQueryLimit = 100
+
+// ErrClosed is returned by a Fake after Close
+var ErrClosed = errors.New("client is closed")
+
+// Fake is an in-memory Client for testing consumers. It stores events in
+// the order they are appended and delivers them to subscriptions like the
+// server does, without schema validation, encryption or upcasting. Of the
+// metadata the server records only eventsdb.received_at is set.
+type Fake struct {
+	mutex  sync.Mutex
+	events []*Event
+	// changed is closed and replaced whenever an event is appended
+	changed chan struct{}
+	closed  bool
+	now     func() time.Time
+}
+
+var _ Client = (*Fake)(nil)
+
+// NewFake returns an empty in-memory client
+func NewFake() *Fake {
+	return &Fake{
+		changed: make(chan struct{}),
+		now:     time.Now,
+	}
+}
+
+func (f *Fake) Append(ctx context.Context, event NewEvent) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.closed {
+		return 0, ErrClosed
+	}
+	if event.Source == "" || event.Type == "" || event.Subject == "" {
+		return 0, errors.New("source, type and subject are required")
+	}
+	for key := range event.Metadata {
+		if strings.HasPrefix(key, "eventsdb.") {
+			return 0, errors.New("metadata keys starting with eventsdb. are reserved")
+		}
+	}
+
+	now := f.now().UTC().Truncate(time.Microsecond)
+	metadata := maps.Clone(event.Metadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata["eventsdb.received_at"] = now.Format(time.RFC3339Nano)
+
+	correlationID := event.CorrelationID
+	if correlationID == "" && event.CausationID != "" {
+		correlationID = event.CausationID
+		if id, err := strconv.ParseInt(event.CausationID, 10, 64); err == nil && id >= 1 && id <= int64(len(f.events)) && f.events[id-1].CorrelationID != "" {
+			correlationID = f.events[id-1].CorrelationID
+		}
+	}
+
+	stored := &Event{
+		ID:              int64(len(f.events)) + 1,
+		Source:          event.Source,
+		Type:            event.Type,
+		Subject:         event.Subject,
+		Time:            now,
+		OccurredAt:      event.OccurredAt.UTC().Truncate(time.Microsecond),
+		Data:            slices.Clone(event.Data),
+		DataContentType: event.DataContentType,
+		DataKey:         event.DataKey,
+		Metadata:        metadata,
+		CorrelationID:   correlationID,
+		CausationID:     event.CausationID,
+	}
+	if event.BlobSHA256 != "" {
+		stored.Blob = &Blob{SHA256: event.BlobSHA256}
+	}
+	f.events = append(f.events, stored)
+
+	close(f.changed)
+	f.changed = make(chan struct{})
+
+	return stored.ID, nil
+}
+
+func (f *Fake) Get(ctx context.Context, id int64, upcast bool) (*Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if id < 1 || id > int64(len(f.events)) {
+		return nil, ErrNotFound
+	}
+	return copyEvent(f.events[id-1]), nil
+}
+
+func (f *Fake) Query(ctx context.Context, query Query) (*Page, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+	match, err := compileMatch(Subscription{TypePattern: query.TypePattern, SourcePattern: query.SourcePattern})
+	if err != nil {
+		return nil, err
+	}
+	limit := int(query.Limit)
+	if limit <= 0 {
+		limit = defaultQueryLimit
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	page := &Page{Position: query.After, End: true}
+	for _, event := range f.events[min(max(query.After, 0), int64(len(f.events))):] {
+		if len(page.Events) == limit {
+			page.End = false
+			break
+		}
+		page.Position = event.ID
+		if match(event) && (query.CorrelationID == "" || event.CorrelationID == query.CorrelationID) {
+			page.Events = append(page.Events, copyEvent(event))
+		}
+	}
+	return page, nil
+}
+
+func (f *Fake) Subscribe(ctx context.Context, subscription Subscription, handler func(*Event) error) error {
+	if err := subscription.validate(); err != nil {
+		return err
+	}
+	match, err := compileMatch(subscription)
+	if err != nil {
+		return err
+	}
+
+	position := max(subscription.After, 0)
+	for {
+		f.mutex.Lock()
+		if f.closed {
+			f.mutex.Unlock()
+			return ErrClosed
+		}
+		pending := f.events[min(position, int64(len(f.events))):]
+		changed := f.changed
+		f.mutex.Unlock()
+
+		for _, event := range pending {
+			position = event.ID
+			if !match(event) {
+				continue
+			}
+			if err := handler(copyEvent(event)); err != nil {
+				return err
+			}
+		}
+
+		if len(pending) == 0 {
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// Close makes pending and future calls fail with ErrClosed
+func (f *Fake) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.closed {
+		f.closed = true
+		close(f.changed)
+	}
+	return nil
+}
+
+// Events returns every appended event, for assertions in tests
+func (f *Fake) Events() []*Event {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	events := make([]*Event, 0, len(f.events))
+	for _, event := range f.events {
+		events = append(events, copyEvent(event))
+	}
+	return events
+}
+
+// SetClock replaces the clock setting the time of appended events
+func (f *Fake) SetClock(now func() time.Time) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.now = now
+}
+
+// compileMatch returns a predicate for the events selected by a subscription
+func compileMatch(subscription Subscription) (func(*Event) bool, error) {
+	var typePattern, sourcePattern *regexp.Regexp
+	var err error
+	if subscription.TypePattern != "" {
+		if typePattern, err = regexp.Compile(subscription.TypePattern); err != nil {
+			return nil, err
+		}
+	}
+	if subscription.SourcePattern != "" {
+		if sourcePattern, err = regexp.Compile(subscription.SourcePattern); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(event *Event) bool {
+		switch {
+		case subscription.Subject != "" && subscription.Prefix && !strings.HasPrefix(event.Subject, subscription.Subject):
+			return false
+		case subscription.Subject != "" && !subscription.Prefix && event.Subject != subscription.Subject:
+			return false
+		case len(subscription.Types) > 0 && !slices.Contains(subscription.Types, event.Type):
+			return false
+		case typePattern != nil && !typePattern.MatchString(event.Type):
+			return false
+		case sourcePattern != nil && !sourcePattern.MatchString(event.Source):
+			return false
+		}
+		return true
+	}, nil
+}
+
+// copyEvent copies an event, so callers can't change the stored one
+func copyEvent(event *Event) *Event {
+	copied := *event
+	copied.Data = slices.Clone(event.Data)
+	copied.Metadata = maps.Clone(event.Metadata)
+	if event.Blob != nil {
+		blob := *event.Blob
+		copied.Blob = &blob
+	}
+	return &copied
+}
@@ -0,0 +1,278 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	pb "github.com/idot-digital/events-db/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// subscriptionFilterID identifies the single filter of a subject subscription
+const subscriptionFilterID = "client"
+
+type grpcClient struct {
+	conn    *grpc.ClientConn
+	service pb.EventsDBClient
+	options *options
+}
+
+// NewGRPC returns a client connecting to the gRPC API at target, for
+// example localhost:50051. The connection is established lazily.
+func NewGRPC(target string, opts ...Option) (Client, error) {
+	o := newOptions(opts)
+
+	creds := insecure.NewCredentials()
+	if o.tls != nil {
+		creds = credentials.NewTLS(o.tls)
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcClient{
+		conn:    conn,
+		service: pb.NewEventsDBClient(conn),
+		options: o,
+	}, nil
+}
+
+func (c *grpcClient) Append(ctx context.Context, event NewEvent) (int64, error) {
+	reply, err := c.service.CreateEvent(c.outgoing(ctx), &pb.CreateEventRequest{
+		Source:          event.Source,
+		Type:            event.Type,
+		Subject:         event.Subject,
+		Data:            event.Data,
+		DataKey:         event.DataKey,
+		BlobSha256:      event.BlobSHA256,
+		Datacontenttype: event.DataContentType,
+		SchemaVersion:   event.SchemaVersion,
+		Metadata:        event.Metadata,
+		CorrelationId:   event.CorrelationID,
+		CausationId:     event.CausationID,
+		OccurredAt:      pbTime(event.OccurredAt),
+	})
+	if err != nil {
+		return 0, err
+	}
+	return reply.Id, nil
+}
+
+func (c *grpcClient) Get(ctx context.Context, id int64, upcast bool) (*Event, error) {
+	event, err := c.service.GetEventByID(c.outgoing(ctx), &pb.GetEventByIDRequest{Id: id, Upcast: upcast})
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromPB(event), nil
+}
+
+func (c *grpcClient) Query(ctx context.Context, query Query) (*Page, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+	if query.CorrelationID != "" {
+		return c.correlated(ctx, query)
+	}
+
+	req := &pb.ReadAllRequest{
+		FromPosition: query.After,
+		Upcast:       query.Upcast,
+	}
+	if query.Limit > 0 {
+		req.Limit = &query.Limit
+	}
+	if query.TypePattern != "" {
+		req.TypePattern = &query.TypePattern
+	}
+	if query.SourcePattern != "" {
+		req.SourcePattern = &query.SourcePattern
+	}
+
+	reply, err := c.service.ReadAll(c.outgoing(ctx), req)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Position: reply.Position, End: reply.End}
+	for _, event := range reply.Events {
+		page.Events = append(page.Events, fromPB(event))
+	}
+	return page, nil
+}
+
+// correlated reads a page of the events sharing a correlation ID
+func (c *grpcClient) correlated(ctx context.Context, query Query) (*Page, error) {
+	req := &pb.ReadCorrelatedRequest{
+		CorrelationId: query.CorrelationID,
+		FromPosition:  query.After,
+		Upcast:        query.Upcast,
+	}
+	if query.Limit > 0 {
+		req.Limit = &query.Limit
+	}
+
+	reply, err := c.service.ReadCorrelated(c.outgoing(ctx), req)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Position: reply.Position, End: reply.End}
+	for _, event := range reply.Events {
+		page.Events = append(page.Events, fromPB(event))
+	}
+	return page, nil
+}
+
+func (c *grpcClient) Subscribe(ctx context.Context, subscription Subscription, handler func(*Event) error) error {
+	return subscribe(ctx, c.options, subscription, handler, c.stream, retryableGRPC)
+}
+
+func (c *grpcClient) Close() error {
+	return c.conn.Close()
+}
+
+// stream opens a Subscribe stream for subject subscriptions and a
+// SubscribeAll stream otherwise
+func (c *grpcClient) stream(ctx context.Context, subscription Subscription, deliver func(*Event) error) error {
+	ctx, cancel := context.WithCancel(c.outgoing(ctx))
+	defer cancel()
+
+	var recv func() ([]*pb.Event, error)
+	if subscription.Subject != "" {
+		stream, err := c.service.Subscribe(ctx)
+		if err != nil {
+			return err
+		}
+		err = stream.Send(&pb.SubscribeRequest{
+			Add: []*pb.SubjectFilter{{
+				Id:      subscriptionFilterID,
+				Subject: subscription.Subject,
+				Prefix:  subscription.Prefix,
+				Types:   subscription.Types,
+				FromId:  &subscription.After,
+			}},
+			Upcast: subscription.Upcast,
+		})
+		if err != nil {
+			return err
+		}
+		if err := stream.CloseSend(); err != nil {
+			return err
+		}
+		recv = func() ([]*pb.Event, error) {
+			reply, err := stream.Recv()
+			return reply.GetEvents(), err
+		}
+	} else {
+		req := &pb.SubscribeAllRequest{
+			FromPosition: subscription.After,
+			Upcast:       subscription.Upcast,
+		}
+		if subscription.TypePattern != "" {
+			req.TypePattern = &subscription.TypePattern
+		}
+		if subscription.SourcePattern != "" {
+			req.SourcePattern = &subscription.SourcePattern
+		}
+		stream, err := c.service.SubscribeAll(ctx, req)
+		if err != nil {
+			return err
+		}
+		recv = func() ([]*pb.Event, error) {
+			reply, err := stream.Recv()
+			return reply.GetEvents(), err
+		}
+	}
+
+	for {
+		events, err := recv()
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := deliver(fromPB(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// outgoing adds the token and tenant to the metadata of a call
+func (c *grpcClient) outgoing(ctx context.Context) context.Context {
+	if c.options.token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "authorization", c.options.token)
+	}
+	if c.options.tenant != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-tenant", c.options.tenant)
+	}
+	return ctx
+}
+
+// retryableGRPC reports whether a subscription failed for a transient reason
+func retryableGRPC(err error) bool {
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.Internal, codes.Unknown, codes.DeadlineExceeded:
+		return true
+	default:
+		return false
+	}
+}
+
+// pbTime converts a time to send, nil for the zero time
+func pbTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// fromPB converts an event received over gRPC
+func fromPB(event *pb.Event) *Event {
+	converted := &Event{
+		ID:              event.Id,
+		Source:          event.Source,
+		Type:            event.Type,
+		Subject:         event.Subject,
+		Time:            event.Timestamp.AsTime(),
+		Data:            event.Data,
+		DataContentType: event.Datacontenttype,
+		DataSchema:      event.Dataschema,
+		TraceParent:     event.Traceparent,
+		TraceState:      event.Tracestate,
+		DataKey:         event.DataKey,
+		Redacted:        event.Redacted,
+		Metadata:        event.Metadata,
+		CorrelationID:   event.CorrelationId,
+		CausationID:     event.CausationId,
+		TombstoneID:     event.TombstoneId,
+	}
+	if event.Timestamp == nil {
+		// Servers before microsecond precision only send the RFC 3339 time
+		converted.Time = parseTime(event.Time)
+	}
+	if event.OccurredAt != nil {
+		converted.OccurredAt = event.OccurredAt.AsTime()
+	}
+	if event.TombstonedAt != nil {
+		converted.TombstonedAt = event.TombstonedAt.AsTime()
+	}
+	if event.Blob != nil {
+		converted.Blob = &Blob{SHA256: event.Blob.Sha256, Size: event.Blob.Size}
+	}
+	return converted
+}
@@ -0,0 +1,343 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxLineBytes bounds the lines of event streams, which hold a whole event
+const maxLineBytes = 16 << 20
+
+// HTTPError is returned for requests the REST API rejected
+type HTTPError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("events-db: %d %s", e.StatusCode, e.Message)
+}
+
+type restClient struct {
+	baseURL *url.URL
+	http    *http.Client
+	options *options
+}
+
+// restEvent is an event in the JSON representation of the REST API
+type restEvent struct {
+	ID              int64  `json:"id"`
+	Source          string `json:"source"`
+	Type            string `json:"type"`
+	Subject         string `json:"subject"`
+	Time            string `json:"time"`
+	OccurredAt      string `json:"occurred_at"`
+	Data            []byte `json:"data"`
+	DataContentType string `json:"datacontenttype"`
+	DataSchema      string `json:"dataschema"`
+	TraceParent     string `json:"traceparent"`
+	TraceState      string `json:"tracestate"`
+	DataKey         string `json:"data_key"`
+	Redacted        bool   `json:"redacted"`
+	Blob            *struct {
+		SHA256 string `json:"sha256"`
+		Size   int64  `json:"size"`
+	} `json:"blob"`
+	Metadata      map[string]string `json:"metadata"`
+	CorrelationID string            `json:"correlation_id"`
+	CausationID   string            `json:"causation_id"`
+	TombstoneID   int64             `json:"tombstone_id"`
+	TombstonedAt  string            `json:"tombstoned_at"`
+}
+
+// NewREST returns a client of the REST API at baseURL, for example
+// http://localhost:8080
+func NewREST(baseURL string, opts ...Option) (Client, error) {
+	o := newOptions(opts)
+
+	parsed, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported scheme %q, must be http or https", parsed.Scheme)
+	}
+
+	httpClient := o.httpClient
+	if httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = o.tls
+		httpClient = &http.Client{Transport: transport}
+	}
+
+	return &restClient{
+		baseURL: parsed,
+		http:    httpClient,
+		options: o,
+	}, nil
+}
+
+func (c *restClient) Append(ctx context.Context, event NewEvent) (int64, error) {
+	request := map[string]any{
+		"source":          event.Source,
+		"type":            event.Type,
+		"subject":         event.Subject,
+		"data":            event.Data,
+		"datacontenttype": event.DataContentType,
+		"schema_version":  event.SchemaVersion,
+		"data_key":        event.DataKey,
+		"blob_sha256":     event.BlobSHA256,
+		"metadata":        event.Metadata,
+		"correlation_id":  event.CorrelationID,
+		"causation_id":    event.CausationID,
+	}
+	if !event.OccurredAt.IsZero() {
+		request["occurred_at"] = event.OccurredAt.Format(time.RFC3339Nano)
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+
+	var reply struct {
+		ID int64 `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/events", nil, body, &reply); err != nil {
+		return 0, err
+	}
+	return reply.ID, nil
+}
+
+func (c *restClient) Get(ctx context.Context, id int64, upcast bool) (*Event, error) {
+	query := url.Values{"id": {strconv.FormatInt(id, 10)}}
+	if upcast {
+		query.Set("upcast", "true")
+	}
+
+	var event restEvent
+	err := c.do(ctx, http.MethodGet, "/events/get", query, nil, &event)
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return event.convert(), nil
+}
+
+func (c *restClient) Query(ctx context.Context, query Query) (*Page, error) {
+	if err := query.validate(); err != nil {
+		return nil, err
+	}
+
+	path := "/events/all"
+	values := url.Values{"from": {strconv.FormatInt(query.After, 10)}}
+	if query.CorrelationID != "" {
+		path = "/events/correlated"
+		values.Set("correlation_id", query.CorrelationID)
+	}
+	if query.Limit > 0 {
+		values.Set("limit", strconv.Itoa(int(query.Limit)))
+	}
+	if query.TypePattern != "" {
+		values.Set("type", query.TypePattern)
+	}
+	if query.SourcePattern != "" {
+		values.Set("source", query.SourcePattern)
+	}
+	if query.Upcast {
+		values.Set("upcast", "true")
+	}
+
+	var reply struct {
+		Events   []restEvent `json:"events"`
+		Position int64       `json:"position"`
+		End      bool        `json:"end"`
+	}
+	if err := c.do(ctx, http.MethodGet, path, values, nil, &reply); err != nil {
+		return nil, err
+	}
+
+	page := &Page{Position: reply.Position, End: reply.End}
+	for _, event := range reply.Events {
+		page.Events = append(page.Events, event.convert())
+	}
+	return page, nil
+}
+
+func (c *restClient) Subscribe(ctx context.Context, subscription Subscription, handler func(*Event) error) error {
+	return subscribe(ctx, c.options, subscription, handler, c.stream, retryableREST)
+}
+
+func (c *restClient) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+// stream reads the Server-Sent Events of /events/stream for subject
+// subscriptions and of /events/all/stream otherwise
+func (c *restClient) stream(ctx context.Context, subscription Subscription, deliver func(*Event) error) error {
+	path := "/events/all/stream"
+	query := url.Values{"from": {strconv.FormatInt(subscription.After, 10)}}
+	if subscription.Subject != "" {
+		path = "/events/stream"
+		query = url.Values{
+			"subject": {subscription.Subject},
+			"from_id": {strconv.FormatInt(subscription.After, 10)},
+		}
+		if subscription.Prefix {
+			query.Set("prefix", "true")
+		}
+	} else {
+		if subscription.TypePattern != "" {
+			query.Set("type", subscription.TypePattern)
+		}
+		if subscription.SourcePattern != "" {
+			query.Set("source", subscription.SourcePattern)
+		}
+	}
+	if subscription.Upcast {
+		query.Set("upcast", "true")
+	}
+
+	req, err := c.request(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return readError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+
+		var event restEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			return err
+		}
+		// The subject stream doesn't filter by type
+		if len(subscription.Types) > 0 && !slices.Contains(subscription.Types, event.Type) {
+			continue
+		}
+		if err := deliver(event.convert()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// do sends a request and decodes the JSON response into reply
+func (c *restClient) do(ctx context.Context, method string, path string, query url.Values, body []byte, reply any) error {
+	req, err := c.request(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return readError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// request builds an authenticated request
+func (c *restClient) request(ctx context.Context, method string, path string, query url.Values, body []byte) (*http.Request, error) {
+	target := *c.baseURL
+	target.Path += path
+	target.RawQuery = query.Encode()
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.options.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.options.token)
+	}
+	if c.options.tenant != "" {
+		req.Header.Set("X-Tenant", c.options.tenant)
+	}
+	return req, nil
+}
+
+// readError converts an unsuccessful response into an HTTPError
+func readError(resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return &HTTPError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+}
+
+// retryableREST reports whether a subscription failed for a transient reason
+func retryableREST(err error) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= 500
+	}
+	// Connection failures and streams ended by the server
+	return true
+}
+
+func (e *restEvent) convert() *Event {
+	event := &Event{
+		ID:              e.ID,
+		Source:          e.Source,
+		Type:            e.Type,
+		Subject:         e.Subject,
+		Time:            parseTime(e.Time),
+		OccurredAt:      parseTime(e.OccurredAt),
+		Data:            e.Data,
+		DataContentType: e.DataContentType,
+		DataSchema:      e.DataSchema,
+		TraceParent:     e.TraceParent,
+		TraceState:      e.TraceState,
+		DataKey:         e.DataKey,
+		Redacted:        e.Redacted,
+		Metadata:        e.Metadata,
+		CorrelationID:   e.CorrelationID,
+		CausationID:     e.CausationID,
+		TombstoneID:     e.TombstoneID,
+		TombstonedAt:    parseTime(e.TombstonedAt),
+	}
+	if e.Blob != nil {
+		event.Blob = &Blob{SHA256: e.Blob.SHA256, Size: e.Blob.Size}
+	}
+	return event
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/retention"
+)
+
+// listSubjects prints the subjects of the tenant
+func listSubjects(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("subjects", flag.ContinueOnError)
+	if err := parse(flags, args, ""); err != nil {
+		return err
+	}
+
+	var subjects []string
+	if err := c.do(ctx, http.MethodGet, "/subjects", nil, nil, &subjects); err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.json(subjects)
+	}
+
+	rows := make([][]string, 0, len(subjects))
+	for _, subject := range subjects {
+		rows = append(rows, []string{subject})
+	}
+	return c.table([]string{"SUBJECT"}, rows)
+}
+
+// showStreams prints the live streams of every tenant grouped by the first
+// segment of their subject, with how far they lag behind the newest event
+func showStreams(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("streams", flag.ContinueOnError)
+	if err := parse(flags, args, ""); err != nil {
+		return err
+	}
+
+	var streams models.StreamStats
+	if err := c.do(ctx, http.MethodGet, "/admin/streams", nil, nil, &streams); err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.json(streams)
+	}
+
+	fmt.Fprintf(c.stdout, "Head event: %d, emitter backlog: %d\n\n", streams.HeadID, streams.Backlog)
+	rows := make([][]string, 0, len(streams.Groups))
+	for _, group := range streams.Groups {
+		rows = append(rows, []string{
+			group.Tenant,
+			group.Group,
+			strconv.Itoa(group.Clients),
+			strconv.FormatInt(group.MaxLag, 10),
+			fmt.Sprintf("%.0f%%", group.BufferUsage*100),
+		})
+	}
+	return c.table([]string{"TENANT", "GROUP", "CLIENTS", "MAX LAG", "BUFFER"}, rows)
+}
+
+// showRetention prints how many events every retention rule would delete,
+// without deleting them
+func showRetention(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("retention", flag.ContinueOnError)
+	if err := parse(flags, args, ""); err != nil {
+		return err
+	}
+
+	var results []retention.Result
+	if err := c.do(ctx, http.MethodGet, "/admin/retention", nil, nil, &results); err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.json(results)
+	}
+
+	rows := make([][]string, 0, len(results))
+	for _, result := range results {
+		rows = append(rows, []string{result.Rule, strconv.FormatInt(result.Events, 10)})
+	}
+	return c.table([]string{"RULE", "EVENTS"}, rows)
+}
+
+// manageTokens lists, creates and revokes tenant tokens
+func manageTokens(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("tokens", flag.ContinueOnError)
+	description := flags.String("description", "", "Description of a created token")
+	if err := parse(flags, args, "[flags] list <tenant> | create <tenant> | revoke <id>"); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errUsage
+	}
+
+	switch action, arg := flags.Arg(0), flags.Arg(1); action {
+	case "list":
+		var tokens []models.TenantToken
+		if err := c.do(ctx, http.MethodGet, "/admin/tokens", url.Values{"tenant": {arg}}, nil, &tokens); err != nil {
+			return err
+		}
+		if c.output == "json" {
+			return c.json(tokens)
+		}
+
+		rows := make([][]string, 0, len(tokens))
+		for _, token := range tokens {
+			rows = append(rows, []string{strconv.FormatInt(token.ID, 10), token.Tenant, token.Description, token.CreatedAt})
+		}
+		return c.table([]string{"ID", "TENANT", "DESCRIPTION", "CREATED"}, rows)
+
+	case "create":
+		var created models.CreateTenantTokenResponse
+		req := models.CreateTenantTokenRequest{Tenant: arg, Description: *description}
+		if err := c.do(ctx, http.MethodPost, "/admin/tokens", nil, req, &created); err != nil {
+			return err
+		}
+		if c.output == "json" {
+			return c.json(created)
+		}
+		return c.table([]string{"ID", "TENANT", "TOKEN"}, [][]string{{strconv.FormatInt(created.ID, 10), created.Tenant, created.Token}})
+
+	case "revoke":
+		if _, err := strconv.ParseInt(arg, 10, 64); err != nil {
+			return fmt.Errorf("invalid token ID %q", arg)
+		}
+		if err := c.do(ctx, http.MethodDelete, "/admin/tokens", url.Values{"id": {arg}}, nil, nil); err != nil {
+			return err
+		}
+		if c.output == "json" {
+			return c.json(map[string]string{"revoked": arg})
+		}
+		fmt.Fprintf(c.stdout, "Revoked token %s\n", arg)
+		return nil
+
+	default:
+		flags.Usage()
+		return errUsage
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/idot-digital/events-db/client"
+)
+
+// maxDataColumn bounds the payloads shown in tables
+const maxDataColumn = 60
+
+// event is the JSON form of events read and appended by the CLI. JSON
+// payloads are embedded as they are, others are base64 encoded, so the
+// output of tail and get can be appended again.
+type event struct {
+	ID              int64             `json:"id,omitempty"`
+	Source          string            `json:"source"`
+	Type            string            `json:"type"`
+	Subject         string            `json:"subject"`
+	Time            string            `json:"time,omitempty"`
+	OccurredAt      string            `json:"occurred_at,omitempty"`
+	Data            json.RawMessage   `json:"data,omitempty"`
+	DataBase64      []byte            `json:"data_base64,omitempty"`
+	DataContentType string            `json:"datacontenttype,omitempty"`
+	DataSchema      string            `json:"dataschema,omitempty"`
+	SchemaVersion   int32             `json:"schema_version,omitempty"`
+	DataKey         string            `json:"data_key,omitempty"`
+	Redacted        bool              `json:"redacted,omitempty"`
+	BlobSHA256      string            `json:"blob_sha256,omitempty"`
+	BlobSize        int64             `json:"blob_size,omitempty"`
+	CorrelationID   string            `json:"correlation_id,omitempty"`
+	CausationID     string            `json:"causation_id,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	TombstoneID     int64             `json:"tombstone_id,omitempty"`
+	TombstonedAt    string            `json:"tombstoned_at,omitempty"`
+}
+
+func fromClient(e *client.Event) event {
+	converted := event{
+		ID:              e.ID,
+		Source:          e.Source,
+		Type:            e.Type,
+		Subject:         e.Subject,
+		Time:            e.Time.Format(time.RFC3339Nano),
+		DataContentType: e.DataContentType,
+		DataSchema:      e.DataSchema,
+		DataKey:         e.DataKey,
+		Redacted:        e.Redacted,
+		CorrelationID:   e.CorrelationID,
+		CausationID:     e.CausationID,
+		Metadata:        e.Metadata,
+		TombstoneID:     e.TombstoneID,
+	}
+	if !e.OccurredAt.IsZero() {
+		converted.OccurredAt = e.OccurredAt.Format(time.RFC3339Nano)
+	}
+	if !e.TombstonedAt.IsZero() {
+		converted.TombstonedAt = e.TombstonedAt.Format(time.RFC3339Nano)
+	}
+	if json.Valid(e.Data) {
+		converted.Data = e.Data
+	} else {
+		converted.DataBase64 = e.Data
+	}
+	if e.Blob != nil {
+		converted.BlobSHA256 = e.Blob.SHA256
+		converted.BlobSize = e.Blob.Size
+	}
+	return converted
+}
+
+// summary describes the payload of an event for a table column, JSON
+// payloads longer than limit runes are shortened unless limit is 0
+func (e event) summary(limit int) string {
+	switch {
+	case e.TombstoneID != 0:
+		return "<tombstoned>"
+	case e.Redacted:
+		return "<redacted>"
+	case e.BlobSHA256 != "":
+		return fmt.Sprintf("<blob %s, %d bytes>", e.BlobSHA256, e.BlobSize)
+	case len(e.DataBase64) > 0:
+		return fmt.Sprintf("<%d bytes>", len(e.DataBase64))
+	}
+
+	data := strings.Join(strings.Fields(string(e.Data)), " ")
+	if limit > 0 && utf8.RuneCountInString(data) > limit {
+		data = string([]rune(data)[:limit-3]) + "..."
+	}
+	return data
+}
+
+// appendEvents appends the events read from files or stdin. Without -type the
+// input is a sequence of JSON events, otherwise every input is the payload of
+// one event.
+func appendEvents(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("append", flag.ContinueOnError)
+	source := flags.String("source", "", "Source of the events, when every input is one payload")
+	eventType := flags.String("type", "", "Type of the events, when every input is one payload")
+	subject := flags.String("subject", "", "Subject of the events, when every input is one payload")
+	contentType := flags.String("content-type", "", "Content type of the payloads, when every input is one payload")
+	correlationID := flags.String("correlation-id", "", "Correlation ID of the events, when every input is one payload")
+	causationID := flags.String("causation-id", "", "Causation ID of the events, when every input is one payload")
+	if err := parse(flags, args, "[flags] [file ...]\n\nReads stdin without files or for -. Without -type every input holds JSON\nevents with source, type, subject and a data value or data_base64 string.\nMetadata recorded by the server (eventsdb.*) is recorded anew."); err != nil {
+		return err
+	}
+	raw := *eventType != ""
+	if raw && (*source == "" || *subject == "") {
+		return errors.New("-type requires -source and -subject")
+	}
+
+	events, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	var appended []event
+	appendEvent := func(e event) error {
+		data := []byte(e.Data)
+		if e.DataBase64 != nil {
+			data = e.DataBase64
+		}
+		var occurredAt time.Time
+		if e.OccurredAt != "" {
+			var err error
+			if occurredAt, err = time.Parse(time.RFC3339Nano, e.OccurredAt); err != nil {
+				return fmt.Errorf("invalid occurred_at %q of %s event to %s", e.OccurredAt, e.Type, e.Subject)
+			}
+		}
+		// Events printed by tail and get carry the metadata the server recorded
+		metadata := maps.Clone(e.Metadata)
+		maps.DeleteFunc(metadata, func(key string, _ string) bool {
+			return strings.HasPrefix(key, "eventsdb.")
+		})
+		id, err := events.Append(ctx, client.NewEvent{
+			Source:          e.Source,
+			Type:            e.Type,
+			Subject:         e.Subject,
+			Data:            data,
+			DataContentType: e.DataContentType,
+			SchemaVersion:   e.SchemaVersion,
+			DataKey:         e.DataKey,
+			BlobSHA256:      e.BlobSHA256,
+			Metadata:        metadata,
+			CorrelationID:   e.CorrelationID,
+			CausationID:     e.CausationID,
+			OccurredAt:      occurredAt,
+		})
+		if err != nil {
+			return fmt.Errorf("append %s event to %s: %w", e.Type, e.Subject, err)
+		}
+		appended = append(appended, event{ID: id, Source: e.Source, Type: e.Type, Subject: e.Subject})
+		return nil
+	}
+
+	inputs := flags.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+	for _, input := range inputs {
+		err = readInput(c, input, func(r io.Reader) error {
+			if raw {
+				data, err := io.ReadAll(r)
+				if err != nil {
+					return err
+				}
+				return appendEvent(event{
+					Source:          *source,
+					Type:            *eventType,
+					Subject:         *subject,
+					DataBase64:      data,
+					DataContentType: *contentType,
+					CorrelationID:   *correlationID,
+					CausationID:     *causationID,
+				})
+			}
+
+			decoder := json.NewDecoder(r)
+			for {
+				var e event
+				err := decoder.Decode(&e)
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("%s: %w", input, err)
+				}
+				if err := appendEvent(e); err != nil {
+					return err
+				}
+			}
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	// Report the appended events even when a later one failed
+	if printErr := c.printAppended(appended); printErr != nil && err == nil {
+		err = printErr
+	}
+	return err
+}
+
+func (c *cli) printAppended(events []event) error {
+	if c.output == "json" {
+		if events == nil {
+			events = []event{}
+		}
+		return c.json(events)
+	}
+
+	rows := make([][]string, 0, len(events))
+	for _, e := range events {
+		rows = append(rows, []string{strconv.FormatInt(e.ID, 10), e.Subject, e.Type})
+	}
+	return c.table([]string{"ID", "SUBJECT", "TYPE"}, rows)
+}
+
+// readInput calls read with the content of a file, of stdin for -
+func readInput(c *cli, name string, read func(io.Reader) error) error {
+	if name == "-" {
+		return read(c.stdin)
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return read(file)
+}
+
+// tailEvents prints the events of a subject until interrupted, one JSON
+// event per line with -o json
+func tailEvents(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("tail", flag.ContinueOnError)
+	recursive := flags.Bool("recursive", false, "Include the events of every subject below the subject")
+	types := flags.String("type", "", "Comma separated event types to print")
+	from := flags.Int64("from", 0, "Print the events after this ID, 0 for the whole history")
+	upcast := flags.Bool("upcast", false, "Upcast payloads to the latest schema version")
+	if err := parse(flags, args, "[flags] <subject>"); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	subscription := client.Subscription{
+		Subject: flags.Arg(0),
+		Prefix:  *recursive,
+		After:   *from,
+		Upcast:  *upcast,
+	}
+	if *types != "" {
+		subscription.Types = strings.Split(*types, ",")
+	}
+
+	events, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	encoder := json.NewEncoder(c.stdout)
+	err = events.Subscribe(ctx, subscription, func(e *client.Event) error {
+		converted := fromClient(e)
+		if c.output == "json" {
+			return encoder.Encode(converted)
+		}
+		_, err := fmt.Fprintf(c.stdout, "%d  %s  %s  %s  %s\n", converted.ID, converted.Time, converted.Subject, converted.Type, converted.summary(maxDataColumn))
+		return err
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// getEvent prints an event by its ID
+func getEvent(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("get", flag.ContinueOnError)
+	upcast := flags.Bool("upcast", false, "Upcast the payload to the latest schema version")
+	if err := parse(flags, args, "[flags] <id>"); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+	id, err := strconv.ParseInt(flags.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid event ID %q", flags.Arg(0))
+	}
+
+	events, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	e, err := events.Get(ctx, id, *upcast)
+	if err != nil {
+		return err
+	}
+	converted := fromClient(e)
+	if c.output == "json" {
+		return c.json(converted)
+	}
+
+	rows := [][]string{
+		{"ID", strconv.FormatInt(converted.ID, 10)},
+		{"SOURCE", converted.Source},
+		{"TYPE", converted.Type},
+		{"SUBJECT", converted.Subject},
+		{"TIME", converted.Time},
+	}
+	if converted.OccurredAt != "" {
+		rows = append(rows, []string{"OCCURRED AT", converted.OccurredAt})
+	}
+	if converted.DataContentType != "" {
+		rows = append(rows, []string{"DATACONTENTTYPE", converted.DataContentType})
+	}
+	if converted.DataSchema != "" {
+		rows = append(rows, []string{"DATASCHEMA", converted.DataSchema})
+	}
+	if converted.DataKey != "" {
+		rows = append(rows, []string{"DATA KEY", converted.DataKey})
+	}
+	if converted.CorrelationID != "" {
+		rows = append(rows, []string{"CORRELATION ID", converted.CorrelationID})
+	}
+	if converted.CausationID != "" {
+		rows = append(rows, []string{"CAUSATION ID", converted.CausationID})
+	}
+	for _, key := range slices.Sorted(maps.Keys(converted.Metadata)) {
+		rows = append(rows, []string{"METADATA " + key, converted.Metadata[key]})
+	}
+	if converted.TombstoneID != 0 {
+		rows = append(rows, []string{"TOMBSTONE", strconv.FormatInt(converted.TombstoneID, 10)})
+		rows = append(rows, []string{"TOMBSTONED AT", converted.TombstonedAt})
+	}
+	rows = append(rows, []string{"DATA", converted.summary(0)})
+	return c.table([]string{"FIELD", "VALUE"}, rows)
+}
+
+// correlatedEvents prints the events sharing a correlation ID across subjects
+func correlatedEvents(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("correlated", flag.ContinueOnError)
+	upcast := flags.Bool("upcast", false, "Upcast payloads to the latest schema version")
+	if err := parse(flags, args, "[flags] <correlation-id>"); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+
+	events, err := c.client()
+	if err != nil {
+		return err
+	}
+	defer events.Close()
+
+	correlated := []event{}
+	query := client.Query{CorrelationID: flags.Arg(0), Upcast: *upcast}
+	for {
+		page, err := events.Query(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, e := range page.Events {
+			correlated = append(correlated, fromClient(e))
+		}
+		if page.End {
+			break
+		}
+		query.After = page.Position
+	}
+
+	if c.output == "json" {
+		return c.json(correlated)
+	}
+	rows := make([][]string, 0, len(correlated))
+	for _, e := range correlated {
+		rows = append(rows, []string{strconv.FormatInt(e.ID, 10), e.Time, e.Subject, e.Type, e.CausationID, e.summary(maxDataColumn)})
+	}
+	return c.table([]string{"ID", "TIME", "SUBJECT", "TYPE", "CAUSATION", "DATA"}, rows)
+}
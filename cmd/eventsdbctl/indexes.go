@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/idot-digital/events-db/internal/models"
+)
+
+// manageIndexes lists, declares and deletes the secondary indexes of the tenant
+func manageIndexes(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("indexes", flag.ContinueOnError)
+	eventType := flags.String("type", "", "Event type of a created index")
+	path := flags.String("path", "", "JSON path of a created index, like $.customerId")
+	if err := parse(flags, args, "[flags] list | create <name> | delete <name>"); err != nil {
+		return err
+	}
+
+	switch action := flags.Arg(0); {
+	case action == "list" && flags.NArg() == 1:
+		var list []models.PayloadIndex
+		if err := c.do(ctx, http.MethodGet, "/admin/indexes", nil, nil, &list); err != nil {
+			return err
+		}
+		if c.output == "json" {
+			return c.json(list)
+		}
+
+		rows := make([][]string, 0, len(list))
+		for _, index := range list {
+			state := "ready"
+			if !index.Ready {
+				state = "backfilling, at " + strconv.FormatInt(index.Position, 10)
+			}
+			rows = append(rows, []string{index.Name, index.Type, index.Path, state, index.CreatedAt})
+		}
+		return c.table([]string{"NAME", "TYPE", "PATH", "STATE", "CREATED"}, rows)
+
+	case action == "create" && flags.NArg() == 2:
+		if *eventType == "" || *path == "" {
+			return errors.New("create requires -type and -path")
+		}
+		var created models.PayloadIndex
+		req := models.CreatePayloadIndexRequest{Name: flags.Arg(1), Type: *eventType, Path: *path}
+		if err := c.do(ctx, http.MethodPost, "/admin/indexes", nil, req, &created); err != nil {
+			return err
+		}
+		if c.output == "json" {
+			return c.json(created)
+		}
+		fmt.Fprintf(c.stdout, "Created index %s, existing events are indexed in the background\n", created.Name)
+		return nil
+
+	case action == "delete" && flags.NArg() == 2:
+		name := flags.Arg(1)
+		if err := c.do(ctx, http.MethodDelete, "/admin/indexes", url.Values{"name": {name}}, nil, nil); err != nil {
+			return err
+		}
+		if c.output == "json" {
+			return c.json(map[string]string{"deleted": name})
+		}
+		fmt.Fprintf(c.stdout, "Deleted index %s\n", name)
+		return nil
+
+	default:
+		flags.Usage()
+		return errUsage
+	}
+}
+
+// indexedEvents prints the events holding a value in a secondary index
+func indexedEvents(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("indexed", flag.ContinueOnError)
+	upcast := flags.Bool("upcast", false, "Upcast payloads to the latest schema version")
+	if err := parse(flags, args, "[flags] <index> <value>"); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return errUsage
+	}
+
+	query := url.Values{"index": {flags.Arg(0)}, "value": {flags.Arg(1)}}
+	if *upcast {
+		query.Set("upcast", "true")
+	}
+
+	found := []event{}
+	ready := true
+	for {
+		var page models.ReadIndexedResponse
+		if err := c.do(ctx, http.MethodGet, "/events/indexed", query, nil, &page); err != nil {
+			return err
+		}
+		for _, e := range page.Events {
+			found = append(found, fromModel(e))
+		}
+		ready = ready && page.Ready
+		if page.End {
+			break
+		}
+		query.Set("from", strconv.FormatInt(page.Position, 10))
+	}
+	if !ready {
+		fmt.Fprintln(os.Stderr, "eventsdbctl: the index is still backfilled, older events may be missing")
+	}
+
+	if c.output == "json" {
+		return c.json(found)
+	}
+	rows := make([][]string, 0, len(found))
+	for _, e := range found {
+		rows = append(rows, []string{strconv.FormatInt(e.ID, 10), e.Time, e.Subject, e.Type, e.summary(maxDataColumn)})
+	}
+	return c.table([]string{"ID", "TIME", "SUBJECT", "TYPE", "DATA"}, rows)
+}
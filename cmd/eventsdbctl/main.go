@@ -0,0 +1,218 @@
+// Command eventsdbctl administers a running events-db server over its REST
+// API. It appends, reads, searches and tails events, lists subjects, inspects
+// live streams, previews retention and manages tenant tokens and indexes.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/idot-digital/events-db/client"
+)
+
+const usage = `Usage: eventsdbctl [flags] <command> [arguments]
+
+Commands:
+  append      Append events from files or stdin
+  tail        Print the events of a subject as they are created
+  get         Print an event by its ID
+  correlated  Print the events sharing a correlation ID
+  search      Search event payloads by JSON path and words
+  indexed     Print the events holding a value in a secondary index
+  subjects    List the subjects of the tenant
+  streams     Show the live streams and how far they lag (admin)
+  retention   Show what the retention rules would delete (admin)
+  tokens      List, create and revoke tenant tokens (admin)
+  indexes     List, create and delete secondary indexes (admin)
+  tombstone   Remove an event or the events of a subject (admin)
+  audit       Show the audit trail of removed events (admin)
+  export      Export events to NDJSON or a CloudEvents batch
+  import      Import an export file
+
+Run eventsdbctl <command> -h for the arguments of a command.
+
+Flags:
+`
+
+// errUsage is returned for invalid arguments, after the usage was printed
+var errUsage = errors.New("invalid arguments")
+
+// cli holds the global flags shared by the commands
+type cli struct {
+	server string
+	token  string
+	tenant string
+	output string
+	stdin  io.Reader
+	stdout io.Writer
+}
+
+type command func(ctx context.Context, c *cli, args []string) error
+
+var commands = map[string]command{
+	"append":     appendEvents,
+	"tail":       tailEvents,
+	"get":        getEvent,
+	"correlated": correlatedEvents,
+	"search":     searchEvents,
+	"indexed":    indexedEvents,
+	"subjects":   listSubjects,
+	"streams":    showStreams,
+	"retention":  showRetention,
+	"tokens":     manageTokens,
+	"indexes":    manageIndexes,
+	"tombstone":  tombstoneEvents,
+	"audit":      showAudit,
+	"export":     exportEvents,
+	"import":     importEvents,
+}
+
+func main() {
+	c := &cli{stdin: os.Stdin, stdout: os.Stdout}
+	flag.StringVar(&c.server, "server", envOr("EVENTSDB_URL", "http://localhost:8080"), "URL of the REST API (EVENTSDB_URL)")
+	flag.StringVar(&c.token, "token", os.Getenv("EVENTSDB_TOKEN"), "Token to authenticate with (EVENTSDB_TOKEN)")
+	flag.StringVar(&c.tenant, "tenant", os.Getenv("EVENTSDB_TENANT"), "Tenant to act on (EVENTSDB_TENANT)")
+	flag.StringVar(&c.output, "o", "table", "Output format: table or json")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if c.output != "table" && c.output != "json" {
+		fmt.Fprintf(os.Stderr, "eventsdbctl: unsupported output format %q, must be table or json\n", c.output)
+		os.Exit(2)
+	}
+
+	run, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err := run(ctx, c, flag.Args()[1:])
+	switch {
+	case errors.Is(err, errUsage):
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "eventsdbctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// envOr returns the value of an environment variable, fallback if it is unset
+func envOr(key string, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// parse parses the flags of a command, usage describes its arguments
+func parse(flags *flag.FlagSet, args []string, usage string) error {
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: eventsdbctl %s %s\n", flags.Name(), usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	return nil
+}
+
+// client returns an events client of the server
+func (c *cli) client() (client.Client, error) {
+	return client.NewREST(c.server, client.WithToken(c.token), client.WithTenant(c.tenant))
+}
+
+// do sends a request to the REST API and decodes the JSON response into
+// reply, which may be nil for responses without body
+func (c *cli) do(ctx context.Context, method string, path string, query url.Values, body any, reply any) error {
+	var reader io.Reader
+	contentType := ""
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+		contentType = "application/json"
+	}
+
+	resp, err := c.send(ctx, method, path, query, reader, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if reply == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(reply)
+}
+
+// send sends a request to the REST API and returns the response, which the
+// caller must close. Unsuccessful responses are returned as errors.
+func (c *cli) send(ctx context.Context, method string, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	target, err := url.Parse(strings.TrimSuffix(c.server, "/") + path)
+	if err != nil {
+		return nil, err
+	}
+	target.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant", c.tenant)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &client.HTTPError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+	return resp, nil
+}
+
+// json writes a value as indented JSON
+func (c *cli) json(value any) error {
+	encoder := json.NewEncoder(c.stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// table writes rows as aligned columns below a header
+func (c *cli) table(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(c.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/idot-digital/events-db/internal/models"
+)
+
+// predicates collects the repeated -where flags
+type predicates []string
+
+func (p *predicates) String() string {
+	return strings.Join(*p, ", ")
+}
+
+func (p *predicates) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// searchEvents prints one page of the events whose payloads match a search
+func searchEvents(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	var where predicates
+	flags.Var(&where, "where", "JSON path predicate like '$.orderId = \"123\"', repeatable")
+	subject := flags.String("subject", "", "Search only this subject, all subjects if empty")
+	recursive := flags.Bool("recursive", false, "Include the subjects below -subject")
+	eventType := flags.String("type", "", "Search only events of this type")
+	since := flags.String("since", "", "Search the events created at or after this RFC 3339 time")
+	until := flags.String("until", "", "Search the events created before this RFC 3339 time")
+	after := flags.Int64("after", 0, "Search the events after this ID, to continue a search")
+	limit := flags.Int("limit", 0, "Maximum number of results, the server limit if 0")
+	if err := parse(flags, args, "[flags] [words ...]\n\nPrints the events matching all -where predicates and containing all words.\nA search reads a limited number of events, continue with -after <position>."); err != nil {
+		return err
+	}
+
+	query := url.Values{"where": where}
+	if text := strings.Join(flags.Args(), " "); text != "" {
+		query.Set("q", text)
+	}
+	for name, value := range map[string]string{"subject": *subject, "type": *eventType, "since": *since, "until": *until} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	if *recursive {
+		query.Set("recursive", "true")
+	}
+	if *after > 0 {
+		query.Set("after", strconv.FormatInt(*after, 10))
+	}
+	if *limit > 0 {
+		query.Set("limit", strconv.Itoa(*limit))
+	}
+
+	var result models.SearchResult
+	if err := c.do(ctx, http.MethodGet, "/events/search", query, nil, &result); err != nil {
+		return err
+	}
+
+	found := make([]event, 0, len(result.Events))
+	for _, e := range result.Events {
+		found = append(found, fromModel(e))
+	}
+	if c.output == "json" {
+		return c.json(struct {
+			Events   []event `json:"events"`
+			Position int64   `json:"position"`
+			Scanned  int64   `json:"scanned"`
+			End      bool    `json:"end"`
+		}{found, result.Position, result.Scanned, result.End})
+	}
+
+	rows := make([][]string, 0, len(found))
+	for _, e := range found {
+		rows = append(rows, []string{strconv.FormatInt(e.ID, 10), e.Time, e.Subject, e.Type, e.summary(maxDataColumn)})
+	}
+	if err := c.table([]string{"ID", "TIME", "SUBJECT", "TYPE", "DATA"}, rows); err != nil {
+		return err
+	}
+	if !result.End {
+		fmt.Fprintf(c.stdout, "\nScanned %d events, continue with -after %d\n", result.Scanned, result.Position)
+	}
+	return nil
+}
+
+// fromModel converts an event as returned by the REST API
+func fromModel(e *models.Event) event {
+	converted := event{
+		ID:              e.ID,
+		Source:          e.Source,
+		Type:            e.Type,
+		Subject:         e.Subject,
+		Time:            e.Time,
+		OccurredAt:      e.OccurredAt,
+		DataContentType: e.DataContentType,
+		DataSchema:      e.DataSchema,
+		DataKey:         e.DataKey,
+		Redacted:        e.Redacted,
+		CorrelationID:   e.CorrelationID,
+		CausationID:     e.CausationID,
+		Metadata:        e.Metadata,
+		TombstoneID:     e.TombstoneID,
+		TombstonedAt:    e.TombstonedAt,
+	}
+	if json.Valid(e.Data) {
+		converted.Data = e.Data
+	} else {
+		converted.DataBase64 = e.Data
+	}
+	if e.Blob != nil {
+		converted.BlobSHA256 = e.Blob.SHA256
+		converted.BlobSize = e.Blob.Size
+	}
+	return converted
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/idot-digital/events-db/internal/models"
+)
+
+// tombstoneEvents replaces an event or the events of a subject by tombstones
+func tombstoneEvents(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("tombstone", flag.ContinueOnError)
+	subject := flags.String("subject", "", "Subject whose events are removed, instead of an event ID")
+	recursive := flags.Bool("recursive", false, "Also remove the events of the subjects below -subject")
+	reason := flags.String("reason", "", "Why the events are removed, recorded in the audit trail")
+	actor := flags.String("actor", "", "Who asked for the removal, defaults to the token")
+	if err := parse(flags, args, "-reason <reason> [flags] <event-id> | -subject <subject> [-recursive]"); err != nil {
+		return err
+	}
+
+	if *recursive && *subject == "" {
+		return errors.New("-recursive requires -subject")
+	}
+
+	req := models.TombstoneRequest{Subject: *subject, Recursive: *recursive, Reason: *reason, Actor: *actor}
+	switch {
+	case *subject == "" && flags.NArg() == 1:
+		id, err := strconv.ParseInt(flags.Arg(0), 10, 64)
+		if err != nil || id < 1 {
+			return fmt.Errorf("invalid event ID %q", flags.Arg(0))
+		}
+		req.EventID = id
+	case *subject == "" || flags.NArg() != 0:
+		flags.Usage()
+		return errUsage
+	}
+	if *reason == "" {
+		return errors.New("tombstone requires -reason")
+	}
+
+	var result models.TombstoneResponse
+	if err := c.do(ctx, http.MethodPost, "/admin/tombstones", nil, req, &result); err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.json(result)
+	}
+	fmt.Fprintf(c.stdout, "Tombstoned %d events, tombstone %d\n", result.Events, result.Tombstone.ID)
+	return nil
+}
+
+// showAudit prints the audit trail of the removed events of the tenant
+func showAudit(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("audit", flag.ContinueOnError)
+	after := flags.Int64("after", 0, "Print the entries after this ID")
+	limit := flags.Int("limit", 100, "Maximum number of entries")
+	if err := parse(flags, args, "[flags]"); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return errUsage
+	}
+
+	query := url.Values{
+		"after": {strconv.FormatInt(*after, 10)},
+		"limit": {strconv.Itoa(*limit)},
+	}
+	var page models.AuditResponse
+	if err := c.do(ctx, http.MethodGet, "/admin/tombstones", query, nil, &page); err != nil {
+		return err
+	}
+	if c.output == "json" {
+		return c.json(page)
+	}
+
+	rows := make([][]string, 0, len(page.Entries))
+	for _, entry := range page.Entries {
+		target := entry.Subject
+		switch {
+		case entry.Action == "tombstone_subtree":
+			target += " (recursive)"
+		case entry.EventID != 0:
+			target = "event " + strconv.FormatInt(entry.EventID, 10)
+		}
+		if entry.TombstoneID != 0 {
+			target = fmt.Sprintf("tombstone %d, %d events", entry.TombstoneID, entry.Events)
+		}
+		rows = append(rows, []string{strconv.FormatInt(entry.ID, 10), entry.CreatedAt, entry.Action, target, entry.Actor, entry.Reason})
+	}
+	return c.table([]string{"ID", "TIME", "ACTION", "TARGET", "ACTOR", "REASON"}, rows)
+}
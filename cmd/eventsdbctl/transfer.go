@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/idot-digital/events-db/internal/models"
+)
+
+// exportEvents writes the events of the tenant to a file or stdout
+func exportEvents(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	subject := flags.String("subject", "", "Export only this subject, all subjects if empty")
+	recursive := flags.Bool("recursive", false, "Include the subjects below -subject")
+	since := flags.String("since", "", "Export the events created at or after this RFC 3339 time")
+	until := flags.String("until", "", "Export the events created before this RFC 3339 time")
+	after := flags.Int64("after", 0, "Export the events after this ID, to continue an interrupted export")
+	format := flags.String("format", "ndjson", "File format: ndjson or cloudevents")
+	gzip := flags.Bool("gzip", false, "Compress the file with gzip")
+	out := flags.String("out", "-", "File to write, - for stdout")
+	if err := parse(flags, args, "[flags]"); err != nil {
+		return err
+	}
+
+	query := url.Values{"format": {*format}}
+	if *subject != "" {
+		query.Set("subject", *subject)
+	}
+	if *recursive {
+		query.Set("recursive", "true")
+	}
+	if *since != "" {
+		query.Set("since", *since)
+	}
+	if *until != "" {
+		query.Set("until", *until)
+	}
+	if *after > 0 {
+		query.Set("after", strconv.FormatInt(*after, 10))
+	}
+	if *gzip {
+		query.Set("gzip", "true")
+	}
+
+	resp, err := c.send(ctx, http.MethodGet, "/events/export", query, nil, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	w := c.stdout
+	if *out != "-" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("export broke off, the output is incomplete: %w", err)
+	}
+	return nil
+}
+
+// importEvents sends an export file to the server and reports the progress
+// of the import on stderr
+func importEvents(ctx context.Context, c *cli, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	importID := flags.String("id", "", "ID of the import, repeating it skips the events imported before. Defaults to the file name.")
+	mode := flags.String("mode", "remap", "remap to store events under new IDs, preserve to keep their IDs")
+	if err := parse(flags, args, "[flags] <file>\n\nReads stdin for -, which requires -id. gzip compressed files are detected."); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errUsage
+	}
+	name := flags.Arg(0)
+	if *importID == "" {
+		if name == "-" {
+			return errors.New("importing from stdin requires -id")
+		}
+		*importID = filepath.Base(name)
+	}
+
+	var progress models.ImportProgress
+	err := readInput(c, name, func(r io.Reader) error {
+		query := url.Values{"import_id": {*importID}, "mode": {*mode}}
+		resp, err := c.send(ctx, http.MethodPost, "/events/import", query, r, "application/octet-stream")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if err := json.Unmarshal(scanner.Bytes(), &progress); err != nil {
+				return err
+			}
+			if !progress.Done && progress.Error == "" {
+				fmt.Fprintf(os.Stderr, "Read %d events, imported %d, skipped %d\n", progress.Read, progress.Imported, progress.Skipped)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if !progress.Done && progress.Error == "" {
+			return errors.New("import broke off, repeat it with the same -id to continue")
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if c.output == "json" {
+		if err := c.json(progress); err != nil {
+			return err
+		}
+	} else {
+		err := c.table([]string{"IMPORT", "MODE", "READ", "IMPORTED", "SKIPPED", "LAST ID"}, [][]string{{
+			progress.ImportID,
+			progress.Mode,
+			strconv.FormatInt(progress.Read, 10),
+			strconv.FormatInt(progress.Imported, 10),
+			strconv.FormatInt(progress.Skipped, 10),
+			progress.LastID,
+		}})
+		if err != nil {
+			return err
+		}
+	}
+
+	if progress.Error != "" {
+		return fmt.Errorf("import stopped at event %q: %s", progress.LastID, progress.Error)
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log/slog"
@@ -11,11 +12,30 @@ import (
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/idot-digital/events-db/database"
 	pb "github.com/idot-digital/events-db/grpc"
+	"github.com/idot-digital/events-db/internal/archive"
+	"github.com/idot-digital/events-db/internal/blobs"
+	"github.com/idot-digital/events-db/internal/compression"
 	"github.com/idot-digital/events-db/internal/config"
 	"github.com/idot-digital/events-db/internal/handlers"
+	"github.com/idot-digital/events-db/internal/indexes"
+	"github.com/idot-digital/events-db/internal/limits"
 	"github.com/idot-digital/events-db/internal/middleware"
+	"github.com/idot-digital/events-db/internal/migrations"
+	"github.com/idot-digital/events-db/internal/retention"
+	"github.com/idot-digital/events-db/internal/schemas"
+	"github.com/idot-digital/events-db/internal/search"
 	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/shredding"
+	"github.com/idot-digital/events-db/internal/storage"
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"github.com/idot-digital/events-db/internal/tombstones"
+	"github.com/idot-digital/events-db/internal/tracing"
+	"github.com/idot-digital/events-db/internal/transfer"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
@@ -27,6 +47,14 @@ func main() {
 	jsonHandler := slog.NewJSONHandler(os.Stderr, nil)
 	log := slog.New(jsonHandler)
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracesExporter)
+	if err != nil {
+		log.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize database connection
 	d, err := sql.Open("mysql", cfg.GetDBURI())
 	if err != nil {
@@ -42,16 +70,142 @@ func main() {
 		os.Exit(1)
 	}
 
-	_, err = d.Exec(string(schemaSQL))
+	err = migrations.Migrate(context.Background(), d, string(schemaSQL))
 	if err != nil {
 		log.Error("Failed to execute schema.sql", "error", err)
 		os.Exit(1)
 	}
 
-	queries := database.New(d)
+	queries := database.New(tracing.NewDB(d))
 	srv := server.New(queries, cfg.EventEmitterBufferLimit, cfg.MaxTotalClients, cfg.ClientBufferSize, log)
-	grpcHandlers := handlers.NewGRPCHandlers(srv, cfg.StreamBatchSize)
-	httpHandlers := handlers.NewHTTPHandlers(srv, cfg.StreamBatchSize)
+	registry := tenancy.NewRegistry(queries, cfg.AuthToken)
+	srv.SetMaxPayloadBytes(cfg.MaxPayloadBytes)
+
+	// Payloads are stored as is unless a compression codec is configured
+	if cfg.Compression != "none" {
+		if cfg.Compression == compression.None || !compression.Valid(cfg.Compression) {
+			log.Error("Invalid compression codec", "codec", cfg.Compression)
+			os.Exit(1)
+		}
+		srv.SetCompression(cfg.Compression, cfg.CompressionThreshold)
+	}
+
+	// Rate limits and quotas are only enforced when configured
+	var limiter *limits.Limiter
+	if cfg.LimitsConfigFile != "" {
+		limitsConfig, err := limits.Load(cfg.LimitsConfigFile)
+		if err != nil {
+			log.Error("Failed to load rate limit config", "error", err)
+			os.Exit(1)
+		}
+		limiter = limits.New(limitsConfig, srv.SubjectUsage)
+	}
+
+	// Payloads are only encrypted when master keys are configured
+	if cfg.EncryptionKeyFile != "" {
+		kms, err := shredding.NewFileKMS(cfg.EncryptionKeyFile)
+		if err != nil {
+			log.Error("Failed to load encryption keys", "error", err)
+			os.Exit(1)
+		}
+		srv.SetEncryption(shredding.New(queries, kms))
+	}
+
+	// Large payloads can only be offloaded when a blob store is configured
+	var blobStore *blobs.Blobs
+	if cfg.BlobURL != "" {
+		store, err := storage.Open(context.Background(), cfg.BlobURL, storage.S3Options{
+			Endpoint:        cfg.BlobS3Endpoint,
+			AccessKeyID:     cfg.BlobS3AccessKeyID,
+			SecretAccessKey: cfg.BlobS3SecretKey,
+			Region:          cfg.BlobS3Region,
+			Insecure:        cfg.BlobS3Insecure,
+		})
+		if err != nil {
+			log.Error("Failed to open blob store", "error", err)
+			os.Exit(1)
+		}
+		blobStore = blobs.New(store, queries, cfg.MaxBlobBytes, log)
+		srv.SetBlobs(blobStore, cfg.BlobThreshold)
+		registry.SetBlobs(blobStore)
+		go blobStore.Run(context.Background())
+	}
+
+	schemaRegistry := schemas.NewRegistry(queries)
+	srv.SetSchemas(schemaRegistry)
+
+	// Old events are only archived when an archive store is configured
+	if cfg.ArchiveURL != "" {
+		store, err := storage.Open(context.Background(), cfg.ArchiveURL, storage.S3Options{
+			Endpoint:        cfg.ArchiveS3Endpoint,
+			AccessKeyID:     cfg.ArchiveS3AccessKeyID,
+			SecretAccessKey: cfg.ArchiveS3SecretKey,
+			Region:          cfg.ArchiveS3Region,
+			Insecure:        cfg.ArchiveS3Insecure,
+		})
+		if err != nil {
+			log.Error("Failed to open archive store", "error", err)
+			os.Exit(1)
+		}
+
+		archiver := archive.New(archive.Config{
+			After:       cfg.ArchiveAfter,
+			SegmentSize: int32(cfg.ArchiveSegmentSize),
+			Interval:    cfg.ArchiveInterval,
+		}, store, d, queries, log)
+		srv.SetArchive(archiver)
+		go archiver.Run(context.Background())
+	}
+
+	// Events are only pruned when retention rules are configured
+	var pruner *retention.Pruner
+	if cfg.RetentionConfigFile != "" {
+		retentionConfig, err := retention.Load(cfg.RetentionConfigFile)
+		if err != nil {
+			log.Error("Failed to load retention config", "error", err)
+			os.Exit(1)
+		}
+		pruner = retention.New(retentionConfig, queries, srv.LowestPosition, log)
+		go pruner.Run(context.Background())
+	}
+
+	// Searches run within the default limits unless configured otherwise
+	searchConfig := search.DefaultConfig()
+	if cfg.SearchConfigFile != "" {
+		searchConfig, err = search.Load(cfg.SearchConfigFile)
+		if err != nil {
+			log.Error("Failed to load search config", "error", err)
+			os.Exit(1)
+		}
+	}
+	searcher := search.New(srv, searchConfig)
+
+	indexRegistry := indexes.NewRegistry(srv, queries, log)
+	srv.SetIndexes(indexRegistry)
+	go indexRegistry.Run(context.Background())
+
+	// Tombstones are only deleted for good when a grace period is configured
+	tombstoner := tombstones.New(d, queries, cfg.TombstoneGracePeriod, log)
+	if blobStore != nil {
+		tombstoner.SetBlobs(blobStore)
+	}
+	if cfg.TombstoneGracePeriod > 0 {
+		go tombstoner.Run(context.Background())
+	}
+
+	grpcHandlers := handlers.NewGRPCHandlers(srv, cfg.StreamBatchSize, cfg.StreamMaxMessageBytes, limiter, searcher, indexRegistry)
+	httpHandlers := handlers.NewHTTPHandlers(srv, cfg.StreamBatchSize, limiter)
+	adminHandlers := handlers.NewAdminHandlers(srv, registry, pruner, log)
+	schemaHandlers := handlers.NewSchemaHandlers(schemaRegistry, log)
+	transferHandlers := handlers.NewTransferHandlers(srv, transfer.NewImporter(srv, d, limiter, log))
+	searchHandlers := handlers.NewSearchHandlers(searcher, log)
+	indexHandlers := handlers.NewIndexHandlers(srv, indexRegistry, cfg.StreamBatchSize, log)
+	tombstoneHandlers := handlers.NewTombstoneHandlers(tombstoner, log)
+
+	prometheus.MustRegister(
+		server.NewCollector(srv),
+		collectors.NewDBStatsCollector(d, cfg.DBName),
+	)
 
 	// Start gRPC server
 	go func() {
@@ -61,6 +215,22 @@ func main() {
 			os.Exit(1)
 		}
 
+		opts := []grpc.ServerOption{
+			grpc.StatsHandler(otelgrpc.NewServerHandler()),
+			grpc.ChainUnaryInterceptor(
+				middleware.RecoveryInterceptor(log),
+				middleware.LoggingInterceptor(log),
+				middleware.MetricsInterceptor(),
+				middleware.AuthInterceptor(registry),
+			),
+			grpc.ChainStreamInterceptor(
+				middleware.StreamRecoveryInterceptor(log),
+				middleware.StreamLoggingInterceptor(log),
+				middleware.StreamMetricsInterceptor(),
+				middleware.StreamAuthInterceptor(registry),
+			),
+		}
+
 		var s *grpc.Server
 		// Configure TLS if certificates are provided
 		if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
@@ -74,17 +244,10 @@ func main() {
 				os.Exit(1)
 			}
 
-			s = grpc.NewServer(
-				grpc.Creds(creds),
-				grpc.UnaryInterceptor(middleware.AuthInterceptor(cfg.AuthToken)),
-				grpc.StreamInterceptor(middleware.StreamAuthInterceptor(cfg.AuthToken)),
-			)
+			s = grpc.NewServer(append(opts, grpc.Creds(creds))...)
 		} else {
 			log.Info("Starting gRPC server without TLS")
-			s = grpc.NewServer(
-				grpc.UnaryInterceptor(middleware.AuthInterceptor(cfg.AuthToken)),
-				grpc.StreamInterceptor(middleware.StreamAuthInterceptor(cfg.AuthToken)),
-			)
+			s = grpc.NewServer(opts...)
 		}
 
 		pb.RegisterEventsDBServer(s, grpcHandlers)
@@ -102,10 +265,41 @@ func main() {
 	mux.Handle("/metrics", promhttp.Handler())
 
 	// Wrap handlers with auth and metrics middleware
-	mux.HandleFunc("/subjects", middleware.Auth(middleware.Metrics(httpHandlers.GetSubjectsHandler, "get_subjects"), cfg.AuthToken))
-	mux.HandleFunc("/events", middleware.Auth(middleware.Metrics(httpHandlers.CreateEventHandler, "create_event"), cfg.AuthToken))
-	mux.HandleFunc("/events/get", middleware.Auth(middleware.Metrics(httpHandlers.GetEventByIDHandler, "get_event"), cfg.AuthToken))
-	mux.HandleFunc("/events/stream", middleware.Auth(middleware.Metrics(httpHandlers.StreamEventsFromSubjectHandler, "stream_events"), cfg.AuthToken))
+	mux.HandleFunc("/subjects", middleware.Metrics(middleware.Auth(httpHandlers.GetSubjectsHandler, registry), "get_subjects"))
+	mux.HandleFunc("/events", middleware.Metrics(middleware.Auth(httpHandlers.CreateEventHandler, registry), "create_event"))
+	mux.HandleFunc("/events/get", middleware.Metrics(middleware.Auth(httpHandlers.GetEventByIDHandler, registry), "get_event"))
+	mux.HandleFunc("/events/stream", middleware.Metrics(middleware.Auth(httpHandlers.StreamEventsFromSubjectHandler, registry), "stream_events"))
+	mux.HandleFunc("/events/erase", middleware.Metrics(middleware.Auth(httpHandlers.EraseDataKeyHandler, registry), "erase_data_key"))
+	mux.HandleFunc("/blobs", middleware.Metrics(middleware.Auth(httpHandlers.BlobsHandler, registry), "blobs"))
+	mux.HandleFunc("/schemas", middleware.Metrics(middleware.Auth(schemaHandlers.SchemasHandler, registry), "schemas"))
+	mux.HandleFunc("/schemas/upcasters", middleware.Metrics(middleware.Auth(schemaHandlers.UpcastersHandler, registry), "schema_upcasters"))
+	mux.HandleFunc("/schemas/compatibility", middleware.Metrics(middleware.Auth(schemaHandlers.CompatibilityHandler, registry), "schema_compatibility"))
+	mux.HandleFunc("/events/all", middleware.Metrics(middleware.Auth(httpHandlers.ReadAllHandler, registry), "read_all"))
+	mux.HandleFunc("/events/correlated", middleware.Metrics(middleware.Auth(httpHandlers.ReadCorrelatedHandler, registry), "read_correlated"))
+	mux.HandleFunc("/events/search", middleware.Metrics(middleware.Auth(searchHandlers.SearchHandler, registry), "search_events"))
+	mux.HandleFunc("/events/indexed", middleware.Metrics(middleware.Auth(indexHandlers.ReadIndexedHandler, registry), "read_indexed"))
+	mux.HandleFunc("/events/export", middleware.Metrics(middleware.Auth(transferHandlers.ExportHandler, registry), "export_events"))
+	mux.HandleFunc("/events/import", middleware.Metrics(middleware.Auth(transferHandlers.ImportHandler, registry), "import_events"))
+	mux.HandleFunc("/events/all/stream", middleware.Metrics(middleware.Auth(httpHandlers.StreamAllHandler, registry), "stream_all"))
+
+	// Tenant management requires the admin token
+	mux.HandleFunc("/admin/tenants", middleware.Metrics(middleware.Auth(middleware.Admin(adminHandlers.TenantsHandler), registry), "admin_tenants"))
+	mux.HandleFunc("/admin/tokens", middleware.Metrics(middleware.Auth(middleware.Admin(adminHandlers.TokensHandler), registry), "admin_tokens"))
+	mux.HandleFunc("/admin/retention", middleware.Metrics(middleware.Auth(middleware.Admin(adminHandlers.RetentionHandler), registry), "admin_retention"))
+	mux.HandleFunc("/admin/streams", middleware.Metrics(middleware.Auth(middleware.Admin(adminHandlers.StreamsHandler), registry), "admin_streams"))
+	mux.HandleFunc("/admin/indexes", middleware.Metrics(middleware.Auth(middleware.Admin(indexHandlers.IndexesHandler), registry), "admin_indexes"))
+	mux.HandleFunc("/admin/tombstones", middleware.Metrics(middleware.Auth(middleware.Admin(tombstoneHandlers.TombstonesHandler), registry), "admin_tombstones"))
+
+	// Trace every request, convert panics in any handler into a 500 response
+	// and compress responses for clients accepting it
+	handler := otelhttp.NewHandler(middleware.Recovery(middleware.Compress(mux.ServeHTTP), log), "rest",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			return r.URL.Path != "/metrics"
+		}),
+	)
 
 	log.Info("REST server listening", "address", fmt.Sprintf(":%d", cfg.RESTPort))
 
@@ -118,14 +312,14 @@ func main() {
 			fmt.Sprintf(":%d", cfg.RESTPort),
 			cfg.TLSCertFile,
 			cfg.TLSKeyFile,
-			mux,
+			handler,
 		); err != nil {
 			log.Error("Failed to serve REST over HTTPS", "error", err)
 			os.Exit(1)
 		}
 	} else {
 		log.Info("Starting HTTP server (no TLS)")
-		if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.RESTPort), mux); err != nil {
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", cfg.RESTPort), handler); err != nil {
 			log.Error("Failed to serve REST", "error", err)
 			os.Exit(1)
 		}
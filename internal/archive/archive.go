@@ -0,0 +1,446 @@
+package archive
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/storage"
+	"github.com/idot-digital/events-db/internal/tracing"
+)
+
+// watermarkRefreshInterval is how long the cached watermark is trusted
+const watermarkRefreshInterval = 10 * time.Second
+
+// purgeGrace is how long archived events stay in the database after their
+// segment was indexed, so every server instance has refreshed its watermark
+// before reads have to be served from the archive
+const purgeGrace = time.Minute
+
+// purgeBatchSize is the number of archived events deleted per statement
+const purgeBatchSize = 1000
+
+// cachedSegments is the number of decoded segments kept in memory
+const cachedSegments = 4
+
+// maxSegmentsPerRead caps the segments consulted by a single Read
+const maxSegmentsPerRead = 16
+
+// errTombstonedMeanwhile is returned by writeSegment when events were
+// tombstoned after they were read, the segment is written again then
+var errTombstonedMeanwhile = errors.New("events were tombstoned while archiving")
+
+// Config controls which events are archived and how they are grouped
+type Config struct {
+	// After is the age from which events are archived
+	After time.Duration
+	// SegmentSize is the number of events per segment
+	SegmentSize int32
+	// Interval between two runs of the archiver
+	Interval time.Duration
+}
+
+type cachedSegment struct {
+	key    string
+	events []*models.Event
+}
+
+// Archive moves old events from the database into segment files and serves
+// them from there. Segments are indexed in the database by their ID range
+// and the subjects they contain.
+type Archive struct {
+	cfg     Config
+	store   storage.Store
+	db      *sql.DB
+	queries *database.Queries
+	logger  *slog.Logger
+
+	watermarkMutex    sync.Mutex
+	watermark         int64
+	watermarkLoadedAt time.Time
+
+	cacheMutex sync.Mutex
+	cache      *list.List
+}
+
+func New(cfg Config, store storage.Store, db *sql.DB, queries *database.Queries, logger *slog.Logger) *Archive {
+	return &Archive{
+		cfg:     cfg,
+		store:   store,
+		db:      db,
+		queries: queries,
+		logger:  logger,
+		cache:   list.New(),
+	}
+}
+
+// Run archives old events every interval until the context is done
+func (a *Archive) Run(ctx context.Context) {
+	ticker := time.NewTicker(a.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.archive(ctx); err != nil && ctx.Err() == nil {
+			a.logger.Error("Failed to archive events", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// archive writes the events older than the threshold into segments. Only
+// full segments are written, unless the events are older than twice the
+// threshold, so a quiet history is archived eventually without creating
+// many small segments.
+func (a *Archive) archive(ctx context.Context) error {
+	if err := a.purge(ctx); err != nil {
+		return err
+	}
+
+	for {
+		watermark, err := a.queries.GetArchiveWatermark(ctx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		before, err := a.queries.GetFirstEventIDSince(ctx, now.Add(-a.cfg.After))
+		if err != nil {
+			return err
+		}
+		if before == 0 {
+			before = math.MaxInt64
+		}
+
+		rows, err := a.queries.GetEventsForArchive(ctx, database.GetEventsForArchiveParams{
+			AfterID:  watermark,
+			BeforeID: before,
+			Limit:    a.cfg.SegmentSize,
+		})
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if len(rows) < int(a.cfg.SegmentSize) && rows[0].Time.After(now.Add(-2*a.cfg.After)) {
+			return nil
+		}
+
+		err = a.writeSegment(ctx, rows)
+		if errors.Is(err, errTombstonedMeanwhile) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(rows) < int(a.cfg.SegmentSize) {
+			return nil
+		}
+	}
+}
+
+// writeSegment stores the rows as a segment and indexes it
+func (a *Archive) writeSegment(ctx context.Context, rows []database.Event) error {
+	events := make([]*models.Event, 0, len(rows))
+	// The number and stored size of the events per tenant and subject
+	subjects := make(map[[2]string][2]int64)
+	blobs := make(map[[2]string]struct{})
+	tombstoned := int64(0)
+	for _, row := range rows {
+		if row.TombstoneID != 0 {
+			tombstoned++
+		}
+		event, err := server.EventFromRow(row)
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+		subject := [2]string{row.Tenant, row.Subject}
+		subjects[subject] = [2]int64{subjects[subject][0] + 1, subjects[subject][1] + int64(len(row.Data)) + row.BlobSize}
+		if row.BlobSha256 != "" {
+			blobs[[2]string{row.Tenant, row.BlobSha256}] = struct{}{}
+		}
+	}
+
+	data, err := encodeSegment(events)
+	if err != nil {
+		return err
+	}
+
+	firstID, lastID := rows[0].ID, rows[len(rows)-1].ID
+	key := segmentKey(firstID, lastID)
+	if err := a.store.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		return err
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	queries := database.New(tracing.NewDB(tx))
+	// A tombstone set after the rows were read would be undone by the
+	// segment. The shared locks make later tombstones wait for the segment,
+	// which they leave alone then.
+	count, err := queries.CountTombstonedEventsForArchive(ctx, database.CountTombstonedEventsForArchiveParams{
+		FirstID: firstID,
+		LastID:  lastID,
+	})
+	if err != nil {
+		return err
+	}
+	if count != tombstoned {
+		return errTombstonedMeanwhile
+	}
+	segmentID, err := queries.CreateArchiveSegment(ctx, database.CreateArchiveSegmentParams{
+		SegmentKey: key,
+		FirstID:    firstID,
+		LastID:     lastID,
+		EventCount: int32(len(rows)),
+		SizeBytes:  int64(len(data)),
+	})
+	if err != nil {
+		return err
+	}
+	// Subjects of tenants deleted meanwhile aren't recorded, their events stay unreachable
+	for subject, usage := range subjects {
+		err := queries.CreateArchiveSegmentSubject(ctx, database.CreateArchiveSegmentSubjectParams{
+			SegmentID:  segmentID,
+			Tenant:     subject[0],
+			Subject:    subject[1],
+			EventCount: usage[0],
+			SizeBytes:  usage[1],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// The blobs referenced by archived events are kept from being collected
+	for blob := range blobs {
+		err := queries.CreateArchiveSegmentBlob(ctx, database.CreateArchiveSegmentBlobParams{
+			SegmentID: segmentID,
+			Tenant:    blob[0],
+			Sha256:    blob[1],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	a.watermarkMutex.Lock()
+	a.watermark = max(a.watermark, lastID)
+	a.watermarkMutex.Unlock()
+
+	metrics.ArchivedEvents.Add(float64(len(rows)))
+	metrics.ArchiveSegments.Inc()
+	metrics.ArchiveSegmentBytes.Add(float64(len(data)))
+	a.logger.Info("Archived events", "segment", key, "events", len(rows), "bytes", len(data))
+
+	return nil
+}
+
+// purge deletes the archived events from the database once the grace period of their segment has passed
+func (a *Archive) purge(ctx context.Context) error {
+	upTo, err := a.queries.GetArchivedBefore(ctx, time.Now().Add(-purgeGrace))
+	if err != nil {
+		return err
+	}
+	if upTo == 0 {
+		return nil
+	}
+
+	for {
+		count, err := a.queries.DeleteArchivedEvents(ctx, database.DeleteArchivedEventsParams{
+			ID:    upTo,
+			Limit: purgeBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+		if count < purgeBatchSize {
+			break
+		}
+	}
+
+	// The secondary indexes only serve stored events
+	for {
+		count, err := a.queries.DeleteArchivedPayloadIndexEntries(ctx, database.DeleteArchivedPayloadIndexEntriesParams{
+			EventID: upTo,
+			Limit:   purgeBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+		if count < purgeBatchSize {
+			return nil
+		}
+	}
+}
+
+// Watermark returns the highest archived event ID. Events up to it are read
+// from the archive.
+func (a *Archive) Watermark(ctx context.Context) (int64, error) {
+	a.watermarkMutex.Lock()
+	defer a.watermarkMutex.Unlock()
+
+	if time.Since(a.watermarkLoadedAt) < watermarkRefreshInterval {
+		return a.watermark, nil
+	}
+
+	watermark, err := a.queries.GetArchiveWatermark(ctx)
+	if err != nil {
+		return 0, err
+	}
+	a.watermark = max(a.watermark, watermark)
+	a.watermarkLoadedAt = time.Now()
+
+	return a.watermark, nil
+}
+
+// Read returns the archived events of a tenant after afterID up to untilID
+// whose subject equals subject, or starts with it if prefix is set. At most
+// limit events are returned, position is the ID up to which was read.
+func (a *Archive) Read(ctx context.Context, tenant string, subject string, prefix bool, afterID int64, untilID int64, limit int32) ([]*models.Event, int64, error) {
+	pattern := server.LikePrefix(subject)
+	if !prefix {
+		// Without the trailing wildcard the pattern only matches the subject
+		pattern = strings.TrimSuffix(pattern, "%")
+	}
+
+	// Only the segments recorded for the tenant are read, like in Get
+	segments, err := a.queries.GetArchiveSegmentsBySubject(ctx, database.GetArchiveSegmentsBySubjectParams{
+		Tenant:  tenant,
+		Pattern: pattern,
+		AfterID: afterID,
+		UntilID: untilID,
+		Limit:   maxSegmentsPerRead,
+	})
+	if err != nil {
+		return nil, afterID, err
+	}
+
+	var events []*models.Event
+	for _, segment := range segments {
+		stored, err := a.segment(ctx, segment.SegmentKey)
+		if err != nil {
+			return nil, afterID, err
+		}
+
+		for _, event := range stored {
+			if event.ID <= afterID {
+				continue
+			}
+			if event.ID > untilID {
+				return events, untilID, nil
+			}
+			if event.Tenant != tenant || !matchesSubject(event.Subject, subject, prefix) {
+				continue
+			}
+
+			events = append(events, event)
+			if len(events) >= int(limit) {
+				return events, event.ID, nil
+			}
+		}
+	}
+
+	// More segments may follow the ones read
+	if len(segments) == maxSegmentsPerRead {
+		return events, segments[len(segments)-1].LastID, nil
+	}
+	return events, untilID, nil
+}
+
+// Get returns an archived event of a tenant, or nil if there is none
+func (a *Archive) Get(ctx context.Context, tenant string, id int64) (*models.Event, error) {
+	segment, err := a.queries.GetArchiveSegmentByEventID(ctx, database.GetArchiveSegmentByEventIDParams{ID: id})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Deleting a tenant deletes its records of the segments, so the events
+	// it left in them stay hidden from a tenant later created with the same name
+	owned, err := a.queries.HasArchiveTenantSegment(ctx, database.HasArchiveTenantSegmentParams{
+		Tenant:    tenant,
+		SegmentID: segment.ID,
+	})
+	if err != nil || !owned {
+		return nil, err
+	}
+
+	events, err := a.segment(ctx, segment.SegmentKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if event.ID == id && event.Tenant == tenant {
+			return event, nil
+		}
+	}
+	return nil, nil
+}
+
+// segment returns the decoded events of a segment, from the cache if possible
+func (a *Archive) segment(ctx context.Context, key string) ([]*models.Event, error) {
+	a.cacheMutex.Lock()
+	for element := a.cache.Front(); element != nil; element = element.Next() {
+		if cached := element.Value.(*cachedSegment); cached.key == key {
+			a.cache.MoveToFront(element)
+			a.cacheMutex.Unlock()
+			return cached.events, nil
+		}
+	}
+	a.cacheMutex.Unlock()
+
+	reader, err := a.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	events, err := decodeSegment(reader)
+	if err != nil {
+		return nil, err
+	}
+	metrics.ArchiveSegmentReads.Inc()
+
+	a.cacheMutex.Lock()
+	a.cache.PushFront(&cachedSegment{key: key, events: events})
+	if a.cache.Len() > cachedSegments {
+		a.cache.Remove(a.cache.Back())
+	}
+	a.cacheMutex.Unlock()
+
+	return events, nil
+}
+
+// matchesSubject reports whether an event subject is selected by subject and prefix
+func matchesSubject(eventSubject string, subject string, prefix bool) bool {
+	if prefix {
+		return strings.HasPrefix(eventSubject, subject)
+	}
+	return eventSubject == subject
+}
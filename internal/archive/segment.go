@@ -0,0 +1,132 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/schemas"
+	"github.com/klauspost/compress/zstd"
+)
+
+// segmentEvent is a line of a segment file
+type segmentEvent struct {
+	ID          int64        `json:"id"`
+	Tenant      string       `json:"tenant"`
+	Source      string       `json:"source"`
+	Type        string       `json:"type"`
+	Subject     string       `json:"subject"`
+	Time        string       `json:"time"`
+	OccurredAt  string       `json:"occurred_at,omitempty"`
+	Data        []byte       `json:"data"`
+	TraceParent string       `json:"traceparent,omitempty"`
+	TraceState  string       `json:"tracestate,omitempty"`
+	DataKey     string       `json:"data_key,omitempty"`
+	DataCodec   string       `json:"data_codec,omitempty"`
+	Blob        *models.Blob `json:"blob,omitempty"`
+	// DataContentType and SchemaVersion describe the payload as written
+	DataContentType string            `json:"datacontenttype,omitempty"`
+	SchemaVersion   int32             `json:"schema_version,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	CorrelationID   string            `json:"correlation_id,omitempty"`
+	CausationID     string            `json:"causation_id,omitempty"`
+	TombstoneID     int64             `json:"tombstone_id,omitempty"`
+	TombstonedAt    string            `json:"tombstoned_at,omitempty"`
+}
+
+// segmentKey names the segment file of an ID range
+func segmentKey(firstID int64, lastID int64) string {
+	return fmt.Sprintf("segments/%020d-%020d.ndjson.zst", firstID, lastID)
+}
+
+// encodeSegment writes events as zstd compressed newline delimited JSON
+func encodeSegment(events []*models.Event) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := zstd.NewWriter(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder := json.NewEncoder(writer)
+	for _, event := range events {
+		err := encoder.Encode(segmentEvent{
+			ID:              event.ID,
+			Tenant:          event.Tenant,
+			Source:          event.Source,
+			Type:            event.Type,
+			Subject:         event.Subject,
+			Time:            event.Time,
+			OccurredAt:      event.OccurredAt,
+			Data:            event.Data,
+			TraceParent:     event.TraceParent,
+			TraceState:      event.TraceState,
+			DataKey:         event.DataKey,
+			DataCodec:       event.DataCodec,
+			Blob:            event.Blob,
+			DataContentType: event.DataContentType,
+			SchemaVersion:   event.SchemaVersion,
+			Metadata:        event.Metadata,
+			CorrelationID:   event.CorrelationID,
+			CausationID:     event.CausationID,
+			TombstoneID:     event.TombstoneID,
+			TombstonedAt:    event.TombstonedAt,
+		})
+		if err != nil {
+			writer.Close()
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeSegment reads the events of a segment file
+func decodeSegment(r io.Reader) ([]*models.Event, error) {
+	reader, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var events []*models.Event
+	decoder := json.NewDecoder(reader)
+	for {
+		var line segmentEvent
+		if err := decoder.Decode(&line); err != nil {
+			if errors.Is(err, io.EOF) {
+				return events, nil
+			}
+			return nil, err
+		}
+
+		events = append(events, &models.Event{
+			ID:              line.ID,
+			Tenant:          line.Tenant,
+			Source:          line.Source,
+			Type:            line.Type,
+			Subject:         line.Subject,
+			Time:            line.Time,
+			OccurredAt:      line.OccurredAt,
+			Data:            line.Data,
+			TraceParent:     line.TraceParent,
+			TraceState:      line.TraceState,
+			DataKey:         line.DataKey,
+			DataCodec:       line.DataCodec,
+			Blob:            line.Blob,
+			DataContentType: line.DataContentType,
+			DataSchema:      schemas.URI(line.Type, line.SchemaVersion),
+			SchemaVersion:   line.SchemaVersion,
+			Metadata:        line.Metadata,
+			CorrelationID:   line.CorrelationID,
+			CausationID:     line.CausationID,
+			TombstoneID:     line.TombstoneID,
+			TombstonedAt:    line.TombstonedAt,
+		})
+	}
+}
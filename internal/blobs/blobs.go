@@ -0,0 +1,304 @@
+package blobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/storage"
+)
+
+var (
+	// ErrTooLarge is returned for blobs above the size limit
+	ErrTooLarge = errors.New("blob too large")
+	// ErrHashMismatch is returned when an uploaded blob doesn't match its expected SHA-256
+	ErrHashMismatch = errors.New("blob does not match the expected SHA-256")
+	// ErrNotFound is returned for blobs that haven't been uploaded
+	ErrNotFound = errors.New("blob not found")
+	// ErrCorrupt is returned when a stored blob no longer matches its SHA-256
+	ErrCorrupt = errors.New("stored blob is corrupt")
+)
+
+const (
+	// collectInterval is the time between two runs of the collection
+	collectInterval = time.Hour
+	// collectAge keeps blobs uploaded recently from being collected before
+	// the events referencing them were appended
+	collectAge = 24 * time.Hour
+	// collectPageSize is the number of blobs checked per query for them
+	collectPageSize = 500
+)
+
+var hashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidHash reports whether s is a hex encoded SHA-256
+func ValidHash(s string) bool {
+	return hashPattern.MatchString(s)
+}
+
+// Blobs keeps payloads too large for the events table in a store, addressed
+// by their SHA-256 per tenant. The blobs table records which blobs a tenant
+// uploaded and their size. Blobs no longer referenced by an event, stored or
+// archived, are collected a day after their last upload.
+type Blobs struct {
+	store    storage.Store
+	queries  *database.Queries
+	maxBytes int64
+	logger   *slog.Logger
+}
+
+func New(store storage.Store, queries *database.Queries, maxBytes int64, logger *slog.Logger) *Blobs {
+	return &Blobs{
+		store:    store,
+		queries:  queries,
+		maxBytes: maxBytes,
+		logger:   logger,
+	}
+}
+
+// Put stores a blob read from r. If expected is set, the blob must have this
+// SHA-256. Uploading the same content twice stores it once.
+func (b *Blobs) Put(ctx context.Context, tenant string, r io.Reader, expected string) (*models.Blob, error) {
+	// The key is only known once the content was hashed, so the upload is
+	// spooled to a temporary file first
+	tmp, err := os.CreateTemp("", "events-db-blob-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(r, b.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if size > b.maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	sum := hex.EncodeToString(hasher.Sum(nil))
+	if expected != "" && !strings.EqualFold(expected, sum) {
+		return nil, ErrHashMismatch
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := b.store.Put(ctx, key(tenant, sum), tmp, size); err != nil {
+		return nil, err
+	}
+
+	err = b.queries.CreateBlob(ctx, database.CreateBlobParams{
+		Tenant: tenant,
+		Sha256: sum,
+		Size:   size,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.BlobUploadedBytes.Add(float64(size))
+	return &models.Blob{SHA256: sum, Size: size}, nil
+}
+
+// PutBytes stores a blob held in memory
+func (b *Blobs) PutBytes(ctx context.Context, tenant string, data []byte) (*models.Blob, error) {
+	return b.Put(ctx, tenant, bytes.NewReader(data), "")
+}
+
+// Get returns an uploaded blob of a tenant, or ErrNotFound
+func (b *Blobs) Get(ctx context.Context, tenant string, sum string) (*models.Blob, error) {
+	row, err := b.queries.GetBlob(ctx, database.GetBlobParams{
+		Tenant: tenant,
+		Sha256: strings.ToLower(sum),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &models.Blob{SHA256: row.Sha256, Size: row.Size}, nil
+}
+
+// Reference returns an uploaded blob of a tenant an event is about to
+// reference, or ErrNotFound. The blob counts as uploaded again, so it isn't
+// collected before the event is stored.
+func (b *Blobs) Reference(ctx context.Context, tenant string, sum string) (*models.Blob, error) {
+	err := b.queries.TouchBlob(ctx, database.TouchBlobParams{
+		Tenant: tenant,
+		Sha256: strings.ToLower(sum),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.Get(ctx, tenant, sum)
+}
+
+// Open returns the content of an uploaded blob of a tenant. The reader
+// returns ErrCorrupt at the end if the content doesn't match the SHA-256.
+func (b *Blobs) Open(ctx context.Context, tenant string, sum string) (io.ReadCloser, *models.Blob, error) {
+	blob, err := b.Get(ctx, tenant, sum)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader, err := b.store.Get(ctx, key(tenant, blob.SHA256))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	metrics.BlobDownloadedBytes.Add(float64(blob.Size))
+	return &verifyingReader{
+		reader:   reader,
+		hasher:   sha256.New(),
+		expected: blob.SHA256,
+		size:     blob.Size,
+	}, blob, nil
+}
+
+// Run collects the unreferenced blobs every interval until the context is done
+func (b *Blobs) Run(ctx context.Context) {
+	ticker := time.NewTicker(collectInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := b.Collect(ctx); err != nil && ctx.Err() == nil {
+			b.logger.Error("Failed to collect blobs", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect deletes the blobs uploaded more than a day ago that no stored or
+// archived event references
+func (b *Blobs) Collect(ctx context.Context) error {
+	var afterTenant, afterSHA256 string
+	for {
+		rows, err := b.queries.GetCollectableBlobs(ctx, database.GetCollectableBlobsParams{
+			AfterTenant: afterTenant,
+			AfterSha256: afterSHA256,
+			AgeSeconds:  int64(collectAge.Seconds()),
+			Limit:       collectPageSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			if _, err := b.DeleteUnreferenced(ctx, row.Tenant, row.Sha256, collectAge); err != nil {
+				return fmt.Errorf("collect blob %s of %s: %w", row.Sha256, row.Tenant, err)
+			}
+		}
+		if len(rows) < collectPageSize {
+			return nil
+		}
+		afterTenant, afterSHA256 = rows[len(rows)-1].Tenant, rows[len(rows)-1].Sha256
+	}
+}
+
+// DeleteUnreferenced deletes a blob of a tenant uploaded at least minAge ago
+// if no stored or archived event references it. Blobs uploaded before the
+// archive recorded the blobs of its segments are kept. It returns whether the
+// blob was deleted.
+func (b *Blobs) DeleteUnreferenced(ctx context.Context, tenant string, sum string, minAge time.Duration) (bool, error) {
+	count, err := b.queries.DeleteUnreferencedBlob(ctx, database.DeleteUnreferencedBlobParams{
+		Tenant:     tenant,
+		Sha256:     sum,
+		AgeSeconds: int64(minAge.Seconds()),
+	})
+	if err != nil || count == 0 {
+		return false, err
+	}
+
+	// Without its row the blob can't be referenced anymore, a failed delete
+	// only leaves an orphaned file in the store
+	if err := b.store.Delete(ctx, key(tenant, sum)); err != nil {
+		return true, err
+	}
+	metrics.CollectedBlobs.Inc()
+	return true, nil
+}
+
+// DeleteTenant deletes all blobs of a deleted tenant
+func (b *Blobs) DeleteTenant(ctx context.Context, tenant string) error {
+	after := ""
+	for {
+		sums, err := b.queries.GetTenantBlobs(ctx, database.GetTenantBlobsParams{
+			Tenant: tenant,
+			Sha256: after,
+			Limit:  collectPageSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, sum := range sums {
+			if err := b.store.Delete(ctx, key(tenant, sum)); err != nil {
+				return err
+			}
+			err := b.queries.DeleteTenantBlob(ctx, database.DeleteTenantBlobParams{
+				Tenant: tenant,
+				Sha256: sum,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(sums) < collectPageSize {
+			return nil
+		}
+		after = sums[len(sums)-1]
+	}
+}
+
+// key names the file of a blob in the store
+func key(tenant string, sum string) string {
+	return fmt.Sprintf("blobs/%s/%s/%s", tenant, sum[:2], sum)
+}
+
+// verifyingReader checks the size and SHA-256 of a blob as it is read
+type verifyingReader struct {
+	reader   io.ReadCloser
+	hasher   hash.Hash
+	expected string
+	size     int64
+	read     int64
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hasher.Write(p[:n])
+	r.read += int64(n)
+
+	if errors.Is(err, io.EOF) {
+		if r.read != r.size || hex.EncodeToString(r.hasher.Sum(nil)) != r.expected {
+			return n, ErrCorrupt
+		}
+	}
+	return n, err
+}
+
+func (r *verifyingReader) Close() error {
+	return r.reader.Close()
+}
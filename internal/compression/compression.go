@@ -0,0 +1,68 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs a payload can be stored with. None leaves the payload as is.
+const (
+	None = ""
+	Zstd = "zstd"
+	Gzip = "gzip"
+)
+
+var (
+	encoder, _ = zstd.NewWriter(nil)
+	decoder, _ = zstd.NewReader(nil)
+)
+
+// Valid reports whether codec names a supported codec
+func Valid(codec string) bool {
+	return codec == None || codec == Zstd || codec == Gzip
+}
+
+// Compress compresses data with a codec
+func Compress(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case None:
+		return data, nil
+	case Zstd:
+		return encoder.EncodeAll(data, nil), nil
+	case Gzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported codec %q", codec)
+	}
+}
+
+// Decompress reverses Compress
+func Decompress(codec string, data []byte) ([]byte, error) {
+	switch codec {
+	case None:
+		return data, nil
+	case Zstd:
+		return decoder.DecodeAll(data, nil)
+	case Gzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return io.ReadAll(reader)
+	default:
+		return nil, fmt.Errorf("unsupported codec %q", codec)
+	}
+}
@@ -0,0 +1,47 @@
+package compression
+
+import (
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+
+	// Registers the gzip compressor, so clients may request gzip responses
+	_ "google.golang.org/grpc/encoding/gzip"
+)
+
+func init() {
+	encoding.RegisterCompressor(grpcZstd{})
+}
+
+// grpcZstd lets gRPC clients request zstd compressed messages
+type grpcZstd struct{}
+
+func (grpcZstd) Name() string {
+	return Zstd
+}
+
+func (grpcZstd) Compress(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+}
+
+func (grpcZstd) Decompress(r io.Reader) (io.Reader, error) {
+	reader, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &closingReader{reader}, nil
+}
+
+// closingReader releases the decoder once the message has been read
+type closingReader struct {
+	decoder *zstd.Decoder
+}
+
+func (r *closingReader) Read(p []byte) (int, error) {
+	n, err := r.decoder.Read(p)
+	if err != nil {
+		r.decoder.Close()
+	}
+	return n, err
+}
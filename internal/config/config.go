@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"time"
 )
 
 type Config struct {
@@ -22,6 +23,33 @@ type Config struct {
 	ClientBufferSize        int
 	MaxTotalClients         int
 	StreamBatchSize         int
+	StreamMaxMessageBytes   int
+	TracesExporter          string
+	LimitsConfigFile        string
+	RetentionConfigFile     string
+	SearchConfigFile        string
+	ArchiveURL              string
+	ArchiveS3Endpoint       string
+	ArchiveS3AccessKeyID    string
+	ArchiveS3SecretKey      string
+	ArchiveS3Region         string
+	ArchiveS3Insecure       bool
+	ArchiveAfter            time.Duration
+	ArchiveSegmentSize      int
+	ArchiveInterval         time.Duration
+	EncryptionKeyFile       string
+	Compression             string
+	CompressionThreshold    int
+	MaxPayloadBytes         int
+	BlobURL                 string
+	BlobS3Endpoint          string
+	BlobS3AccessKeyID       string
+	BlobS3SecretKey         string
+	BlobS3Region            string
+	BlobS3Insecure          bool
+	BlobThreshold           int
+	MaxBlobBytes            int64
+	TombstoneGracePeriod    time.Duration
 }
 
 func New() *Config {
@@ -30,6 +58,16 @@ func New() *Config {
 	clientBufferSize := flag.Int("client-buffer-size", 100, "Buffer size for client event channels")
 	maxTotalClients := flag.Int("max-total-clients", 10000, "Maximum total number of clients across all subjects")
 	streamBatchSize := flag.Int("stream-batch-size", 10, "Number of events to fetch in each stream batch")
+	streamMaxMessageBytes := flag.Int("stream-max-message-bytes", 1<<20, "Maximum size of a gRPC stream message in bytes")
+	archiveAfter := flag.Duration("archive-after", 30*24*time.Hour, "Age from which events are moved into the archive")
+	archiveSegmentSize := flag.Int("archive-segment-size", 10000, "Number of events per archive segment")
+	compression := flag.String("compression", "none", "Codec to store payloads with: none, zstd or gzip")
+	compressionThreshold := flag.Int("compression-threshold", 1024, "Size in bytes from which payloads are compressed")
+	maxPayloadBytes := flag.Int("max-payload-bytes", 60000, "Maximum size of a payload in bytes before compression")
+	blobThreshold := flag.Int("blob-threshold", 32*1024, "Size in bytes above which payloads are stored in the blob store")
+	maxBlobBytes := flag.Int64("max-blob-bytes", 100<<20, "Maximum size of a blob in bytes")
+	archiveInterval := flag.Duration("archive-interval", time.Minute, "Interval between two runs of the archiver")
+	tombstoneGracePeriod := flag.Duration("tombstone-grace-period", 0, "Age from which tombstoned events are deleted for good, 0 keeps them")
 	flag.Parse()
 
 	DBUser, isSet := os.LookupEnv("MYSQL_USER")
@@ -58,6 +96,26 @@ func New() *Config {
 	}
 	tlsCertFile, _ := os.LookupEnv("TLS_CERT_FILE")
 	tlsKeyFile, _ := os.LookupEnv("TLS_KEY_FILE")
+	limitsConfigFile, _ := os.LookupEnv("RATE_LIMIT_CONFIG")
+	retentionConfigFile, _ := os.LookupEnv("RETENTION_CONFIG")
+	searchConfigFile, _ := os.LookupEnv("SEARCH_CONFIG")
+	archiveURL, _ := os.LookupEnv("ARCHIVE_URL")
+	archiveS3Endpoint, _ := os.LookupEnv("ARCHIVE_S3_ENDPOINT")
+	archiveS3AccessKeyID, _ := os.LookupEnv("ARCHIVE_S3_ACCESS_KEY_ID")
+	archiveS3SecretKey, _ := os.LookupEnv("ARCHIVE_S3_SECRET_ACCESS_KEY")
+	archiveS3Region, _ := os.LookupEnv("ARCHIVE_S3_REGION")
+	archiveS3Insecure, _ := os.LookupEnv("ARCHIVE_S3_INSECURE")
+	encryptionKeyFile, _ := os.LookupEnv("ENCRYPTION_KEY_FILE")
+	blobURL, _ := os.LookupEnv("BLOB_URL")
+	blobS3Endpoint, _ := os.LookupEnv("BLOB_S3_ENDPOINT")
+	blobS3AccessKeyID, _ := os.LookupEnv("BLOB_S3_ACCESS_KEY_ID")
+	blobS3SecretKey, _ := os.LookupEnv("BLOB_S3_SECRET_ACCESS_KEY")
+	blobS3Region, _ := os.LookupEnv("BLOB_S3_REGION")
+	blobS3Insecure, _ := os.LookupEnv("BLOB_S3_INSECURE")
+	tracesExporter, isSet := os.LookupEnv("OTEL_TRACES_EXPORTER")
+	if !isSet {
+		tracesExporter = "none"
+	}
 
 	return &Config{
 		DBUser:                  DBUser,
@@ -75,6 +133,33 @@ func New() *Config {
 		ClientBufferSize:        *clientBufferSize,
 		MaxTotalClients:         *maxTotalClients,
 		StreamBatchSize:         *streamBatchSize,
+		StreamMaxMessageBytes:   *streamMaxMessageBytes,
+		TracesExporter:          tracesExporter,
+		LimitsConfigFile:        limitsConfigFile,
+		RetentionConfigFile:     retentionConfigFile,
+		SearchConfigFile:        searchConfigFile,
+		ArchiveURL:              archiveURL,
+		ArchiveS3Endpoint:       archiveS3Endpoint,
+		ArchiveS3AccessKeyID:    archiveS3AccessKeyID,
+		ArchiveS3SecretKey:      archiveS3SecretKey,
+		ArchiveS3Region:         archiveS3Region,
+		ArchiveS3Insecure:       archiveS3Insecure == "true",
+		ArchiveAfter:            *archiveAfter,
+		ArchiveSegmentSize:      *archiveSegmentSize,
+		ArchiveInterval:         *archiveInterval,
+		EncryptionKeyFile:       encryptionKeyFile,
+		Compression:             *compression,
+		CompressionThreshold:    *compressionThreshold,
+		MaxPayloadBytes:         *maxPayloadBytes,
+		BlobURL:                 blobURL,
+		BlobS3Endpoint:          blobS3Endpoint,
+		BlobS3AccessKeyID:       blobS3AccessKeyID,
+		BlobS3SecretKey:         blobS3SecretKey,
+		BlobS3Region:            blobS3Region,
+		BlobS3Insecure:          blobS3Insecure == "true",
+		BlobThreshold:           *blobThreshold,
+		MaxBlobBytes:            *maxBlobBytes,
+		TombstoneGracePeriod:    *tombstoneGracePeriod,
 	}
 }
 
@@ -3,14 +3,26 @@ package handlers
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/idot-digital/events-db/database"
 	pb "github.com/idot-digital/events-db/grpc"
+	"github.com/idot-digital/events-db/internal/blobs"
+	"github.com/idot-digital/events-db/internal/indexes"
+	"github.com/idot-digital/events-db/internal/limits"
 	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/schemas"
+	"github.com/idot-digital/events-db/internal/search"
 	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/shredding"
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"github.com/idot-digital/events-db/internal/tracing"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // GRPCHandlers implements the gRPC server interface
@@ -18,45 +30,125 @@ type GRPCHandlers struct {
 	pb.UnimplementedEventsDBServer
 	server          *server.Server
 	streamBatchSize int32
+	maxMessageBytes int
+	limiter         *limits.Limiter
+	searcher        *search.Searcher
+	indexes         *indexes.Registry
 }
 
-func NewGRPCHandlers(s *server.Server, streamBatchSize int) *GRPCHandlers {
+func NewGRPCHandlers(s *server.Server, streamBatchSize int, maxMessageBytes int, limiter *limits.Limiter, searcher *search.Searcher, registry *indexes.Registry) *GRPCHandlers {
 	return &GRPCHandlers{
 		server:          s,
 		streamBatchSize: int32(streamBatchSize),
+		maxMessageBytes: maxMessageBytes,
+		limiter:         limiter,
+		searcher:        searcher,
+		indexes:         registry,
 	}
 }
 
 func (h *GRPCHandlers) CreateEvent(ctx context.Context, req *pb.CreateEventRequest) (*pb.CreateEventReply, error) {
+	client := limits.ClientFromContext(ctx)
+	if err := h.limiter.AllowWrite(client, req.Subject); err != nil {
+		return nil, h.limitError(err)
+	}
+
+	var occurredAt sql.NullTime
+	if req.OccurredAt != nil {
+		if err := req.OccurredAt.CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "Invalid occurred_at")
+		}
+		occurredAt = server.OccurredAt(req.OccurredAt.AsTime())
+	}
+
+	now := server.Now()
+	tenant := tenancy.Tenant(ctx)
+	origin := server.Origin{
+		Principal:  tenancy.FromContext(ctx).Name(),
+		RemoteAddr: client.IP,
+		ReceivedAt: now,
+	}
+	metadata, err := h.server.PrepareMetadata(ctx, tenant, origin, req.Metadata, req.CorrelationId, req.CausationId)
+	if err != nil {
+		return nil, h.metadataError(err)
+	}
+
+	schemaVersion, err := h.server.ValidateData(ctx, tenant, req.Type, req.SchemaVersion, req.Datacontenttype, req.BlobSha256, req.Data)
+	if err != nil {
+		return nil, h.validationError(err)
+	}
+
+	payload, err := h.server.SealData(ctx, tenant, req.Subject, req.DataKey, req.BlobSha256, req.Data)
+	if err != nil {
+		return nil, h.sealError(err)
+	}
+	if err := h.limiter.AllowStorage(ctx, tenant, req.Subject, payload.Size()); err != nil {
+		return nil, h.limitError(err)
+	}
+
+	traceParent, traceState := tracing.Carrier(ctx)
 	id, err := h.server.GetQueries().CreateEvent(ctx, database.CreateEventParams{
-		Source:  req.Source,
-		Type:    req.Type,
-		Subject: req.Subject,
-		Data:    req.Data,
+		Tenant:          tenant,
+		Source:          req.Source,
+		Type:            req.Type,
+		Subject:         req.Subject,
+		Time:            now,
+		OccurredAt:      occurredAt,
+		Data:            payload.Data,
+		Traceparent:     traceParent,
+		Tracestate:      traceState,
+		DataKey:         payload.DataKey,
+		DataCodec:       payload.Codec,
+		BlobSha256:      blobSHA256(payload.Blob),
+		BlobSize:        blobSize(payload.Blob),
+		Datacontenttype: req.Datacontenttype,
+		SchemaVersion:   schemaVersion,
+		Metadata:        metadata.Encoded,
+		CorrelationID:   metadata.CorrelationID,
+		CausationID:     metadata.CausationID,
 	})
 	if err != nil {
+		h.limiter.ReleaseStorage(tenant, req.Subject, payload.Size())
 		h.server.GetLogger().Error("Failed to create event", "error", err)
 		return nil, status.Error(codes.Internal, "Failed to create event")
 	}
+	h.limiter.RecordWrite(tenant, req.Subject, payload.Size())
 
 	event := &models.Event{
-		ID:      id,
-		Source:  req.Source,
-		Type:    req.Type,
-		Subject: req.Subject,
-		Time:    time.Now().Format(time.RFC3339),
-		Data:    req.Data,
+		ID:              id,
+		Tenant:          tenant,
+		Source:          req.Source,
+		Type:            req.Type,
+		Subject:         req.Subject,
+		Time:            server.FormatTime(now),
+		OccurredAt:      server.FormatOccurredAt(occurredAt),
+		Data:            req.Data,
+		TraceParent:     traceParent,
+		TraceState:      traceState,
+		DataKey:         payload.DataKey,
+		Blob:            payload.Blob,
+		DataContentType: req.Datacontenttype,
+		DataSchema:      schemas.URI(req.Type, schemaVersion),
+		SchemaVersion:   schemaVersion,
+		Metadata:        metadata.Metadata,
+		CorrelationID:   metadata.CorrelationID,
+		CausationID:     metadata.CausationID,
+	}
+	if payload.Blob != nil {
+		event.Data = nil
 	}
 
+	h.server.IndexEvent(ctx, event)
 	h.server.GetEmitterChan() <- event
 
 	return &pb.CreateEventReply{
-		Id: id,
+		Id:   id,
+		Time: timestamppb.New(now),
 	}, nil
 }
 
 func (h *GRPCHandlers) GetEventByID(ctx context.Context, req *pb.GetEventByIDRequest) (*pb.Event, error) {
-	res, err := h.server.GetQueries().GetEventByID(ctx, req.Id)
+	event, err := h.server.GetEvent(ctx, tenancy.Tenant(ctx), req.Id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, status.Error(codes.NotFound, "Event not found")
@@ -65,100 +157,302 @@ func (h *GRPCHandlers) GetEventByID(ctx context.Context, req *pb.GetEventByIDReq
 		return nil, status.Error(codes.Internal, "Internal server error")
 	}
 
-	time, err := res.Time.MarshalText()
+	events, err := upcastEvents(ctx, h.server, []*models.Event{event}, req.Upcast)
 	if err != nil {
-		h.server.GetLogger().Error("Failed to marshal time", "error", err)
-		return nil, status.Error(codes.Internal, "Failed to process event time")
+		h.server.GetLogger().Error("Failed to upcast event", "id", req.Id, "error", err)
+		return nil, status.Error(codes.Internal, "Internal server error")
 	}
 
-	return &pb.Event{
-		Id:      res.ID,
-		Source:  res.Source,
-		Type:    res.Type,
-		Subject: res.Subject,
-		Time:    string(time),
-		Data:    res.Data,
-	}, nil
+	return toPBEvents(events)[0], nil
 }
 
+// StreamEventsFromSubject replays the events of a subject and then streams
+// new ones. With until_id or until_time only the events up to the bound are
+// replayed, to reconstruct the state at that point, and in finite mode the
+// events stored so far. These streams end with a message marking the end and
+// carrying the position the replay covered.
 func (h *GRPCHandlers) StreamEventsFromSubject(req *pb.StreamEventsFromSubjectRequest, stream pb.EventsDB_StreamEventsFromSubjectServer) error {
 	ctx := stream.Context()
-	lastID := int64(0)
 
-	for {
-		events, err := h.server.GetQueries().GetEventsBySubject(ctx, database.GetEventsBySubjectParams{
-			Subject: req.Subject,
-			Limit:   h.streamBatchSize,
-			ID:      lastID,
-		})
+	if err := h.limiter.AllowStream(limits.ClientFromContext(ctx)); err != nil {
+		return h.limitError(err)
+	}
+
+	var types []string
+	if req.Type != nil {
+		types = []string{*req.Type}
+	}
+	filter := server.NewSubjectFilter(tenancy.Tenant(ctx), "", req.Subject, req.GetRecursive(), types, req.GetFromId())
+	send := func(events []*models.Event) error {
+		events, err := upcastEvents(ctx, h.server, events, req.Upcast)
 		if err != nil {
-			h.server.GetLogger().Error("Failed to get events", "subject", req.Subject, "error", err)
-			return status.Error(codes.Internal, "Failed to get events")
+			return err
 		}
+		return h.sendEvents(ctx, events, nil, func(batch []*pb.Event) error {
+			return stream.Send(&pb.StreamEventsFromSubjectReply{Events: batch})
+		})
+	}
 
-		if len(events) == 0 {
-			// No events found, but this is not an error - just an empty result
-			break
+	if req.Finite || req.UntilId != nil || req.UntilTime != nil {
+		head, err := h.server.Head(ctx)
+		if err != nil {
+			return h.streamError(err, "subject", req.Subject)
 		}
-
-		pbEvents := make([]*pb.Event, 0, len(events))
-		for _, event := range events {
-			time, err := event.Time.MarshalText()
-			if err != nil {
-				h.server.GetLogger().Error("Failed to marshal time", "error", err)
-				return status.Error(codes.Internal, "Failed to process event time")
+		untilID := head
+		if req.UntilId != nil {
+			untilID = min(*req.UntilId, head)
+		}
+		var untilTime time.Time
+		if req.UntilTime != nil {
+			var err error
+			if untilTime, err = time.Parse(time.RFC3339Nano, *req.UntilTime); err != nil {
+				return status.Error(codes.InvalidArgument, "Invalid until_time, must be an RFC 3339 timestamp")
 			}
-
-			pbEvents = append(pbEvents, &pb.Event{
-				Id:      event.ID,
-				Source:  event.Source,
-				Type:    event.Type,
-				Subject: event.Subject,
-				Time:    string(time),
-				Data:    event.Data,
-			})
 		}
 
-		if len(pbEvents) > 0 {
-			reply := &pb.StreamEventsFromSubjectReply{
-				Events: pbEvents,
-			}
-			lastID = pbEvents[len(pbEvents)-1].Id
-			if err := stream.Send(reply); err != nil {
-				return status.Error(codes.Internal, "Failed to send events")
-			}
+		if _, err := h.server.Replay(ctx, filter, untilID, untilTime, h.streamBatchSize, send); err != nil {
+			return h.streamError(err, "subject", req.Subject)
 		}
+		return stream.Send(&pb.StreamEventsFromSubjectReply{End: true, HeadPosition: untilID})
 	}
 
-	channel, listener, err := h.server.AttachListener()
+	sub, err := h.server.Subscribe(ctx, []*server.SubjectFilter{filter}, h.streamBatchSize, send)
 	if err != nil {
-		h.server.GetLogger().Error("Failed to attach listener", "subject", req.Subject, "error", err)
-		return status.Error(codes.ResourceExhausted, "Too many clients for this subject")
+		return h.streamError(err, "subject", req.Subject)
 	}
 
-	defer h.server.DetachListener(listener)
+	defer sub.Close()
 
 	for {
 		select {
-		case event := <-channel:
-			if event.Subject == req.Subject && event.ID > lastID {
-				reply := &pb.StreamEventsFromSubjectReply{
-					Events: []*pb.Event{{
-						Id:      event.ID,
-						Source:  event.Source,
-						Type:    event.Type,
-						Subject: event.Subject,
-						Time:    event.Time,
-						Data:    event.Data,
-					}},
-				}
-				if err := stream.Send(reply); err != nil {
-					return status.Error(codes.Internal, "Failed to send new event")
-				}
-				lastID = event.ID
+		case event := <-sub.Events():
+			if err := sub.Deliver(ctx, event); err != nil {
+				return h.streamError(err, "subject", req.Subject)
 			}
 		case <-ctx.Done():
 			return nil
 		}
 	}
 }
+
+// ReadSubject returns a page of the stored events of a subject in ID order
+func (h *GRPCHandlers) ReadSubject(ctx context.Context, req *pb.ReadSubjectRequest) (*pb.ReadSubjectReply, error) {
+	if req.Subject == "" {
+		return nil, status.Error(codes.InvalidArgument, "Missing subject")
+	}
+
+	limit := h.streamBatchSize
+	if req.Limit != nil {
+		limit = min(max(*req.Limit, 1), maxReadLimit)
+	}
+
+	filter := server.NewSubjectFilter(tenancy.Tenant(ctx), "", req.Subject, req.Recursive, req.Types, req.FromPosition)
+	events, done, err := h.server.Read(ctx, filter, limit)
+	if err != nil {
+		h.server.GetLogger().Error("Failed to read events", "subject", req.Subject, "position", req.FromPosition, "error", err)
+		return nil, status.Error(codes.Internal, "Failed to get events")
+	}
+
+	events, err = upcastEvents(ctx, h.server, events, req.Upcast)
+	if err != nil {
+		h.server.GetLogger().Error("Failed to upcast events", "subject", req.Subject, "position", req.FromPosition, "error", err)
+		return nil, status.Error(codes.Internal, "Failed to get events")
+	}
+
+	return &pb.ReadSubjectReply{
+		Events:   toPBEvents(events),
+		Position: filter.Position(),
+		End:      done,
+	}, nil
+}
+
+// ReadCorrelated returns a page of the stored events sharing a correlation ID
+func (h *GRPCHandlers) ReadCorrelated(ctx context.Context, req *pb.ReadCorrelatedRequest) (*pb.ReadCorrelatedReply, error) {
+	if req.CorrelationId == "" {
+		return nil, status.Error(codes.InvalidArgument, "Missing correlation ID")
+	}
+
+	limit := h.streamBatchSize
+	if req.Limit != nil {
+		limit = min(max(*req.Limit, 1), maxReadLimit)
+	}
+
+	events, done, err := h.server.ReadCorrelated(ctx, tenancy.Tenant(ctx), req.CorrelationId, req.FromPosition, limit)
+	if err != nil {
+		h.server.GetLogger().Error("Failed to read correlated events", "correlation_id", req.CorrelationId, "position", req.FromPosition, "error", err)
+		return nil, status.Error(codes.Internal, "Failed to get events")
+	}
+
+	position := req.FromPosition
+	if len(events) > 0 {
+		position = events[len(events)-1].ID
+	}
+
+	events, err = upcastEvents(ctx, h.server, events, req.Upcast)
+	if err != nil {
+		h.server.GetLogger().Error("Failed to upcast events", "correlation_id", req.CorrelationId, "position", req.FromPosition, "error", err)
+		return nil, status.Error(codes.Internal, "Failed to get events")
+	}
+
+	return &pb.ReadCorrelatedReply{
+		Events:   toPBEvents(events),
+		Position: position,
+		End:      done,
+	}, nil
+}
+
+func (h *GRPCHandlers) EraseDataKey(ctx context.Context, req *pb.EraseDataKeyRequest) (*pb.EraseDataKeyReply, error) {
+	if req.DataKey == "" {
+		return nil, status.Error(codes.InvalidArgument, "Missing data key")
+	}
+
+	erased, err := h.server.EraseDataKey(ctx, tenancy.Tenant(ctx), req.DataKey)
+	if err != nil {
+		if errors.Is(err, server.ErrEncryptionDisabled) {
+			return nil, status.Error(codes.FailedPrecondition, "Encryption is not enabled")
+		}
+		h.server.GetLogger().Error("Failed to erase data key", "error", err)
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+
+	return &pb.EraseDataKeyReply{Erased: erased}, nil
+}
+
+// validationError converts a payload rejected by its schema into a gRPC
+// status, detailing the violations as field violations
+func (h *GRPCHandlers) validationError(err error) error {
+	var validationErr *schemas.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		st := status.New(codes.InvalidArgument, validationErr.Error())
+		details := &errdetails.BadRequest{}
+		for _, violation := range validationErr.Violations {
+			details.FieldViolations = append(details.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       "data" + violation.Field,
+				Description: violation.Description,
+			})
+		}
+		if detailed, err := st.WithDetails(details); err == nil {
+			st = detailed
+		}
+		return st.Err()
+	case errors.Is(err, schemas.ErrNotFound):
+		return status.Error(codes.InvalidArgument, "Schema version not found")
+	default:
+		h.server.GetLogger().Error("Failed to validate event data", "error", err)
+		return status.Error(codes.Internal, "Failed to create event")
+	}
+}
+
+// sealError converts a failure to prepare a payload for storage into a gRPC status
+func (h *GRPCHandlers) sealError(err error) error {
+	switch {
+	case errors.Is(err, server.ErrEncryptionDisabled):
+		return status.Error(codes.FailedPrecondition, "Encryption is not enabled, data_key is not supported")
+	case errors.Is(err, shredding.ErrKeyErased):
+		return status.Error(codes.FailedPrecondition, "Data key has been erased")
+	case errors.Is(err, server.ErrPayloadTooLarge):
+		return status.Error(codes.InvalidArgument, "Payload too large")
+	case errors.Is(err, server.ErrDataAndBlob):
+		return status.Error(codes.InvalidArgument, "data and blob_sha256 are mutually exclusive")
+	case errors.Is(err, server.ErrBlobDataKey):
+		return status.Error(codes.InvalidArgument, "data_key and blob_sha256 are mutually exclusive, blobs are stored unencrypted")
+	case errors.Is(err, server.ErrBlobsDisabled):
+		return status.Error(codes.FailedPrecondition, "Blob store is not enabled")
+	case errors.Is(err, blobs.ErrNotFound):
+		return status.Error(codes.InvalidArgument, "Blob not found")
+	case errors.Is(err, blobs.ErrTooLarge):
+		return status.Error(codes.InvalidArgument, "Payload too large")
+	default:
+		h.server.GetLogger().Error("Failed to prepare event data", "error", err)
+		return status.Error(codes.Internal, "Failed to create event")
+	}
+}
+
+// metadataError converts invalid event metadata into a gRPC status
+func (h *GRPCHandlers) metadataError(err error) error {
+	switch {
+	case errors.Is(err, server.ErrReservedMetadata):
+		return status.Error(codes.InvalidArgument, "Metadata keys starting with eventsdb. are reserved")
+	case errors.Is(err, server.ErrMetadataTooLarge):
+		return status.Error(codes.InvalidArgument, "Metadata too large")
+	case errors.Is(err, server.ErrTrackingIDTooLong):
+		return status.Error(codes.InvalidArgument, "correlation_id and causation_id are limited to 255 bytes")
+	default:
+		h.server.GetLogger().Error("Failed to prepare event metadata", "error", err)
+		return status.Error(codes.Internal, "Failed to create event")
+	}
+}
+
+// limitError converts a rejection by the limiter into a gRPC status
+func (h *GRPCHandlers) limitError(err error) error {
+	var rateLimited *limits.RateLimitedError
+	var quotaExceeded *limits.QuotaExceededError
+	switch {
+	case errors.As(err, &rateLimited):
+		st := status.New(codes.ResourceExhausted, "Rate limit exceeded")
+		if detailed, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(rateLimited.RetryAfter)}); err == nil {
+			st = detailed
+		}
+		return st.Err()
+	case errors.As(err, &quotaExceeded):
+		return status.Error(codes.ResourceExhausted, "Storage quota exceeded")
+	default:
+		h.server.GetLogger().Error("Failed to check limits", "error", err)
+		return status.Error(codes.Internal, "Internal server error")
+	}
+}
+
+// streamError logs a failed stream and converts the error into a gRPC status
+func (h *GRPCHandlers) streamError(err error, args ...any) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, server.ErrTooManyClients) {
+		h.server.GetLogger().Error("Failed to attach listener", append(args, "error", err)...)
+		return status.Error(codes.ResourceExhausted, "Too many clients")
+	}
+	h.server.GetLogger().Error("Failed to get events", append(args, "error", err)...)
+	return status.Error(codes.Internal, "Failed to get events")
+}
+
+// toPBTime converts an RFC 3339 event time, nil for events without one
+func toPBTime(value string) *timestamppb.Timestamp {
+	if value == "" {
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil
+	}
+	return timestamppb.New(parsed)
+}
+
+func toPBEvents(events []*models.Event) []*pb.Event {
+	pbEvents := make([]*pb.Event, 0, len(events))
+	for _, event := range events {
+		pbEvents = append(pbEvents, &pb.Event{
+			Id:              event.ID,
+			Source:          event.Source,
+			Type:            event.Type,
+			Subject:         event.Subject,
+			Time:            event.Time,
+			Timestamp:       toPBTime(event.Time),
+			OccurredAt:      toPBTime(event.OccurredAt),
+			Data:            event.Data,
+			Traceparent:     event.TraceParent,
+			Tracestate:      event.TraceState,
+			DataKey:         event.DataKey,
+			Redacted:        event.Redacted,
+			Blob:            toPBBlob(event.Blob),
+			Datacontenttype: event.DataContentType,
+			Dataschema:      event.DataSchema,
+			Metadata:        event.Metadata,
+			CorrelationId:   event.CorrelationID,
+			CausationId:     event.CausationID,
+			TombstoneId:     event.TombstoneID,
+			TombstonedAt:    toPBTime(event.TombstonedAt),
+		})
+	}
+	return pbEvents
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	pb "github.com/idot-digital/events-db/grpc"
+	"github.com/idot-digital/events-db/internal/limits"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxReadLimit caps the number of events returned by a single read
+const maxReadLimit = 1000
+
+// ReadAll returns a page of all stored events in global ID order
+func (h *GRPCHandlers) ReadAll(ctx context.Context, req *pb.ReadAllRequest) (*pb.ReadAllReply, error) {
+	filter, err := newAllFilter(tenancy.Tenant(ctx), req.GetTypePattern(), req.GetSourcePattern(), req.FromPosition)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	limit := h.streamBatchSize
+	if req.Limit != nil {
+		limit = min(max(*req.Limit, 1), maxReadLimit)
+	}
+
+	events, done, err := h.server.Read(ctx, filter, limit)
+	if err != nil {
+		h.server.GetLogger().Error("Failed to read events", "position", req.FromPosition, "error", err)
+		return nil, status.Error(codes.Internal, "Failed to get events")
+	}
+
+	events, err = upcastEvents(ctx, h.server, events, req.Upcast)
+	if err != nil {
+		h.server.GetLogger().Error("Failed to upcast events", "position", req.FromPosition, "error", err)
+		return nil, status.Error(codes.Internal, "Failed to get events")
+	}
+
+	return &pb.ReadAllReply{
+		Events:   toPBEvents(events),
+		Position: filter.Position(),
+		End:      done,
+	}, nil
+}
+
+// SubscribeAll streams all stored events in global ID order and then the new
+// events as they are created
+func (h *GRPCHandlers) SubscribeAll(req *pb.SubscribeAllRequest, stream pb.EventsDB_SubscribeAllServer) error {
+	ctx := stream.Context()
+
+	if err := h.limiter.AllowStream(limits.ClientFromContext(ctx)); err != nil {
+		return h.limitError(err)
+	}
+
+	filter, err := newAllFilter(tenancy.Tenant(ctx), req.GetTypePattern(), req.GetSourcePattern(), req.FromPosition)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	sub, err := h.server.Subscribe(ctx, []*server.SubjectFilter{filter}, h.streamBatchSize, func(events []*models.Event) error {
+		events, err := upcastEvents(ctx, h.server, events, req.Upcast)
+		if err != nil {
+			return err
+		}
+		return h.sendEvents(ctx, events, nil, func(batch []*pb.Event) error {
+			return stream.Send(&pb.SubscribeAllReply{Events: batch})
+		})
+	})
+	if err != nil {
+		return h.streamError(err, "position", req.FromPosition)
+	}
+
+	defer sub.Close()
+
+	for {
+		select {
+		case event := <-sub.Events():
+			if err := sub.Deliver(ctx, event); err != nil {
+				return h.streamError(err, "position", req.FromPosition)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// newAllFilter builds a filter over every event of a tenant from optional type and source patterns
+func newAllFilter(tenant string, typePattern string, sourcePattern string, fromID int64) (*server.SubjectFilter, error) {
+	var typeRegexp, sourceRegexp *regexp.Regexp
+	var err error
+
+	if typePattern != "" {
+		typeRegexp, err = regexp.Compile(typePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid type pattern: %w", err)
+		}
+	}
+	if sourcePattern != "" {
+		sourceRegexp, err = regexp.Compile(sourcePattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source pattern: %w", err)
+		}
+	}
+
+	return server.NewAllFilter(tenant, typeRegexp, sourceRegexp, fromID), nil
+}
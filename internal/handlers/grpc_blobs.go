@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+
+	pb "github.com/idot-digital/events-db/grpc"
+	"github.com/idot-digital/events-db/internal/blobs"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// blobChunkSize is the size of the chunks a blob is downloaded in
+const blobChunkSize = 64 * 1024
+
+func (h *GRPCHandlers) UploadBlob(stream pb.EventsDB_UploadBlobServer) error {
+	ctx := stream.Context()
+
+	first, err := stream.Recv()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if first == nil {
+		first = &pb.UploadBlobRequest{}
+	}
+	if first.Sha256 != "" && !blobs.ValidHash(first.Sha256) {
+		return status.Error(codes.InvalidArgument, "Invalid sha256")
+	}
+
+	reader := &chunkReader{stream: stream, chunk: first.Chunk, done: err != nil}
+	blob, err := h.server.UploadBlob(ctx, tenancy.Tenant(ctx), reader, first.Sha256)
+	if err != nil {
+		if _, ok := status.FromError(err); ok {
+			return err
+		}
+		switch {
+		case errors.Is(err, blobs.ErrTooLarge):
+			return status.Error(codes.InvalidArgument, "Blob too large")
+		case errors.Is(err, blobs.ErrHashMismatch):
+			return status.Error(codes.InvalidArgument, "Blob does not match sha256")
+		case errors.Is(err, server.ErrBlobsDisabled):
+			return status.Error(codes.FailedPrecondition, "Blob store is not enabled")
+		default:
+			h.server.GetLogger().Error("Failed to upload blob", "error", err)
+			return status.Error(codes.Internal, "Failed to upload blob")
+		}
+	}
+
+	return stream.SendAndClose(toPBBlob(blob))
+}
+
+func (h *GRPCHandlers) DownloadBlob(req *pb.DownloadBlobRequest, stream pb.EventsDB_DownloadBlobServer) error {
+	ctx := stream.Context()
+
+	if !blobs.ValidHash(req.Sha256) {
+		return status.Error(codes.InvalidArgument, "Invalid sha256")
+	}
+
+	reader, _, err := h.server.OpenBlob(ctx, tenancy.Tenant(ctx), req.Sha256)
+	if err != nil {
+		switch {
+		case errors.Is(err, blobs.ErrNotFound):
+			return status.Error(codes.NotFound, "Blob not found")
+		case errors.Is(err, server.ErrBlobsDisabled):
+			return status.Error(codes.FailedPrecondition, "Blob store is not enabled")
+		default:
+			h.server.GetLogger().Error("Failed to open blob", "sha256", req.Sha256, "error", err)
+			return status.Error(codes.Internal, "Failed to download blob")
+		}
+	}
+	defer reader.Close()
+
+	buf := make([]byte, blobChunkSize)
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			if err := stream.Send(&pb.DownloadBlobReply{Chunk: buf[:n]}); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			h.server.GetLogger().Error("Failed to download blob", "sha256", req.Sha256, "error", err)
+			if errors.Is(err, blobs.ErrCorrupt) {
+				return status.Error(codes.DataLoss, "Stored blob is corrupt")
+			}
+			return status.Error(codes.Internal, "Failed to download blob")
+		}
+	}
+}
+
+// chunkReader reads the chunks of an upload stream as one byte stream
+type chunkReader struct {
+	stream pb.EventsDB_UploadBlobServer
+	chunk  []byte
+	done   bool
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.chunk) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		req, err := r.stream.Recv()
+		if errors.Is(err, io.EOF) {
+			r.done = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		r.chunk = req.Chunk
+	}
+
+	n := copy(p, r.chunk)
+	r.chunk = r.chunk[n:]
+	return n, nil
+}
+
+// toPBBlob converts a blob reference, nil stays nil
+func toPBBlob(blob *models.Blob) *pb.Blob {
+	if blob == nil {
+		return nil
+	}
+	return &pb.Blob{Sha256: blob.SHA256, Size: blob.Size}
+}
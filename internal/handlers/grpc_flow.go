@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+
+	pb "github.com/idot-digital/events-db/grpc"
+	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxPendingRequests caps the subscription requests queued while the handler is busy
+const maxPendingRequests = 64
+
+// inbox collects the requests of a subscription stream without blocking the
+// receiving goroutine, so credits keep arriving while the handler waits for them
+type inbox struct {
+	mu      sync.Mutex
+	updates []*pb.SubscribeRequest
+	credits int64
+	err     error
+	signal  chan struct{}
+}
+
+func newInbox() *inbox {
+	return &inbox{signal: make(chan struct{}, 1)}
+}
+
+func (in *inbox) notify() {
+	select {
+	case in.signal <- struct{}{}:
+	default:
+	}
+}
+
+// push queues the filter changes of a request and adds its credits
+func (in *inbox) push(req *pb.SubscribeRequest) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if req.Credits > 0 {
+		in.credits += req.Credits
+	}
+	if len(req.Add) > 0 || len(req.Remove) > 0 {
+		if len(in.updates) >= maxPendingRequests {
+			in.err = status.Error(codes.ResourceExhausted, "Too many pending filter changes")
+		} else {
+			in.updates = append(in.updates, req)
+		}
+	}
+	in.notify()
+}
+
+// fail records the error that ended the receiving side of the stream
+func (in *inbox) fail(err error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if in.err == nil {
+		in.err = err
+	}
+	in.notify()
+}
+
+// take returns the queued filter changes and the receive error, if any
+func (in *inbox) take() ([]*pb.SubscribeRequest, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	updates := in.updates
+	in.updates = nil
+	err := in.err
+	in.err = nil
+	return updates, err
+}
+
+// wait blocks until the client granted credits and returns how many are available
+func (in *inbox) wait(ctx context.Context) (int64, error) {
+	for {
+		in.mu.Lock()
+		credits := in.credits
+		pending := len(in.updates) > 0 || in.err != nil
+		in.mu.Unlock()
+
+		if credits > 0 {
+			if pending {
+				// Leave the signal for the handler loop to pick up the changes
+				in.notify()
+			}
+			return credits, nil
+		}
+
+		select {
+		case <-in.signal:
+		case <-ctx.Done():
+			return 0, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// spend consumes credits for sent events
+func (in *inbox) spend(n int) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	in.credits -= int64(n)
+}
+
+// sendEvents splits events into messages of at most the stream batch size and
+// the configured message size. With flow control, no more events are sent than
+// the client granted credits for.
+func (h *GRPCHandlers) sendEvents(ctx context.Context, events []*models.Event, flow *inbox, send func([]*pb.Event) error) error {
+	pbEvents := toPBEvents(events)
+	sizes := make([]int, len(pbEvents))
+	pendingBytes := 0
+	for i, event := range pbEvents {
+		sizes[i] = proto.Size(event)
+		pendingBytes += sizes[i]
+	}
+
+	tenant := tenancy.Tenant(ctx)
+	pendingEvents := metrics.StreamPendingEvents.WithLabelValues(tenant)
+	pendingSize := metrics.StreamPendingBytes.WithLabelValues(tenant)
+	pendingEvents.Add(float64(len(pbEvents)))
+	pendingSize.Add(float64(pendingBytes))
+	defer func() {
+		pendingEvents.Sub(float64(len(pbEvents)))
+		pendingSize.Sub(float64(pendingBytes))
+	}()
+
+	for len(pbEvents) > 0 {
+		limit := min(len(pbEvents), int(h.streamBatchSize))
+		if flow != nil {
+			credits, err := flow.wait(ctx)
+			if err != nil {
+				return err
+			}
+			limit = int(min(int64(limit), credits))
+		}
+
+		// Always send at least one event, even if it exceeds the message size
+		size := sizes[0]
+		count := 1
+		for count < limit && size+sizes[count] <= h.maxMessageBytes {
+			size += sizes[count]
+			count++
+		}
+
+		if err := send(pbEvents[:count]); err != nil {
+			return status.Error(codes.Internal, "Failed to send events")
+		}
+		if flow != nil {
+			flow.spend(count)
+		}
+		metrics.StreamLagEvents.WithLabelValues(tenant).Observe(float64(max(h.server.HeadID()-pbEvents[count-1].Id, 0)))
+
+		pendingEvents.Sub(float64(count))
+		pendingSize.Sub(float64(size))
+		pbEvents = pbEvents[count:]
+		sizes = sizes[count:]
+		pendingBytes -= size
+	}
+
+	return nil
+}
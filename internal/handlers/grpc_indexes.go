@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	pb "github.com/idot-digital/events-db/grpc"
+	"github.com/idot-digital/events-db/internal/indexes"
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (h *GRPCHandlers) ReadIndexed(ctx context.Context, req *pb.ReadIndexedRequest) (*pb.ReadIndexedReply, error) {
+	if req.Index == "" {
+		return nil, status.Error(codes.InvalidArgument, "Missing index")
+	}
+
+	limit := h.streamBatchSize
+	if req.Limit != nil {
+		limit = min(max(*req.Limit, 1), maxReadLimit)
+	}
+
+	events, done, ready, err := h.indexes.Read(ctx, tenancy.Tenant(ctx), req.Index, req.Value, req.FromPosition, limit)
+	if errors.Is(err, indexes.ErrNotFound) {
+		return nil, status.Error(codes.NotFound, "Index not found")
+	}
+	if err != nil {
+		h.server.GetLogger().Error("Failed to read indexed events", "index", req.Index, "position", req.FromPosition, "error", err)
+		return nil, status.Error(codes.Internal, "Failed to get events")
+	}
+
+	position := req.FromPosition
+	if len(events) > 0 {
+		position = events[len(events)-1].ID
+	}
+
+	events, err = upcastEvents(ctx, h.server, events, req.Upcast)
+	if err != nil {
+		h.server.GetLogger().Error("Failed to upcast events", "index", req.Index, "position", req.FromPosition, "error", err)
+		return nil, status.Error(codes.Internal, "Failed to get events")
+	}
+
+	return &pb.ReadIndexedReply{
+		Events:   toPBEvents(events),
+		Position: position,
+		End:      done,
+		Ready:    ready,
+	}, nil
+}
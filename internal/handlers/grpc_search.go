@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	pb "github.com/idot-digital/events-db/grpc"
+	"github.com/idot-digital/events-db/internal/search"
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (h *GRPCHandlers) Search(ctx context.Context, req *pb.SearchRequest) (*pb.SearchReply, error) {
+	if req.Recursive && req.Subject == "" {
+		return nil, status.Error(codes.InvalidArgument, "Recursive requires a subject")
+	}
+
+	q := search.Query{
+		Subject:   req.Subject,
+		Recursive: req.Recursive,
+		Type:      req.Type,
+		After:     req.After,
+		Text:      req.Text,
+	}
+	if req.Limit != nil {
+		q.Limit = max(*req.Limit, 1)
+	}
+	if req.Since != nil {
+		if err := req.Since.CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "Invalid since")
+		}
+		q.Since = req.Since.AsTime()
+	}
+	if req.Until != nil {
+		if err := req.Until.CheckValid(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "Invalid until")
+		}
+		q.Until = req.Until.AsTime()
+	}
+	for _, where := range req.Where {
+		predicate, err := search.ParsePredicate(where)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "Invalid predicate: "+err.Error())
+		}
+		q.Predicates = append(q.Predicates, predicate)
+	}
+
+	result, err := h.searcher.Search(ctx, tenancy.Tenant(ctx), q)
+	if err != nil {
+		return nil, h.searchError(err)
+	}
+
+	return &pb.SearchReply{
+		Events:   toPBEvents(result.Events),
+		Position: result.Position,
+		Scanned:  result.Scanned,
+		End:      result.End,
+	}, nil
+}
+
+// searchError converts a failed search into a gRPC status
+func (h *GRPCHandlers) searchError(err error) error {
+	var pathErr *search.PathNotSearchableError
+	switch {
+	case errors.Is(err, search.ErrNoCondition):
+		return status.Error(codes.InvalidArgument, "A search needs a predicate or text")
+	case errors.As(err, &pathErr):
+		return status.Error(codes.InvalidArgument, "Path "+pathErr.Path+" is not searchable")
+	case errors.Is(err, search.ErrBusy):
+		return status.Error(codes.ResourceExhausted, "Too many searches running")
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, "Search canceled")
+	}
+	h.server.GetLogger().Error("Failed to search events", "error", err)
+	return status.Error(codes.Internal, "Failed to search events")
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"slices"
+
+	pb "github.com/idot-digital/events-db/grpc"
+	"github.com/idot-digital/events-db/internal/limits"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Subscribe streams the events matching a set of subject filters as one stream
+// in global ID order. The first request must add at least one filter, later
+// requests add or remove filters while the stream stays open.
+func (h *GRPCHandlers) Subscribe(stream pb.EventsDB_SubscribeServer) error {
+	ctx := stream.Context()
+
+	if err := h.limiter.AllowStream(limits.ClientFromContext(ctx)); err != nil {
+		return h.limitError(err)
+	}
+
+	req, err := stream.Recv()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+
+	filters, err := newFilters(tenancy.Tenant(ctx), req, nil, 0)
+	if err != nil {
+		return err
+	}
+	if len(filters) == 0 {
+		return status.Error(codes.InvalidArgument, "At least one filter is required")
+	}
+
+	// The first request decides, later requests only change filters and credits
+	upcast := req.Upcast
+
+	var flow *inbox
+	in := newInbox()
+	if req.FlowControl {
+		flow = in
+		in.push(&pb.SubscribeRequest{Credits: req.Credits})
+	}
+
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				in.fail(err)
+				return
+			}
+			in.push(req)
+		}
+	}()
+
+	sub, err := h.server.Subscribe(ctx, filters, h.streamBatchSize, func(events []*models.Event) error {
+		events, err := upcastEvents(ctx, h.server, events, upcast)
+		if err != nil {
+			return err
+		}
+		return h.sendEvents(ctx, events, flow, func(batch []*pb.Event) error {
+			return stream.Send(&pb.SubscribeReply{Events: batch})
+		})
+	})
+	if err != nil {
+		return h.streamError(err)
+	}
+
+	defer sub.Close()
+
+	for {
+		select {
+		case event := <-sub.Events():
+			if err := sub.Deliver(ctx, event); err != nil {
+				return h.streamError(err)
+			}
+		case <-in.signal:
+			updates, err := in.take()
+			for _, req := range updates {
+				remaining := make([]*server.SubjectFilter, 0, len(sub.Filters()))
+				for _, f := range sub.Filters() {
+					if !slices.Contains(req.Remove, f.ID) {
+						remaining = append(remaining, f)
+					}
+				}
+
+				added, err := newFilters(tenancy.Tenant(ctx), req, remaining, sub.Position())
+				if err != nil {
+					return err
+				}
+				if err := sub.Update(ctx, req.Remove, added); err != nil {
+					return h.streamError(err)
+				}
+			}
+			if err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+			// On io.EOF the client is done changing filters, keep streaming
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// newFilters validates the filters added by a subscription request against the
+// existing ones. Filters without a from_id start at defaultFromID.
+func newFilters(tenant string, req *pb.SubscribeRequest, existing []*server.SubjectFilter, defaultFromID int64) ([]*server.SubjectFilter, error) {
+	ids := make([]string, 0, len(existing)+len(req.Add))
+	for _, f := range existing {
+		ids = append(ids, f.ID)
+	}
+
+	filters := make([]*server.SubjectFilter, 0, len(req.Add))
+	for _, f := range req.Add {
+		if f.Id == "" {
+			return nil, status.Error(codes.InvalidArgument, "Filter id is required")
+		}
+		if f.Subject == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "Filter %q has no subject", f.Id)
+		}
+		if slices.Contains(ids, f.Id) {
+			return nil, status.Errorf(codes.InvalidArgument, "Filter %q already exists", f.Id)
+		}
+		ids = append(ids, f.Id)
+
+		fromID := defaultFromID
+		if f.FromId != nil {
+			fromID = *f.FromId
+		}
+		filters = append(filters, server.NewSubjectFilter(tenant, f.Id, f.Subject, f.Prefix, f.Types, fromID))
+	}
+
+	return filters, nil
+}
@@ -3,26 +3,37 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/blobs"
+	"github.com/idot-digital/events-db/internal/limits"
 	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/schemas"
 	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/shredding"
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"github.com/idot-digital/events-db/internal/tracing"
 )
 
 // HTTPHandlers implements the HTTP server handlers
 type HTTPHandlers struct {
 	server          *server.Server
 	streamBatchSize int32
+	limiter         *limits.Limiter
 }
 
-func NewHTTPHandlers(s *server.Server, streamBatchSize int) *HTTPHandlers {
+func NewHTTPHandlers(s *server.Server, streamBatchSize int, limiter *limits.Limiter) *HTTPHandlers {
 	return &HTTPHandlers{
 		server:          s,
 		streamBatchSize: int32(streamBatchSize),
+		limiter:         limiter,
 	}
 }
 
@@ -38,31 +49,105 @@ func (h *HTTPHandlers) CreateEventHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	client := limits.ClientFromRequest(r)
+	if err := h.limiter.AllowWrite(client, req.Subject); err != nil {
+		h.limitError(w, err)
+		return
+	}
+
+	occurredAt, err := server.ParseOccurredAt(req.OccurredAt)
+	if err != nil {
+		http.Error(w, "Invalid occurred_at, must be an RFC 3339 timestamp", http.StatusBadRequest)
+		return
+	}
+
+	now := server.Now()
+	tenant := tenancy.Tenant(r.Context())
+	origin := server.Origin{
+		Principal:  tenancy.FromContext(r.Context()).Name(),
+		RemoteAddr: client.IP,
+		ReceivedAt: now,
+	}
+	metadata, err := h.server.PrepareMetadata(r.Context(), tenant, origin, req.Metadata, req.CorrelationID, req.CausationID)
+	if err != nil {
+		h.metadataError(w, err)
+		return
+	}
+
+	schemaVersion, err := h.server.ValidateData(r.Context(), tenant, req.Type, req.SchemaVersion, req.DataContentType, req.BlobSHA256, req.Data)
+	if err != nil {
+		h.validationError(w, err)
+		return
+	}
+
+	payload, err := h.server.SealData(r.Context(), tenant, req.Subject, req.DataKey, req.BlobSHA256, req.Data)
+	if err != nil {
+		h.sealError(w, err)
+		return
+	}
+	if err := h.limiter.AllowStorage(r.Context(), tenant, req.Subject, payload.Size()); err != nil {
+		h.limitError(w, err)
+		return
+	}
+
+	traceParent, traceState := tracing.Carrier(r.Context())
 	id, err := h.server.GetQueries().CreateEvent(r.Context(), database.CreateEventParams{
-		Source:  req.Source,
-		Type:    req.Type,
-		Subject: req.Subject,
-		Data:    req.Data,
+		Tenant:          tenant,
+		Source:          req.Source,
+		Type:            req.Type,
+		Subject:         req.Subject,
+		Time:            now,
+		OccurredAt:      occurredAt,
+		Data:            payload.Data,
+		Traceparent:     traceParent,
+		Tracestate:      traceState,
+		DataKey:         payload.DataKey,
+		DataCodec:       payload.Codec,
+		BlobSha256:      blobSHA256(payload.Blob),
+		BlobSize:        blobSize(payload.Blob),
+		Datacontenttype: req.DataContentType,
+		SchemaVersion:   schemaVersion,
+		Metadata:        metadata.Encoded,
+		CorrelationID:   metadata.CorrelationID,
+		CausationID:     metadata.CausationID,
 	})
 	if err != nil {
+		h.limiter.ReleaseStorage(tenant, req.Subject, payload.Size())
 		h.server.GetLogger().Error("Failed to create event", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	h.limiter.RecordWrite(tenant, req.Subject, payload.Size())
 
 	event := &models.Event{
-		ID:      id,
-		Source:  req.Source,
-		Type:    req.Type,
-		Subject: req.Subject,
-		Time:    time.Now().Format(time.RFC3339),
-		Data:    req.Data,
+		ID:              id,
+		Tenant:          tenant,
+		Source:          req.Source,
+		Type:            req.Type,
+		Subject:         req.Subject,
+		Time:            server.FormatTime(now),
+		OccurredAt:      server.FormatOccurredAt(occurredAt),
+		Data:            req.Data,
+		TraceParent:     traceParent,
+		TraceState:      traceState,
+		DataKey:         payload.DataKey,
+		Blob:            payload.Blob,
+		DataContentType: req.DataContentType,
+		DataSchema:      schemas.URI(req.Type, schemaVersion),
+		SchemaVersion:   schemaVersion,
+		Metadata:        metadata.Metadata,
+		CorrelationID:   metadata.CorrelationID,
+		CausationID:     metadata.CausationID,
+	}
+	if payload.Blob != nil {
+		event.Data = nil
 	}
 
+	h.server.IndexEvent(r.Context(), event)
 	h.server.GetEmitterChan() <- event
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(models.CreateEventResponse{ID: id})
+	json.NewEncoder(w).Encode(models.CreateEventResponse{ID: id, Time: event.Time})
 }
 
 func (h *HTTPHandlers) GetEventByIDHandler(w http.ResponseWriter, r *http.Request) {
@@ -83,7 +168,12 @@ func (h *HTTPHandlers) GetEventByIDHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	res, err := h.server.GetQueries().GetEventByID(r.Context(), id)
+	upcast, ok := upcastParam(w, r)
+	if !ok {
+		return
+	}
+
+	event, err := h.server.GetEvent(r.Context(), tenancy.Tenant(r.Context()), id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Event not found", http.StatusNotFound)
@@ -94,113 +184,135 @@ func (h *HTTPHandlers) GetEventByIDHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	time, err := res.Time.MarshalText()
+	events, err := upcastEvents(r.Context(), h.server, []*models.Event{event}, upcast)
 	if err != nil {
-		h.server.GetLogger().Error("Failed to marshal time", "error", err)
+		h.server.GetLogger().Error("Failed to upcast event", "id", id, "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
-	event := models.Event{
-		ID:      res.ID,
-		Source:  res.Source,
-		Type:    res.Type,
-		Subject: res.Subject,
-		Time:    string(time),
-		Data:    res.Data,
-	}
-
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(event)
+	json.NewEncoder(w).Encode(events[0])
 }
 
+// StreamEventsFromSubjectHandler replays the events of a subject as
+// Server-Sent Events and then streams new ones. With until_id or until_time
+// only the events up to the bound are replayed, and with finite=true the
+// events stored so far. These responses end with an end event carrying the
+// position the replay covered.
 func (h *HTTPHandlers) StreamEventsFromSubjectHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	subject := r.URL.Query().Get("subject")
+	query := r.URL.Query()
+	subject := query.Get("subject")
 	if subject == "" {
 		http.Error(w, "Missing subject parameter", http.StatusBadRequest)
 		return
 	}
 
+	fromID := int64(0)
+	if fromStr := query.Get("from_id"); fromStr != "" {
+		var err error
+		fromID, err = strconv.ParseInt(fromStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid from_id parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	prefix := query.Get("prefix") == "true"
+
+	untilID := int64(server.NoUpperBound)
+	if untilStr := query.Get("until_id"); untilStr != "" {
+		var err error
+		untilID, err = strconv.ParseInt(untilStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid until_id parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	var untilTime time.Time
+	if untilStr := query.Get("until_time"); untilStr != "" {
+		var err error
+		untilTime, err = time.Parse(time.RFC3339Nano, untilStr)
+		if err != nil {
+			http.Error(w, "Invalid until_time parameter, must be an RFC 3339 timestamp", http.StatusBadRequest)
+			return
+		}
+	}
+	bounded := query.Get("finite") == "true" || query.Has("until_id") || query.Has("until_time")
+
+	upcast, ok := upcastParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.limiter.AllowStream(limits.ClientFromRequest(r)); err != nil {
+		h.limitError(w, err)
+		return
+	}
+
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
 	clientGone := w.(http.CloseNotifier).CloseNotify()
-	lastID := int64(0)
 
-	for {
-		events, err := h.server.GetQueries().GetEventsBySubject(r.Context(), database.GetEventsBySubjectParams{
-			Subject: subject,
-			Limit:   h.streamBatchSize,
-			ID:      lastID,
-		})
+	// Once the stream was flushed the status was sent, failures are reported as error events
+	started := false
+	filter := server.NewSubjectFilter(tenancy.Tenant(r.Context()), "", subject, prefix, nil, fromID)
+	send := func(events []*models.Event) error {
+		events, err := upcastEvents(r.Context(), h.server, events, upcast)
 		if err != nil {
-			h.server.GetLogger().Error("Failed to get events", "subject", subject, "error", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-
-		if len(events) == 0 {
-			// No events found, but this is not an error - just an empty result
-			break
+			return err
 		}
-
 		for _, event := range events {
-			time, err := event.Time.MarshalText()
+			eventJSON, err := json.Marshal(event)
 			if err != nil {
-				h.server.GetLogger().Error("Failed to marshal time", "error", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
-
-			eventJSON, err := json.Marshal(models.Event{
-				ID:      event.ID,
-				Source:  event.Source,
-				Type:    event.Type,
-				Subject: event.Subject,
-				Time:    string(time),
-				Data:    event.Data,
-			})
-			if err != nil {
-				h.server.GetLogger().Error("Failed to marshal event", "error", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
+				return err
 			}
 
 			fmt.Fprintf(w, "data: %s\n\n", eventJSON)
-			w.(http.Flusher).Flush()
-			lastID = event.ID
 		}
+		w.(http.Flusher).Flush()
+		started = true
+		return nil
 	}
 
-	channel, listener, err := h.server.AttachListener()
+	if bounded {
+		head, err := h.server.Head(r.Context())
+		if err != nil {
+			h.streamError(w, err, "subject", subject)
+			return
+		}
+		untilID = min(untilID, head)
+
+		if _, err := h.server.Replay(r.Context(), filter, untilID, untilTime, h.streamBatchSize, send); err != nil {
+			h.sseError(w, err, started, "subject", subject)
+			return
+		}
+		// Named so EventSource clients can tell it apart and close instead of reconnecting
+		fmt.Fprintf(w, "event: end\ndata: {\"head_position\":%d}\n\n", untilID)
+		w.(http.Flusher).Flush()
+		return
+	}
+
+	sub, err := h.server.Subscribe(r.Context(), []*server.SubjectFilter{filter}, h.streamBatchSize, send)
 	if err != nil {
-		h.server.GetLogger().Error("Failed to attach listener", "subject", subject, "error", err)
-		http.Error(w, "Too many clients for this subject", http.StatusTooManyRequests)
+		h.sseError(w, err, started, "subject", subject)
 		return
 	}
 
-	defer h.server.DetachListener(listener)
+	defer sub.Close()
 	for {
 		select {
-		case event := <-channel:
-			if event.Subject == subject && event.ID > lastID {
-				eventJSON, err := json.Marshal(event)
-				if err != nil {
-					h.server.GetLogger().Error("Failed to marshal event", "error", err)
-					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-					return
-				}
-
-				fmt.Fprintf(w, "data: %s\n\n", eventJSON)
-				w.(http.Flusher).Flush()
-				lastID = event.ID
+		case event := <-sub.Events():
+			if err := sub.Deliver(r.Context(), event); err != nil {
+				h.sseError(w, err, started, "subject", subject)
+				return
 			}
 		case <-clientGone:
 			return
@@ -208,13 +320,142 @@ func (h *HTTPHandlers) StreamEventsFromSubjectHandler(w http.ResponseWriter, r *
 	}
 }
 
+func (h *HTTPHandlers) EraseDataKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.EraseDataKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.DataKey == "" {
+		http.Error(w, "Missing data_key", http.StatusBadRequest)
+		return
+	}
+
+	erased, err := h.server.EraseDataKey(r.Context(), tenancy.Tenant(r.Context()), req.DataKey)
+	if err != nil {
+		if errors.Is(err, server.ErrEncryptionDisabled) {
+			http.Error(w, "Encryption is not enabled", http.StatusConflict)
+			return
+		}
+		h.server.GetLogger().Error("Failed to erase data key", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.EraseDataKeyResponse{Erased: erased})
+}
+
+// validationError reports a payload rejected by its schema, one violation per line
+func (h *HTTPHandlers) validationError(w http.ResponseWriter, err error) {
+	var validationErr *schemas.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		lines := []string{fmt.Sprintf("Payload does not match schema %s version %d", validationErr.Type, validationErr.Version)}
+		for _, violation := range validationErr.Violations {
+			lines = append(lines, "data"+violation.Field+": "+violation.Description)
+		}
+		http.Error(w, strings.Join(lines, "\n"), http.StatusBadRequest)
+	case errors.Is(err, schemas.ErrNotFound):
+		http.Error(w, "Schema version not found", http.StatusBadRequest)
+	default:
+		h.server.GetLogger().Error("Failed to validate event data", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// sealError reports a failure to prepare a payload for storage to the client
+func (h *HTTPHandlers) sealError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, server.ErrEncryptionDisabled):
+		http.Error(w, "Encryption is not enabled, data_key is not supported", http.StatusBadRequest)
+	case errors.Is(err, shredding.ErrKeyErased):
+		http.Error(w, "Data key has been erased", http.StatusConflict)
+	case errors.Is(err, server.ErrPayloadTooLarge), errors.Is(err, blobs.ErrTooLarge):
+		http.Error(w, "Payload too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, server.ErrDataAndBlob):
+		http.Error(w, "data and blob_sha256 are mutually exclusive", http.StatusBadRequest)
+	case errors.Is(err, server.ErrBlobDataKey):
+		http.Error(w, "data_key and blob_sha256 are mutually exclusive, blobs are stored unencrypted", http.StatusBadRequest)
+	case errors.Is(err, server.ErrBlobsDisabled):
+		http.Error(w, "Blob store is not enabled", http.StatusBadRequest)
+	case errors.Is(err, blobs.ErrNotFound):
+		http.Error(w, "Blob not found", http.StatusBadRequest)
+	default:
+		h.server.GetLogger().Error("Failed to prepare event data", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// metadataError reports invalid event metadata to the client
+func (h *HTTPHandlers) metadataError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, server.ErrReservedMetadata):
+		http.Error(w, "Metadata keys starting with eventsdb. are reserved", http.StatusBadRequest)
+	case errors.Is(err, server.ErrMetadataTooLarge):
+		http.Error(w, "Metadata too large", http.StatusBadRequest)
+	case errors.Is(err, server.ErrTrackingIDTooLong):
+		http.Error(w, "correlation_id and causation_id are limited to 255 bytes", http.StatusBadRequest)
+	default:
+		h.server.GetLogger().Error("Failed to prepare event metadata", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// limitError reports a rejection by the limiter to the client
+func (h *HTTPHandlers) limitError(w http.ResponseWriter, err error) {
+	var rateLimited *limits.RateLimitedError
+	var quotaExceeded *limits.QuotaExceededError
+	switch {
+	case errors.As(err, &rateLimited):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rateLimited.RetryAfter.Seconds()))))
+		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+	case errors.As(err, &quotaExceeded):
+		http.Error(w, "Storage quota exceeded", http.StatusTooManyRequests)
+	default:
+		h.server.GetLogger().Error("Failed to check limits", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// streamError logs a failed stream and reports it to the client
+func (h *HTTPHandlers) streamError(w http.ResponseWriter, err error, args ...any) {
+	if errors.Is(err, server.ErrTooManyClients) {
+		h.server.GetLogger().Error("Failed to attach listener", append(args, "error", err)...)
+		http.Error(w, "Too many clients", http.StatusTooManyRequests)
+		return
+	}
+	h.server.GetLogger().Error("Failed to stream events", append(args, "error", err)...)
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// sseError logs a failed event stream and reports it to the client. Once
+// events were sent the status can't be changed anymore, the failure is sent
+// as a named error event then.
+func (h *HTTPHandlers) sseError(w http.ResponseWriter, err error, started bool, args ...any) {
+	if !started {
+		h.streamError(w, err, args...)
+		return
+	}
+	h.server.GetLogger().Error("Failed to stream events", append(args, "error", err)...)
+	fmt.Fprint(w, "event: error\ndata: {\"error\":\"Internal Server Error\"}\n\n")
+	w.(http.Flusher).Flush()
+}
+
 func (h *HTTPHandlers) GetSubjectsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	subjects, err := h.server.GetQueries().GetAvailableSubjects(r.Context())
+	subjects, err := h.server.GetQueries().GetAvailableSubjects(r.Context(), database.GetAvailableSubjectsParams{
+		Tenant: tenancy.Tenant(r.Context()),
+	})
 	if err != nil {
 		h.server.GetLogger().Error("Failed to get subjects", "error", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
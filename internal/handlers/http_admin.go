@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/retention"
+	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/tenancy"
+)
+
+// AdminHandlers implements the REST admin API for managing tenants, their
+// tokens and the retention rules and for inspecting live streams
+type AdminHandlers struct {
+	server   *server.Server
+	registry *tenancy.Registry
+	pruner   *retention.Pruner
+	logger   *slog.Logger
+}
+
+func NewAdminHandlers(s *server.Server, registry *tenancy.Registry, pruner *retention.Pruner, logger *slog.Logger) *AdminHandlers {
+	return &AdminHandlers{
+		server:   s,
+		registry: registry,
+		pruner:   pruner,
+		logger:   logger,
+	}
+}
+
+// TenantsHandler lists (GET), creates (POST) and deletes (DELETE) tenants.
+// Deleting a tenant removes its tokens and events.
+func (h *AdminHandlers) TenantsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := h.registry.List(r.Context())
+		if err != nil {
+			h.logger.Error("Failed to list tenants", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		tenants := make([]models.Tenant, 0, len(rows))
+		for _, row := range rows {
+			tenants = append(tenants, models.Tenant{
+				Name:      row.Name,
+				CreatedAt: row.CreatedAt.Format(time.RFC3339),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tenants)
+
+	case http.MethodPost:
+		var req models.CreateTenantRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		err := h.registry.Create(r.Context(), req.Name)
+		switch {
+		case errors.Is(err, tenancy.ErrInvalidName):
+			http.Error(w, "Invalid tenant name", http.StatusBadRequest)
+			return
+		case errors.Is(err, tenancy.ErrTenantExists):
+			http.Error(w, "Tenant already exists", http.StatusConflict)
+			return
+		case err != nil:
+			h.logger.Error("Failed to create tenant", "tenant", req.Name, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.logger.Info("Created tenant", "tenant", req.Name)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(models.Tenant{Name: req.Name, CreatedAt: time.Now().Format(time.RFC3339)})
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "Missing name parameter", http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := h.registry.Delete(r.Context(), name)
+		switch {
+		case errors.Is(err, tenancy.ErrDefaultTenant):
+			http.Error(w, "The default tenant cannot be deleted", http.StatusBadRequest)
+			return
+		case err != nil:
+			h.logger.Error("Failed to delete tenant", "tenant", name, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		case !deleted:
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+			return
+		}
+
+		h.logger.Info("Deleted tenant", "tenant", name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// TokensHandler lists (GET), issues (POST) and revokes (DELETE) tenant tokens
+func (h *AdminHandlers) TokensHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tenant := r.URL.Query().Get("tenant")
+		if tenant == "" {
+			http.Error(w, "Missing tenant parameter", http.StatusBadRequest)
+			return
+		}
+
+		rows, err := h.registry.Tokens(r.Context(), tenant)
+		if err != nil {
+			h.logger.Error("Failed to list tokens", "tenant", tenant, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		tokens := make([]models.TenantToken, 0, len(rows))
+		for _, row := range rows {
+			tokens = append(tokens, models.TenantToken{
+				ID:          row.ID,
+				Tenant:      row.Tenant,
+				Description: row.Description,
+				CreatedAt:   row.CreatedAt.Format(time.RFC3339),
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tokens)
+
+	case http.MethodPost:
+		var req models.CreateTenantTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		id, token, err := h.registry.CreateToken(r.Context(), req.Tenant, req.Description)
+		switch {
+		case errors.Is(err, tenancy.ErrUnknownTenant):
+			http.Error(w, "Tenant not found", http.StatusNotFound)
+			return
+		case err != nil:
+			h.logger.Error("Failed to create token", "tenant", req.Tenant, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.logger.Info("Created tenant token", "tenant", req.Tenant, "id", id)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(models.CreateTenantTokenResponse{ID: id, Tenant: req.Tenant, Token: token})
+
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid id parameter", http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := h.registry.DeleteToken(r.Context(), id)
+		switch {
+		case err != nil:
+			h.logger.Error("Failed to delete token", "id", id, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		case !deleted:
+			http.Error(w, "Token not found", http.StatusNotFound)
+			return
+		}
+
+		h.logger.Info("Revoked tenant token", "id", id)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// RetentionHandler reports the events the retention rules would delete (GET)
+// or applies the rules right away (POST)
+func (h *AdminHandlers) RetentionHandler(w http.ResponseWriter, r *http.Request) {
+	if h.pruner == nil {
+		http.Error(w, "Retention is not configured", http.StatusNotFound)
+		return
+	}
+
+	var results []retention.Result
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		results, err = h.pruner.Prune(r.Context(), true)
+	case http.MethodPost:
+		results, err = h.pruner.Apply(r.Context())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to apply retention rules", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
+
+// StreamsHandler reports the live streams of all tenants grouped by subject
+// and how far they lag behind the newest event
+func (h *AdminHandlers) StreamsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.server.Streams())
+}
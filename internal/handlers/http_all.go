@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/idot-digital/events-db/internal/limits"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/tenancy"
+)
+
+// ReadAllHandler returns a page of all stored events in global ID order
+func (h *HTTPHandlers) ReadAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	from := int64(0)
+	if fromStr := query.Get("from"); fromStr != "" {
+		var err error
+		from, err = strconv.ParseInt(fromStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid from parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := h.streamBatchSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.ParseInt(limitStr, 10, 32)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = min(int32(parsed), maxReadLimit)
+	}
+
+	upcast, ok := upcastParam(w, r)
+	if !ok {
+		return
+	}
+
+	filter, err := newAllFilter(tenancy.Tenant(r.Context()), query.Get("type"), query.Get("source"), from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, done, err := h.server.Read(r.Context(), filter, limit)
+	if err != nil {
+		h.server.GetLogger().Error("Failed to read events", "position", from, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	events, err = upcastEvents(r.Context(), h.server, events, upcast)
+	if err != nil {
+		h.server.GetLogger().Error("Failed to upcast events", "position", from, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if events == nil {
+		events = []*models.Event{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ReadAllResponse{
+		Events:   events,
+		Position: filter.Position(),
+		End:      done,
+	})
+}
+
+// StreamAllHandler streams all stored events in global ID order and then the
+// new events as they are created. Every message carries the event ID so
+// clients can resume with the Last-Event-ID header.
+func (h *HTTPHandlers) StreamAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+
+	fromStr := query.Get("from")
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		fromStr = lastEventID
+	}
+	from := int64(0)
+	if fromStr != "" {
+		var err error
+		from, err = strconv.ParseInt(fromStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid from parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	filter, err := newAllFilter(tenancy.Tenant(r.Context()), query.Get("type"), query.Get("source"), from)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	upcast, ok := upcastParam(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.limiter.AllowStream(limits.ClientFromRequest(r)); err != nil {
+		h.limitError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	clientGone := w.(http.CloseNotifier).CloseNotify()
+
+	// Once the stream was flushed the status was sent, failures are reported as error events
+	started := false
+	sub, err := h.server.Subscribe(r.Context(), []*server.SubjectFilter{filter}, h.streamBatchSize, func(events []*models.Event) error {
+		events, err := upcastEvents(r.Context(), h.server, events, upcast)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			eventJSON, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.ID, eventJSON)
+		}
+		w.(http.Flusher).Flush()
+		started = true
+		return nil
+	})
+	if err != nil {
+		h.sseError(w, err, started, "position", from)
+		return
+	}
+
+	defer sub.Close()
+	for {
+		select {
+		case event := <-sub.Events():
+			if err := sub.Deliver(r.Context(), event); err != nil {
+				h.sseError(w, err, started, "position", from)
+				return
+			}
+		case <-clientGone:
+			return
+		}
+	}
+}
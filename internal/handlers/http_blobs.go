@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/idot-digital/events-db/internal/blobs"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/tenancy"
+)
+
+// blobHashHeader carries the hex encoded SHA-256 of an uploaded or downloaded blob
+const blobHashHeader = "X-Content-SHA256"
+
+// BlobsHandler uploads a blob with POST and downloads it with GET ?sha256=
+func (h *HTTPHandlers) BlobsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		h.uploadBlob(w, r)
+	case http.MethodGet:
+		h.downloadBlob(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// uploadBlob streams the request body into the blob store
+func (h *HTTPHandlers) uploadBlob(w http.ResponseWriter, r *http.Request) {
+	expected := r.Header.Get(blobHashHeader)
+	if expected != "" && !blobs.ValidHash(expected) {
+		http.Error(w, "Invalid "+blobHashHeader+" header", http.StatusBadRequest)
+		return
+	}
+
+	blob, err := h.server.UploadBlob(r.Context(), tenancy.Tenant(r.Context()), r.Body, expected)
+	if err != nil {
+		switch {
+		case errors.Is(err, blobs.ErrTooLarge):
+			http.Error(w, "Blob too large", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, blobs.ErrHashMismatch):
+			http.Error(w, "Blob does not match "+blobHashHeader, http.StatusBadRequest)
+		case errors.Is(err, server.ErrBlobsDisabled):
+			http.Error(w, "Blob store is not enabled", http.StatusNotFound)
+		default:
+			h.server.GetLogger().Error("Failed to upload blob", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(blob)
+}
+
+// downloadBlob streams a blob to the client. A blob found corrupt while
+// streaming aborts the response, so clients never receive it complete.
+func (h *HTTPHandlers) downloadBlob(w http.ResponseWriter, r *http.Request) {
+	sha256 := r.URL.Query().Get("sha256")
+	if !blobs.ValidHash(sha256) {
+		http.Error(w, "Invalid sha256 parameter", http.StatusBadRequest)
+		return
+	}
+
+	reader, blob, err := h.server.OpenBlob(r.Context(), tenancy.Tenant(r.Context()), sha256)
+	if err != nil {
+		switch {
+		case errors.Is(err, blobs.ErrNotFound):
+			http.Error(w, "Blob not found", http.StatusNotFound)
+		case errors.Is(err, server.ErrBlobsDisabled):
+			http.Error(w, "Blob store is not enabled", http.StatusNotFound)
+		default:
+			h.server.GetLogger().Error("Failed to open blob", "sha256", sha256, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(blob.Size, 10))
+	w.Header().Set(blobHashHeader, blob.SHA256)
+
+	if _, err := io.Copy(w, reader); err != nil {
+		h.server.GetLogger().Error("Failed to download blob", "sha256", sha256, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// blobSHA256 returns the SHA-256 of a blob, or "" for none
+func blobSHA256(blob *models.Blob) string {
+	if blob == nil {
+		return ""
+	}
+	return blob.SHA256
+}
+
+// blobSize returns the size of a blob, or 0 for none
+func blobSize(blob *models.Blob) int64 {
+	if blob == nil {
+		return 0
+	}
+	return blob.Size
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/tenancy"
+)
+
+// ReadCorrelatedHandler returns a page of the stored events sharing a
+// correlation ID, across subjects and in ID order
+func (h *HTTPHandlers) ReadCorrelatedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	correlationID := query.Get("correlation_id")
+	if correlationID == "" {
+		http.Error(w, "Missing correlation_id parameter", http.StatusBadRequest)
+		return
+	}
+
+	from := int64(0)
+	if fromStr := query.Get("from"); fromStr != "" {
+		var err error
+		from, err = strconv.ParseInt(fromStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid from parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := h.streamBatchSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.ParseInt(limitStr, 10, 32)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = min(int32(parsed), maxReadLimit)
+	}
+
+	upcast, ok := upcastParam(w, r)
+	if !ok {
+		return
+	}
+
+	events, done, err := h.server.ReadCorrelated(r.Context(), tenancy.Tenant(r.Context()), correlationID, from, limit)
+	if err != nil {
+		h.server.GetLogger().Error("Failed to read correlated events", "correlation_id", correlationID, "position", from, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	position := from
+	if len(events) > 0 {
+		position = events[len(events)-1].ID
+	}
+
+	events, err = upcastEvents(r.Context(), h.server, events, upcast)
+	if err != nil {
+		h.server.GetLogger().Error("Failed to upcast events", "correlation_id", correlationID, "position", from, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if events == nil {
+		events = []*models.Event{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ReadAllResponse{
+		Events:   events,
+		Position: position,
+		End:      done,
+	})
+}
@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/idot-digital/events-db/internal/indexes"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/tenancy"
+)
+
+// IndexHandlers implements the REST endpoints declaring secondary indexes on
+// payload fields and reading events through them
+type IndexHandlers struct {
+	server          *server.Server
+	indexes         *indexes.Registry
+	streamBatchSize int32
+	logger          *slog.Logger
+}
+
+func NewIndexHandlers(s *server.Server, registry *indexes.Registry, streamBatchSize int, logger *slog.Logger) *IndexHandlers {
+	return &IndexHandlers{
+		server:          s,
+		indexes:         registry,
+		streamBatchSize: int32(streamBatchSize),
+		logger:          logger,
+	}
+}
+
+// IndexesHandler lists (GET), declares (POST) and deletes (DELETE) the
+// indexes of the tenant
+func (h *IndexHandlers) IndexesHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := tenancy.Tenant(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		list, err := h.indexes.List(r.Context(), tenant)
+		if err != nil {
+			h.logger.Error("Failed to list indexes", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+
+	case http.MethodPost:
+		var req models.CreatePayloadIndexRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		index, err := h.indexes.Create(r.Context(), tenant, req.Name, req.Type, req.Path)
+		var pathErr *indexes.InvalidPathError
+		switch {
+		case errors.Is(err, indexes.ErrInvalidName):
+			http.Error(w, "Invalid index name", http.StatusBadRequest)
+			return
+		case errors.Is(err, indexes.ErrInvalidType):
+			http.Error(w, "Invalid index type", http.StatusBadRequest)
+			return
+		case errors.As(err, &pathErr):
+			http.Error(w, pathErr.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, indexes.ErrIndexExists):
+			http.Error(w, "Index already exists", http.StatusConflict)
+			return
+		case err != nil:
+			h.logger.Error("Failed to create index", "name", req.Name, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.logger.Info("Created index", "tenant", tenant, "name", req.Name, "type", req.Type, "path", req.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(index)
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			http.Error(w, "Missing name parameter", http.StatusBadRequest)
+			return
+		}
+
+		err := h.indexes.Delete(r.Context(), tenant, name)
+		switch {
+		case errors.Is(err, indexes.ErrNotFound):
+			http.Error(w, "Index not found", http.StatusNotFound)
+			return
+		case err != nil:
+			h.logger.Error("Failed to delete index", "name", name, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.logger.Info("Deleted index", "tenant", tenant, "name", name)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// ReadIndexedHandler returns a page of the stored events whose payloads
+// hold a value at the path of an index, in ID order
+func (h *IndexHandlers) ReadIndexedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	name := query.Get("index")
+	if name == "" {
+		http.Error(w, "Missing index parameter", http.StatusBadRequest)
+		return
+	}
+	if !query.Has("value") {
+		http.Error(w, "Missing value parameter", http.StatusBadRequest)
+		return
+	}
+	value := query.Get("value")
+
+	from := int64(0)
+	if fromStr := query.Get("from"); fromStr != "" {
+		var err error
+		from, err = strconv.ParseInt(fromStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid from parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	limit := h.streamBatchSize
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.ParseInt(limitStr, 10, 32)
+		if err != nil || parsed < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		limit = min(int32(parsed), maxReadLimit)
+	}
+
+	upcast, ok := upcastParam(w, r)
+	if !ok {
+		return
+	}
+
+	events, done, ready, err := h.indexes.Read(r.Context(), tenancy.Tenant(r.Context()), name, value, from, limit)
+	if errors.Is(err, indexes.ErrNotFound) {
+		http.Error(w, "Index not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.logger.Error("Failed to read indexed events", "index", name, "position", from, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	position := from
+	if len(events) > 0 {
+		position = events[len(events)-1].ID
+	}
+
+	events, err = upcastEvents(r.Context(), h.server, events, upcast)
+	if err != nil {
+		h.logger.Error("Failed to upcast events", "index", name, "position", from, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if events == nil {
+		events = []*models.Event{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.ReadIndexedResponse{
+		Events:   events,
+		Position: position,
+		End:      done,
+		Ready:    ready,
+	})
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/schemas"
+	"github.com/idot-digital/events-db/internal/tenancy"
+)
+
+// SchemaHandlers implements the REST API of the schema registry
+type SchemaHandlers struct {
+	registry *schemas.Registry
+	logger   *slog.Logger
+}
+
+func NewSchemaHandlers(registry *schemas.Registry, logger *slog.Logger) *SchemaHandlers {
+	return &SchemaHandlers{
+		registry: registry,
+		logger:   logger,
+	}
+}
+
+// SchemasHandler lists the versions of the schema of a type or gets one
+// with ?version= (GET) and registers a new version (POST)
+func (h *SchemaHandlers) SchemasHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.getSchemas(w, r)
+	case http.MethodPost:
+		h.registerSchema(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *SchemaHandlers) getSchemas(w http.ResponseWriter, r *http.Request) {
+	tenant := tenancy.Tenant(r.Context())
+	eventType := r.URL.Query().Get("type")
+	if eventType == "" {
+		http.Error(w, "Missing type parameter", http.StatusBadRequest)
+		return
+	}
+
+	versionStr := r.URL.Query().Get("version")
+	if versionStr == "" {
+		list, err := h.registry.List(r.Context(), tenant, eventType)
+		if err != nil {
+			h.logger.Error("Failed to list schemas", "type", eventType, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+		return
+	}
+
+	version, err := strconv.ParseInt(versionStr, 10, 32)
+	if err != nil || version < 0 {
+		http.Error(w, "Invalid version parameter", http.StatusBadRequest)
+		return
+	}
+
+	schema, err := h.registry.Get(r.Context(), tenant, eventType, int32(version))
+	if err != nil {
+		if errors.Is(err, schemas.ErrNotFound) {
+			http.Error(w, "Schema not found", http.StatusNotFound)
+			return
+		}
+		h.logger.Error("Failed to get schema", "type", eventType, "version", version, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schema)
+}
+
+func (h *SchemaHandlers) registerSchema(w http.ResponseWriter, r *http.Request) {
+	schema, mode, ok := decodeSchemaRequest(w, r)
+	if !ok {
+		return
+	}
+
+	registered, err := h.registry.Register(r.Context(), tenancy.Tenant(r.Context()), schema, mode)
+	var invalid *schemas.InvalidSchemaError
+	var incompatible *schemas.IncompatibleError
+	switch {
+	case errors.As(err, &invalid):
+		http.Error(w, "Invalid schema: "+invalid.Err.Error(), http.StatusBadRequest)
+		return
+	case errors.As(err, &incompatible):
+		http.Error(w, "Schema is not "+mode+" compatible with the latest version\n"+strings.Join(incompatible.Problems, "\n"), http.StatusConflict)
+		return
+	case errors.Is(err, schemas.ErrVersionConflict):
+		http.Error(w, "Another version was registered concurrently", http.StatusConflict)
+		return
+	case err != nil:
+		h.logger.Error("Failed to register schema", "type", schema.Type, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("Registered schema", "tenant", tenancy.Tenant(r.Context()), "type", registered.Type, "version", registered.Version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(registered)
+}
+
+// CompatibilityHandler checks a schema against the latest version of its type without registering it
+func (h *SchemaHandlers) CompatibilityHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	schema, mode, ok := decodeSchemaRequest(w, r)
+	if !ok {
+		return
+	}
+
+	problems, err := h.registry.Check(r.Context(), tenancy.Tenant(r.Context()), schema, mode)
+	var invalid *schemas.InvalidSchemaError
+	switch {
+	case errors.As(err, &invalid):
+		http.Error(w, "Invalid schema: "+invalid.Err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		h.logger.Error("Failed to check schema compatibility", "type", schema.Type, "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if problems == nil {
+		problems = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models.CompatibilityResponse{Compatible: len(problems) == 0, Problems: problems})
+}
+
+// decodeSchemaRequest reads a schema and its compatibility mode from the
+// request body, reporting invalid requests to the client
+func decodeSchemaRequest(w http.ResponseWriter, r *http.Request) (models.Schema, string, bool) {
+	var req models.RegisterSchemaRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return models.Schema{}, "", false
+	}
+	if req.Type == "" {
+		http.Error(w, "Missing type", http.StatusBadRequest)
+		return models.Schema{}, "", false
+	}
+	if req.Format == "" {
+		req.Format = schemas.FormatJSON
+	}
+	if req.Compatibility == "" {
+		req.Compatibility = schemas.CompatibilityBackward
+	}
+	if !schemas.ValidCompatibility(req.Compatibility) {
+		http.Error(w, "Invalid compatibility, must be none, backward, forward or full", http.StatusBadRequest)
+		return models.Schema{}, "", false
+	}
+
+	return models.Schema{
+		Type:       req.Type,
+		Format:     req.Format,
+		Schema:     req.Schema,
+		Descriptor: req.Descriptor,
+		Message:    req.Message,
+	}, req.Compatibility, true
+}
+
+// UpcastersHandler lists the upcasters of a type (GET), registers or replaces
+// the upcaster of a version (POST) and removes it (DELETE)
+func (h *SchemaHandlers) UpcastersHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := tenancy.Tenant(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		eventType := r.URL.Query().Get("type")
+		if eventType == "" {
+			http.Error(w, "Missing type parameter", http.StatusBadRequest)
+			return
+		}
+
+		upcasters, err := h.registry.ListUpcasters(r.Context(), tenant, eventType)
+		if err != nil {
+			h.logger.Error("Failed to list upcasters", "type", eventType, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(upcasters)
+
+	case http.MethodPost:
+		var req models.Upcaster
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Type == "" {
+			http.Error(w, "Missing type", http.StatusBadRequest)
+			return
+		}
+
+		upcaster, err := h.registry.PutUpcaster(r.Context(), tenant, req)
+		var invalid *schemas.InvalidUpcasterError
+		switch {
+		case errors.As(err, &invalid):
+			http.Error(w, "Invalid upcaster: "+invalid.Err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, schemas.ErrNotFound):
+			http.Error(w, "Schema version "+strconv.Itoa(int(req.FromVersion)+1)+" not found", http.StatusBadRequest)
+			return
+		case err != nil:
+			h.logger.Error("Failed to register upcaster", "type", req.Type, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.logger.Info("Registered upcaster", "tenant", tenant, "type", upcaster.Type, "from_version", upcaster.FromVersion)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(upcaster)
+
+	case http.MethodDelete:
+		eventType := r.URL.Query().Get("type")
+		if eventType == "" {
+			http.Error(w, "Missing type parameter", http.StatusBadRequest)
+			return
+		}
+		fromVersion, err := strconv.ParseInt(r.URL.Query().Get("from_version"), 10, 32)
+		if err != nil || fromVersion < 0 {
+			http.Error(w, "Invalid from_version parameter", http.StatusBadRequest)
+			return
+		}
+
+		deleted, err := h.registry.DeleteUpcaster(r.Context(), tenant, eventType, int32(fromVersion))
+		if err != nil {
+			h.logger.Error("Failed to delete upcaster", "type", eventType, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !deleted {
+			http.Error(w, "Upcaster not found", http.StatusNotFound)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
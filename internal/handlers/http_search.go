@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/idot-digital/events-db/internal/search"
+	"github.com/idot-digital/events-db/internal/tenancy"
+)
+
+// SearchHandlers implements the REST endpoint searching event payloads
+type SearchHandlers struct {
+	searcher *search.Searcher
+	logger   *slog.Logger
+}
+
+func NewSearchHandlers(searcher *search.Searcher, logger *slog.Logger) *SearchHandlers {
+	return &SearchHandlers{
+		searcher: searcher,
+		logger:   logger,
+	}
+}
+
+// SearchHandler returns the events of a bounded range whose payloads match
+// all where predicates and contain all words of q
+func (h *SearchHandlers) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	q := search.Query{
+		Subject:   query.Get("subject"),
+		Recursive: query.Get("recursive") == "true",
+		Type:      query.Get("type"),
+		Text:      query.Get("q"),
+	}
+	if q.Recursive && q.Subject == "" {
+		http.Error(w, "recursive requires a subject", http.StatusBadRequest)
+		return
+	}
+	for name, target := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				http.Error(w, "Invalid "+name+" parameter, must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*target = parsed
+		}
+	}
+	if afterStr := query.Get("after"); afterStr != "" {
+		after, err := strconv.ParseInt(afterStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid after parameter", http.StatusBadRequest)
+			return
+		}
+		q.After = after
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		limit, err := strconv.ParseInt(limitStr, 10, 32)
+		if err != nil || limit < 1 {
+			http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+		q.Limit = int32(limit)
+	}
+	for _, where := range query["where"] {
+		predicate, err := search.ParsePredicate(where)
+		if err != nil {
+			http.Error(w, "Invalid where parameter: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.Predicates = append(q.Predicates, predicate)
+	}
+
+	result, err := h.searcher.Search(r.Context(), tenancy.Tenant(r.Context()), q)
+	if err != nil {
+		var pathErr *search.PathNotSearchableError
+		switch {
+		case errors.Is(err, search.ErrNoCondition):
+			http.Error(w, "A search needs a where or q parameter", http.StatusBadRequest)
+		case errors.As(err, &pathErr):
+			http.Error(w, "Path "+pathErr.Path+" is not searchable", http.StatusBadRequest)
+		case errors.Is(err, search.ErrBusy):
+			http.Error(w, "Too many searches running", http.StatusTooManyRequests)
+		case errors.Is(err, context.Canceled):
+		default:
+			h.logger.Error("Failed to search events", "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
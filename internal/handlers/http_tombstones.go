@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/idot-digital/events-db/internal/limits"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"github.com/idot-digital/events-db/internal/tombstones"
+)
+
+// defaultAuditLimit is the number of audit entries returned per page by default
+const defaultAuditLimit = 100
+
+// TombstoneHandlers implements the REST admin API removing events and
+// reading the audit trail of the removals
+type TombstoneHandlers struct {
+	tombstones *tombstones.Tombstoner
+	logger     *slog.Logger
+}
+
+func NewTombstoneHandlers(tombstoner *tombstones.Tombstoner, logger *slog.Logger) *TombstoneHandlers {
+	return &TombstoneHandlers{
+		tombstones: tombstoner,
+		logger:     logger,
+	}
+}
+
+// TombstonesHandler returns a page of the audit trail of the tenant (GET)
+// and replaces an event or the events of a subject by tombstones (POST)
+func (h *TombstoneHandlers) TombstonesHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := tenancy.Tenant(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		after := int64(0)
+		if afterStr := query.Get("after"); afterStr != "" {
+			var err error
+			after, err = strconv.ParseInt(afterStr, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid after parameter", http.StatusBadRequest)
+				return
+			}
+		}
+
+		limit := int32(defaultAuditLimit)
+		if limitStr := query.Get("limit"); limitStr != "" {
+			parsed, err := strconv.ParseInt(limitStr, 10, 32)
+			if err != nil || parsed < 1 {
+				http.Error(w, "Invalid limit parameter", http.StatusBadRequest)
+				return
+			}
+			limit = min(int32(parsed), maxReadLimit)
+		}
+
+		entries, done, err := h.tombstones.Audit(r.Context(), tenant, after, limit)
+		if err != nil {
+			h.logger.Error("Failed to read audit trail", "position", after, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		position := after
+		if len(entries) > 0 {
+			position = entries[len(entries)-1].ID
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(models.AuditResponse{
+			Entries:  entries,
+			Position: position,
+			End:      done,
+		})
+
+	case http.MethodPost:
+		var req models.TombstoneRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		result, err := h.tombstones.Tombstone(r.Context(), tombstones.Request{
+			Tenant:           tenant,
+			TombstoneRequest: req,
+			Principal:        tenancy.FromContext(r.Context()).Name(),
+			RemoteAddr:       limits.ClientFromRequest(r).IP,
+		})
+		switch {
+		case errors.Is(err, tombstones.ErrNoTarget),
+			errors.Is(err, tombstones.ErrAmbiguousTarget),
+			errors.Is(err, tombstones.ErrInvalidReason),
+			errors.Is(err, tombstones.ErrInvalidActor):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, tombstones.ErrNotFound):
+			http.Error(w, "Event not found or archived, archived events can't be tombstoned", http.StatusNotFound)
+			return
+		case errors.Is(err, tombstones.ErrAlreadyTombstoned):
+			http.Error(w, "Event already tombstoned", http.StatusConflict)
+			return
+		case err != nil:
+			h.logger.Error("Failed to tombstone events", "event_id", req.EventID, "subject", req.Subject, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.logger.Info("Tombstoned events", "tenant", tenant, "tombstone", result.Tombstone.ID, "action", result.Tombstone.Action,
+			"event_id", req.EventID, "subject", req.Subject, "events", result.Events, "actor", result.Tombstone.Actor)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(result)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/idot-digital/events-db/internal/blobs"
+	"github.com/idot-digital/events-db/internal/limits"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/shredding"
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"github.com/idot-digital/events-db/internal/transfer"
+)
+
+// exportBatchSize is the number of events read per query during exports
+const exportBatchSize = 500
+
+// TransferHandlers implements the REST endpoints exporting events into files
+// and importing them again
+type TransferHandlers struct {
+	server   *server.Server
+	importer *transfer.Importer
+}
+
+func NewTransferHandlers(s *server.Server, importer *transfer.Importer) *TransferHandlers {
+	return &TransferHandlers{
+		server:   s,
+		importer: importer,
+	}
+}
+
+// ExportHandler streams the events of the tenant in a subject tree, a time
+// range or all of them as NDJSON or a CloudEvents batch, optionally gzip
+// compressed. Failures before the response body started are answered with
+// a 500, later ones abort the response, so partial exports are noticed.
+func (h *TransferHandlers) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = transfer.FormatNDJSON
+	}
+	if !transfer.ValidFormat(format) {
+		http.Error(w, "Invalid format parameter, must be ndjson or cloudevents", http.StatusBadRequest)
+		return
+	}
+
+	exportRange := transfer.Range{
+		Subject:   query.Get("subject"),
+		Recursive: query.Get("recursive") == "true",
+	}
+	if exportRange.Recursive && exportRange.Subject == "" {
+		http.Error(w, "recursive requires a subject", http.StatusBadRequest)
+		return
+	}
+	for name, target := range map[string]*time.Time{"since": &exportRange.Since, "until": &exportRange.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				http.Error(w, "Invalid "+name+" parameter, must be an RFC 3339 timestamp", http.StatusBadRequest)
+				return
+			}
+			*target = parsed
+		}
+	}
+	if afterStr := query.Get("after"); afterStr != "" {
+		after, err := strconv.ParseInt(afterStr, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid after parameter", http.StatusBadRequest)
+			return
+		}
+		exportRange.After = after
+	}
+
+	filename := "events." + format
+	if format == transfer.FormatCloudEvents {
+		filename = "events.json"
+	}
+	body := &startedWriter{w: w}
+	var out io.Writer = body
+	var gz *gzip.Writer
+	if query.Get("gzip") == "true" {
+		gz = gzip.NewWriter(body)
+		out = gz
+		filename += ".gz"
+		w.Header().Set("Content-Type", "application/gzip")
+	} else {
+		w.Header().Set("Content-Type", transfer.ContentType(format))
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	tenant := tenancy.Tenant(r.Context())
+	encoder := transfer.NewEncoder(out, format)
+	err := transfer.Export(r.Context(), h.server, tenant, exportRange, exportBatchSize, func(events []*models.Event) error {
+		for _, event := range events {
+			if err := encoder.Encode(transfer.FromEvent(event)); err != nil {
+				return err
+			}
+		}
+		return encoder.Flush()
+	})
+	if err == nil {
+		err = encoder.Close()
+	}
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			h.server.GetLogger().Error("Failed to export events", "tenant", tenant, "error", err)
+		}
+		if !body.started {
+			w.Header().Del("Content-Disposition")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		// The status was sent already, breaking the connection tells the client
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// startedWriter records whether a response body was started, after which
+// the status can't be changed anymore
+type startedWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (s *startedWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		s.started = true
+	}
+	return s.w.Write(p)
+}
+
+// ImportHandler stores the events of an export file sent as the request body,
+// in either format and optionally gzip compressed. Preserving the IDs of the
+// file requires the admin token. The response is NDJSON with
+// a progress line every second and a final one, which reports the error if
+// the import stopped early. Repeating an import with the same import_id
+// skips the events it stored before.
+func (h *TransferHandlers) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	importID := query.Get("import_id")
+	if importID == "" || len(importID) > 255 {
+		http.Error(w, "Missing or invalid import_id parameter", http.StatusBadRequest)
+		return
+	}
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = transfer.ModeRemap
+	}
+	if !transfer.ValidMode(mode) {
+		http.Error(w, "Invalid mode parameter, must be remap or preserve", http.StatusBadRequest)
+		return
+	}
+	// Preserved IDs move the ID sequence shared by all tenants
+	if mode == transfer.ModePreserve && !tenancy.FromContext(r.Context()).Admin {
+		http.Error(w, "Forbidden - Admin token required for mode preserve", http.StatusForbidden)
+		return
+	}
+
+	decoder, err := transfer.NewDecoder(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid import file", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	report := func(progress models.ImportProgress) {
+		encoder.Encode(progress)
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+
+	client := limits.ClientFromRequest(r)
+	progress, err := h.importer.Import(r.Context(), client, importID, mode, decoder, report)
+	if err != nil {
+		progress.Error = h.importError(err, client.Tenant, importID)
+	}
+	report(progress)
+}
+
+// importError logs a failed import and returns the reason to report
+func (h *TransferHandlers) importError(err error, tenant string, importID string) string {
+	var invalid *transfer.InvalidRecordError
+	var quotaExceeded *limits.QuotaExceededError
+	switch {
+	case errors.As(err, &invalid):
+		return invalid.Error()
+	case errors.As(err, &quotaExceeded):
+		return "Storage quota exceeded"
+	case errors.Is(err, server.ErrEncryptionDisabled):
+		return "Encryption is not enabled, data keys are not supported"
+	case errors.Is(err, shredding.ErrKeyErased):
+		return "Data key has been erased"
+	case errors.Is(err, server.ErrPayloadTooLarge), errors.Is(err, blobs.ErrTooLarge):
+		return "Payload too large"
+	case errors.Is(err, server.ErrDataAndBlob):
+		return "data and blobsha256 are mutually exclusive"
+	case errors.Is(err, server.ErrBlobDataKey):
+		return "datakey and blobsha256 are mutually exclusive, blobs are stored unencrypted"
+	case errors.Is(err, server.ErrBlobsDisabled):
+		return "Blob store is not enabled"
+	case errors.Is(err, blobs.ErrNotFound):
+		return "Blob not found, upload it before importing the event"
+	case errors.Is(err, context.Canceled):
+		return "Import canceled"
+	default:
+		h.server.GetLogger().Error("Failed to import events", "tenant", tenant, "import_id", importID, "error", err)
+		return "Internal Server Error"
+	}
+}
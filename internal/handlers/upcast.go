@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+)
+
+// upcastEvents transforms the payloads of events into the latest schema
+// version of their type when the client asked for it
+func upcastEvents(ctx context.Context, s *server.Server, events []*models.Event, upcast bool) ([]*models.Event, error) {
+	if !upcast {
+		return events, nil
+	}
+	return s.Upcast(ctx, events)
+}
+
+// upcastParam reads the upcast query parameter, reporting invalid values to the client
+func upcastParam(w http.ResponseWriter, r *http.Request) (bool, bool) {
+	value := r.URL.Query().Get("upcast")
+	if value == "" {
+		return false, true
+	}
+	upcast, err := strconv.ParseBool(value)
+	if err != nil {
+		http.Error(w, "Invalid upcast parameter", http.StatusBadRequest)
+		return false, false
+	}
+	return upcast, true
+}
@@ -0,0 +1,113 @@
+package indexes
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/jsonpath"
+	"github.com/idot-digital/events-db/internal/metrics"
+)
+
+const (
+	// backfillInterval is how often pending indexes are backfilled
+	backfillInterval = 10 * time.Second
+	// backfillWindow is the range of event IDs read per query, bounding the
+	// rows each query scans however rare the indexed type is
+	backfillWindow = 1000
+	// backfillPause is the pause between windows to spare the primary workload
+	backfillPause = 50 * time.Millisecond
+)
+
+// Run backfills the pending indexes every backfillInterval, and right after
+// an index was declared, until the context is done
+func (r *Registry) Run(ctx context.Context) {
+	ticker := time.NewTicker(backfillInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.Backfill(ctx); err != nil && ctx.Err() == nil {
+			r.logger.Error("Failed to backfill indexes", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// Backfill indexes the stored events of every pending index up to the newest event
+func (r *Registry) Backfill(ctx context.Context) error {
+	rows, err := r.queries.ListPendingPayloadIndexes(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if err := r.backfill(ctx, row); err != nil {
+			return fmt.Errorf("index %s of tenant %s: %w", row.Name, row.Tenant, err)
+		}
+	}
+	return nil
+}
+
+// backfill indexes the stored events of an index window by window, recording
+// its position after each. It stops when the position was changed meanwhile,
+// by a failed append rewinding the index or by another server instance.
+func (r *Registry) backfill(ctx context.Context, row database.PayloadIndex) error {
+	path, err := jsonpath.Parse(row.Path)
+	if err != nil {
+		return err
+	}
+	definition := definition{row: row, path: path}
+
+	position := row.Position
+	for {
+		head, err := r.queries.GetLastEventID(ctx)
+		if err != nil {
+			return err
+		}
+		if position >= head {
+			// Instances that cached the indexes of the type before the index
+			// was declared don't index new events until their cache expires
+			_, err := r.queries.MarkPayloadIndexReady(ctx, database.MarkPayloadIndexReadyParams{
+				ID:            row.ID,
+				Head:          head,
+				SettleSeconds: int64(cacheTTL / time.Second),
+			})
+			return err
+		}
+
+		until := min(position+backfillWindow, head)
+		events, err := r.server.ReadTypeRange(ctx, row.Tenant, row.Type, position, until)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := r.index(ctx, definition, event); err != nil {
+				return err
+			}
+		}
+		metrics.IndexedEvents.WithLabelValues(row.Tenant, "backfill").Add(float64(len(events)))
+
+		advanced, err := r.queries.AdvancePayloadIndex(ctx, database.AdvancePayloadIndexParams{
+			Position:         until,
+			ID:               row.ID,
+			PreviousPosition: position,
+		})
+		if err != nil || advanced == 0 {
+			return err
+		}
+		position = until
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backfillPause):
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Package indexes maintains secondary indexes on the payload fields of event
+// types. An index maps the values at a JSON path of the payloads of one type
+// to the events holding them. New events are indexed as they are appended,
+// the events stored before an index was declared by a background backfill.
+package indexes
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/jsonpath"
+	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+)
+
+// cacheTTL is how long the cached indexes of a type are trusted. Indexes
+// declared on another server instance are maintained on append afterwards.
+const cacheTTL = 30 * time.Second
+
+// maxCacheEntries bounds the cache of the indexes per tenant and type
+const maxCacheEntries = 10000
+
+// maxValueLength is the size of the value column, longer values are not indexed
+const maxValueLength = 255
+
+// mysqlDuplicateEntry is the MySQL error number of unique key violations
+const mysqlDuplicateEntry = 1062
+
+// deleteBatchSize is the number of entries removed per statement when an
+// index is deleted
+const deleteBatchSize = 5000
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+var (
+	// ErrInvalidName is returned for index names not matching namePattern
+	ErrInvalidName = errors.New("index names must be 1 to 64 lower case letters, digits, _ and -, starting with a letter or digit")
+	// ErrInvalidType is returned for missing or too long event types
+	ErrInvalidType = errors.New("index type must be 1 to 255 bytes")
+	// ErrIndexExists is returned when the tenant has an index of the name
+	ErrIndexExists = errors.New("index already exists")
+	// ErrNotFound is returned for indexes that don't exist
+	ErrNotFound = errors.New("index not found")
+)
+
+// InvalidPathError is returned for paths outside the supported JSON path subset
+type InvalidPathError struct {
+	Err error
+}
+
+func (e *InvalidPathError) Error() string {
+	return "invalid index path: " + e.Err.Error()
+}
+
+func (e *InvalidPathError) Unwrap() error {
+	return e.Err
+}
+
+// definition is a declared index with its parsed path
+type definition struct {
+	row  database.PayloadIndex
+	path jsonpath.Path
+}
+
+type typeEntry struct {
+	definitions []definition
+	loadedAt    time.Time
+}
+
+// Registry declares indexes, maintains them and looks values up in them
+type Registry struct {
+	server  *server.Server
+	queries *database.Queries
+	logger  *slog.Logger
+	mutex   sync.Mutex
+	types   map[string]typeEntry
+	// wake starts a backfill right away after an index was declared
+	wake chan struct{}
+}
+
+func NewRegistry(s *server.Server, queries *database.Queries, logger *slog.Logger) *Registry {
+	return &Registry{
+		server:  s,
+		queries: queries,
+		logger:  logger,
+		types:   make(map[string]typeEntry),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Create declares an index of a tenant on a JSON path of the payloads of an
+// event type. The existing events are indexed in the background.
+func (r *Registry) Create(ctx context.Context, tenant string, name string, eventType string, path string) (*models.PayloadIndex, error) {
+	if !namePattern.MatchString(name) {
+		return nil, ErrInvalidName
+	}
+	if eventType == "" || len(eventType) > 255 {
+		return nil, ErrInvalidType
+	}
+	if len(path) > 255 {
+		return nil, &InvalidPathError{Err: errors.New("path is longer than 255 bytes")}
+	}
+	if _, err := jsonpath.Parse(path); err != nil {
+		return nil, &InvalidPathError{Err: err}
+	}
+
+	_, err := r.queries.CreatePayloadIndex(ctx, database.CreatePayloadIndexParams{
+		Tenant: tenant,
+		Name:   name,
+		Type:   eventType,
+		Path:   path,
+	})
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return nil, ErrIndexExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.forget(tenant, eventType)
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+
+	row, err := r.queries.GetPayloadIndex(ctx, database.GetPayloadIndexParams{
+		Tenant: tenant,
+		Name:   name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return toModel(row), nil
+}
+
+// List returns the indexes of a tenant ordered by name
+func (r *Registry) List(ctx context.Context, tenant string) ([]models.PayloadIndex, error) {
+	rows, err := r.queries.ListPayloadIndexes(ctx, tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]models.PayloadIndex, 0, len(rows))
+	for _, row := range rows {
+		indexes = append(indexes, *toModel(row))
+	}
+	return indexes, nil
+}
+
+// Delete removes an index of a tenant with its entries
+func (r *Registry) Delete(ctx context.Context, tenant string, name string) error {
+	row, err := r.get(ctx, tenant, name)
+	if err != nil {
+		return err
+	}
+
+	// The definition goes first, so appends stop adding entries to the index
+	if err := r.queries.DeletePayloadIndex(ctx, row.ID); err != nil {
+		return err
+	}
+	r.forget(tenant, row.Type)
+
+	for {
+		deleted, err := r.queries.DeletePayloadIndexEntries(ctx, database.DeletePayloadIndexEntriesParams{
+			IndexID: row.ID,
+			Limit:   deleteBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+		if deleted < deleteBatchSize {
+			return nil
+		}
+	}
+}
+
+// Read returns the next page of at most limit stored events of a tenant
+// whose payloads hold a value at the path of an index, after the event
+// fromID. done is set once no events are left, ready is unset while the
+// index is backfilled and older events may be missing.
+func (r *Registry) Read(ctx context.Context, tenant string, name string, value string, fromID int64, limit int32) (events []*models.Event, done bool, ready bool, err error) {
+	row, err := r.get(ctx, tenant, name)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	events, done, err = r.server.ReadIndexed(ctx, tenant, row.ID, value, fromID, limit)
+	return events, done, row.Ready, err
+}
+
+// Index adds a stored event with its payload in plain form to the indexes of
+// its type. When that fails the indexes are rewound to before the event, so
+// the backfill indexes it again.
+func (r *Registry) Index(ctx context.Context, event *models.Event) error {
+	definitions, err := r.definitions(ctx, event.Tenant, event.Type)
+	if err != nil || len(definitions) == 0 {
+		return err
+	}
+
+	for _, definition := range definitions {
+		if err := r.index(ctx, definition, event); err != nil {
+			metrics.IndexFailures.WithLabelValues(event.Tenant).Inc()
+			// Rewinding with a context of its own as a cancelled request may have caused the failure
+			rewindErr := r.queries.RewindPayloadIndex(context.WithoutCancel(ctx), database.RewindPayloadIndexParams{
+				ID:       definition.row.ID,
+				Position: event.ID - 1,
+			})
+			return errors.Join(fmt.Errorf("index %s: %w", definition.row.Name, err), rewindErr)
+		}
+	}
+	metrics.IndexedEvents.WithLabelValues(event.Tenant, "append").Inc()
+	return nil
+}
+
+// index stores the entries of an event in an index
+func (r *Registry) index(ctx context.Context, definition definition, event *models.Event) error {
+	for _, value := range values(definition.path, event) {
+		err := r.queries.CreatePayloadIndexEntry(ctx, database.CreatePayloadIndexEntryParams{
+			IndexID: definition.row.ID,
+			Value:   value,
+			EventID: event.ID,
+			Tenant:  event.Tenant,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// values returns the values of an event at an index path: the scalar there
+// or the scalars of the array there, as text. Payloads that aren't JSON,
+// objects, nulls and values longer than maxValueLength are not indexed, nor
+// are encrypted payloads, whose values would outlive an erased data key.
+func values(path jsonpath.Path, event *models.Event) []string {
+	if event.Redacted || event.Blob != nil || event.DataKey != "" || len(event.Data) == 0 {
+		return nil
+	}
+	document, err := jsonpath.Decode(event.Data)
+	if err != nil {
+		return nil
+	}
+	value, ok := path.Lookup(document)
+	if !ok {
+		return nil
+	}
+
+	candidates := []any{value}
+	if array, ok := value.([]any); ok {
+		candidates = array
+	}
+
+	var found []string
+	seen := make(map[string]struct{}, len(candidates))
+	for _, candidate := range candidates {
+		var text string
+		switch candidate := candidate.(type) {
+		case string:
+			text = candidate
+		case json.Number:
+			text = candidate.String()
+		case bool:
+			text = fmt.Sprint(candidate)
+		default:
+			continue
+		}
+		if _, ok := seen[text]; ok || len(text) > maxValueLength {
+			continue
+		}
+		seen[text] = struct{}{}
+		found = append(found, text)
+	}
+	return found
+}
+
+// get returns an index of a tenant, or ErrNotFound
+func (r *Registry) get(ctx context.Context, tenant string, name string) (database.PayloadIndex, error) {
+	row, err := r.queries.GetPayloadIndex(ctx, database.GetPayloadIndexParams{
+		Tenant: tenant,
+		Name:   name,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return row, ErrNotFound
+	}
+	return row, err
+}
+
+// definitions returns the indexes of a tenant and event type
+func (r *Registry) definitions(ctx context.Context, tenant string, eventType string) ([]definition, error) {
+	key := tenant + "/" + eventType
+
+	r.mutex.Lock()
+	entry, ok := r.types[key]
+	r.mutex.Unlock()
+	if ok && time.Since(entry.loadedAt) < cacheTTL {
+		return entry.definitions, nil
+	}
+
+	rows, err := r.queries.ListPayloadIndexesByType(ctx, database.ListPayloadIndexesByTypeParams{
+		Tenant: tenant,
+		Type:   eventType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := make([]definition, 0, len(rows))
+	for _, row := range rows {
+		path, err := jsonpath.Parse(row.Path)
+		if err != nil {
+			return nil, fmt.Errorf("index %s: %w", row.Name, err)
+		}
+		definitions = append(definitions, definition{row: row, path: path})
+	}
+
+	r.mutex.Lock()
+	if len(r.types) >= maxCacheEntries {
+		r.types = make(map[string]typeEntry)
+	}
+	r.types[key] = typeEntry{definitions: definitions, loadedAt: time.Now()}
+	r.mutex.Unlock()
+
+	return definitions, nil
+}
+
+// forget drops the cached indexes of a tenant and event type
+func (r *Registry) forget(tenant string, eventType string) {
+	r.mutex.Lock()
+	delete(r.types, tenant+"/"+eventType)
+	r.mutex.Unlock()
+}
+
+func toModel(row database.PayloadIndex) *models.PayloadIndex {
+	return &models.PayloadIndex{
+		Name:      row.Name,
+		Type:      row.Type,
+		Path:      row.Path,
+		Position:  row.Position,
+		Ready:     row.Ready,
+		CreatedAt: row.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,105 @@
+// Package jsonpath evaluates the JSON path subset used to address payload
+// fields in searches and secondary indexes
+package jsonpath
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a parsed JSON path like $.order.items[0].sku
+type Path struct {
+	expression string
+	// steps are object keys (string) and array indexes (int)
+	steps []any
+}
+
+func (p Path) String() string {
+	return p.expression
+}
+
+// Parse parses the supported JSON path subset: $ followed by .key and
+// [index] steps, keys consisting of ASCII letters, digits, _ and -
+func Parse(expression string) (Path, error) {
+	if !strings.HasPrefix(expression, "$") {
+		return Path{}, errors.New("path must start with $")
+	}
+
+	path := Path{expression: expression}
+	rest := expression[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := 1
+			for end < len(rest) && isKeyByte(rest[end]) {
+				end++
+			}
+			if end == 1 {
+				return Path{}, fmt.Errorf("empty key in path %s", expression)
+			}
+			path.steps = append(path.steps, rest[1:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return Path{}, fmt.Errorf("unclosed [ in path %s", expression)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return Path{}, fmt.Errorf("invalid array index in path %s", expression)
+			}
+			path.steps = append(path.steps, index)
+			rest = rest[end+1:]
+		default:
+			return Path{}, fmt.Errorf("unexpected %q in path %s", rest[0], expression)
+		}
+	}
+	return path, nil
+}
+
+func isKeyByte(b byte) bool {
+	return b == '_' || b == '-' || 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9'
+}
+
+// Lookup returns the value at the path in a document decoded by Decode
+func (p Path) Lookup(document any) (any, bool) {
+	value := document
+	for _, step := range p.steps {
+		switch step := step.(type) {
+		case string:
+			object, ok := value.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[step]; !ok {
+				return nil, false
+			}
+		case int:
+			array, ok := value.([]any)
+			if !ok || step >= len(array) {
+				return nil, false
+			}
+			value = array[step]
+		}
+	}
+	return value, true
+}
+
+// Decode decodes a JSON document keeping numbers exact as json.Number
+func Decode(raw []byte) (any, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, errors.New("trailing data after JSON value")
+	}
+	return value, nil
+}
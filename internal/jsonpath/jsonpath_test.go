@@ -0,0 +1,135 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		expression string
+		steps      []any
+		wantErr    bool
+	}{
+		{expression: "$"},
+		{expression: "$.orderId", steps: []any{"orderId"}},
+		{expression: "$.order.items[0].sku", steps: []any{"order", "items", 0, "sku"}},
+		{expression: "$.order_id-2", steps: []any{"order_id-2"}},
+		{expression: "$[12]", steps: []any{12}},
+		{expression: "$.matrix[1][2]", steps: []any{"matrix", 1, 2}},
+		{expression: "", wantErr: true},
+		{expression: "orderId", wantErr: true},
+		{expression: "$.", wantErr: true},
+		{expression: "$..orderId", wantErr: true},
+		{expression: "$.order id", wantErr: true},
+		{expression: "$orderId", wantErr: true},
+		{expression: "$.items[0", wantErr: true},
+		{expression: "$.items[]", wantErr: true},
+		{expression: "$.items[-1]", wantErr: true},
+		{expression: "$.items[x]", wantErr: true},
+		{expression: `$["orderId"]`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			path, err := Parse(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %v, want error", tt.expression, path.steps)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.expression, err)
+			}
+			if !reflect.DeepEqual(path.steps, tt.steps) {
+				t.Errorf("steps = %#v, want %#v", path.steps, tt.steps)
+			}
+			if path.String() != tt.expression {
+				t.Errorf("String() = %q, want %q", path.String(), tt.expression)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	document, err := Decode([]byte(`{
+		"orderId": "123",
+		"total": 10.50,
+		"note": null,
+		"order": {"items": [{"sku": "m-1"}, {"sku": "m-2"}]},
+		"matrix": [[1, 2], [3, 4]]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expression string
+		want       any
+		ok         bool
+	}{
+		{expression: "$", want: document, ok: true},
+		{expression: "$.orderId", want: "123", ok: true},
+		{expression: "$.total", want: json.Number("10.50"), ok: true},
+		{expression: "$.note", want: nil, ok: true},
+		{expression: "$.order.items[1].sku", want: "m-2", ok: true},
+		{expression: "$.order.items[0]", want: map[string]any{"sku": "m-1"}, ok: true},
+		{expression: "$.matrix[1][0]", want: json.Number("3"), ok: true},
+		{expression: "$.missing"},
+		{expression: "$.order.items[2].sku"},
+		{expression: "$.orderId.length"},
+		{expression: "$.orderId[0]"},
+		{expression: "$.order[0]"},
+		{expression: "$.note.value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			path, err := Parse(tt.expression)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.expression, err)
+			}
+			got, ok := path.Lookup(document)
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lookup() = %#v, %v, want %#v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    any
+		wantErr bool
+	}{
+		{raw: `12345678901234567890`, want: json.Number("12345678901234567890")},
+		{raw: ` "abc" `, want: "abc"},
+		{raw: `{"a":[1,true]}`, want: map[string]any{"a": []any{json.Number("1"), true}}},
+		{raw: `null`, want: nil},
+		{raw: ``, wantErr: true},
+		{raw: `{"a":`, wantErr: true},
+		{raw: `1 2`, wantErr: true},
+		{raw: `{} {}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := Decode([]byte(tt.raw))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Decode(%q) = %#v, want error", tt.raw, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decode(%q) failed: %v", tt.raw, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode(%q) = %#v, want %#v", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
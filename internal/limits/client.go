@@ -0,0 +1,46 @@
+package limits
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/idot-digital/events-db/internal/tenancy"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ClientFromRequest identifies the caller of a REST request
+func ClientFromRequest(r *http.Request) Client {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return Client{
+		Tenant:     tenancy.Tenant(r.Context()),
+		Credential: strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "),
+		IP:         ip,
+	}
+}
+
+// ClientFromContext identifies the caller of a gRPC call
+func ClientFromContext(ctx context.Context) Client {
+	client := Client{Tenant: tenancy.Tenant(ctx)}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if auth := md.Get("authorization"); len(auth) > 0 {
+			client.Credential = auth[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ip, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			ip = p.Addr.String()
+		}
+		client.IP = ip
+	}
+
+	return client
+}
@@ -0,0 +1,298 @@
+package limits
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// idleBucketTimeout is how long an unused client bucket is kept around
+const idleBucketTimeout = 10 * time.Minute
+
+// Tier holds the token bucket settings for a class of clients. A zero rate
+// disables the corresponding limit.
+type Tier struct {
+	WritesPerSecond  float64 `json:"writes_per_second"`
+	WriteBurst       int     `json:"write_burst"`
+	StreamsPerSecond float64 `json:"streams_per_second"`
+	StreamBurst      int     `json:"stream_burst"`
+}
+
+// SubjectRule limits the writes to and the storage used by a subject prefix
+// across all clients of a tenant. Every tenant has its own budget. Zero values
+// disable the corresponding limit.
+type SubjectRule struct {
+	Prefix          string  `json:"prefix"`
+	WritesPerSecond float64 `json:"writes_per_second"`
+	WriteBurst      int     `json:"write_burst"`
+	MaxEvents       int64   `json:"max_events"`
+	MaxBytes        int64   `json:"max_bytes"`
+}
+
+// Config is read from the JSON file named by RATE_LIMIT_CONFIG
+type Config struct {
+	// Key identifies clients by "credential" (the presented token) or "ip"
+	Key         string          `json:"key"`
+	DefaultTier string          `json:"default_tier"`
+	Tiers       map[string]Tier `json:"tiers"`
+	// Clients assigns credentials or IPs, depending on Key, to tiers
+	Clients map[string]string `json:"clients"`
+	// Tenants assigns tenants to tiers for clients not listed in Clients
+	Tenants  map[string]string `json:"tenants"`
+	Subjects []SubjectRule     `json:"subjects"`
+}
+
+// Load reads and validates a limits configuration file
+func Load(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid limits config: %w", err)
+	}
+
+	if cfg.Key == "" {
+		cfg.Key = "credential"
+	}
+	if cfg.Key != "credential" && cfg.Key != "ip" {
+		return nil, fmt.Errorf("invalid limits key %q, must be credential or ip", cfg.Key)
+	}
+	if _, ok := cfg.Tiers[cfg.DefaultTier]; cfg.DefaultTier != "" && !ok {
+		return nil, fmt.Errorf("unknown default tier %q", cfg.DefaultTier)
+	}
+	for client, tier := range cfg.Clients {
+		if _, ok := cfg.Tiers[tier]; !ok {
+			return nil, fmt.Errorf("unknown tier %q for client %q", tier, client)
+		}
+	}
+	for tenant, tier := range cfg.Tenants {
+		if _, ok := cfg.Tiers[tier]; !ok {
+			return nil, fmt.Errorf("unknown tier %q for tenant %q", tier, tenant)
+		}
+	}
+	for _, rule := range cfg.Subjects {
+		if rule.Prefix == "" {
+			return nil, fmt.Errorf("subject rule without prefix")
+		}
+	}
+
+	return &cfg, nil
+}
+
+// RateLimitedError is returned when a client exceeded its rate limit
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
+// QuotaExceededError is returned when a write would exceed a storage quota
+type QuotaExceededError struct {
+	Tenant string
+	Prefix string
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("storage quota for subject prefix %q of tenant %q exceeded", e.Prefix, e.Tenant)
+}
+
+// Client identifies the caller of an operation
+type Client struct {
+	Tenant     string
+	Credential string
+	IP         string
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// UsageFunc returns the number of stored events and the size in bytes of their
+// stored payloads and blobs for a subject prefix of a tenant
+type UsageFunc func(ctx context.Context, tenant string, prefix string) (events int64, bytes int64, err error)
+
+// Limiter enforces the configured rate limits and storage quotas. A nil
+// Limiter allows everything.
+type Limiter struct {
+	cfg       *Config
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	quotas    *quotas
+}
+
+func New(cfg *Config, usage UsageFunc) *Limiter {
+	return &Limiter{
+		cfg:     cfg,
+		buckets: make(map[string]*bucket),
+		quotas:  newQuotas(usage),
+	}
+}
+
+// tier returns the tier of a client and the key its buckets are stored under.
+// Clients get separate buckets per tenant.
+func (l *Limiter) tier(client Client) (Tier, string) {
+	key := client.Credential
+	if l.cfg.Key == "ip" {
+		key = client.IP
+	}
+
+	name, ok := l.cfg.Clients[key]
+	if !ok {
+		name, ok = l.cfg.Tenants[client.Tenant]
+	}
+	if !ok {
+		name = l.cfg.DefaultTier
+	}
+	return l.cfg.Tiers[name], client.Tenant + "/" + key
+}
+
+// take takes a token from the bucket of a client for an operation
+func (l *Limiter) take(key string, limit float64, burst int) error {
+	if limit <= 0 {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > idleBucketTimeout {
+		for k, b := range l.buckets {
+			if now.Sub(b.lastUsed) > idleBucketTimeout {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(limit), max(burst, 1))}
+		l.buckets[key] = b
+	}
+	b.lastUsed = now
+
+	return reserve(b.limiter, now)
+}
+
+// reserve takes a token if one is available, otherwise it reports when to retry
+func reserve(limiter *rate.Limiter, now time.Time) error {
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return &RateLimitedError{RetryAfter: time.Second}
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return &RateLimitedError{RetryAfter: delay}
+	}
+	return nil
+}
+
+// AllowWrite checks the rate limits for creating an event
+func (l *Limiter) AllowWrite(client Client, subject string) error {
+	if l == nil {
+		return nil
+	}
+
+	tier, key := l.tier(client)
+	if err := l.take("write:"+key, tier.WritesPerSecond, tier.WriteBurst); err != nil {
+		return err
+	}
+
+	for _, rule := range l.cfg.Subjects {
+		if !strings.HasPrefix(subject, rule.Prefix) {
+			continue
+		}
+		if err := l.take("subject:"+client.Tenant+"/"+rule.Prefix, rule.WritesPerSecond, rule.WriteBurst); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AllowStorage checks the storage quotas for creating an event whose payload
+// takes up size bytes once stored, the measure the usage loaded from the
+// database counts as well. The size is reserved until the write is passed to
+// RecordWrite, or to ReleaseStorage if it failed.
+func (l *Limiter) AllowStorage(ctx context.Context, tenant string, subject string, size int) error {
+	if l == nil {
+		return nil
+	}
+
+	var reserved []string
+	for _, rule := range l.cfg.Subjects {
+		if !strings.HasPrefix(subject, rule.Prefix) {
+			continue
+		}
+		ok, err := l.quotas.reserve(ctx, tenant, rule, size)
+		if err != nil {
+			for _, prefix := range reserved {
+				l.quotas.release(tenant, prefix, size)
+			}
+			return err
+		}
+		if ok {
+			reserved = append(reserved, rule.Prefix)
+		}
+	}
+	return nil
+}
+
+// RecordWrite accounts a created event whose payload takes up size bytes once
+// stored against the storage quotas of its tenant
+func (l *Limiter) RecordWrite(tenant string, subject string, size int) {
+	if l == nil {
+		return
+	}
+
+	for _, prefix := range l.quotaPrefixes(subject) {
+		l.quotas.record(tenant, prefix, size)
+	}
+}
+
+// ReleaseStorage releases the storage reserved by AllowStorage for a write
+// that failed
+func (l *Limiter) ReleaseStorage(tenant string, subject string, size int) {
+	if l == nil {
+		return
+	}
+
+	for _, prefix := range l.quotaPrefixes(subject) {
+		l.quotas.release(tenant, prefix, size)
+	}
+}
+
+// quotaPrefixes returns the prefixes of the rules with storage quotas matching a subject
+func (l *Limiter) quotaPrefixes(subject string) []string {
+	var prefixes []string
+	for _, rule := range l.cfg.Subjects {
+		if (rule.MaxEvents > 0 || rule.MaxBytes > 0) && strings.HasPrefix(subject, rule.Prefix) {
+			prefixes = append(prefixes, rule.Prefix)
+		}
+	}
+	return prefixes
+}
+
+// AllowStream checks the rate limit for opening a stream
+func (l *Limiter) AllowStream(client Client) error {
+	if l == nil {
+		return nil
+	}
+
+	tier, key := l.tier(client)
+	return l.take("stream:"+key, tier.StreamsPerSecond, tier.StreamBurst)
+}
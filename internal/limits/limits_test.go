@@ -0,0 +1,269 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		key     string
+		wantErr bool
+	}{
+		{name: "defaults to credential key", config: `{}`, key: "credential"},
+		{name: "ip key", config: `{"key":"ip"}`, key: "ip"},
+		{name: "tiers", config: `{"default_tier":"free","tiers":{"free":{},"paid":{}},"clients":{"t1":"paid"},"tenants":{"acme":"free"}}`, key: "credential"},
+		{name: "unknown key", config: `{"key":"user"}`, wantErr: true},
+		{name: "unknown default tier", config: `{"default_tier":"free"}`, wantErr: true},
+		{name: "unknown client tier", config: `{"tiers":{"free":{}},"clients":{"t1":"paid"}}`, wantErr: true},
+		{name: "unknown tenant tier", config: `{"tiers":{"free":{}},"tenants":{"acme":"paid"}}`, wantErr: true},
+		{name: "subject rule without prefix", config: `{"subjects":[{"max_events":1}]}`, wantErr: true},
+		{name: "invalid JSON", config: `{"key":`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "limits.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := Load(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Load(%s) succeeded, want error", tt.config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load(%s) failed: %v", tt.config, err)
+			}
+			if cfg.Key != tt.key {
+				t.Errorf("key = %q, want %q", cfg.Key, tt.key)
+			}
+		})
+	}
+}
+
+func TestTier(t *testing.T) {
+	cfg := &Config{
+		Key:         "credential",
+		DefaultTier: "free",
+		Tiers: map[string]Tier{
+			"free":       {WritesPerSecond: 1},
+			"paid":       {WritesPerSecond: 10},
+			"enterprise": {WritesPerSecond: 100},
+		},
+		Clients: map[string]string{"token-paid": "paid", "10.0.0.1": "enterprise"},
+		Tenants: map[string]string{"acme": "enterprise"},
+	}
+
+	tests := []struct {
+		name   string
+		key    string
+		client Client
+		tier   string
+		bucket string
+	}{
+		{name: "default tier", client: Client{Tenant: "other", Credential: "token"}, tier: "free", bucket: "other/token"},
+		{name: "client tier", client: Client{Tenant: "other", Credential: "token-paid"}, tier: "paid", bucket: "other/token-paid"},
+		{name: "tenant tier", client: Client{Tenant: "acme", Credential: "token"}, tier: "enterprise", bucket: "acme/token"},
+		{name: "client tier before tenant tier", client: Client{Tenant: "acme", Credential: "token-paid"}, tier: "paid", bucket: "acme/token-paid"},
+		{name: "credential key ignores IP", client: Client{Tenant: "other", Credential: "token", IP: "10.0.0.1"}, tier: "free", bucket: "other/token"},
+		{name: "ip key", key: "ip", client: Client{Tenant: "other", Credential: "token-paid", IP: "10.0.0.1"}, tier: "enterprise", bucket: "other/10.0.0.1"},
+		{name: "ip key default tier", key: "ip", client: Client{Tenant: "other", Credential: "token-paid", IP: "10.0.0.2"}, tier: "free", bucket: "other/10.0.0.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tierCfg := *cfg
+			if tt.key != "" {
+				tierCfg.Key = tt.key
+			}
+			limiter := New(&tierCfg, nil)
+
+			tier, bucket := limiter.tier(tt.client)
+			if tier != cfg.Tiers[tt.tier] {
+				t.Errorf("tier = %+v, want %s %+v", tier, tt.tier, cfg.Tiers[tt.tier])
+			}
+			if bucket != tt.bucket {
+				t.Errorf("bucket = %q, want %q", bucket, tt.bucket)
+			}
+		})
+	}
+}
+
+func TestReserve(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		limit   rate.Limit
+		burst   int
+		takes   int
+		allowed int
+	}{
+		{name: "within burst", limit: 0.001, burst: 3, takes: 3, allowed: 3},
+		{name: "burst exhausted", limit: 0.001, burst: 2, takes: 4, allowed: 2},
+		{name: "zero burst", limit: 0.001, burst: 0, takes: 1, allowed: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := rate.NewLimiter(tt.limit, tt.burst)
+			allowed := 0
+			for i := 0; i < tt.takes; i++ {
+				err := reserve(limiter, now)
+				if err == nil {
+					allowed++
+					continue
+				}
+				var rateLimited *RateLimitedError
+				if !errors.As(err, &rateLimited) || rateLimited.RetryAfter <= 0 {
+					t.Fatalf("reserve() = %v, want RateLimitedError with a retry delay", err)
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("allowed %d of %d takes, want %d", allowed, tt.takes, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestReserveRefills(t *testing.T) {
+	now := time.Now()
+	limiter := rate.NewLimiter(1, 1)
+
+	if err := reserve(limiter, now); err != nil {
+		t.Fatalf("first take failed: %v", err)
+	}
+	err := reserve(limiter, now)
+	var rateLimited *RateLimitedError
+	if !errors.As(err, &rateLimited) {
+		t.Fatalf("second take = %v, want RateLimitedError", err)
+	}
+	if rateLimited.RetryAfter <= 0 || rateLimited.RetryAfter > time.Second {
+		t.Errorf("retry after %s, want at most 1s", rateLimited.RetryAfter)
+	}
+	// A rejected take must not consume the token that refills
+	if err := reserve(limiter, now.Add(time.Second)); err != nil {
+		t.Errorf("take after refill failed: %v", err)
+	}
+}
+
+func TestAllowWrite(t *testing.T) {
+	cfg := &Config{
+		Key:         "credential",
+		DefaultTier: "free",
+		Tiers: map[string]Tier{
+			"free":      {WritesPerSecond: 0.001, WriteBurst: 2},
+			"unlimited": {},
+		},
+		Clients: map[string]string{"token-unlimited": "unlimited"},
+		Subjects: []SubjectRule{
+			{Prefix: "/orders", WritesPerSecond: 0.001, WriteBurst: 1},
+		},
+	}
+
+	type write struct {
+		client  Client
+		subject string
+		allowed bool
+	}
+	tests := []struct {
+		name   string
+		writes []write
+	}{
+		{
+			name: "client burst",
+			writes: []write{
+				{Client{Tenant: "acme", Credential: "a"}, "/users/1", true},
+				{Client{Tenant: "acme", Credential: "a"}, "/users/2", true},
+				{Client{Tenant: "acme", Credential: "a"}, "/users/3", false},
+				{Client{Tenant: "acme", Credential: "b"}, "/users/4", true},
+			},
+		},
+		{
+			name: "clients are separated per tenant",
+			writes: []write{
+				{Client{Tenant: "acme", Credential: "a"}, "/users/1", true},
+				{Client{Tenant: "acme", Credential: "a"}, "/users/2", true},
+				{Client{Tenant: "other", Credential: "a"}, "/users/3", true},
+			},
+		},
+		{
+			name: "subject rule shared by clients of a tenant",
+			writes: []write{
+				{Client{Tenant: "acme", Credential: "a"}, "/orders/1", true},
+				{Client{Tenant: "acme", Credential: "b"}, "/orders/2", false},
+				{Client{Tenant: "other", Credential: "b"}, "/orders/3", true},
+			},
+		},
+		{
+			name: "disabled tier limit",
+			writes: []write{
+				{Client{Tenant: "acme", Credential: "token-unlimited"}, "/users/1", true},
+				{Client{Tenant: "acme", Credential: "token-unlimited"}, "/users/2", true},
+				{Client{Tenant: "acme", Credential: "token-unlimited"}, "/users/3", true},
+				{Client{Tenant: "acme", Credential: "token-unlimited"}, "/orders/1", true},
+				{Client{Tenant: "acme", Credential: "token-unlimited"}, "/orders/2", false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := New(cfg, nil)
+			for i, w := range tt.writes {
+				err := limiter.AllowWrite(w.client, w.subject)
+				if (err == nil) != w.allowed {
+					t.Errorf("write %d of %+v to %s: err = %v, want allowed %v", i, w.client, w.subject, err, w.allowed)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowStream(t *testing.T) {
+	cfg := &Config{
+		Key:   "ip",
+		Tiers: map[string]Tier{"": {StreamsPerSecond: 0.001, StreamBurst: 1, WritesPerSecond: 0.001, WriteBurst: 1}},
+	}
+	limiter := New(cfg, nil)
+	client := Client{Tenant: "acme", IP: "10.0.0.1"}
+
+	if err := limiter.AllowStream(client); err != nil {
+		t.Fatalf("first stream failed: %v", err)
+	}
+	if err := limiter.AllowStream(client); err == nil {
+		t.Error("second stream allowed, want rate limited")
+	}
+	// Streams and writes use separate buckets
+	if err := limiter.AllowWrite(client, "/orders/1"); err != nil {
+		t.Errorf("write after streams failed: %v", err)
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var limiter *Limiter
+	client := Client{Tenant: "acme", Credential: "a"}
+
+	if err := limiter.AllowWrite(client, "/orders/1"); err != nil {
+		t.Errorf("AllowWrite() = %v, want nil", err)
+	}
+	if err := limiter.AllowStream(client); err != nil {
+		t.Errorf("AllowStream() = %v, want nil", err)
+	}
+	if err := limiter.AllowStorage(context.Background(), "acme", "/orders/1", 1<<30); err != nil {
+		t.Errorf("AllowStorage() = %v, want nil", err)
+	}
+	limiter.RecordWrite("acme", "/orders/1", 1)
+}
@@ -0,0 +1,106 @@
+package limits
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// usageRefreshInterval is how long the cached usage of a prefix is trusted
+// before it is read from the database again
+const usageRefreshInterval = time.Minute
+
+type usage struct {
+	events int64
+	bytes  int64
+	// pendingEvents and pendingBytes are reserved by writes in progress
+	pendingEvents int64
+	pendingBytes  int64
+	loadedAt      time.Time
+}
+
+// quotas caches the storage used per tenant and subject prefix. Checks reserve
+// the size of the write until it is recorded or released, so concurrent
+// writers to one instance can't overshoot a quota together. The cache is
+// refreshed from the database periodically, writers on other instances may
+// overshoot a quota until then.
+type quotas struct {
+	load  UsageFunc
+	mutex sync.Mutex
+	usage map[string]*usage
+}
+
+func newQuotas(load UsageFunc) *quotas {
+	return &quotas{
+		load:  load,
+		usage: make(map[string]*usage),
+	}
+}
+
+// reserve reserves a write of size bytes if it fits into the quotas of the
+// rule for a tenant. It reports whether a reservation was made, rules
+// without quotas don't need one.
+func (q *quotas) reserve(ctx context.Context, tenant string, rule SubjectRule, size int) (bool, error) {
+	if rule.MaxEvents <= 0 && rule.MaxBytes <= 0 {
+		return false, nil
+	}
+
+	key := tenant + "/" + rule.Prefix
+	q.mutex.Lock()
+	u, ok := q.usage[key]
+	stale := !ok || time.Since(u.loadedAt) > usageRefreshInterval
+	q.mutex.Unlock()
+
+	if stale {
+		events, bytes, err := q.load(ctx, tenant, rule.Prefix)
+		if err != nil {
+			return false, err
+		}
+
+		q.mutex.Lock()
+		// Reservations made meanwhile are kept, they aren't stored yet
+		if u, ok = q.usage[key]; !ok {
+			u = &usage{}
+			q.usage[key] = u
+		}
+		u.events, u.bytes, u.loadedAt = events, bytes, time.Now()
+		q.mutex.Unlock()
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if rule.MaxEvents > 0 && u.events+u.pendingEvents+1 > rule.MaxEvents {
+		return false, &QuotaExceededError{Tenant: tenant, Prefix: rule.Prefix}
+	}
+	if rule.MaxBytes > 0 && u.bytes+u.pendingBytes+int64(size) > rule.MaxBytes {
+		return false, &QuotaExceededError{Tenant: tenant, Prefix: rule.Prefix}
+	}
+	u.pendingEvents++
+	u.pendingBytes += int64(size)
+	return true, nil
+}
+
+// record turns the reservation of a created event into stored usage
+func (q *quotas) record(tenant string, prefix string, size int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if u, ok := q.usage[tenant+"/"+prefix]; ok {
+		u.pendingEvents--
+		u.pendingBytes -= int64(size)
+		u.events++
+		u.bytes += int64(size)
+	}
+}
+
+// release drops the reservation of a write that failed
+func (q *quotas) release(tenant string, prefix string, size int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if u, ok := q.usage[tenant+"/"+prefix]; ok {
+		u.pendingEvents--
+		u.pendingBytes -= int64(size)
+	}
+}
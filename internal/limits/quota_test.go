@@ -0,0 +1,200 @@
+package limits
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestAllowStorage(t *testing.T) {
+	cfg := &Config{
+		Key: "credential",
+		Subjects: []SubjectRule{
+			{Prefix: "/orders", MaxEvents: 3, MaxBytes: 100},
+			{Prefix: "/orders/eu", MaxBytes: 50},
+			{Prefix: "/users", WritesPerSecond: 1},
+		},
+	}
+	usage := map[string][2]int64{
+		"acme//orders":    {2, 60},
+		"acme//orders/eu": {1, 40},
+		"full//orders":    {3, 0},
+	}
+	load := func(ctx context.Context, tenant string, prefix string) (int64, int64, error) {
+		u := usage[tenant+"/"+prefix]
+		return u[0], u[1], nil
+	}
+
+	tests := []struct {
+		name     string
+		tenant   string
+		subject  string
+		size     int
+		exceeded string
+	}{
+		{name: "fits", tenant: "acme", subject: "/orders/us/1", size: 40},
+		{name: "bytes exceeded", tenant: "acme", subject: "/orders/us/1", size: 41, exceeded: "/orders"},
+		{name: "nested rule exceeded", tenant: "acme", subject: "/orders/eu/1", size: 11, exceeded: "/orders/eu"},
+		{name: "nested rule fits", tenant: "acme", subject: "/orders/eu/1", size: 10},
+		{name: "events exceeded", tenant: "full", subject: "/orders/1", size: 0, exceeded: "/orders"},
+		{name: "other tenant", tenant: "other", subject: "/orders/1", size: 100},
+		{name: "rule without quota", tenant: "acme", subject: "/users/1", size: 1 << 30},
+		{name: "no matching rule", tenant: "acme", subject: "/products/1", size: 1 << 30},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := New(cfg, load)
+			err := limiter.AllowStorage(context.Background(), tt.tenant, tt.subject, tt.size)
+			if tt.exceeded == "" {
+				if err != nil {
+					t.Errorf("AllowStorage() = %v, want nil", err)
+				}
+				return
+			}
+			var quotaExceeded *QuotaExceededError
+			if !errors.As(err, &quotaExceeded) {
+				t.Fatalf("AllowStorage() = %v, want QuotaExceededError", err)
+			}
+			if quotaExceeded.Tenant != tt.tenant || quotaExceeded.Prefix != tt.exceeded {
+				t.Errorf("exceeded %q of %q, want %q of %q", quotaExceeded.Prefix, quotaExceeded.Tenant, tt.exceeded, tt.tenant)
+			}
+		})
+	}
+}
+
+func TestReservations(t *testing.T) {
+	cfg := &Config{
+		Key:      "credential",
+		Subjects: []SubjectRule{{Prefix: "/orders", MaxEvents: 2, MaxBytes: 100}},
+	}
+	loads := 0
+	load := func(ctx context.Context, tenant string, prefix string) (int64, int64, error) {
+		loads++
+		return 0, 0, nil
+	}
+
+	// Writes reserve their size, then are either recorded or released
+	const (
+		record  = "record"
+		release = "release"
+		pending = "pending"
+	)
+	type write struct {
+		size    int
+		outcome string
+	}
+	tests := []struct {
+		name    string
+		writes  []write
+		size    int
+		allowed bool
+	}{
+		{name: "nothing written", size: 100, allowed: true},
+		{name: "bytes recorded", writes: []write{{60, record}}, size: 41, allowed: false},
+		{name: "events recorded", writes: []write{{1, record}, {1, record}}, size: 1, allowed: false},
+		{name: "room left", writes: []write{{30, record}}, size: 70, allowed: true},
+		{name: "bytes pending", writes: []write{{60, pending}}, size: 41, allowed: false},
+		{name: "events pending", writes: []write{{1, pending}, {1, record}}, size: 1, allowed: false},
+		{name: "released", writes: []write{{60, release}, {1, release}}, size: 100, allowed: true},
+		{name: "released after pending", writes: []write{{60, pending}, {30, release}}, size: 40, allowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := New(cfg, load)
+			ctx := context.Background()
+			loads = 0
+
+			for i, w := range tt.writes {
+				if err := limiter.AllowStorage(ctx, "acme", "/orders/1", w.size); err != nil {
+					t.Fatalf("write %d: AllowStorage() failed: %v", i, err)
+				}
+				switch w.outcome {
+				case record:
+					limiter.RecordWrite("acme", "/orders/1", w.size)
+				case release:
+					limiter.ReleaseStorage("acme", "/orders/1", w.size)
+				}
+			}
+
+			err := limiter.AllowStorage(ctx, "acme", "/orders/1", tt.size)
+			if (err == nil) != tt.allowed {
+				t.Errorf("AllowStorage() = %v, want allowed %v", err, tt.allowed)
+			}
+			if loads != 1 {
+				t.Errorf("usage loaded %d times, want once", loads)
+			}
+		})
+	}
+}
+
+func TestConcurrentReservations(t *testing.T) {
+	cfg := &Config{
+		Key:      "credential",
+		Subjects: []SubjectRule{{Prefix: "/orders", MaxEvents: 10}},
+	}
+	limiter := New(cfg, func(ctx context.Context, tenant string, prefix string) (int64, int64, error) {
+		return 0, 0, nil
+	})
+
+	const writers = 50
+	allowed := make(chan bool, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			allowed <- limiter.AllowStorage(context.Background(), "acme", "/orders/1", 1) == nil
+		}()
+	}
+	wg.Wait()
+	close(allowed)
+
+	count := 0
+	for ok := range allowed {
+		if ok {
+			count++
+		}
+	}
+	if count != 10 {
+		t.Errorf("%d of %d concurrent writes allowed, want 10", count, writers)
+	}
+}
+
+func TestReservationRollback(t *testing.T) {
+	cfg := &Config{
+		Key: "credential",
+		Subjects: []SubjectRule{
+			{Prefix: "/orders", MaxEvents: 2},
+			{Prefix: "/orders/eu", MaxBytes: 10},
+		},
+	}
+	limiter := New(cfg, func(ctx context.Context, tenant string, prefix string) (int64, int64, error) {
+		return 0, 0, nil
+	})
+	ctx := context.Background()
+
+	// The first rule is reserved before the second one rejects the write
+	if err := limiter.AllowStorage(ctx, "acme", "/orders/eu/1", 11); err == nil {
+		t.Fatal("AllowStorage() allowed a write over the byte quota")
+	}
+	for i := 0; i < 2; i++ {
+		if err := limiter.AllowStorage(ctx, "acme", "/orders/us/1", 1); err != nil {
+			t.Fatalf("write %d: AllowStorage() = %v, want the rejected reservation released", i, err)
+		}
+	}
+}
+
+func TestAllowStorageLoadError(t *testing.T) {
+	loadErr := errors.New("database unavailable")
+	cfg := &Config{Subjects: []SubjectRule{{Prefix: "/orders", MaxEvents: 1}}}
+	limiter := New(cfg, func(ctx context.Context, tenant string, prefix string) (int64, int64, error) {
+		return 0, 0, loadErr
+	})
+
+	if err := limiter.AllowStorage(context.Background(), "acme", "/orders/1", 1); !errors.Is(err, loadErr) {
+		t.Errorf("AllowStorage() = %v, want %v", err, loadErr)
+	}
+}
@@ -12,7 +12,7 @@ var (
 			Name: "app_event_operations_total",
 			Help: "The total number of event operations",
 		},
-		[]string{"operation", "status"},
+		[]string{"operation", "status", "tenant"},
 	)
 
 	// EventOperationDuration tracks the duration of event operations
@@ -22,7 +22,54 @@ var (
 			Help:    "The duration of event operations in seconds",
 			Buckets: prometheus.DefBuckets,
 		},
-		[]string{"operation"},
+		[]string{"operation", "tenant"},
+	)
+
+	// StreamPendingEvents tracks the events waiting to be sent to stream clients per tenant
+	StreamPendingEvents = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "app_stream_pending_events",
+			Help: "The number of events waiting to be sent to stream clients",
+		},
+		[]string{"tenant"},
+	)
+
+	// StreamPendingBytes tracks the size of the events waiting to be sent to stream clients per tenant
+	StreamPendingBytes = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "app_stream_pending_bytes",
+			Help: "The size in bytes of the events waiting to be sent to stream clients",
+		},
+		[]string{"tenant"},
+	)
+
+	// StreamLagEvents tracks how far behind the newest event a stream is when sending, per tenant
+	StreamLagEvents = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_stream_lag_events",
+			Help:    "The number of event IDs between the newest event and the last event sent to a stream",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+		},
+		[]string{"tenant"},
+	)
+
+	// StreamCatchUpDuration tracks how long streams take to replay stored events
+	StreamCatchUpDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_stream_catchup_duration_seconds",
+			Help:    "The duration of replaying stored events to a stream in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.005, 4, 10),
+		},
+		[]string{"reason"},
+	)
+
+	// StreamDroppedEvents tracks the live events dropped for streams that fell behind
+	StreamDroppedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_stream_dropped_events_total",
+			Help: "The total number of live events dropped because a stream's buffer was full",
+		},
+		[]string{"tenant", "group"},
 	)
 
 	// ActiveEventStreams tracks the number of active event streams
@@ -32,4 +79,221 @@ var (
 			Help: "The number of currently active event streams",
 		},
 	)
+
+	// RetentionDeletedEvents tracks the events deleted by retention rules
+	RetentionDeletedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_retention_deleted_events_total",
+			Help: "The total number of events deleted by retention rules",
+		},
+		[]string{"rule"},
+	)
+
+	// RetentionMatchedEvents tracks the events a dry run would delete
+	RetentionMatchedEvents = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "app_retention_dry_run_events",
+			Help: "The number of events the last dry run of a retention rule would delete",
+		},
+		[]string{"rule"},
+	)
+
+	// RetentionRunDuration tracks how long applying the retention rules takes
+	RetentionRunDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "app_retention_run_duration_seconds",
+			Help:    "The duration of applying all retention rules in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 10),
+		},
+	)
+
+	// RetentionLastRun tracks when the retention rules were last applied successfully
+	RetentionLastRun = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "app_retention_last_run_timestamp_seconds",
+			Help: "The Unix time the retention rules were last applied successfully",
+		},
+	)
+
+	// ArchivedEvents tracks the events moved into archive segments
+	ArchivedEvents = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_archived_events_total",
+			Help: "The total number of events moved into archive segments",
+		},
+	)
+
+	// ArchiveSegments tracks the archive segments written
+	ArchiveSegments = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_archive_segments_total",
+			Help: "The total number of archive segments written",
+		},
+	)
+
+	// ArchiveSegmentBytes tracks the compressed size of the archive segments written
+	ArchiveSegmentBytes = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_archive_segment_bytes_total",
+			Help: "The total compressed size in bytes of the archive segments written",
+		},
+	)
+
+	// ArchiveSegmentReads tracks the archive segments loaded to serve reads
+	ArchiveSegmentReads = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_archive_segment_reads_total",
+			Help: "The total number of archive segments loaded from the store to serve reads",
+		},
+	)
+
+	// ErasedDataKeys tracks the data keys destroyed for crypto-shredding
+	ErasedDataKeys = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_erased_data_keys_total",
+			Help: "The total number of data keys erased",
+		},
+	)
+
+	// PayloadCompressedBytes tracks the payload bytes stored compressed, before compression
+	PayloadCompressedBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_payload_compressed_bytes_total",
+			Help: "The total size in bytes of the payloads stored compressed, before compression",
+		},
+		[]string{"codec"},
+	)
+
+	// PayloadStoredBytes tracks the stored size of the compressed payloads
+	PayloadStoredBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_payload_compressed_stored_bytes_total",
+			Help: "The total size in bytes of the payloads stored compressed, after compression",
+		},
+		[]string{"codec"},
+	)
+
+	// PayloadCompressionRatio tracks the compressed to original size ratio of payloads
+	PayloadCompressionRatio = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "app_payload_compression_ratio",
+			Help:    "Compressed size divided by original size of payloads above the compression threshold",
+			Buckets: []float64{0.05, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1},
+		},
+		[]string{"codec"},
+	)
+
+	// BlobUploadedBytes tracks the size of the blobs uploaded
+	BlobUploadedBytes = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_blob_uploaded_bytes_total",
+			Help: "The total size in bytes of the blobs uploaded, including offloaded payloads",
+		},
+	)
+
+	// BlobDownloadedBytes tracks the size of the blobs downloaded
+	BlobDownloadedBytes = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_blob_downloaded_bytes_total",
+			Help: "The total size in bytes of the blobs downloaded",
+		},
+	)
+
+	// CollectedBlobs tracks the blobs deleted because no event references them
+	CollectedBlobs = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_collected_blobs_total",
+			Help: "The total number of blobs deleted because no stored or archived event referenced them",
+		},
+	)
+
+	// OffloadedPayloads tracks the event payloads stored as blobs because of their size
+	OffloadedPayloads = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "app_offloaded_payloads_total",
+			Help: "The total number of event payloads stored as blobs because they exceeded the blob threshold",
+		},
+	)
+
+	// SchemaValidationFailures tracks the payloads rejected by their schema
+	SchemaValidationFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_schema_validation_failures_total",
+			Help: "The total number of event payloads rejected because they did not match their schema",
+		},
+		[]string{"tenant", "type"},
+	)
+
+	// UpcastFailures tracks the payloads that could not be upcast on read
+	UpcastFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_upcast_failures_total",
+			Help: "The total number of event payloads returned as stored because an upcaster failed to transform them",
+		},
+		[]string{"tenant", "type"},
+	)
+
+	// ExportedEvents tracks the events written to export files
+	ExportedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_exported_events_total",
+			Help: "The total number of events written to exports",
+		},
+		[]string{"tenant"},
+	)
+
+	// ImportedEvents tracks the events stored from import files
+	ImportedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_imported_events_total",
+			Help: "The total number of events stored from imports, without the ones skipped as already imported",
+		},
+		[]string{"tenant", "mode"},
+	)
+
+	// SearchedEvents tracks the events read by payload searches
+	SearchedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_searched_events_total",
+			Help: "The total number of events read by payload searches",
+		},
+		[]string{"tenant"},
+	)
+
+	// IndexedEvents tracks the events added to secondary indexes, by whether
+	// they were indexed on append or by the background backfill
+	IndexedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_indexed_events_total",
+			Help: "The total number of events added to secondary indexes",
+		},
+		[]string{"tenant", "mode"},
+	)
+
+	// IndexFailures tracks the events that failed to be indexed on append
+	IndexFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_index_failures_total",
+			Help: "The total number of events that failed to be indexed on append",
+		},
+		[]string{"tenant"},
+	)
+
+	// TombstonedEvents tracks the events replaced by tombstones
+	TombstonedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_tombstoned_events_total",
+			Help: "The total number of events replaced by tombstones",
+		},
+		[]string{"tenant"},
+	)
+
+	// PurgedEvents tracks the tombstoned events deleted after the grace period
+	PurgedEvents = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "app_purged_events_total",
+			Help: "The total number of tombstoned events deleted once their grace period passed",
+		},
+		[]string{"tenant"},
+	)
 )
@@ -1,30 +1,54 @@
 package middleware
 
-import "net/http"
+import (
+	"errors"
+	"net/http"
 
-// AuthMiddleware checks for a valid authentication token
-func Auth(next http.HandlerFunc, authToken string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// Skip auth if no token is configured
-		if authToken == "" {
-			next(w, r)
-			return
-		}
+	"github.com/idot-digital/events-db/internal/tenancy"
+)
 
+// AuthMiddleware checks for a valid authentication token and scopes the
+// request to the tenant of the token or the one selected by the X-Tenant header
+func Auth(next http.HandlerFunc, registry *tenancy.Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		token := r.Header.Get("Authorization")
-		if token == "" {
-			http.Error(w, "Unauthorized - No token provided", http.StatusUnauthorized)
-			return
-		}
 
 		// Remove "Bearer " prefix if present
 		if len(token) > 7 && token[:7] == "Bearer " {
 			token = token[7:]
 		}
 
-		if token != authToken {
+		principal, err := registry.Resolve(r.Context(), token, r.Header.Get(tenancy.Header))
+		switch {
+		case err == nil:
+		case errors.Is(err, tenancy.ErrInvalidToken) && token == "":
+			http.Error(w, "Unauthorized - No token provided", http.StatusUnauthorized)
+			return
+		case errors.Is(err, tenancy.ErrInvalidToken):
 			http.Error(w, "Unauthorized - Invalid token", http.StatusUnauthorized)
 			return
+		case errors.Is(err, tenancy.ErrUnknownTenant):
+			http.Error(w, "Forbidden - Unknown tenant", http.StatusForbidden)
+			return
+		case errors.Is(err, tenancy.ErrTenantMismatch):
+			http.Error(w, "Forbidden - Token is not valid for the selected tenant", http.StatusForbidden)
+			return
+		default:
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		recordTenant(r.Context(), principal)
+		next(w, r.WithContext(tenancy.NewContext(r.Context(), principal)))
+	}
+}
+
+// Admin only lets requests authenticated with the admin token through
+func Admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !tenancy.FromContext(r.Context()).Admin {
+			http.Error(w, "Forbidden - Admin token required", http.StatusForbidden)
+			return
 		}
 
 		next(w, r)
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/idot-digital/events-db/internal/compression"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoder is a compressing writer that can flush buffered output
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Compress compresses responses with zstd or gzip when the client accepts
+// it, and decompresses request bodies sent with either content encoding.
+// Flushes pass through, so event streams are delivered without delay.
+func Compress(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("Content-Encoding") {
+		case "":
+		case compression.Gzip:
+			reader, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "Invalid gzip request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = reader
+			r.Header.Del("Content-Encoding")
+		case compression.Zstd:
+			reader, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				http.Error(w, "Invalid zstd request body", http.StatusBadRequest)
+				return
+			}
+			defer reader.Close()
+			r.Body = io.NopCloser(reader)
+			r.Header.Del("Content-Encoding")
+		default:
+			http.Error(w, "Unsupported content encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		codec := negotiate(r.Header.Get("Accept-Encoding"))
+		if codec == compression.None {
+			next(w, r)
+			return
+		}
+
+		// Handlers behind this middleware must not compress a second time
+		r.Header.Del("Accept-Encoding")
+
+		cw := &compressWriter{ResponseWriter: w, codec: codec}
+		defer cw.Close()
+		next(cw, r)
+	}
+}
+
+// negotiate picks the preferred codec out of an Accept-Encoding header
+func negotiate(header string) string {
+	gzipAccepted := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.ReplaceAll(params, " ", "") == "q=0" {
+			continue
+		}
+		switch strings.ToLower(name) {
+		case compression.Zstd:
+			return compression.Zstd
+		case compression.Gzip:
+			gzipAccepted = true
+		}
+	}
+	if gzipAccepted {
+		return compression.Gzip
+	}
+	return compression.None
+}
+
+// precompressed reports whether a content type is compressed already, like
+// gzip compressed exports
+func precompressed(contentType string) bool {
+	return contentType == "application/gzip" || contentType == "application/zstd"
+}
+
+// compressWriter compresses the response body once the handler starts writing it
+type compressWriter struct {
+	http.ResponseWriter
+	codec       string
+	encoder     encoder
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.wroteHeader {
+		return
+	}
+	cw.wroteHeader = true
+
+	header := cw.Header()
+	bodyless := code == http.StatusNoContent || code == http.StatusNotModified || code < http.StatusOK
+	if !bodyless && header.Get("Content-Encoding") == "" && !precompressed(header.Get("Content-Type")) {
+		header.Set("Content-Encoding", cw.codec)
+		header.Add("Vary", "Accept-Encoding")
+		header.Del("Content-Length")
+
+		if cw.codec == compression.Zstd {
+			cw.encoder, _ = zstd.NewWriter(cw.ResponseWriter, zstd.WithEncoderConcurrency(1))
+		} else {
+			cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.encoder == nil {
+		return cw.ResponseWriter.Write(b)
+	}
+	return cw.encoder.Write(b)
+}
+
+// Close writes the end of the compressed body
+func (cw *compressWriter) Close() error {
+	if cw.encoder == nil {
+		return nil
+	}
+	return cw.encoder.Close()
+}
+
+func (cw *compressWriter) Flush() {
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if flusher, ok := cw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (cw *compressWriter) CloseNotify() <-chan bool {
+	if cn, ok := cw.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return nil
+}
@@ -2,36 +2,64 @@ package middleware
 
 import (
 	"context"
+	"errors"
 
+	"github.com/idot-digital/events-db/internal/tenancy"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
-// AuthInterceptor returns a new unary server interceptor for authentication
-func AuthInterceptor(authToken string) grpc.UnaryServerInterceptor {
-	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		// Skip auth if no token is configured
-		if authToken == "" {
-			return handler(ctx, req)
-		}
+// contextStream overrides the context of a server stream
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
 
-		// Get metadata from context
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Error(codes.Unauthenticated, "metadata is not provided")
-		}
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
 
-		// Get authorization token from metadata
-		auth := md.Get("authorization")
-		if len(auth) == 0 {
-			return nil, status.Error(codes.Unauthenticated, "authorization token is not provided")
-		}
+// authenticate resolves the principal of a call from its metadata
+func authenticate(ctx context.Context, registry *tenancy.Registry) (context.Context, error) {
+	// Get metadata from context
+	md, _ := metadata.FromIncomingContext(ctx)
 
-		// Check if token matches
-		if auth[0] != authToken {
-			return nil, status.Error(codes.Unauthenticated, "invalid authorization token")
+	// Get authorization token and tenant from metadata
+	token := ""
+	if auth := md.Get("authorization"); len(auth) > 0 {
+		token = auth[0]
+	}
+	requested := ""
+	if tenant := md.Get(tenancy.MetadataKey); len(tenant) > 0 {
+		requested = tenant[0]
+	}
+
+	principal, err := registry.Resolve(ctx, token, requested)
+	switch {
+	case err == nil:
+		recordTenant(ctx, principal)
+		return tenancy.NewContext(ctx, principal), nil
+	case errors.Is(err, tenancy.ErrInvalidToken) && token == "":
+		return nil, status.Error(codes.Unauthenticated, "authorization token is not provided")
+	case errors.Is(err, tenancy.ErrInvalidToken):
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization token")
+	case errors.Is(err, tenancy.ErrUnknownTenant):
+		return nil, status.Error(codes.PermissionDenied, "unknown tenant")
+	case errors.Is(err, tenancy.ErrTenantMismatch):
+		return nil, status.Error(codes.PermissionDenied, "token is not valid for the selected tenant")
+	default:
+		return nil, status.Error(codes.Internal, "Internal server error")
+	}
+}
+
+// AuthInterceptor returns a new unary server interceptor for authentication
+func AuthInterceptor(registry *tenancy.Registry) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, registry)
+		if err != nil {
+			return nil, err
 		}
 
 		return handler(ctx, req)
@@ -39,30 +67,13 @@ func AuthInterceptor(authToken string) grpc.UnaryServerInterceptor {
 }
 
 // StreamAuthInterceptor returns a new stream server interceptor for authentication
-func StreamAuthInterceptor(authToken string) grpc.StreamServerInterceptor {
+func StreamAuthInterceptor(registry *tenancy.Registry) grpc.StreamServerInterceptor {
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		// Skip auth if no token is configured
-		if authToken == "" {
-			return handler(srv, ss)
-		}
-
-		// Get metadata from context
-		md, ok := metadata.FromIncomingContext(ss.Context())
-		if !ok {
-			return status.Error(codes.Unauthenticated, "metadata is not provided")
-		}
-
-		// Get authorization token from metadata
-		auth := md.Get("authorization")
-		if len(auth) == 0 {
-			return status.Error(codes.Unauthenticated, "authorization token is not provided")
-		}
-
-		// Check if token matches
-		if auth[0] != authToken {
-			return status.Error(codes.Unauthenticated, "invalid authorization token")
+		ctx, err := authenticate(ss.Context(), registry)
+		if err != nil {
+			return err
 		}
 
-		return handler(srv, ss)
+		return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
 	}
 }
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/idot-digital/events-db/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// countingStream counts the bytes of the messages passing through a stream
+type countingStream struct {
+	grpc.ServerStream
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+func (s *countingStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if msg, ok := m.(proto.Message); ok && err == nil {
+		s.sent.Add(int64(proto.Size(msg)))
+	}
+	return err
+}
+
+func (s *countingStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if msg, ok := m.(proto.Message); ok && err == nil {
+		s.received.Add(int64(proto.Size(msg)))
+	}
+	return err
+}
+
+// messageSize returns the encoded size of a message, or 0 for anything else
+func messageSize(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok {
+		return proto.Size(msg)
+	}
+	return 0
+}
+
+// peerAddress returns the remote address of the call
+func peerAddress(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// MetricsInterceptor returns a new unary server interceptor recording the same
+// operation metrics as the REST middleware, labeled by method, status code and
+// tenant. It must run before the auth interceptor, so rejected calls are
+// counted as well, with an empty tenant.
+func MetricsInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx, label := withTenantLabel(ctx)
+		// Calls that panic are recorded as the recovery interceptor reports them
+		code := codes.Internal
+		defer func() {
+			operation := path.Base(info.FullMethod)
+			metrics.EventOperationDuration.WithLabelValues(operation, label.tenant).Observe(time.Since(start).Seconds())
+			metrics.EventOperations.WithLabelValues(operation, code.String(), label.tenant).Inc()
+		}()
+
+		resp, err := handler(ctx, req)
+		code = status.Code(err)
+		return resp, err
+	}
+}
+
+// StreamMetricsInterceptor returns a new stream server interceptor recording
+// the same operation metrics as the REST middleware. It must run before the
+// auth interceptor.
+func StreamMetricsInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, label := withTenantLabel(ss.Context())
+		// Streams that panic are recorded as the recovery interceptor reports them
+		code := codes.Internal
+		defer func() {
+			operation := path.Base(info.FullMethod)
+			metrics.EventOperationDuration.WithLabelValues(operation, label.tenant).Observe(time.Since(start).Seconds())
+			metrics.EventOperations.WithLabelValues(operation, code.String(), label.tenant).Inc()
+		}()
+
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		code = status.Code(err)
+		return err
+	}
+}
+
+// LoggingInterceptor returns a new unary server interceptor writing an access log entry per call
+func LoggingInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		// Calls that panic are logged as the recovery interceptor reports them
+		code := codes.Internal
+		var resp interface{}
+		defer func() {
+			logger.Info("gRPC request",
+				"method", info.FullMethod,
+				"peer", peerAddress(ctx),
+				"code", code.String(),
+				"duration", time.Since(start),
+				"bytes_received", messageSize(req),
+				"bytes_sent", messageSize(resp),
+			)
+		}()
+
+		resp, err := handler(ctx, req)
+		code = status.Code(err)
+		return resp, err
+	}
+}
+
+// StreamLoggingInterceptor returns a new stream server interceptor writing an
+// access log entry when a stream ends
+func StreamLoggingInterceptor(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		stream := &countingStream{ServerStream: ss}
+		// Streams that panic are logged as the recovery interceptor reports them
+		code := codes.Internal
+		defer func() {
+			logger.Info("gRPC stream",
+				"method", info.FullMethod,
+				"peer", peerAddress(ss.Context()),
+				"code", code.String(),
+				"duration", time.Since(start),
+				"bytes_received", stream.received.Load(),
+				"bytes_sent", stream.sent.Load(),
+			)
+		}()
+
+		err := handler(srv, stream)
+		code = status.Code(err)
+		return err
+	}
+}
+
+// RecoveryInterceptor returns a new unary server interceptor converting panics into codes.Internal
+func RecoveryInterceptor(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("Recovered from panic", "method", info.FullMethod, "panic", r, "stack", string(debug.Stack()))
+				err = status.Error(codes.Internal, "Internal server error")
+			}
+		}()
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecoveryInterceptor returns a new stream server interceptor converting panics into codes.Internal
+func StreamRecoveryInterceptor(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Error("Recovered from panic", "method", info.FullMethod, "panic", r, "stack", string(debug.Stack()))
+				err = status.Error(codes.Internal, "Internal server error")
+			}
+		}()
+
+		return handler(srv, ss)
+	}
+}
@@ -1,26 +1,61 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/tenancy"
 )
 
-// MetricsMiddleware wraps an HTTP handler with Prometheus metrics
+// tenantLabel carries the tenant resolved by the auth middleware out to the
+// metrics recorded around it. It stays empty for rejected requests.
+type tenantLabel struct {
+	tenant string
+}
+
+type tenantLabelKey struct{}
+
+// withTenantLabel returns a context the auth middleware records the tenant in
+func withTenantLabel(ctx context.Context) (context.Context, *tenantLabel) {
+	label := &tenantLabel{}
+	return context.WithValue(ctx, tenantLabelKey{}, label), label
+}
+
+// recordTenant records the tenant of an authenticated request for its metrics
+func recordTenant(ctx context.Context, principal tenancy.Principal) {
+	if label, ok := ctx.Value(tenantLabelKey{}).(*tenantLabel); ok {
+		label.tenant = principal.Tenant
+	}
+}
+
+// MetricsMiddleware wraps an HTTP handler with Prometheus metrics. It runs
+// before the auth middleware, so rejected requests are counted as well.
 func Metrics(next http.HandlerFunc, operation string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		ctx, label := withTenantLabel(r.Context())
 
 		// Create a custom response writer to capture the status code
 		rw := &responseWriter{ResponseWriter: w}
 
-		next(rw, r)
+		// Requests that panic are recorded as the 500 the recovery middleware
+		// responds with
+		statusCode := http.StatusInternalServerError
+		defer func() {
+			duration := time.Since(start).Seconds()
+			metrics.EventOperationDuration.WithLabelValues(operation, label.tenant).Observe(duration)
+			metrics.EventOperations.WithLabelValues(operation, fmt.Sprintf("%d", statusCode), label.tenant).Inc()
+		}()
+
+		next(rw, r.WithContext(ctx))
 
-		// Record metrics
-		duration := time.Since(start).Seconds()
-		metrics.EventOperationDuration.WithLabelValues(operation).Observe(duration)
-		metrics.EventOperations.WithLabelValues(operation, fmt.Sprintf("%d", rw.statusCode)).Inc()
+		// net/http responds with 200 to handlers that wrote nothing
+		statusCode = rw.statusCode
+		if statusCode == 0 {
+			statusCode = http.StatusOK
+		}
 	}
 }
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+)
+
+// Recovery converts panics in the wrapped handler into a 500 response
+func Recovery(next http.HandlerFunc, logger *slog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				// Let net/http abort the response as intended
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+
+				logger.Error("Recovered from panic", "path", r.URL.Path, "panic", rec, "stack", string(debug.Stack()))
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+		}()
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,139 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// column is a column added to a table after the table was first released
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+// columns lists the columns added since the tables were first released. New
+// deployments get them from schema.sql, existing ones through Migrate.
+var columns = []column{
+	{"events", "traceparent", "VARCHAR(55) NOT NULL DEFAULT ''"},
+	{"events", "tracestate", "VARCHAR(512) NOT NULL DEFAULT ''"},
+	{"events", "tenant", "VARCHAR(64) NOT NULL DEFAULT 'default' AFTER `id`"},
+	{"events", "data_key", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"events", "data_codec", "VARCHAR(16) NOT NULL DEFAULT ''"},
+	{"events", "blob_sha256", "CHAR(64) NOT NULL DEFAULT ''"},
+	{"events", "blob_size", "BIGINT NOT NULL DEFAULT 0"},
+	{"events", "datacontenttype", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"events", "schema_version", "INT NOT NULL DEFAULT 0"},
+	{"events", "metadata", "JSON NOT NULL DEFAULT (JSON_OBJECT())"},
+	{"events", "correlation_id", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"events", "causation_id", "VARCHAR(255) NOT NULL DEFAULT ''"},
+	{"events", "occurred_at", "DATETIME(6) NULL"},
+	{"events", "tombstone_id", "BIGINT NOT NULL DEFAULT 0"},
+	{"events", "tombstoned_at", "DATETIME(6) NULL"},
+	{"archive_segments", "blobs_recorded", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"archive_segment_subjects", "event_count", "BIGINT NOT NULL DEFAULT 0"},
+	{"archive_segment_subjects", "size_bytes", "BIGINT NOT NULL DEFAULT 0"},
+	{"blobs", "uploaded_at", "DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP"},
+}
+
+// modification is a column whose type changed after the table was first
+// released, columnType is the new type as information_schema reports it
+type modification struct {
+	table      string
+	name       string
+	columnType string
+	definition string
+}
+
+// modifications lists the columns whose type changed since they were first released
+var modifications = []modification{
+	{"events", "time", "datetime(6)", "DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)"},
+}
+
+// index is an index added to a table after the table was first released
+type index struct {
+	table   string
+	name    string
+	columns string
+}
+
+// indexes lists the indexes added since the tables were first released
+var indexes = []index{
+	{"events", "idx_tenant_subject", "`tenant`, `subject`, `id`"},
+	{"events", "idx_tenant_correlation", "`tenant`, `correlation_id`, `id`"},
+	{"events", "idx_tombstone", "`tombstone_id`"},
+	{"payload_index_entries", "idx_event", "`event_id`"},
+	{"events", "idx_tenant_blob", "`tenant`, `blob_sha256`"},
+}
+
+// Migrate executes the statements of the schema, adds the columns and
+// indexes missing from tables created by an earlier version and changes the
+// types of their modified columns
+func Migrate(ctx context.Context, db *sql.DB, schema string) error {
+	for _, statement := range strings.Split(schema, ";") {
+		if strings.TrimSpace(statement) == "" {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range columns {
+		var count int
+		err := db.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+			c.table, c.name,
+		).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE `%s` ADD COLUMN `%s` %s", c.table, c.name, c.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.name, err)
+		}
+	}
+
+	for _, m := range modifications {
+		var columnType string
+		err := db.QueryRowContext(ctx,
+			"SELECT COLUMN_TYPE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?",
+			m.table, m.name,
+		).Scan(&columnType)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(columnType, m.columnType) {
+			continue
+		}
+
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE `%s` MODIFY COLUMN `%s` %s", m.table, m.name, m.definition)); err != nil {
+			return fmt.Errorf("failed to modify column %s.%s: %w", m.table, m.name, err)
+		}
+	}
+
+	for _, i := range indexes {
+		var count int
+		err := db.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?",
+			i.table, i.name,
+		).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE `%s` ADD INDEX `%s` (%s)", i.table, i.name, i.columns)); err != nil {
+			return fmt.Errorf("failed to add index %s.%s: %w", i.table, i.name, err)
+		}
+	}
+
+	return nil
+}
@@ -2,11 +2,45 @@ package models
 
 type Event struct {
 	ID      int64  `json:"id"`
+	Tenant  string `json:"-"`
 	Source  string `json:"source"`
 	Type    string `json:"type"`
 	Subject string `json:"subject"`
-	Time    string `json:"time"`
-	Data    []byte `json:"data"`
+	// Time is when the server stored the event, OccurredAt when the event
+	// occurred according to its producer. Both are RFC 3339 with microseconds.
+	Time        string `json:"time"`
+	OccurredAt  string `json:"occurred_at,omitempty"`
+	Data        []byte `json:"data"`
+	TraceParent string `json:"traceparent,omitempty"`
+	TraceState  string `json:"tracestate,omitempty"`
+	DataKey     string `json:"data_key,omitempty"`
+	// DataCodec is the compression codec of Data while it is in stored form
+	DataCodec string `json:"-"`
+	// Redacted is set when the data key was erased, Data is empty then
+	Redacted bool `json:"redacted,omitempty"`
+	// Blob is set when the payload is stored as a blob, Data is empty then
+	Blob            *Blob  `json:"blob,omitempty"`
+	DataContentType string `json:"datacontenttype,omitempty"`
+	// DataSchema is the URI of the schema version the payload was validated against
+	DataSchema    string `json:"dataschema,omitempty"`
+	SchemaVersion int32  `json:"-"`
+	// Metadata holds the attributes set by the producer and the ones the
+	// server records about the request that created the event
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// CorrelationID groups the events of a workflow across subjects,
+	// CausationID identifies the event or message that caused this one
+	CorrelationID string `json:"correlation_id,omitempty"`
+	CausationID   string `json:"causation_id,omitempty"`
+	// TombstoneID is set when an administrator removed the event, its payload
+	// and metadata are gone then. TombstonedAt is when, in RFC 3339.
+	TombstoneID  int64  `json:"tombstone_id,omitempty"`
+	TombstonedAt string `json:"tombstoned_at,omitempty"`
+}
+
+// Blob is a payload stored outside of the event, identified by its SHA-256
+type Blob struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
 }
 
 type CreateEventRequest struct {
@@ -14,8 +48,50 @@ type CreateEventRequest struct {
 	Type    string `json:"type"`
 	Subject string `json:"subject"`
 	Data    []byte `json:"data"`
+	// DataKey is the key the payload is encrypted with, defaults to the subject
+	DataKey string `json:"data_key,omitempty"`
+	// BlobSHA256 references an uploaded blob holding the payload, instead of Data
+	BlobSHA256      string `json:"blob_sha256,omitempty"`
+	DataContentType string `json:"datacontenttype,omitempty"`
+	// SchemaVersion pins the schema version to validate against, defaults to the latest
+	SchemaVersion int32 `json:"schema_version,omitempty"`
+	// Metadata holds attributes like the actor or producer version, keys
+	// starting with eventsdb. are reserved for the server
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// CorrelationID defaults to the one of the event named by CausationID
+	CorrelationID string `json:"correlation_id,omitempty"`
+	CausationID   string `json:"causation_id,omitempty"`
+	// OccurredAt is the RFC 3339 time the event occurred according to the producer
+	OccurredAt string `json:"occurred_at,omitempty"`
 }
 
 type CreateEventResponse struct {
 	ID int64 `json:"id"`
+	// Time is when the server stored the event, as reads return it
+	Time string `json:"time"`
+}
+
+type ReadAllResponse struct {
+	Events   []*Event `json:"events"`
+	Position int64    `json:"position"`
+	End      bool     `json:"end"`
+}
+
+type EraseDataKeyRequest struct {
+	DataKey string `json:"data_key"`
+}
+
+type EraseDataKeyResponse struct {
+	Erased bool `json:"erased"`
+}
+
+// SearchResult is a page of events found by a payload search
+type SearchResult struct {
+	Events []*Event `json:"events"`
+	// Position is the ID to continue the search after
+	Position int64 `json:"position"`
+	// Scanned is the number of events read by the search
+	Scanned int64 `json:"scanned"`
+	// End is set once the whole range was searched
+	End bool `json:"end"`
 }
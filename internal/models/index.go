@@ -0,0 +1,27 @@
+package models
+
+// PayloadIndex is a secondary index on a JSON path of the payloads of an
+// event type. Position is the event ID up to which existing events were
+// indexed, Ready is set once all of them are.
+type PayloadIndex struct {
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Path      string `json:"path"`
+	Position  int64  `json:"position"`
+	Ready     bool   `json:"ready"`
+	CreatedAt string `json:"created_at"`
+}
+
+type CreatePayloadIndexRequest struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Path string `json:"path"`
+}
+
+type ReadIndexedResponse struct {
+	Events   []*Event `json:"events"`
+	Position int64    `json:"position"`
+	End      bool     `json:"end"`
+	// Ready is unset while existing events are indexed, older events may be missing then
+	Ready bool `json:"ready"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import "encoding/json"
+
+// Schema is a version of the payload schema of an event type. JSON schemas
+// are held in Schema, protobuf schemas as a FileDescriptorSet in Descriptor
+// together with the name of the Message.
+type Schema struct {
+	Type       string          `json:"type"`
+	Version    int32           `json:"version"`
+	Format     string          `json:"format"`
+	Schema     json.RawMessage `json:"schema,omitempty"`
+	Descriptor []byte          `json:"descriptor,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+type RegisterSchemaRequest struct {
+	Type       string          `json:"type"`
+	Format     string          `json:"format"`
+	Schema     json.RawMessage `json:"schema,omitempty"`
+	Descriptor []byte          `json:"descriptor,omitempty"`
+	Message    string          `json:"message,omitempty"`
+	// Compatibility is checked against the latest version, defaults to backward
+	Compatibility string `json:"compatibility,omitempty"`
+}
+
+type CompatibilityResponse struct {
+	Compatible bool     `json:"compatible"`
+	Problems   []string `json:"problems"`
+}
+
+// Upcaster transforms payloads of a schema version into the next version on read
+type Upcaster struct {
+	Type        string            `json:"type"`
+	FromVersion int32             `json:"from_version"`
+	Operations  []UpcastOperation `json:"operations"`
+	UpdatedAt   string            `json:"updated_at,omitempty"`
+}
+
+// UpcastOperation is a step of an upcaster, addressing payload fields by JSON pointer
+type UpcastOperation struct {
+	// Op is one of move, copy, remove, set and default
+	Op    string          `json:"op"`
+	From  string          `json:"from,omitempty"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
@@ -0,0 +1,21 @@
+package models
+
+// StreamStats is the state of the live event fan-out
+type StreamStats struct {
+	// HeadID is the ID of the newest event emitted since the server started
+	HeadID int64 `json:"head_id"`
+	// Backlog is the number of events waiting in the emitter channel
+	Backlog int           `json:"backlog"`
+	Groups  []StreamGroup `json:"groups"`
+}
+
+// StreamGroup aggregates the live streams of a tenant by the first segment of their subject
+type StreamGroup struct {
+	Tenant  string `json:"tenant"`
+	Group   string `json:"group"`
+	Clients int    `json:"clients"`
+	// MaxLag is the highest distance in event IDs between the newest event and a stream's position
+	MaxLag int64 `json:"max_lag"`
+	// BufferUsage is the fill level of the fullest listener buffer
+	BufferUsage float64 `json:"buffer_usage"`
+}
@@ -0,0 +1,29 @@
+package models
+
+type Tenant struct {
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+}
+
+type CreateTenantRequest struct {
+	Name string `json:"name"`
+}
+
+type TenantToken struct {
+	ID          int64  `json:"id"`
+	Tenant      string `json:"tenant"`
+	Description string `json:"description"`
+	CreatedAt   string `json:"created_at"`
+}
+
+type CreateTenantTokenRequest struct {
+	Tenant      string `json:"tenant"`
+	Description string `json:"description"`
+}
+
+type CreateTenantTokenResponse struct {
+	ID     int64  `json:"id"`
+	Tenant string `json:"tenant"`
+	// Token is only returned once, the server keeps its hash
+	Token string `json:"token"`
+}
@@ -0,0 +1,43 @@
+package models
+
+// TombstoneRequest removes a single event, the events of a subject or, with
+// Recursive, the events of a subject and the subjects below it
+type TombstoneRequest struct {
+	EventID   int64  `json:"event_id,omitempty"`
+	Subject   string `json:"subject,omitempty"`
+	Recursive bool   `json:"recursive,omitempty"`
+	// Reason is recorded in the audit trail, like the ticket or legal request
+	Reason string `json:"reason"`
+	// Actor is the person asking for the removal, defaults to the principal
+	Actor string `json:"actor,omitempty"`
+}
+
+// AuditEntry records a removal of events. Tombstone entries name what was
+// removed by whom and why, purge entries the tombstone whose events were
+// deleted for good once its grace period passed.
+type AuditEntry struct {
+	ID          int64  `json:"id"`
+	Action      string `json:"action"`
+	EventID     int64  `json:"event_id,omitempty"`
+	Subject     string `json:"subject,omitempty"`
+	TombstoneID int64  `json:"tombstone_id,omitempty"`
+	// Events is the number of events a purge deleted
+	Events     int64  `json:"events,omitempty"`
+	Actor      string `json:"actor"`
+	Principal  string `json:"principal,omitempty"`
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	Reason     string `json:"reason"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type TombstoneResponse struct {
+	Tombstone AuditEntry `json:"tombstone"`
+	// Events is the number of events replaced by tombstones
+	Events int64 `json:"events"`
+}
+
+type AuditResponse struct {
+	Entries  []AuditEntry `json:"entries"`
+	Position int64        `json:"position"`
+	End      bool         `json:"end"`
+}
@@ -0,0 +1,19 @@
+package models
+
+// ImportProgress reports how far an import got, it is sent periodically
+// while the import runs and once more when it ends
+type ImportProgress struct {
+	ImportID string `json:"import_id"`
+	Mode     string `json:"mode"`
+	// Read is the number of events read from the import file
+	Read int64 `json:"read"`
+	// Imported is the number of events stored
+	Imported int64 `json:"imported"`
+	// Skipped is the number of events imported before or redacted in the file
+	Skipped int64 `json:"skipped"`
+	// LastID is the ID in the import file of the last event read
+	LastID string `json:"last_id,omitempty"`
+	Done   bool   `json:"done"`
+	// Error is why the import stopped early, it can be repeated after fixing it
+	Error string `json:"error,omitempty"`
+}
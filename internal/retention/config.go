@@ -0,0 +1,98 @@
+package retention
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+)
+
+// Duration is a time.Duration read from strings like "720h" in JSON
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(raw []byte) error {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Rule selects events by tenant, subject prefix and type and decides which of
+// them to delete. Each criterion is applied on its own, so an event is
+// deleted as soon as one of them matches. Zero values disable a criterion.
+type Rule struct {
+	Name string `json:"name"`
+	// Tenant restricts the rule to one tenant, empty applies it to all
+	Tenant string `json:"tenant"`
+	Prefix string `json:"prefix"`
+	// Type restricts the rule to events of one type, empty applies it to all
+	Type string `json:"type"`
+	// MaxAge deletes events older than the duration
+	MaxAge Duration `json:"max_age"`
+	// MaxCount keeps the newest events of each subject
+	MaxCount int64 `json:"max_count"`
+	// SnapshotType deletes the events of each subject stored before its
+	// latest event of this type
+	SnapshotType string `json:"snapshot_type"`
+}
+
+// Config is read from the JSON file named by RETENTION_CONFIG
+type Config struct {
+	// Interval between two runs of the background job
+	Interval Duration `json:"interval"`
+	// BatchSize is the number of events deleted per statement
+	BatchSize int32 `json:"batch_size"`
+	// BatchPause is waited between two batches to give other queries room
+	BatchPause Duration `json:"batch_pause"`
+	// DryRun only reports the events the rules would delete
+	DryRun bool   `json:"dry_run"`
+	Rules  []Rule `json:"rules"`
+}
+
+// Load reads and validates a retention configuration file
+func Load(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid retention config: %w", err)
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = Duration(10 * time.Minute)
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1000
+	}
+
+	names := make(map[string]struct{})
+	for i, rule := range cfg.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("retention rule %d has no name", i)
+		}
+		if _, ok := names[rule.Name]; ok {
+			return nil, fmt.Errorf("duplicate retention rule %q", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+
+		if rule.MaxAge <= 0 && rule.MaxCount <= 0 && rule.SnapshotType == "" {
+			return nil, fmt.Errorf("retention rule %q has no max_age, max_count or snapshot_type", rule.Name)
+		}
+		if rule.MaxCount > math.MaxInt32 {
+			return nil, fmt.Errorf("max_count of retention rule %q is too large", rule.Name)
+		}
+	}
+
+	return &cfg, nil
+}
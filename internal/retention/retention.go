@@ -0,0 +1,251 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/metrics"
+)
+
+// Result reports the events a rule deleted, or would delete in a dry run
+type Result struct {
+	Rule   string `json:"rule"`
+	Events int64  `json:"events"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// Pruner applies the retention rules. Every criterion is turned into an event
+// ID below which events are deleted, in batches of ascending IDs.
+//
+// Events that an attached stream hasn't reached yet are never deleted, so
+// lagging streams replay a consistent history. Streams resuming from a
+// position whose history was pruned continue with the oldest retained event.
+type Pruner struct {
+	queries        *database.Queries
+	cfg            *Config
+	lowestPosition func() int64
+	logger         *slog.Logger
+	// mutex serializes runs of the background job and the admin API
+	mutex sync.Mutex
+}
+
+func New(cfg *Config, queries *database.Queries, lowestPosition func() int64, logger *slog.Logger) *Pruner {
+	return &Pruner{
+		queries:        queries,
+		cfg:            cfg,
+		lowestPosition: lowestPosition,
+		logger:         logger,
+	}
+}
+
+// Run applies the rules every interval until the context is done
+func (p *Pruner) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(p.cfg.Interval))
+	defer ticker.Stop()
+
+	for {
+		if _, err := p.Apply(ctx); err != nil && ctx.Err() == nil {
+			p.logger.Error("Failed to apply retention rules", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Apply applies every rule once, as a dry run if the configuration says so
+func (p *Pruner) Apply(ctx context.Context) ([]Result, error) {
+	return p.Prune(ctx, p.cfg.DryRun)
+}
+
+// Prune applies every rule once. A dry run only counts the events that would be deleted.
+func (p *Pruner) Prune(ctx context.Context, dryRun bool) ([]Result, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	start := time.Now()
+
+	// Events up to the lowest stream position have been read by every stream
+	protected := int64(math.MaxInt64)
+	if lowest := p.lowestPosition(); lowest < math.MaxInt64 {
+		protected = lowest + 1
+	}
+
+	results := make([]Result, 0, len(p.cfg.Rules))
+	for _, rule := range p.cfg.Rules {
+		count, err := p.apply(ctx, rule, protected, dryRun)
+		if err != nil {
+			return results, fmt.Errorf("retention rule %q: %w", rule.Name, err)
+		}
+
+		if dryRun {
+			metrics.RetentionMatchedEvents.WithLabelValues(rule.Name).Set(float64(count))
+		} else {
+			metrics.RetentionDeletedEvents.WithLabelValues(rule.Name).Add(float64(count))
+		}
+		if count > 0 {
+			p.logger.Info("Applied retention rule", "rule", rule.Name, "events", count, "dry_run", dryRun)
+		}
+
+		results = append(results, Result{Rule: rule.Name, Events: count, DryRun: dryRun})
+	}
+
+	metrics.RetentionRunDuration.Observe(time.Since(start).Seconds())
+	if !dryRun {
+		metrics.RetentionLastRun.SetToCurrentTime()
+	}
+
+	return results, nil
+}
+
+// apply deletes or counts the events selected by a rule, keeping events from protected on
+func (p *Pruner) apply(ctx context.Context, rule Rule, protected int64, dryRun bool) (int64, error) {
+	tenantPattern := "%"
+	if rule.Tenant != "" {
+		tenantPattern = escapeLike(rule.Tenant)
+	}
+	typePattern := "%"
+	if rule.Type != "" {
+		typePattern = escapeLike(rule.Type)
+	}
+
+	// IDs grow with the insertion time, so events older than max_age are
+	// the ones before the first event stored since then
+	ageBound := int64(0)
+	if rule.MaxAge > 0 {
+		first, err := p.queries.GetFirstEventIDSince(ctx, time.Now().Add(-time.Duration(rule.MaxAge)))
+		if err != nil {
+			return 0, err
+		}
+		ageBound = first
+		if first == 0 {
+			ageBound = math.MaxInt64
+		}
+	}
+
+	if rule.MaxCount <= 0 && rule.SnapshotType == "" {
+		return p.delete(ctx, database.GetRetainedEventIDsParams{
+			TenantPattern:  tenantPattern,
+			SubjectPattern: escapeLike(rule.Prefix) + "%",
+			TypePattern:    typePattern,
+			BeforeID:       min(ageBound, protected),
+		}, dryRun)
+	}
+
+	subjects, err := p.queries.GetRetentionSubjects(ctx, database.GetRetentionSubjectsParams{
+		TenantPattern:  tenantPattern,
+		SubjectPattern: escapeLike(rule.Prefix) + "%",
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	total := int64(0)
+	for _, subject := range subjects {
+		bound := ageBound
+
+		if rule.MaxCount > 0 {
+			// The oldest event to keep, if there are more than max_count
+			oldest, err := p.queries.GetEventIDBySubjectOffset(ctx, database.GetEventIDBySubjectOffsetParams{
+				Tenant:      subject.Tenant,
+				Subject:     subject.Subject,
+				TypePattern: typePattern,
+				Offset:      int32(rule.MaxCount - 1),
+			})
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return total, err
+			}
+			bound = max(bound, oldest)
+		}
+
+		if rule.SnapshotType != "" {
+			snapshot, err := p.queries.GetLatestEventIDBySubjectAndType(ctx, database.GetLatestEventIDBySubjectAndTypeParams{
+				Tenant:  subject.Tenant,
+				Subject: subject.Subject,
+				Type:    rule.SnapshotType,
+			})
+			if err != nil {
+				return total, err
+			}
+			bound = max(bound, snapshot)
+		}
+
+		if bound == 0 {
+			continue
+		}
+
+		count, err := p.delete(ctx, database.GetRetainedEventIDsParams{
+			TenantPattern:  escapeLike(subject.Tenant),
+			SubjectPattern: escapeLike(subject.Subject),
+			TypePattern:    typePattern,
+			BeforeID:       min(bound, protected),
+		}, dryRun)
+		total += count
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// delete removes the selected events in batches, or counts them in a dry run
+func (p *Pruner) delete(ctx context.Context, selection database.GetRetainedEventIDsParams, dryRun bool) (int64, error) {
+	if selection.BeforeID <= 0 {
+		return 0, nil
+	}
+
+	if dryRun {
+		return p.queries.CountRetainedEvents(ctx, database.CountRetainedEventsParams{
+			TenantPattern:  selection.TenantPattern,
+			SubjectPattern: selection.SubjectPattern,
+			TypePattern:    selection.TypePattern,
+			BeforeID:       selection.BeforeID,
+		})
+	}
+
+	selection.Limit = p.cfg.BatchSize
+	total := int64(0)
+	for {
+		ids, err := p.queries.GetRetainedEventIDs(ctx, selection)
+		if err != nil || len(ids) == 0 {
+			return total, err
+		}
+		count, err := p.queries.DeleteEventsByIDs(ctx, ids)
+		if err != nil {
+			return total, err
+		}
+		total += count
+		// The index entries would keep the values of the deleted payloads
+		if err := p.queries.DeletePayloadIndexEntriesByEvents(ctx, ids); err != nil {
+			return total, err
+		}
+		if len(ids) < int(selection.Limit) {
+			return total, nil
+		}
+
+		// Short statements with pauses keep locks from piling up
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(time.Duration(p.cfg.BatchPause)):
+		}
+	}
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(s)
+}
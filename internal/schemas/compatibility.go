@@ -0,0 +1,197 @@
+package schemas
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Compatibility modes checked when registering a new schema version
+const (
+	// CompatibilityNone accepts any new version
+	CompatibilityNone = "none"
+	// CompatibilityBackward requires the new version to accept every payload valid under the previous one
+	CompatibilityBackward = "backward"
+	// CompatibilityForward requires the previous version to accept every payload valid under the new one
+	CompatibilityForward = "forward"
+	// CompatibilityFull requires both
+	CompatibilityFull = "full"
+)
+
+// ValidCompatibility reports whether mode names a compatibility mode
+func ValidCompatibility(mode string) bool {
+	return mode == CompatibilityNone || mode == CompatibilityBackward || mode == CompatibilityForward || mode == CompatibilityFull
+}
+
+// compatible returns the reasons a new schema isn't compatible with the
+// previous one in the given mode, none if it is
+func compatible(previous *compiled, next *compiled, mode string) []string {
+	if mode == CompatibilityNone {
+		return nil
+	}
+	if previous.format != next.format {
+		return []string{fmt.Sprintf("format changed from %s to %s", previous.format, next.format)}
+	}
+
+	if previous.format == FormatProtobuf {
+		// Decoding is symmetric, so one check covers both directions
+		return protoProblems(previous.message, next.message, "", map[protoreflect.FullName]bool{})
+	}
+
+	var problems []string
+	if mode == CompatibilityBackward || mode == CompatibilityFull {
+		problems = append(problems, jsonProblems(previous.document, next.document, "")...)
+	}
+	if mode == CompatibilityForward || mode == CompatibilityFull {
+		problems = append(problems, jsonProblems(next.document, previous.document, "")...)
+	}
+	return problems
+}
+
+// jsonProblems returns the structural differences that make reader reject
+// payloads valid under writer. Only the common keywords are compared: type,
+// enum, required, properties, additionalProperties and items.
+func jsonProblems(writer any, reader any, path string) []string {
+	w, _ := writer.(map[string]any)
+	r, _ := reader.(map[string]any)
+	if r == nil {
+		// A missing or boolean true reader schema accepts anything
+		if reader == false {
+			return []string{location(path) + ": no values are accepted anymore"}
+		}
+		return nil
+	}
+	if w == nil {
+		w = map[string]any{}
+	}
+
+	var problems []string
+
+	readerTypes := types(r["type"])
+	writerTypes := types(w["type"])
+	if len(readerTypes) > 0 {
+		if len(writerTypes) == 0 {
+			problems = append(problems, fmt.Sprintf("%s: type restricted to %v", location(path), readerTypes))
+		}
+		for _, t := range writerTypes {
+			if !slices.Contains(readerTypes, t) && !(t == "integer" && slices.Contains(readerTypes, "number")) {
+				problems = append(problems, fmt.Sprintf("%s: type %s no longer accepted", location(path), t))
+			}
+		}
+	}
+
+	if readerEnum, ok := r["enum"].([]any); ok {
+		writerEnum, ok := w["enum"].([]any)
+		if !ok {
+			problems = append(problems, location(path)+": values restricted to an enum")
+		}
+		for _, value := range writerEnum {
+			if !containsValue(readerEnum, value) {
+				problems = append(problems, fmt.Sprintf("%s: enum value %v no longer accepted", location(path), value))
+			}
+		}
+	}
+
+	writerRequired := stringArray(w["required"])
+	for _, name := range stringArray(r["required"]) {
+		if !slices.Contains(writerRequired, name) {
+			problems = append(problems, fmt.Sprintf("%s: property %q became required", location(path), name))
+		}
+	}
+
+	writerProperties, _ := w["properties"].(map[string]any)
+	readerProperties, _ := r["properties"].(map[string]any)
+	readerClosed := r["additionalProperties"] == false
+	for _, name := range slices.Sorted(maps.Keys(writerProperties)) {
+		writerProperty := writerProperties[name]
+		readerProperty, ok := readerProperties[name]
+		if !ok {
+			if readerClosed {
+				problems = append(problems, fmt.Sprintf("%s: property %q no longer allowed", location(path), name))
+			}
+			continue
+		}
+		problems = append(problems, jsonProblems(writerProperty, readerProperty, path+"/"+name)...)
+	}
+	if readerClosed && w["additionalProperties"] != false {
+		problems = append(problems, location(path)+": additional properties no longer allowed")
+	}
+
+	if readerItems, ok := r["items"]; ok {
+		problems = append(problems, jsonProblems(w["items"], readerItems, path+"/items")...)
+	}
+
+	return problems
+}
+
+// protoProblems returns the fields whose wire representation changed between two messages
+func protoProblems(previous protoreflect.MessageDescriptor, next protoreflect.MessageDescriptor, path string, seen map[protoreflect.FullName]bool) []string {
+	if seen[previous.FullName()] {
+		return nil
+	}
+	seen[previous.FullName()] = true
+
+	var problems []string
+	fields := previous.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		old := fields.Get(i)
+		field := next.Fields().ByNumber(old.Number())
+		name := fmt.Sprintf("%s/%s", path, old.Name())
+		if field == nil {
+			continue
+		}
+
+		if old.Kind() != field.Kind() {
+			problems = append(problems, fmt.Sprintf("%s: field %d changed from %s to %s", name, old.Number(), old.Kind(), field.Kind()))
+			continue
+		}
+		if old.Cardinality() != field.Cardinality() || old.IsMap() != field.IsMap() {
+			problems = append(problems, fmt.Sprintf("%s: field %d changed cardinality", name, old.Number()))
+			continue
+		}
+		if old.Message() != nil && field.Message() != nil {
+			problems = append(problems, protoProblems(old.Message(), field.Message(), name, seen)...)
+		}
+	}
+	return problems
+}
+
+// types returns the types allowed by a type keyword
+func types(value any) []string {
+	if t, ok := value.(string); ok {
+		return []string{t}
+	}
+	return stringArray(value)
+}
+
+// stringArray returns the strings of a JSON array
+func stringArray(value any) []string {
+	array, _ := value.([]any)
+	result := make([]string, 0, len(array))
+	for _, item := range array {
+		if s, ok := item.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// containsValue reports whether a JSON array contains a scalar value
+func containsValue(array []any, value any) bool {
+	for _, item := range array {
+		if fmt.Sprint(item) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// location returns a readable JSON pointer
+func location(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return path
+}
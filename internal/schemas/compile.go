@@ -0,0 +1,161 @@
+package schemas
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Formats a schema can be defined in
+const (
+	FormatJSON     = "json"
+	FormatProtobuf = "protobuf"
+)
+
+// Violation describes why a payload doesn't match a schema
+type Violation struct {
+	// Field is the JSON pointer of the offending value, empty for the whole payload
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// compiled is a schema ready to validate payloads
+type compiled struct {
+	format string
+	// json holds the JSON schema, for FormatJSON
+	json *jsonschema.Schema
+	// document is the parsed JSON schema, used for compatibility checks
+	document any
+	// message holds the protobuf message, for FormatProtobuf
+	message protoreflect.MessageDescriptor
+}
+
+// compile parses a schema definition. For FormatJSON the definition is a JSON
+// Schema, for FormatProtobuf a serialized FileDescriptorSet containing message.
+func compile(format string, definition []byte, message string) (*compiled, error) {
+	switch format {
+	case FormatJSON:
+		document, err := jsonschema.UnmarshalJSON(bytes.NewReader(definition))
+		if err != nil {
+			return nil, fmt.Errorf("schema is not valid JSON: %w", err)
+		}
+
+		compiler := jsonschema.NewCompiler()
+		// References to other documents would read files or fetch URLs
+		compiler.UseLoader(jsonschema.SchemeURLLoader{})
+		if err := compiler.AddResource("schema.json", document); err != nil {
+			return nil, err
+		}
+		schema, err := compiler.Compile("schema.json")
+		if err != nil {
+			return nil, err
+		}
+		return &compiled{format: format, json: schema, document: document}, nil
+
+	case FormatProtobuf:
+		var set descriptorpb.FileDescriptorSet
+		if err := proto.Unmarshal(definition, &set); err != nil {
+			return nil, fmt.Errorf("descriptor is not a FileDescriptorSet: %w", err)
+		}
+		files, err := protodesc.NewFiles(&set)
+		if err != nil {
+			return nil, err
+		}
+		descriptor, err := files.FindDescriptorByName(protoreflect.FullName(message))
+		if err != nil {
+			return nil, fmt.Errorf("message %q not found in descriptor", message)
+		}
+		md, ok := descriptor.(protoreflect.MessageDescriptor)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a message", message)
+		}
+		return &compiled{format: format, message: md}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported schema format %q, must be %s or %s", format, FormatJSON, FormatProtobuf)
+	}
+}
+
+// applies reports whether payloads of a content type are validated by the
+// schema. Payloads without a content type are validated by any schema.
+func (c *compiled) applies(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	switch c.format {
+	case FormatJSON:
+		return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+	case FormatProtobuf:
+		return mediaType == "application/protobuf" || mediaType == "application/x-protobuf"
+	default:
+		return false
+	}
+}
+
+// validate returns the violations of a payload, none if it matches
+func (c *compiled) validate(data []byte) []Violation {
+	switch c.format {
+	case FormatJSON:
+		instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+		if err != nil {
+			return []Violation{{Description: "payload is not valid JSON: " + err.Error()}}
+		}
+
+		err = c.json.Validate(instance)
+		var validationErr *jsonschema.ValidationError
+		if errors.As(err, &validationErr) {
+			return jsonViolations(validationErr)
+		}
+		if err != nil {
+			return []Violation{{Description: err.Error()}}
+		}
+		return nil
+
+	case FormatProtobuf:
+		message := dynamicpb.NewMessage(c.message)
+		if err := proto.Unmarshal(data, message); err != nil {
+			return []Violation{{Description: fmt.Sprintf("payload is not a valid %s: %v", c.message.FullName(), err)}}
+		}
+		if len(message.GetUnknown()) > 0 {
+			return []Violation{{Description: fmt.Sprintf("payload contains fields unknown to %s", c.message.FullName())}}
+		}
+		return nil
+
+	default:
+		return nil
+	}
+}
+
+// jsonViolations flattens a validation error into its leaf causes
+func jsonViolations(err *jsonschema.ValidationError) []Violation {
+	var violations []Violation
+	for _, unit := range err.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		// Errors of combinators only summarize their causes, which follow
+		description := unit.Error.String()
+		if strings.HasPrefix(description, "validation failed") {
+			continue
+		}
+		violations = append(violations, Violation{Field: unit.InstanceLocation, Description: description})
+	}
+	if len(violations) == 0 {
+		violations = append(violations, Violation{Description: err.Error()})
+	}
+	return violations
+}
@@ -0,0 +1,360 @@
+package schemas
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/models"
+)
+
+// cacheTTL is how long cached schemas are trusted. Versions registered on
+// another server instance are used for validation afterwards.
+const cacheTTL = 30 * time.Second
+
+// maxCacheEntries bounds the caches of compiled schemas, latest versions and upcasters
+const maxCacheEntries = 10000
+
+// mysqlDuplicateEntry is the MySQL error number of primary key violations
+const mysqlDuplicateEntry = 1062
+
+var (
+	// ErrNotFound is returned for schema versions that don't exist
+	ErrNotFound = errors.New("schema not found")
+	// ErrVersionConflict is returned when another version was registered concurrently
+	ErrVersionConflict = errors.New("schema version was registered concurrently")
+)
+
+// InvalidSchemaError is returned for schema definitions that don't compile
+type InvalidSchemaError struct {
+	Err error
+}
+
+func (e *InvalidSchemaError) Error() string {
+	return "invalid schema: " + e.Err.Error()
+}
+
+func (e *InvalidSchemaError) Unwrap() error {
+	return e.Err
+}
+
+// IncompatibleError is returned when a new schema version breaks compatibility
+type IncompatibleError struct {
+	Problems []string
+}
+
+func (e *IncompatibleError) Error() string {
+	return "schema is incompatible: " + strings.Join(e.Problems, "; ")
+}
+
+// ValidationError is returned for payloads not matching their schema
+type ValidationError struct {
+	Type       string
+	Version    int32
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		lines = append(lines, location(violation.Field)+": "+violation.Description)
+	}
+	return fmt.Sprintf("payload does not match schema %s version %d: %s", e.Type, e.Version, strings.Join(lines, "; "))
+}
+
+// URI returns the dataschema of events validated against a schema version
+func URI(eventType string, version int32) string {
+	if version == 0 {
+		return ""
+	}
+	return fmt.Sprintf("/schemas?type=%s&version=%d", url.QueryEscape(eventType), version)
+}
+
+// VersionFromURI returns the schema version of a dataschema built by URI,
+// 0 if it isn't one
+func VersionFromURI(uri string) int32 {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Path != "/schemas" {
+		return 0
+	}
+	version, err := strconv.ParseInt(parsed.Query().Get("version"), 10, 32)
+	if err != nil || version < 0 {
+		return 0
+	}
+	return int32(version)
+}
+
+type latestEntry struct {
+	// version is 0 if the type has no schema
+	version  int32
+	loadedAt time.Time
+}
+
+type compiledEntry struct {
+	schema   *compiled
+	loadedAt time.Time
+}
+
+// Registry keeps versioned schemas per tenant and event type, validates
+// payloads against them and upcasts payloads of older versions on read.
+// Registered versions are immutable.
+type Registry struct {
+	queries   *database.Queries
+	mutex     sync.Mutex
+	compiled  map[string]compiledEntry
+	latest    map[string]latestEntry
+	upcasters map[string]upcastersEntry
+}
+
+func NewRegistry(queries *database.Queries) *Registry {
+	return &Registry{
+		queries:   queries,
+		compiled:  make(map[string]compiledEntry),
+		latest:    make(map[string]latestEntry),
+		upcasters: make(map[string]upcastersEntry),
+	}
+}
+
+// Register adds a new version of the schema of an event type, after checking
+// its compatibility with the latest version
+func (r *Registry) Register(ctx context.Context, tenant string, schema models.Schema, mode string) (*models.Schema, error) {
+	problems, latest, err := r.check(ctx, tenant, schema, mode)
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) > 0 {
+		return nil, &IncompatibleError{Problems: problems}
+	}
+
+	schema.Version = latest + 1
+	err = r.queries.CreateSchema(ctx, database.CreateSchemaParams{
+		Tenant:     tenant,
+		Type:       schema.Type,
+		Version:    schema.Version,
+		Format:     schema.Format,
+		Message:    schema.Message,
+		Definition: definition(schema),
+	})
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+		return nil, ErrVersionConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	delete(r.latest, cacheKey(tenant, schema.Type, 0))
+	r.mutex.Unlock()
+
+	return &schema, nil
+}
+
+// Check returns the reasons a schema isn't compatible with the latest
+// version of its event type, none if it is or there is no version yet
+func (r *Registry) Check(ctx context.Context, tenant string, schema models.Schema, mode string) ([]string, error) {
+	problems, _, err := r.check(ctx, tenant, schema, mode)
+	return problems, err
+}
+
+// check compiles a schema and compares it with the latest version, which is returned
+func (r *Registry) check(ctx context.Context, tenant string, schema models.Schema, mode string) ([]string, int32, error) {
+	next, err := compile(schema.Format, definition(schema), schema.Message)
+	if err != nil {
+		return nil, 0, &InvalidSchemaError{Err: err}
+	}
+
+	row, err := r.queries.GetLatestSchema(ctx, database.GetLatestSchemaParams{
+		Tenant: tenant,
+		Type:   schema.Type,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	previous, err := r.load(tenant, row)
+	if err != nil {
+		return nil, 0, err
+	}
+	return compatible(previous, next, mode), row.Version, nil
+}
+
+// Get returns a version of the schema of an event type, the latest for version 0
+func (r *Registry) Get(ctx context.Context, tenant string, eventType string, version int32) (*models.Schema, error) {
+	var row database.EventSchema
+	var err error
+	if version == 0 {
+		row, err = r.queries.GetLatestSchema(ctx, database.GetLatestSchemaParams{
+			Tenant: tenant,
+			Type:   eventType,
+		})
+	} else {
+		row, err = r.queries.GetSchema(ctx, database.GetSchemaParams{
+			Tenant:  tenant,
+			Type:    eventType,
+			Version: version,
+		})
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return fromRow(row), nil
+}
+
+// List returns every version of the schema of an event type
+func (r *Registry) List(ctx context.Context, tenant string, eventType string) ([]*models.Schema, error) {
+	rows, err := r.queries.ListSchemas(ctx, database.ListSchemasParams{
+		Tenant: tenant,
+		Type:   eventType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	schemas := make([]*models.Schema, 0, len(rows))
+	for _, row := range rows {
+		schemas = append(schemas, fromRow(row))
+	}
+	return schemas, nil
+}
+
+// Validate checks a payload against a version of the schema of its event
+// type, the latest for version 0. It returns the version validated against,
+// or 0 if the type has no schema or the content type isn't covered by it.
+func (r *Registry) Validate(ctx context.Context, tenant string, eventType string, version int32, contentType string, data []byte) (int32, error) {
+	if version == 0 {
+		latest, err := r.latestVersion(ctx, tenant, eventType)
+		if err != nil || latest == 0 {
+			return 0, err
+		}
+		version = latest
+	}
+
+	schema, err := r.version(ctx, tenant, eventType, version)
+	if err != nil {
+		return 0, err
+	}
+	if !schema.applies(contentType) {
+		return 0, nil
+	}
+
+	if violations := schema.validate(data); len(violations) > 0 {
+		metrics.SchemaValidationFailures.WithLabelValues(tenant, eventType).Inc()
+		return 0, &ValidationError{Type: eventType, Version: version, Violations: violations}
+	}
+	return version, nil
+}
+
+// latestVersion returns the latest version of an event type, 0 if it has no schema
+func (r *Registry) latestVersion(ctx context.Context, tenant string, eventType string) (int32, error) {
+	key := cacheKey(tenant, eventType, 0)
+
+	r.mutex.Lock()
+	entry, ok := r.latest[key]
+	r.mutex.Unlock()
+	if ok && time.Since(entry.loadedAt) < cacheTTL {
+		return entry.version, nil
+	}
+
+	row, err := r.queries.GetLatestSchema(ctx, database.GetLatestSchemaParams{
+		Tenant: tenant,
+		Type:   eventType,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	r.mutex.Lock()
+	if len(r.latest) >= maxCacheEntries {
+		r.latest = make(map[string]latestEntry)
+	}
+	r.latest[key] = latestEntry{version: row.Version, loadedAt: time.Now()}
+	r.mutex.Unlock()
+
+	return row.Version, nil
+}
+
+// version returns a compiled schema version, from the cache if possible
+func (r *Registry) version(ctx context.Context, tenant string, eventType string, version int32) (*compiled, error) {
+	r.mutex.Lock()
+	entry, ok := r.compiled[cacheKey(tenant, eventType, version)]
+	r.mutex.Unlock()
+	if ok && time.Since(entry.loadedAt) < cacheTTL {
+		return entry.schema, nil
+	}
+
+	row, err := r.queries.GetSchema(ctx, database.GetSchemaParams{
+		Tenant:  tenant,
+		Type:    eventType,
+		Version: version,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.load(tenant, row)
+}
+
+// load compiles a stored schema version and caches it
+func (r *Registry) load(tenant string, row database.EventSchema) (*compiled, error) {
+	schema, err := compile(row.Format, row.Definition, row.Message)
+	if err != nil {
+		return nil, fmt.Errorf("stored schema %s version %d: %w", row.Type, row.Version, err)
+	}
+
+	r.mutex.Lock()
+	if len(r.compiled) >= maxCacheEntries {
+		r.compiled = make(map[string]compiledEntry)
+	}
+	r.compiled[cacheKey(tenant, row.Type, row.Version)] = compiledEntry{schema: schema, loadedAt: time.Now()}
+	r.mutex.Unlock()
+
+	return schema, nil
+}
+
+// cacheKey identifies a schema version, 0 standing for the latest
+func cacheKey(tenant string, eventType string, version int32) string {
+	return fmt.Sprintf("%s/%d/%s", tenant, version, eventType)
+}
+
+// definition returns the stored form of a schema definition
+func definition(schema models.Schema) []byte {
+	if schema.Format == FormatProtobuf {
+		return schema.Descriptor
+	}
+	return schema.Schema
+}
+
+// fromRow converts a stored schema version into its API representation
+func fromRow(row database.EventSchema) *models.Schema {
+	schema := &models.Schema{
+		Type:      row.Type,
+		Version:   row.Version,
+		Format:    row.Format,
+		Message:   row.Message,
+		CreatedAt: row.CreatedAt.Format(time.RFC3339),
+	}
+	if row.Format == FormatProtobuf {
+		schema.Descriptor = row.Definition
+	} else {
+		schema.Schema = row.Definition
+	}
+	return schema
+}
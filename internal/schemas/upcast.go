@@ -0,0 +1,375 @@
+package schemas
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/models"
+)
+
+// Operations of upcasters
+const (
+	// OpMove renames a field, nothing happens if it is missing
+	OpMove = "move"
+	// OpCopy copies a field, nothing happens if it is missing
+	OpCopy = "copy"
+	// OpRemove removes a field
+	OpRemove = "remove"
+	// OpSet sets a field to a value, replacing it if present
+	OpSet = "set"
+	// OpDefault sets a field to a value unless it is present
+	OpDefault = "default"
+)
+
+// InvalidUpcasterError is returned for upcasters that can't be applied
+type InvalidUpcasterError struct {
+	Err error
+}
+
+func (e *InvalidUpcasterError) Error() string {
+	return "invalid upcaster: " + e.Err.Error()
+}
+
+func (e *InvalidUpcasterError) Unwrap() error {
+	return e.Err
+}
+
+type upcastersEntry struct {
+	// operations are keyed by the version they upcast from
+	operations map[int32][]models.UpcastOperation
+	loadedAt   time.Time
+}
+
+// PutUpcaster registers the transform from a schema version to the next one,
+// replacing the previous transform of that version. Version 0 upcasts the
+// payloads written before the type had a schema.
+func (r *Registry) PutUpcaster(ctx context.Context, tenant string, upcaster models.Upcaster) (*models.Upcaster, error) {
+	if upcaster.FromVersion < 0 {
+		return nil, &InvalidUpcasterError{Err: errors.New("from_version must not be negative")}
+	}
+	for i, operation := range upcaster.Operations {
+		if err := checkOperation(operation); err != nil {
+			return nil, &InvalidUpcasterError{Err: fmt.Errorf("operation %d: %w", i, err)}
+		}
+	}
+
+	target, err := r.Get(ctx, tenant, upcaster.Type, upcaster.FromVersion+1)
+	if err != nil {
+		return nil, err
+	}
+	if target.Format != FormatJSON {
+		return nil, &InvalidUpcasterError{Err: fmt.Errorf("version %d is not a JSON schema", target.Version)}
+	}
+
+	if upcaster.Operations == nil {
+		upcaster.Operations = []models.UpcastOperation{}
+	}
+	operations, err := json.Marshal(upcaster.Operations)
+	if err != nil {
+		return nil, err
+	}
+	err = r.queries.PutUpcaster(ctx, database.PutUpcasterParams{
+		Tenant:      tenant,
+		Type:        upcaster.Type,
+		FromVersion: upcaster.FromVersion,
+		Operations:  operations,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.mutex.Lock()
+	delete(r.upcasters, cacheKey(tenant, upcaster.Type, 0))
+	r.mutex.Unlock()
+
+	upcaster.UpdatedAt = time.Now().Format(time.RFC3339)
+	return &upcaster, nil
+}
+
+// ListUpcasters returns the upcasters of an event type
+func (r *Registry) ListUpcasters(ctx context.Context, tenant string, eventType string) ([]*models.Upcaster, error) {
+	rows, err := r.queries.ListUpcasters(ctx, database.ListUpcastersParams{
+		Tenant: tenant,
+		Type:   eventType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	upcasters := make([]*models.Upcaster, 0, len(rows))
+	for _, row := range rows {
+		var operations []models.UpcastOperation
+		if err := json.Unmarshal(row.Operations, &operations); err != nil {
+			return nil, fmt.Errorf("stored upcaster %s version %d: %w", row.Type, row.FromVersion, err)
+		}
+		upcasters = append(upcasters, &models.Upcaster{
+			Type:        row.Type,
+			FromVersion: row.FromVersion,
+			Operations:  operations,
+			UpdatedAt:   row.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+	return upcasters, nil
+}
+
+// DeleteUpcaster removes the upcaster of a version, it returns false if there was none
+func (r *Registry) DeleteUpcaster(ctx context.Context, tenant string, eventType string, fromVersion int32) (bool, error) {
+	count, err := r.queries.DeleteUpcaster(ctx, database.DeleteUpcasterParams{
+		Tenant:      tenant,
+		Type:        eventType,
+		FromVersion: fromVersion,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	r.mutex.Lock()
+	delete(r.upcasters, cacheKey(tenant, eventType, 0))
+	r.mutex.Unlock()
+
+	return count > 0, nil
+}
+
+// Upcast returns the event with its payload transformed into the latest
+// schema version of its type, applying the upcaster of every version in
+// between. Versions without an upcaster are read unchanged. Payloads that
+// aren't JSON objects or fail to transform are returned as stored, with
+// their original dataschema. Events are copied as they may be shared.
+func (r *Registry) Upcast(ctx context.Context, tenant string, event *models.Event) (*models.Event, error) {
+	if event.Redacted || event.Blob != nil || len(event.Data) == 0 {
+		return event, nil
+	}
+
+	latest, err := r.latestVersion(ctx, tenant, event.Type)
+	if err != nil || latest <= event.SchemaVersion {
+		return event, err
+	}
+
+	upcasters, err := r.loadUpcasters(ctx, tenant, event.Type)
+	if err != nil {
+		return event, err
+	}
+	// Payloads written before the type had a schema were never validated
+	if _, ok := upcasters[0]; event.SchemaVersion == 0 && !ok {
+		return event, nil
+	}
+
+	data := event.Data
+	for version := event.SchemaVersion; version < latest; version++ {
+		operations, ok := upcasters[version]
+		if !ok {
+			continue
+		}
+		data, err = apply(data, operations)
+		if err != nil {
+			metrics.UpcastFailures.WithLabelValues(tenant, event.Type).Inc()
+			return event, nil
+		}
+	}
+
+	upcasted := *event
+	upcasted.Data = data
+	upcasted.SchemaVersion = latest
+	upcasted.DataSchema = URI(event.Type, latest)
+	return &upcasted, nil
+}
+
+// loadUpcasters returns the operations of the upcasters of a type by version, from the cache if possible
+func (r *Registry) loadUpcasters(ctx context.Context, tenant string, eventType string) (map[int32][]models.UpcastOperation, error) {
+	key := cacheKey(tenant, eventType, 0)
+
+	r.mutex.Lock()
+	entry, ok := r.upcasters[key]
+	r.mutex.Unlock()
+	if ok && time.Since(entry.loadedAt) < cacheTTL {
+		return entry.operations, nil
+	}
+
+	upcasters, err := r.ListUpcasters(ctx, tenant, eventType)
+	if err != nil {
+		return nil, err
+	}
+	operations := make(map[int32][]models.UpcastOperation, len(upcasters))
+	for _, upcaster := range upcasters {
+		operations[upcaster.FromVersion] = upcaster.Operations
+	}
+
+	r.mutex.Lock()
+	if len(r.upcasters) >= maxCacheEntries {
+		r.upcasters = make(map[string]upcastersEntry)
+	}
+	r.upcasters[key] = upcastersEntry{operations: operations, loadedAt: time.Now()}
+	r.mutex.Unlock()
+
+	return operations, nil
+}
+
+// checkOperation validates an operation without applying it
+func checkOperation(operation models.UpcastOperation) error {
+	if _, err := pointer(operation.Path); err != nil {
+		return err
+	}
+
+	switch operation.Op {
+	case OpMove, OpCopy:
+		if _, err := pointer(operation.From); err != nil {
+			return fmt.Errorf("from: %w", err)
+		}
+	case OpRemove:
+	case OpSet, OpDefault:
+		if len(operation.Value) == 0 {
+			return fmt.Errorf("%s requires a value", operation.Op)
+		}
+		if !json.Valid(operation.Value) {
+			return errors.New("value is not valid JSON")
+		}
+	default:
+		return fmt.Errorf("unknown op %q, must be move, copy, remove, set or default", operation.Op)
+	}
+	return nil
+}
+
+// apply transforms a JSON object payload with the operations in order
+func apply(data []byte, operations []models.UpcastOperation) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document map[string]any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	if document == nil {
+		return nil, errors.New("payload is not a JSON object")
+	}
+
+	for _, operation := range operations {
+		path, err := pointer(operation.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		switch operation.Op {
+		case OpMove, OpCopy:
+			from, err := pointer(operation.From)
+			if err != nil {
+				return nil, err
+			}
+			value, ok := lookup(document, from)
+			if !ok {
+				continue
+			}
+			if operation.Op == OpMove {
+				remove(document, from)
+			} else {
+				value = clone(value)
+			}
+			if err := set(document, path, value); err != nil {
+				return nil, err
+			}
+		case OpRemove:
+			remove(document, path)
+		case OpSet, OpDefault:
+			if _, ok := lookup(document, path); ok && operation.Op == OpDefault {
+				continue
+			}
+			decoder := json.NewDecoder(bytes.NewReader(operation.Value))
+			decoder.UseNumber()
+			var value any
+			if err := decoder.Decode(&value); err != nil {
+				return nil, err
+			}
+			if err := set(document, path, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return json.Marshal(document)
+}
+
+// pointer splits a JSON pointer into its unescaped tokens. The whole
+// document can't be addressed.
+func pointer(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "/") || path == "/" {
+		return nil, fmt.Errorf("invalid JSON pointer %q", path)
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// lookup returns the value at a pointer into nested objects
+func lookup(document map[string]any, tokens []string) (any, bool) {
+	current := document
+	for _, token := range tokens[:len(tokens)-1] {
+		next, ok := current[token].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	value, ok := current[tokens[len(tokens)-1]]
+	return value, ok
+}
+
+// set stores a value at a pointer, creating missing parent objects
+func set(document map[string]any, tokens []string, value any) error {
+	current := document
+	for i, token := range tokens[:len(tokens)-1] {
+		child, ok := current[token]
+		if !ok || child == nil {
+			created := map[string]any{}
+			current[token] = created
+			current = created
+			continue
+		}
+		next, ok := child.(map[string]any)
+		if !ok {
+			return fmt.Errorf("/%s is not an object", strings.Join(tokens[:i+1], "/"))
+		}
+		current = next
+	}
+	current[tokens[len(tokens)-1]] = value
+	return nil
+}
+
+// remove deletes the value at a pointer if present
+func remove(document map[string]any, tokens []string) {
+	current := document
+	for _, token := range tokens[:len(tokens)-1] {
+		next, ok := current[token].(map[string]any)
+		if !ok {
+			return
+		}
+		current = next
+	}
+	delete(current, tokens[len(tokens)-1])
+}
+
+// clone deep copies a decoded JSON value, so copies can be changed independently
+func clone(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(v))
+		for key, item := range v {
+			copied[key] = clone(item)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(v))
+		for i, item := range v {
+			copied[i] = clone(item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
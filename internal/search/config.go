@@ -0,0 +1,93 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/idot-digital/events-db/internal/jsonpath"
+)
+
+// Duration is a time.Duration read from strings like "5s" in JSON
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(raw []byte) error {
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Config is read from the JSON file named by SEARCH_CONFIG, searches use
+// the defaults without one
+type Config struct {
+	// MaxScannedEvents bounds the events a single search reads
+	MaxScannedEvents int64 `json:"max_scanned_events"`
+	// MaxResults bounds the events a single search returns
+	MaxResults int32 `json:"max_results"`
+	// Timeout bounds the time a single search runs
+	Timeout Duration `json:"timeout"`
+	// MaxConcurrent is the number of searches running at once, further ones
+	// are rejected
+	MaxConcurrent int `json:"max_concurrent"`
+	// Paths lists the JSON paths searchable per event type. When set, JSON
+	// path predicates require one of these types and one of its paths.
+	Paths map[string][]string `json:"paths"`
+}
+
+// DefaultConfig returns the limits used without a configuration file
+func DefaultConfig() *Config {
+	cfg := &Config{}
+	cfg.applyDefaults()
+	return cfg
+}
+
+// Load reads and validates a search configuration file
+func Load(path string) (*Config, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid search config: %w", err)
+	}
+	cfg.applyDefaults()
+
+	for eventType, paths := range cfg.Paths {
+		if len(paths) == 0 {
+			return nil, fmt.Errorf("search paths of type %q are empty", eventType)
+		}
+		for _, path := range paths {
+			if _, err := jsonpath.Parse(path); err != nil {
+				return nil, fmt.Errorf("search path %q of type %q: %w", path, eventType, err)
+			}
+		}
+	}
+
+	return &cfg, nil
+}
+
+func (c *Config) applyDefaults() {
+	if c.MaxScannedEvents <= 0 {
+		c.MaxScannedEvents = 10000
+	}
+	if c.MaxResults <= 0 {
+		c.MaxResults = 100
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = Duration(5 * time.Second)
+	}
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = 2
+	}
+}
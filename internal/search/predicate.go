@@ -0,0 +1,158 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/idot-digital/events-db/internal/jsonpath"
+)
+
+// operators are tried in order, so two character operators come first
+var operators = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+// Predicate is a condition on a JSON payload like $.orderId = "123". A
+// predicate without operator and value matches payloads having the path.
+type Predicate struct {
+	Path     jsonpath.Path
+	Operator string
+	// Value is the decoded JSON literal compared against
+	Value any
+}
+
+// ParsePredicate parses a predicate of the form <path> [<operator> <JSON literal>]
+func ParsePredicate(expression string) (Predicate, error) {
+	expression = strings.TrimSpace(expression)
+	end := strings.IndexFunc(expression, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune("!=<>", r)
+	})
+	if end < 0 {
+		end = len(expression)
+	}
+
+	path, err := jsonpath.Parse(expression[:end])
+	if err != nil {
+		return Predicate{}, err
+	}
+	predicate := Predicate{Path: path}
+
+	rest := strings.TrimSpace(expression[end:])
+	if rest == "" {
+		return predicate, nil
+	}
+	for _, operator := range operators {
+		if strings.HasPrefix(rest, operator) {
+			predicate.Operator = operator
+			rest = strings.TrimSpace(rest[len(operator):])
+			break
+		}
+	}
+	if predicate.Operator == "" {
+		return Predicate{}, fmt.Errorf("expected one of %s after %s", strings.Join(operators, " "), path)
+	}
+
+	if predicate.Value, err = jsonpath.Decode([]byte(rest)); err != nil {
+		return Predicate{}, fmt.Errorf("value of %s must be a JSON literal, strings in double quotes", path)
+	}
+	switch predicate.Value.(type) {
+	case json.Number, string:
+	default:
+		if predicate.Operator != "=" && predicate.Operator != "!=" {
+			return Predicate{}, fmt.Errorf("%s requires a number or string", predicate.Operator)
+		}
+	}
+	return predicate, nil
+}
+
+// Matches reports whether a decoded JSON document satisfies the predicate
+func (p Predicate) Matches(document any) bool {
+	value, ok := p.Path.Lookup(document)
+	if !ok {
+		return false
+	}
+
+	switch p.Operator {
+	case "":
+		return true
+	case "=":
+		return equal(value, p.Value)
+	case "!=":
+		return !equal(value, p.Value)
+	}
+
+	comparison, ok := compare(value, p.Value)
+	if !ok {
+		return false
+	}
+	switch p.Operator {
+	case "<":
+		return comparison < 0
+	case "<=":
+		return comparison <= 0
+	case ">":
+		return comparison > 0
+	default:
+		return comparison >= 0
+	}
+}
+
+// equal compares JSON values, numbers by their value
+func equal(a any, b any) bool {
+	if comparison, ok := compare(a, b); ok {
+		return comparison == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders two numbers or two strings
+func compare(a any, b any) (int, bool) {
+	switch a := a.(type) {
+	case json.Number:
+		b, ok := b.(json.Number)
+		if !ok {
+			return 0, false
+		}
+		x, errA := a.Float64()
+		y, errB := b.Float64()
+		if errA != nil || errB != nil {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(a, b), true
+	}
+	return 0, false
+}
+
+// terms splits a full-text query into lower case words
+func terms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// containsTerms reports whether every term is a word of the text, ignoring case
+func containsTerms(text []byte, queryTerms []string) bool {
+	words := make(map[string]struct{})
+	for _, word := range terms(string(text)) {
+		words[word] = struct{}{}
+	}
+	for _, term := range queryTerms {
+		if _, ok := words[term]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package search
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/idot-digital/events-db/internal/jsonpath"
+)
+
+func TestParsePredicate(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		path       string
+		operator   string
+		value      any
+		wantErr    bool
+	}{
+		{name: "path only", expression: "$.orderId", path: "$.orderId"},
+		{name: "surrounding space", expression: "  $.orderId  ", path: "$.orderId"},
+		{name: "string equality", expression: `$.orderId = "123"`, path: "$.orderId", operator: "=", value: "123"},
+		{name: "without spaces", expression: `$.orderId="123"`, path: "$.orderId", operator: "=", value: "123"},
+		{name: "not equal", expression: "$.total != 5", path: "$.total", operator: "!=", value: json.Number("5")},
+		{name: "less or equal", expression: "$.total <= 10.5", path: "$.total", operator: "<=", value: json.Number("10.5")},
+		{name: "greater or equal", expression: "$.total>=3", path: "$.total", operator: ">=", value: json.Number("3")},
+		{name: "less", expression: `$.items[0].sku < "m"`, path: "$.items[0].sku", operator: "<", value: "m"},
+		{name: "greater", expression: "$.total > -1", path: "$.total", operator: ">", value: json.Number("-1")},
+		{name: "boolean equality", expression: "$.paid = true", path: "$.paid", operator: "=", value: true},
+		{name: "null inequality", expression: "$.paid != null", path: "$.paid", operator: "!=", value: nil},
+		{name: "invalid path", expression: "orderId = 1", wantErr: true},
+		{name: "missing operator", expression: "$.orderId 1", wantErr: true},
+		{name: "missing value", expression: "$.orderId =", wantErr: true},
+		{name: "unquoted string", expression: "$.orderId = abc", wantErr: true},
+		{name: "trailing data", expression: "$.orderId = 1 2", wantErr: true},
+		{name: "ordering a boolean", expression: "$.paid < true", wantErr: true},
+		{name: "ordering an object", expression: `$.order >= {"a":1}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			predicate, err := ParsePredicate(tt.expression)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParsePredicate(%q) = %+v, want error", tt.expression, predicate)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePredicate(%q) failed: %v", tt.expression, err)
+			}
+			if predicate.Path.String() != tt.path {
+				t.Errorf("path = %q, want %q", predicate.Path, tt.path)
+			}
+			if predicate.Operator != tt.operator {
+				t.Errorf("operator = %q, want %q", predicate.Operator, tt.operator)
+			}
+			if !reflect.DeepEqual(predicate.Value, tt.value) {
+				t.Errorf("value = %#v, want %#v", predicate.Value, tt.value)
+			}
+		})
+	}
+}
+
+func TestPredicateMatches(t *testing.T) {
+	document, err := jsonpath.Decode([]byte(`{
+		"orderId": "123",
+		"total": 10.50,
+		"count": 3,
+		"paid": false,
+		"note": null,
+		"tags": ["a", "b"],
+		"items": [{"sku": "m-1"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{`$.orderId`, true},
+		{`$.missing`, false},
+		{`$.note`, true},
+		{`$.orderId = "123"`, true},
+		{`$.orderId = "124"`, false},
+		{`$.orderId != "124"`, true},
+		{`$.orderId = 123`, false},
+		{`$.total = 10.5`, true},
+		{`$.total = 1.05e1`, true},
+		{`$.total != 10.5`, false},
+		{`$.total < 11`, true},
+		{`$.total <= 10.5`, true},
+		{`$.total > 10.5`, false},
+		{`$.total >= 10.5`, true},
+		{`$.count > 2`, true},
+		{`$.count < "4"`, false},
+		{`$.orderId > "12"`, true},
+		{`$.orderId < "2"`, true},
+		{`$.items[0].sku >= "m"`, true},
+		{`$.items[1].sku = "m-1"`, false},
+		{`$.paid = false`, true},
+		{`$.paid != true`, true},
+		{`$.note = null`, true},
+		{`$.tags = ["a", "b"]`, true},
+		{`$.tags = ["b", "a"]`, false},
+		{`$.missing != 1`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			predicate, err := ParsePredicate(tt.expression)
+			if err != nil {
+				t.Fatalf("ParsePredicate(%q) failed: %v", tt.expression, err)
+			}
+			if got := predicate.Matches(document); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name string
+		a    any
+		b    any
+		want int
+		ok   bool
+	}{
+		{name: "smaller number", a: json.Number("1"), b: json.Number("2"), want: -1, ok: true},
+		{name: "larger number", a: json.Number("2.5"), b: json.Number("2"), want: 1, ok: true},
+		{name: "equal numbers written differently", a: json.Number("100"), b: json.Number("1e2"), want: 0, ok: true},
+		{name: "strings", a: "abc", b: "abd", want: -1, ok: true},
+		{name: "number and string", a: json.Number("1"), b: "1"},
+		{name: "string and number", a: "1", b: json.Number("1")},
+		{name: "booleans", a: true, b: false},
+		{name: "invalid number", a: json.Number("x"), b: json.Number("1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := compare(tt.a, tt.b)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("compare(%#v, %#v) = %d, %v, want %d, %v", tt.a, tt.b, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestContainsTerms(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		want  bool
+	}{
+		{name: "single word", text: `{"note":"Express delivery"}`, query: "express", want: true},
+		{name: "ignores case", text: `{"note":"Express delivery"}`, query: "DELIVERY Express", want: true},
+		{name: "all words required", text: `{"note":"Express delivery"}`, query: "express pickup", want: false},
+		{name: "whole words only", text: `{"note":"Express delivery"}`, query: "press", want: false},
+		{name: "matches keys", text: `{"note":"Express delivery"}`, query: "note", want: true},
+		{name: "digits", text: `{"orderId":"A-123"}`, query: "a 123", want: true},
+		{name: "empty query", text: `{}`, query: "  ", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := containsTerms([]byte(tt.text), terms(tt.query)); got != tt.want {
+				t.Errorf("containsTerms(%s, %q) = %v, want %v", tt.text, tt.query, got, tt.want)
+			}
+		})
+	}
+}
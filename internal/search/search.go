@@ -0,0 +1,208 @@
+// Package search finds events by their payloads. Payloads may be stored
+// compressed or encrypted, so they are matched after being opened rather than
+// by the database, reading the events of a bounded range in batches.
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/idot-digital/events-db/internal/jsonpath"
+	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+)
+
+// searchFilterID identifies the filter reading the events of a search
+const searchFilterID = "search"
+
+// batchSize is the number of events read per query during searches
+const batchSize = 200
+
+var (
+	// ErrBusy is returned when the configured number of searches is running
+	ErrBusy = errors.New("too many searches running")
+	// ErrNoCondition is returned for searches without predicates and text
+	ErrNoCondition = errors.New("a search needs a JSON path predicate or a text query")
+)
+
+// PathNotSearchableError is returned for predicates on paths not configured
+// as searchable for the type of the search
+type PathNotSearchableError struct {
+	Type string
+	Path string
+}
+
+func (e *PathNotSearchableError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("path %s is not searchable without a type", e.Path)
+	}
+	return fmt.Sprintf("path %s is not searchable for type %s", e.Path, e.Type)
+}
+
+// Query selects the events to search and the conditions they must meet
+type Query struct {
+	// Subject restricts the search to a subject, all subjects if empty
+	Subject string
+	// Recursive includes the subjects below Subject
+	Recursive bool
+	// Type restricts the search to events of one type, all types if empty
+	Type string
+	// Since and Until restrict the search to events stored in [Since, Until),
+	// zero times don't restrict it
+	Since time.Time
+	Until time.Time
+	// After searches the events after this ID, to continue a search
+	After int64
+	// Limit is the maximum number of results, capped by the configuration
+	Limit int32
+	// Predicates must all match the JSON payload
+	Predicates []Predicate
+	// Text holds words that must all occur in the payload
+	Text string
+}
+
+// Searcher runs searches within the configured limits
+type Searcher struct {
+	server *server.Server
+	config *Config
+	// slots holds a token per running search
+	slots chan struct{}
+}
+
+func New(s *server.Server, config *Config) *Searcher {
+	return &Searcher{
+		server: s,
+		config: config,
+		slots:  make(chan struct{}, config.MaxConcurrent),
+	}
+}
+
+// Search returns the events of a tenant matching the query in ID order. It
+// stops at the result limit, after reading the configured number of events
+// or when the timeout passes, whichever comes first. Position is the ID to
+// continue after, End is set once the range was read completely. Events
+// whose payloads are redacted or held in the blob store are not matched.
+func (s *Searcher) Search(ctx context.Context, tenant string, q Query) (models.SearchResult, error) {
+	if len(q.Predicates) == 0 && q.Text == "" {
+		return models.SearchResult{}, ErrNoCondition
+	}
+	if err := s.checkPaths(q); err != nil {
+		return models.SearchResult{}, err
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		return models.SearchResult{}, ErrBusy
+	}
+
+	limit := s.config.MaxResults
+	if q.Limit > 0 {
+		limit = min(q.Limit, limit)
+	}
+	searchCtx, cancel := context.WithTimeout(ctx, time.Duration(s.config.Timeout))
+	defer cancel()
+
+	// The type is checked here rather than by the filter, which would read
+	// on past the scan limit until it finds an event of the type
+	filter := server.NewAllFilter(tenant, nil, nil, q.After)
+	if q.Subject != "" {
+		filter = server.NewSubjectFilter(tenant, searchFilterID, q.Subject, q.Recursive, nil, q.After)
+	}
+
+	result := models.SearchResult{Events: []*models.Event{}, Position: q.After}
+	defer func() {
+		metrics.SearchedEvents.WithLabelValues(tenant).Add(float64(result.Scanned))
+	}()
+
+	queryTerms := terms(q.Text)
+	for result.Scanned < s.config.MaxScannedEvents {
+		remaining := s.config.MaxScannedEvents - result.Scanned
+		events, done, err := s.server.Read(searchCtx, filter, int32(min(batchSize, remaining)))
+		if err != nil {
+			// Running out of time ends the search early like the scan limit
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				return result, nil
+			}
+			return result, err
+		}
+
+		for i, event := range events {
+			result.Scanned++
+			result.Position = event.ID
+			if q.Type != "" && event.Type != q.Type {
+				continue
+			}
+			if !inRange(event, q.Since, q.Until) || !matches(event, q.Predicates, queryTerms) {
+				continue
+			}
+			result.Events = append(result.Events, event)
+			if len(result.Events) == int(limit) {
+				result.End = done && i == len(events)-1
+				return result, nil
+			}
+		}
+
+		result.Position = max(result.Position, filter.Position())
+		if done {
+			result.End = true
+			return result, nil
+		}
+	}
+	return result, nil
+}
+
+// checkPaths rejects predicates on paths not configured as searchable
+func (s *Searcher) checkPaths(q Query) error {
+	if len(s.config.Paths) == 0 {
+		return nil
+	}
+	for _, predicate := range q.Predicates {
+		if !slices.Contains(s.config.Paths[q.Type], predicate.Path.String()) {
+			return &PathNotSearchableError{Type: q.Type, Path: predicate.Path.String()}
+		}
+	}
+	return nil
+}
+
+// inRange reports whether an event was stored in [since, until)
+func inRange(event *models.Event, since time.Time, until time.Time) bool {
+	if since.IsZero() && until.IsZero() {
+		return true
+	}
+	created, err := time.Parse(time.RFC3339Nano, event.Time)
+	if err != nil {
+		return false
+	}
+	return (since.IsZero() || !created.Before(since)) && (until.IsZero() || created.Before(until))
+}
+
+// matches reports whether the payload of an event meets the predicates and
+// contains the terms
+func matches(event *models.Event, predicates []Predicate, queryTerms []string) bool {
+	if event.Redacted || event.Blob != nil || len(event.Data) == 0 {
+		return false
+	}
+	if len(queryTerms) > 0 && !containsTerms(event.Data, queryTerms) {
+		return false
+	}
+	if len(predicates) == 0 {
+		return true
+	}
+
+	document, err := jsonpath.Decode(event.Data)
+	if err != nil {
+		return false
+	}
+	for _, predicate := range predicates {
+		if !predicate.Matches(document) {
+			return false
+		}
+	}
+	return true
+}
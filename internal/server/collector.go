@@ -0,0 +1,67 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector exports the state of the fan-out and its listeners. Listeners are
+// aggregated by tenant and group to keep the label cardinality bounded.
+type Collector struct {
+	server      *Server
+	head        *prometheus.Desc
+	backlog     *prometheus.Desc
+	clients     *prometheus.Desc
+	lag         *prometheus.Desc
+	bufferUsage *prometheus.Desc
+}
+
+func NewCollector(s *Server) *Collector {
+	return &Collector{
+		server: s,
+		head: prometheus.NewDesc(
+			"app_head_event_id",
+			"The ID of the newest event emitted since the server started",
+			nil, nil,
+		),
+		backlog: prometheus.NewDesc(
+			"app_emitter_backlog_events",
+			"The number of events waiting in the emitter channel",
+			nil, nil,
+		),
+		clients: prometheus.NewDesc(
+			"app_stream_clients",
+			"The number of live stream listeners",
+			[]string{"tenant", "group"}, nil,
+		),
+		lag: prometheus.NewDesc(
+			"app_stream_max_lag_events",
+			"The highest distance in event IDs between the newest event and a stream's position",
+			[]string{"tenant", "group"}, nil,
+		),
+		bufferUsage: prometheus.NewDesc(
+			"app_stream_listener_buffer_usage_ratio",
+			"The fill level of the fullest listener buffer",
+			[]string{"tenant", "group"}, nil,
+		),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.head
+	ch <- c.backlog
+	ch <- c.clients
+	ch <- c.lag
+	ch <- c.bufferUsage
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	streams := c.server.Streams()
+
+	ch <- prometheus.MustNewConstMetric(c.head, prometheus.GaugeValue, float64(streams.HeadID))
+	ch <- prometheus.MustNewConstMetric(c.backlog, prometheus.GaugeValue, float64(streams.Backlog))
+	for _, group := range streams.Groups {
+		ch <- prometheus.MustNewConstMetric(c.clients, prometheus.GaugeValue, float64(group.Clients), group.Tenant, group.Group)
+		ch <- prometheus.MustNewConstMetric(c.lag, prometheus.GaugeValue, float64(group.MaxLag), group.Tenant, group.Group)
+		ch <- prometheus.MustNewConstMetric(c.bufferUsage, prometheus.GaugeValue, group.BufferUsage, group.Tenant, group.Group)
+	}
+}
@@ -0,0 +1,340 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/schemas"
+)
+
+// NoUpperBound can be passed to CatchUp to replay every stored event
+const NoUpperBound = math.MaxInt64
+
+// SubjectFilter selects events of a tenant by subject (exact or prefix) and
+// optionally by type or by type and source patterns
+type SubjectFilter struct {
+	ID            string
+	Tenant        string
+	Subject       string
+	Prefix        bool
+	Types         []string
+	TypePattern   *regexp.Regexp
+	SourcePattern *regexp.Regexp
+
+	// fromID is the ID after which the filter delivers events
+	fromID int64
+	// position is the ID of the last stored event read for this filter
+	position int64
+}
+
+func NewSubjectFilter(tenant string, id string, subject string, prefix bool, types []string, fromID int64) *SubjectFilter {
+	return &SubjectFilter{
+		ID:       id,
+		Tenant:   tenant,
+		Subject:  subject,
+		Prefix:   prefix,
+		Types:    types,
+		fromID:   fromID,
+		position: fromID,
+	}
+}
+
+// NewAllFilter creates a filter over every stored event of a tenant,
+// optionally restricted to types and sources matching the given patterns
+func NewAllFilter(tenant string, typePattern *regexp.Regexp, sourcePattern *regexp.Regexp, fromID int64) *SubjectFilter {
+	return &SubjectFilter{
+		Tenant:        tenant,
+		Prefix:        true,
+		TypePattern:   typePattern,
+		SourcePattern: sourcePattern,
+		fromID:        fromID,
+		position:      fromID,
+	}
+}
+
+// Matches reports whether the event is selected by the filter
+func (f *SubjectFilter) Matches(event *models.Event) bool {
+	if event.Tenant != f.Tenant {
+		return false
+	}
+
+	if f.Prefix {
+		if !strings.HasPrefix(event.Subject, f.Subject) {
+			return false
+		}
+	} else if event.Subject != f.Subject {
+		return false
+	}
+
+	if f.TypePattern != nil && !f.TypePattern.MatchString(event.Type) {
+		return false
+	}
+	if f.SourcePattern != nil && !f.SourcePattern.MatchString(event.Source) {
+		return false
+	}
+
+	return len(f.Types) == 0 || slices.Contains(f.Types, event.Type)
+}
+
+// Covers reports whether the event has been delivered through the filter,
+// assuming the stream has advanced past the event
+func (f *SubjectFilter) Covers(event *models.Event) bool {
+	return event.ID > f.fromID && f.Matches(event)
+}
+
+// Position returns the ID of the last stored event read for this filter
+func (f *SubjectFilter) Position() int64 {
+	return f.position
+}
+
+// MatchesAny reports whether the event is selected by at least one of the filters
+func MatchesAny(filters []*SubjectFilter, event *models.Event) bool {
+	for _, f := range filters {
+		if f.Matches(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch reads the next batch of stored events for the filter, skipping events
+// of other types. done is set once the filter has no more events up to untilID.
+func (s *Server) fetch(ctx context.Context, f *SubjectFilter, untilID int64, batchSize int32) (events []*models.Event, done bool, err error) {
+	for len(events) == 0 {
+		// Archived events are read from the archive until it is exhausted
+		if s.archive != nil {
+			watermark, err := s.archive.Watermark(ctx)
+			if err != nil {
+				return nil, false, err
+			}
+			if f.position < watermark && f.position < untilID {
+				archived, position, err := s.archive.Read(ctx, f.Tenant, f.Subject, f.Prefix, f.position, min(watermark, untilID), batchSize)
+				if err != nil {
+					return nil, false, err
+				}
+				f.position = position
+
+				for _, event := range archived {
+					if !f.Matches(event) {
+						continue
+					}
+					event, err := s.openData(ctx, event)
+					if err != nil {
+						return nil, false, err
+					}
+					events = append(events, event)
+				}
+				if f.position >= untilID {
+					return events, true, nil
+				}
+				continue
+			}
+		}
+
+		var rows []database.Event
+		if f.Prefix && f.Subject == "" {
+			rows, err = s.queries.GetEvents(ctx, database.GetEventsParams{
+				Tenant: f.Tenant,
+				ID:     f.position,
+				Limit:  batchSize,
+			})
+		} else if f.Prefix {
+			rows, err = s.queries.GetEventsBySubjectPrefix(ctx, database.GetEventsBySubjectPrefixParams{
+				Tenant:  f.Tenant,
+				ID:      f.position,
+				Pattern: LikePrefix(f.Subject),
+				Limit:   batchSize,
+			})
+		} else {
+			rows, err = s.queries.GetEventsBySubject(ctx, database.GetEventsBySubjectParams{
+				Tenant:  f.Tenant,
+				ID:      f.position,
+				Subject: f.Subject,
+				Limit:   batchSize,
+			})
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		for _, row := range rows {
+			if row.ID > untilID {
+				return events, true, nil
+			}
+			f.position = row.ID
+
+			event, err := EventFromRow(row)
+			if err != nil {
+				return nil, false, err
+			}
+			if !f.Matches(event) {
+				continue
+			}
+			if event, err = s.openData(ctx, event); err != nil {
+				return nil, false, err
+			}
+			events = append(events, event)
+		}
+
+		if len(rows) < int(batchSize) {
+			return events, true, nil
+		}
+	}
+
+	return events, false, nil
+}
+
+// Read returns the next page of at most limit stored events matching the
+// filter and advances its position. done is set once no events are left.
+func (s *Server) Read(ctx context.Context, f *SubjectFilter, limit int32) (events []*models.Event, done bool, err error) {
+	return s.fetch(ctx, f, NoUpperBound, limit)
+}
+
+// CatchUp replays the stored events matching any of the filters up to untilID
+// in global ID order. Each filter is read from its own position, which is
+// advanced as events are read, so a second call only returns newer events.
+// Events matching several filters are delivered once. send is called with
+// batches of at most batchSize events. The ID of the last delivered event is
+// returned, or 0 if nothing was delivered.
+func (s *Server) CatchUp(ctx context.Context, filters []*SubjectFilter, untilID int64, batchSize int32, send func([]*models.Event) error) (int64, error) {
+	type cursor struct {
+		filter  *SubjectFilter
+		pending []*models.Event
+		done    bool
+	}
+
+	cursors := make([]*cursor, 0, len(filters))
+	for _, f := range filters {
+		cursors = append(cursors, &cursor{filter: f})
+	}
+
+	lastID := int64(0)
+	batch := make([]*models.Event, 0, batchSize)
+	for {
+		// Refill drained cursors before picking the lowest ID, otherwise an
+		// event of a drained filter could be overtaken by a later one.
+		var next *cursor
+		for _, c := range cursors {
+			if len(c.pending) == 0 && !c.done {
+				events, done, err := s.fetch(ctx, c.filter, untilID, batchSize)
+				if err != nil {
+					return lastID, err
+				}
+				c.pending = events
+				c.done = done
+			}
+			if len(c.pending) > 0 && (next == nil || c.pending[0].ID < next.pending[0].ID) {
+				next = c
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		event := next.pending[0]
+		next.pending = next.pending[1:]
+		if event.ID <= lastID {
+			continue
+		}
+
+		lastID = event.ID
+		batch = append(batch, event)
+		if len(batch) >= int(batchSize) {
+			if err := send(batch); err != nil {
+				return lastID, err
+			}
+			batch = make([]*models.Event, 0, batchSize)
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := send(batch); err != nil {
+			return lastID, err
+		}
+	}
+
+	return lastID, nil
+}
+
+// Replay delivers the stored events matching the filter up to untilID, and
+// created at or before untilTime unless it is zero, like CatchUp. It returns
+// once they were sent, the events after the bound are never delivered.
+func (s *Server) Replay(ctx context.Context, f *SubjectFilter, untilID int64, untilTime time.Time, batchSize int32, send func([]*models.Event) error) (int64, error) {
+	if untilTime.IsZero() {
+		return s.CatchUp(ctx, []*SubjectFilter{f}, untilID, batchSize, send)
+	}
+
+	// Event times don't strictly follow IDs, so later events are skipped
+	// rather than ending the replay at the first one
+	return s.CatchUp(ctx, []*SubjectFilter{f}, untilID, batchSize, func(events []*models.Event) error {
+		selected := events[:0:0]
+		for _, event := range events {
+			created, err := time.Parse(time.RFC3339Nano, event.Time)
+			if err != nil {
+				return err
+			}
+			if !created.After(untilTime) {
+				selected = append(selected, event)
+			}
+		}
+		if len(selected) == 0 {
+			return nil
+		}
+		return send(selected)
+	})
+}
+
+// EventFromRow converts a stored event into its API representation
+func EventFromRow(row database.Event) (*models.Event, error) {
+	var blob *models.Blob
+	if row.BlobSha256 != "" {
+		blob = &models.Blob{SHA256: row.BlobSha256, Size: row.BlobSize}
+	}
+
+	var metadata map[string]string
+	if len(row.Metadata) > 0 {
+		if err := json.Unmarshal(row.Metadata, &metadata); err != nil {
+			return nil, err
+		}
+		if len(metadata) == 0 {
+			metadata = nil
+		}
+	}
+
+	return &models.Event{
+		ID:              row.ID,
+		Tenant:          row.Tenant,
+		Source:          row.Source,
+		Type:            row.Type,
+		Subject:         row.Subject,
+		Time:            FormatTime(row.Time),
+		OccurredAt:      FormatOccurredAt(row.OccurredAt),
+		Data:            row.Data,
+		TraceParent:     row.Traceparent,
+		TraceState:      row.Tracestate,
+		DataKey:         row.DataKey,
+		DataCodec:       row.DataCodec,
+		Blob:            blob,
+		DataContentType: row.Datacontenttype,
+		DataSchema:      schemas.URI(row.Type, row.SchemaVersion),
+		SchemaVersion:   row.SchemaVersion,
+		Metadata:        metadata,
+		CorrelationID:   row.CorrelationID,
+		CausationID:     row.CausationID,
+		TombstoneID:     row.TombstoneID,
+		TombstonedAt:    FormatOccurredAt(row.TombstonedAt),
+	}, nil
+}
+
+// LikePrefix builds a LIKE pattern matching every string starting with prefix
+func LikePrefix(prefix string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(prefix) + "%"
+}
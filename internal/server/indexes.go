@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/models"
+)
+
+// Indexes maintains the secondary indexes declared on payload fields
+type Indexes interface {
+	// Index adds a stored event to the indexes declared for its type
+	Index(ctx context.Context, event *models.Event) error
+}
+
+// SetIndexes makes new events added to the secondary indexes of their type
+func (s *Server) SetIndexes(indexes Indexes) {
+	s.indexes = indexes
+}
+
+// IndexEvent adds a stored event with its payload in plain form to the
+// secondary indexes of its type. The event is stored already, so failures
+// are only logged; the indexes index the event again in the background.
+func (s *Server) IndexEvent(ctx context.Context, event *models.Event) {
+	if s.indexes == nil {
+		return
+	}
+	if err := s.indexes.Index(ctx, event); err != nil {
+		s.logger.Error("Failed to index event", "id", event.ID, "type", event.Type, "error", err)
+	}
+}
+
+// ReadIndexed returns the next page of at most limit stored events of a
+// tenant holding a value in a secondary index, after the event fromID. done
+// is set once no events are left. Archived events are not included.
+func (s *Server) ReadIndexed(ctx context.Context, tenant string, indexID int64, value string, fromID int64, limit int32) (events []*models.Event, done bool, err error) {
+	rows, err := s.queries.GetIndexedEvents(ctx, database.GetIndexedEventsParams{
+		IndexID: indexID,
+		Value:   value,
+		ID:      fromID,
+		Tenant:  tenant,
+		Limit:   limit,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	events = make([]*models.Event, 0, len(rows))
+	for _, row := range rows {
+		event, err := EventFromRow(row.Event)
+		if err != nil {
+			return nil, false, err
+		}
+		if event, err = s.openData(ctx, event); err != nil {
+			return nil, false, err
+		}
+		events = append(events, event)
+	}
+	return events, len(rows) < int(limit), nil
+}
+
+// ReadTypeRange returns the stored events of a tenant and type with IDs in
+// (afterID, untilID], with their payloads in plain form
+func (s *Server) ReadTypeRange(ctx context.Context, tenant string, eventType string, afterID int64, untilID int64) ([]*models.Event, error) {
+	rows, err := s.queries.GetEventsByTypeInRange(ctx, database.GetEventsByTypeInRangeParams{
+		Tenant:  tenant,
+		Type:    eventType,
+		AfterID: afterID,
+		UntilID: untilID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*models.Event, 0, len(rows))
+	for _, row := range rows {
+		event, err := EventFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		if event, err = s.openData(ctx, event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/models"
+)
+
+// The metadata keys the server sets on created events
+const (
+	// MetadataPrincipal names the token the event was created with
+	MetadataPrincipal = "eventsdb.principal"
+	// MetadataRemoteAddr is the IP address of the client that created the event
+	MetadataRemoteAddr = "eventsdb.remote_addr"
+	// MetadataReceivedAt is when the server received the event
+	MetadataReceivedAt = "eventsdb.received_at"
+)
+
+// reservedMetadataPrefix starts the metadata keys only the server may set
+const reservedMetadataPrefix = "eventsdb."
+
+const (
+	// maxMetadataBytes bounds the encoded metadata of an event
+	maxMetadataBytes = 8192
+	// maxTrackingIDLength is the size of the correlation and causation ID columns
+	maxTrackingIDLength = 255
+)
+
+var (
+	// ErrReservedMetadata is returned for metadata keys reserved for the server
+	ErrReservedMetadata = errors.New("metadata keys starting with eventsdb. are reserved")
+	// ErrMetadataTooLarge is returned for metadata above maxMetadataBytes
+	ErrMetadataTooLarge = errors.New("metadata too large")
+	// ErrTrackingIDTooLong is returned for correlation and causation IDs not fitting their columns
+	ErrTrackingIDTooLong = errors.New("correlation and causation IDs are limited to 255 bytes")
+)
+
+// Origin describes the request an event was created by
+type Origin struct {
+	Principal  string
+	RemoteAddr string
+	ReceivedAt time.Time
+}
+
+// EventMetadata is the metadata of an event prepared for storage
+type EventMetadata struct {
+	Metadata map[string]string
+	// Encoded is Metadata as stored in the metadata column
+	Encoded       json.RawMessage
+	CorrelationID string
+	CausationID   string
+}
+
+// PrepareMetadata validates the metadata of a new event and adds the
+// attributes of its origin. Events without a correlation ID inherit the one
+// of the event of the tenant named by the causation ID, or use the causation
+// ID itself if it names no such event, so chains of events stay correlated.
+func (s *Server) PrepareMetadata(ctx context.Context, tenant string, origin Origin, metadata map[string]string, correlationID string, causationID string) (EventMetadata, error) {
+	if err := ValidateTrackingIDs(correlationID, causationID); err != nil {
+		return EventMetadata{}, err
+	}
+
+	enriched := make(map[string]string, len(metadata)+3)
+	for key, value := range metadata {
+		if strings.HasPrefix(key, reservedMetadataPrefix) {
+			return EventMetadata{}, ErrReservedMetadata
+		}
+		enriched[key] = value
+	}
+	if origin.Principal != "" {
+		enriched[MetadataPrincipal] = origin.Principal
+	}
+	if origin.RemoteAddr != "" {
+		enriched[MetadataRemoteAddr] = origin.RemoteAddr
+	}
+	enriched[MetadataReceivedAt] = origin.ReceivedAt.UTC().Format(time.RFC3339Nano)
+
+	prepared, err := EncodeMetadata(enriched)
+	if err != nil {
+		return EventMetadata{}, err
+	}
+	prepared.CorrelationID = correlationID
+	prepared.CausationID = causationID
+
+	if correlationID == "" && causationID != "" {
+		prepared.CorrelationID = causationID
+		if id, err := strconv.ParseInt(causationID, 10, 64); err == nil {
+			cause, err := s.GetEvent(ctx, tenant, id)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return EventMetadata{}, err
+			}
+			if cause != nil && cause.CorrelationID != "" {
+				prepared.CorrelationID = cause.CorrelationID
+			}
+		}
+	}
+	return prepared, nil
+}
+
+// ValidateTrackingIDs checks that correlation and causation IDs fit their columns
+func ValidateTrackingIDs(correlationID string, causationID string) error {
+	if len(correlationID) > maxTrackingIDLength || len(causationID) > maxTrackingIDLength {
+		return ErrTrackingIDTooLong
+	}
+	return nil
+}
+
+// EncodeMetadata prepares metadata for storage as it is, for events carrying
+// the metadata recorded when they were first created
+func EncodeMetadata(metadata map[string]string) (EventMetadata, error) {
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return EventMetadata{}, err
+	}
+	if len(encoded) > maxMetadataBytes {
+		return EventMetadata{}, ErrMetadataTooLarge
+	}
+
+	prepared := EventMetadata{Encoded: encoded}
+	if len(metadata) > 0 {
+		prepared.Metadata = metadata
+	}
+	return prepared, nil
+}
+
+// ReadCorrelated returns the next page of at most limit stored events of a
+// tenant sharing a correlation ID, across subjects and after the event fromID.
+// done is set once no events are left. Archived events are not included.
+func (s *Server) ReadCorrelated(ctx context.Context, tenant string, correlationID string, fromID int64, limit int32) (events []*models.Event, done bool, err error) {
+	rows, err := s.queries.GetEventsByCorrelationID(ctx, database.GetEventsByCorrelationIDParams{
+		Tenant:        tenant,
+		CorrelationID: correlationID,
+		ID:            fromID,
+		Limit:         limit,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	events = make([]*models.Event, 0, len(rows))
+	for _, row := range rows {
+		event, err := EventFromRow(row)
+		if err != nil {
+			return nil, false, err
+		}
+		if event, err = s.openData(ctx, event); err != nil {
+			return nil, false, err
+		}
+		events = append(events, event)
+	}
+	return events, len(rows) < int(limit), nil
+}
@@ -1,17 +1,114 @@
 package server
 
 import (
+	"cmp"
 	"container/list"
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/idot-digital/events-db/database"
 	pb "github.com/idot-digital/events-db/grpc"
+	"github.com/idot-digital/events-db/internal/compression"
 	"github.com/idot-digital/events-db/internal/metrics"
 	"github.com/idot-digital/events-db/internal/models"
 )
 
+// maxMetricGroups caps the distinct stream groups exported as metric labels
+const maxMetricGroups = 50
+
+// MaxStoredDataBytes is the size of the data column, payloads must fit in
+// their stored form, that is after compression and encryption
+const MaxStoredDataBytes = 60000
+
+var (
+	// ErrTooManyClients is returned when a listener would exceed the client limit
+	ErrTooManyClients = errors.New("maximum number of total clients reached")
+	// ErrEncryptionDisabled is returned for data key operations without encryption configured
+	ErrEncryptionDisabled = errors.New("encryption is not enabled")
+	// ErrPayloadTooLarge is returned for payloads above the size limit or not fitting the data column
+	ErrPayloadTooLarge = errors.New("payload too large")
+	// ErrBlobsDisabled is returned for blob operations without a blob store configured
+	ErrBlobsDisabled = errors.New("blob store is not enabled")
+	// ErrDataAndBlob is returned for events given both a payload and a blob
+	ErrDataAndBlob = errors.New("data and blob are mutually exclusive")
+	// ErrBlobDataKey is returned for events given both a blob and a data key,
+	// blobs are stored unencrypted
+	ErrBlobDataKey = errors.New("blobs can't be encrypted with a data key")
+)
+
+// Archive serves the events moved out of the database
+type Archive interface {
+	// Watermark returns the highest archived event ID
+	Watermark(ctx context.Context) (int64, error)
+	// Read returns the archived events of a tenant after afterID up to untilID
+	// whose subject equals subject, or starts with it if prefix is set. At
+	// most limit events are returned, position is the ID up to which was read.
+	Read(ctx context.Context, tenant string, subject string, prefix bool, afterID int64, untilID int64, limit int32) (events []*models.Event, position int64, err error)
+	// Get returns an archived event of a tenant, or nil if there is none
+	Get(ctx context.Context, tenant string, id int64) (*models.Event, error)
+}
+
+// Encryption encrypts event payloads with named data keys that can be erased
+type Encryption interface {
+	// Encrypt encrypts data with a data key of the tenant, creating it on first use
+	Encrypt(ctx context.Context, tenant string, key string, data []byte) ([]byte, error)
+	// Decrypt decrypts data, erased is set instead if the data key was erased
+	Decrypt(ctx context.Context, tenant string, key string, data []byte) (plaintext []byte, erased bool, err error)
+	// Erase destroys a data key, it returns false if there was none to erase
+	Erase(ctx context.Context, tenant string, key string) (bool, error)
+}
+
+// BlobStore keeps payloads too large to be stored with their event
+type BlobStore interface {
+	// Put stores a blob read from r, which must have the expected SHA-256 if given
+	Put(ctx context.Context, tenant string, r io.Reader, expected string) (*models.Blob, error)
+	// PutBytes stores a blob held in memory
+	PutBytes(ctx context.Context, tenant string, data []byte) (*models.Blob, error)
+	// Get returns an uploaded blob of a tenant
+	Get(ctx context.Context, tenant string, sha256 string) (*models.Blob, error)
+	// Reference returns an uploaded blob of a tenant an event is about to reference
+	Reference(ctx context.Context, tenant string, sha256 string) (*models.Blob, error)
+	// Open returns the content of an uploaded blob of a tenant
+	Open(ctx context.Context, tenant string, sha256 string) (io.ReadCloser, *models.Blob, error)
+}
+
+// Schemas validates event payloads against the schemas registered for their
+// type and upcasts payloads of older schema versions
+type Schemas interface {
+	// Validate checks a payload against a schema version of its type, the
+	// latest for version 0. It returns the version validated against, or 0
+	// if the type has no schema or the content type isn't covered by it.
+	Validate(ctx context.Context, tenant string, eventType string, version int32, contentType string, data []byte) (int32, error)
+	// Upcast returns the event with its payload transformed into the latest schema version of its type
+	Upcast(ctx context.Context, tenant string, event *models.Event) (*models.Event, error)
+}
+
+// Listener receives the events emitted after it was attached. Events are
+// dropped instead of blocking the fan-out when its buffer is full.
+type Listener struct {
+	C       chan *models.Event
+	lagged  atomic.Bool
+	element *list.Element
+	// tenant and group aggregate the listener in metrics
+	tenant string
+	group  string
+	// position is the highest event ID the consumer has dealt with
+	position atomic.Int64
+}
+
+// Lagged reports whether events were dropped since the last call
+func (l *Listener) Lagged() bool {
+	return l.lagged.Swap(false)
+}
+
 // Server is used to implement both gRPC and REST servers
 type Server struct {
 	pb.UnimplementedEventsDBServer
@@ -24,71 +121,454 @@ type Server struct {
 	clientsMutex        sync.Mutex
 	maxTotalClients     int
 	clientBufferSize    int
+	headID              atomic.Int64
+	metricGroups        map[string]struct{}
+	metricGroupsMutex   sync.Mutex
+	archive             Archive
+	encryption          Encryption
+	compressionCodec    string
+	compressionMinBytes int
+	maxPayloadBytes     int
+	blobs               BlobStore
+	blobThreshold       int
+	schemas             Schemas
+	indexes             Indexes
 }
 
-func New(queries *database.Queries, bufferSize int, maxTotalClients int, clientBufferSize int, logger *slog.Logger) *Server {
-	emitterChannel := make(chan *models.Event, bufferSize)
-	listeners := list.New()
+// StoredPayload is an event payload in the form it is stored in
+type StoredPayload struct {
+	Data []byte
+	// Codec is the compression codec applied before encryption
+	Codec string
+	// DataKey is the data key the payload is encrypted with, empty if it isn't
+	DataKey string
+	// Blob is set when the payload is stored as a blob, Data is empty then
+	Blob *models.Blob
+}
 
-	go func() {
-		for event := range emitterChannel {
-			for listener := listeners.Front(); listener != nil; listener = listener.Next() {
-				listener.Value.(chan *models.Event) <- event
-			}
-		}
-		fmt.Println("Channel closed, reader exiting.")
-	}()
+// Size returns the bytes the payload takes up in the events table and the
+// blob store, as the storage quotas count them
+func (p StoredPayload) Size() int {
+	if p.Blob != nil {
+		return len(p.Data) + int(p.Blob.Size)
+	}
+	return len(p.Data)
+}
 
-	return &Server{
+func New(queries *database.Queries, bufferSize int, maxTotalClients int, clientBufferSize int, logger *slog.Logger) *Server {
+	s := &Server{
 		queries:             queries,
-		eventEmitterChannel: emitterChannel,
-		eventListeners:      listeners,
+		eventEmitterChannel: make(chan *models.Event, bufferSize),
+		eventListeners:      list.New(),
 		listenerIdCounter:   0,
 		logger:              logger,
 		totalClients:        0,
 		maxTotalClients:     maxTotalClients,
 		clientBufferSize:    clientBufferSize,
+		metricGroups:        make(map[string]struct{}),
+		maxPayloadBytes:     MaxStoredDataBytes,
 	}
+
+	go func() {
+		for event := range s.eventEmitterChannel {
+			if event.ID > s.headID.Load() {
+				s.headID.Store(event.ID)
+			}
+
+			s.clientsMutex.Lock()
+			for element := s.eventListeners.Front(); element != nil; element = element.Next() {
+				listener := element.Value.(*Listener)
+				select {
+				case listener.C <- event:
+				default:
+					// A slow client must not stall the others, it catches up from the database instead
+					listener.lagged.Store(true)
+					metrics.StreamDroppedEvents.WithLabelValues(listener.tenant, listener.group).Inc()
+				}
+			}
+			s.clientsMutex.Unlock()
+		}
+		fmt.Println("Channel closed, reader exiting.")
+	}()
+
+	return s
 }
 
 func (s *Server) GetEmitterChan() chan *models.Event {
 	return s.eventEmitterChannel
 }
 
+// HeadID returns the ID of the newest event emitted since the server started
+func (s *Server) HeadID() int64 {
+	return s.headID.Load()
+}
+
+// Head returns the ID of the newest stored event, which bounds the replay of
+// reads that end instead of going live
+func (s *Server) Head(ctx context.Context) (int64, error) {
+	head, err := s.queries.GetLastEventID(ctx)
+	if err != nil {
+		return 0, err
+	}
+	head = max(head, s.HeadID())
+
+	// Every event may have been archived already
+	if s.archive != nil {
+		watermark, err := s.archive.Watermark(ctx)
+		if err != nil {
+			return 0, err
+		}
+		head = max(head, watermark)
+	}
+	return head, nil
+}
+
+// LowestPosition returns the lowest position of the attached listeners, or
+// NoUpperBound if there are none
+func (s *Server) LowestPosition() int64 {
+	s.clientsMutex.Lock()
+	defer s.clientsMutex.Unlock()
+
+	lowest := int64(NoUpperBound)
+	for element := s.eventListeners.Front(); element != nil; element = element.Next() {
+		lowest = min(lowest, element.Value.(*Listener).position.Load())
+	}
+	return lowest
+}
+
+// SetArchive makes reads fall back to the archive for archived events
+func (s *Server) SetArchive(archive Archive) {
+	s.archive = archive
+}
+
+// SetEncryption makes new payloads encrypted and stored ones decrypted on read
+func (s *Server) SetEncryption(encryption Encryption) {
+	s.encryption = encryption
+}
+
+// SetCompression makes payloads of at least minBytes bytes stored compressed
+// with codec. Payloads that don't shrink are stored as is.
+func (s *Server) SetCompression(codec string, minBytes int) {
+	s.compressionCodec = codec
+	s.compressionMinBytes = minBytes
+}
+
+// SetMaxPayloadBytes limits the size of payloads before compression. Larger
+// limits than MaxStoredDataBytes only help payloads that compress well.
+func (s *Server) SetMaxPayloadBytes(maxBytes int) {
+	s.maxPayloadBytes = maxBytes
+}
+
+// SetBlobs stores payloads above threshold bytes in the blob store and
+// enables events referencing uploaded blobs
+func (s *Server) SetBlobs(blobs BlobStore, threshold int) {
+	s.blobs = blobs
+	s.blobThreshold = threshold
+}
+
+// SetSchemas makes new payloads validated against the schemas of their type
+func (s *Server) SetSchemas(schemas Schemas) {
+	s.schemas = schemas
+}
+
+// ValidateData checks a payload against the schema of its event type and
+// returns the schema version it matched, 0 if it wasn't validated. Payloads
+// given as uploaded blobs aren't validated as they are never loaded.
+func (s *Server) ValidateData(ctx context.Context, tenant string, eventType string, version int32, contentType string, blobSHA256 string, data []byte) (int32, error) {
+	if s.schemas == nil || blobSHA256 != "" {
+		return 0, nil
+	}
+	return s.schemas.Validate(ctx, tenant, eventType, version, contentType, data)
+}
+
+// Upcast returns the events with their payloads transformed into the latest
+// schema version of their type. Events are returned unchanged without schemas.
+func (s *Server) Upcast(ctx context.Context, events []*models.Event) ([]*models.Event, error) {
+	if s.schemas == nil {
+		return events, nil
+	}
+
+	upcasted := make([]*models.Event, len(events))
+	for i, event := range events {
+		var err error
+		upcasted[i], err = s.schemas.Upcast(ctx, event.Tenant, event)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return upcasted, nil
+}
+
+// SealData prepares a payload for storage. A payload given as an uploaded
+// blob is referenced from the event, it can't be given a data key as blobs
+// are stored unencrypted. Without encryption, payloads above the blob
+// threshold are offloaded the same way. Otherwise payloads above the
+// compression threshold are compressed. With encryption enabled the payload
+// is then encrypted with the given data key, or the subject if none is given,
+// and has to fit the data column. Without encryption no data key may be given.
+func (s *Server) SealData(ctx context.Context, tenant string, subject string, key string, blobSHA256 string, data []byte) (StoredPayload, error) {
+	if blobSHA256 != "" {
+		if len(data) > 0 {
+			return StoredPayload{}, ErrDataAndBlob
+		}
+		if key != "" {
+			return StoredPayload{}, ErrBlobDataKey
+		}
+		if s.blobs == nil {
+			return StoredPayload{}, ErrBlobsDisabled
+		}
+		blob, err := s.blobs.Reference(ctx, tenant, blobSHA256)
+		if err != nil {
+			return StoredPayload{}, err
+		}
+		return StoredPayload{Blob: blob}, nil
+	}
+
+	if len(data) > s.maxPayloadBytes {
+		return StoredPayload{}, ErrPayloadTooLarge
+	}
+	if s.encryption == nil && key != "" {
+		return StoredPayload{}, ErrEncryptionDisabled
+	}
+
+	// Offloaded payloads would bypass the encryption, encrypted events keep
+	// their payloads inline
+	if s.blobs != nil && s.encryption == nil && len(data) > s.blobThreshold {
+		blob, err := s.blobs.PutBytes(ctx, tenant, data)
+		if err != nil {
+			return StoredPayload{}, err
+		}
+		metrics.OffloadedPayloads.Inc()
+		return StoredPayload{Blob: blob}, nil
+	}
+
+	payload := StoredPayload{Data: data}
+	if s.compressionCodec != compression.None && len(data) >= s.compressionMinBytes {
+		compressed, err := compression.Compress(s.compressionCodec, data)
+		if err != nil {
+			return StoredPayload{}, err
+		}
+
+		metrics.PayloadCompressionRatio.WithLabelValues(s.compressionCodec).Observe(float64(len(compressed)) / float64(max(len(data), 1)))
+		if len(compressed) < len(data) {
+			metrics.PayloadCompressedBytes.WithLabelValues(s.compressionCodec).Add(float64(len(data)))
+			metrics.PayloadStoredBytes.WithLabelValues(s.compressionCodec).Add(float64(len(compressed)))
+			payload.Data = compressed
+			payload.Codec = s.compressionCodec
+		}
+	}
+
+	if s.encryption != nil {
+		if key == "" {
+			key = subject
+		}
+		sealed, err := s.encryption.Encrypt(ctx, tenant, key, payload.Data)
+		if err != nil {
+			return StoredPayload{}, err
+		}
+		payload.Data = sealed
+		payload.DataKey = key
+	}
+
+	if len(payload.Data) > MaxStoredDataBytes {
+		return StoredPayload{}, ErrPayloadTooLarge
+	}
+	return payload, nil
+}
+
+// EraseDataKey destroys a data key of a tenant, the payloads encrypted with
+// it are returned redacted from then on. It returns false if there was no
+// key to erase.
+func (s *Server) EraseDataKey(ctx context.Context, tenant string, key string) (bool, error) {
+	if s.encryption == nil {
+		return false, ErrEncryptionDisabled
+	}
+	return s.encryption.Erase(ctx, tenant, key)
+}
+
+// UploadBlob stores a blob of a tenant read from r, which must have the expected SHA-256 if given
+func (s *Server) UploadBlob(ctx context.Context, tenant string, r io.Reader, expected string) (*models.Blob, error) {
+	if s.blobs == nil {
+		return nil, ErrBlobsDisabled
+	}
+	return s.blobs.Put(ctx, tenant, r, expected)
+}
+
+// OpenBlob returns the content of an uploaded blob of a tenant
+func (s *Server) OpenBlob(ctx context.Context, tenant string, sha256 string) (io.ReadCloser, *models.Blob, error) {
+	if s.blobs == nil {
+		return nil, nil, ErrBlobsDisabled
+	}
+	return s.blobs.Open(ctx, tenant, sha256)
+}
+
+// openData returns the event with its payload decrypted and decompressed, or
+// redacted if its data key was erased. Events are copied as they may be shared.
+func (s *Server) openData(ctx context.Context, event *models.Event) (*models.Event, error) {
+	if event.DataKey == "" && event.DataCodec == compression.None {
+		return event, nil
+	}
+
+	opened := *event
+	if event.DataKey != "" {
+		if s.encryption == nil {
+			return nil, fmt.Errorf("event %d is encrypted but encryption is not enabled", event.ID)
+		}
+
+		data, erased, err := s.encryption.Decrypt(ctx, event.Tenant, event.DataKey, event.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt event %d: %w", event.ID, err)
+		}
+		if erased {
+			opened.Data = nil
+			opened.DataCodec = compression.None
+			opened.Redacted = true
+			return &opened, nil
+		}
+		opened.Data = data
+	}
+
+	data, err := compression.Decompress(event.DataCodec, opened.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress event %d: %w", event.ID, err)
+	}
+	opened.Data = data
+	opened.DataCodec = compression.None
+	return &opened, nil
+}
+
+// GetEvent returns a stored or archived event of a tenant, or sql.ErrNoRows
+func (s *Server) GetEvent(ctx context.Context, tenant string, id int64) (*models.Event, error) {
+	row, err := s.queries.GetEventByID(ctx, database.GetEventByIDParams{
+		Tenant: tenant,
+		ID:     id,
+	})
+	if err == nil {
+		event, err := EventFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		return s.openData(ctx, event)
+	}
+	if !errors.Is(err, sql.ErrNoRows) || s.archive == nil {
+		return nil, err
+	}
+
+	event, err := s.archive.Get(ctx, tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	if event == nil {
+		return nil, sql.ErrNoRows
+	}
+	return s.openData(ctx, event)
+}
+
 func (s *Server) GetQueries() *database.Queries {
 	return s.queries
 }
 
-func (s *Server) AttachListener() (chan *models.Event, *list.Element, error) {
+// SubjectUsage returns the number and total size of the events a tenant
+// stored under a subject prefix, their stored payloads and blobs. Archived
+// events count as well, archiving doesn't free storage.
+func (s *Server) SubjectUsage(ctx context.Context, tenant string, prefix string) (int64, int64, error) {
+	usage, err := s.queries.GetSubjectPrefixUsage(ctx, database.GetSubjectPrefixUsageParams{
+		Tenant:  tenant,
+		Pattern: LikePrefix(prefix),
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return usage.Events, usage.Bytes, nil
+}
+
+func (s *Server) AttachListener(tenant string, group string) (*Listener, error) {
 	s.clientsMutex.Lock()
 	defer s.clientsMutex.Unlock()
 
 	if s.totalClients >= s.maxTotalClients {
-		return nil, nil, fmt.Errorf("maximum number of total clients reached")
+		return nil, ErrTooManyClients
 	}
 
 	s.listenerIdCounter += 1
-	channel := make(chan *models.Event, s.clientBufferSize)
-	elmt := s.eventListeners.PushBack(channel)
+	listener := &Listener{
+		C:      make(chan *models.Event, s.clientBufferSize),
+		tenant: tenant,
+		group:  s.metricGroup(group),
+	}
+	listener.element = s.eventListeners.PushBack(listener)
 	s.totalClients++
 
 	// Update active streams metric
 	metrics.ActiveEventStreams.Inc()
 
-	return channel, elmt, nil
+	return listener, nil
 }
 
-func (s *Server) DetachListener(listener *list.Element) {
+func (s *Server) DetachListener(listener *Listener) {
 	s.clientsMutex.Lock()
 	defer s.clientsMutex.Unlock()
 
-	s.eventListeners.Remove(listener)
+	s.eventListeners.Remove(listener.element)
 	s.totalClients--
 
 	// Update active streams metric
 	metrics.ActiveEventStreams.Dec()
 }
 
+// Streams returns the state of the fan-out with its listeners aggregated by
+// tenant and group
+func (s *Server) Streams() models.StreamStats {
+	head := s.HeadID()
+	groups := make(map[[2]string]*models.StreamGroup)
+
+	s.clientsMutex.Lock()
+	for element := s.eventListeners.Front(); element != nil; element = element.Next() {
+		listener := element.Value.(*Listener)
+
+		key := [2]string{listener.tenant, listener.group}
+		group, ok := groups[key]
+		if !ok {
+			group = &models.StreamGroup{Tenant: listener.tenant, Group: listener.group}
+			groups[key] = group
+		}
+		group.Clients++
+		group.MaxLag = max(group.MaxLag, head-listener.position.Load())
+		if cap(listener.C) > 0 {
+			group.BufferUsage = max(group.BufferUsage, float64(len(listener.C))/float64(cap(listener.C)))
+		}
+	}
+	s.clientsMutex.Unlock()
+
+	stats := models.StreamStats{
+		HeadID:  head,
+		Backlog: len(s.eventEmitterChannel),
+		Groups:  make([]models.StreamGroup, 0, len(groups)),
+	}
+	for _, group := range groups {
+		stats.Groups = append(stats.Groups, *group)
+	}
+	slices.SortFunc(stats.Groups, func(a, b models.StreamGroup) int {
+		return cmp.Or(strings.Compare(a.Tenant, b.Tenant), strings.Compare(a.Group, b.Group))
+	})
+	return stats
+}
+
 func (s *Server) GetLogger() *slog.Logger {
 	return s.logger
 }
+
+// metricGroup bounds the number of distinct groups used as metric labels
+func (s *Server) metricGroup(group string) string {
+	s.metricGroupsMutex.Lock()
+	defer s.metricGroupsMutex.Unlock()
+
+	if _, ok := s.metricGroups[group]; ok {
+		return group
+	}
+	if len(s.metricGroups) >= maxMetricGroups {
+		return "other"
+	}
+	s.metricGroups[group] = struct{}{}
+	return group
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/tracing"
+)
+
+// Subscription delivers the events matching a set of filters, first from the
+// database and then live as they are emitted. When its listener falls behind,
+// the dropped events are replayed from the database before going on.
+type Subscription struct {
+	server    *Server
+	listener  *Listener
+	filters   []*SubjectFilter
+	batchSize int32
+	send      func([]*models.Event) error
+	// position is the highest event ID the subscription has dealt with
+	position int64
+}
+
+// Subscribe replays the stored events matching the filters through send and
+// attaches a listener for the live events. The filters must belong to the same
+// tenant and there must be at least one. The subscription must be closed.
+func (s *Server) Subscribe(ctx context.Context, filters []*SubjectFilter, batchSize int32, send func([]*models.Event) error) (*Subscription, error) {
+	sub := &Subscription{
+		server:    s,
+		filters:   filters,
+		batchSize: batchSize,
+		send: func(events []*models.Event) error {
+			return tracing.Deliver(ctx, events, send)
+		},
+	}
+
+	start := time.Now()
+
+	// Catch up before attaching so a long history doesn't fill the listener
+	lastID, err := s.CatchUp(ctx, filters, NoUpperBound, batchSize, sub.send)
+	if err != nil {
+		return nil, err
+	}
+	sub.position = lastID
+
+	sub.listener, err = s.AttachListener(filters[0].Tenant, streamGroup(filters))
+	if err != nil {
+		return nil, err
+	}
+
+	// Events stored while catching up were emitted before the listener existed
+	if err := sub.resync(ctx); err != nil {
+		s.DetachListener(sub.listener)
+		return nil, err
+	}
+	metrics.StreamCatchUpDuration.WithLabelValues("initial").Observe(time.Since(start).Seconds())
+
+	return sub, nil
+}
+
+// Events returns the channel of live events to pass to Deliver
+func (sub *Subscription) Events() <-chan *models.Event {
+	return sub.listener.C
+}
+
+// Position returns the highest event ID the subscription has dealt with
+func (sub *Subscription) Position() int64 {
+	return sub.position
+}
+
+// Filters returns the current filter set
+func (sub *Subscription) Filters() []*SubjectFilter {
+	return sub.filters
+}
+
+// Deliver sends a live event, together with the events already queued behind
+// it, as far as they match the filters and haven't been sent yet
+func (sub *Subscription) Deliver(ctx context.Context, event *models.Event) error {
+	batch := make([]*models.Event, 0, 1)
+	for {
+		if sub.listener.Lagged() {
+			// Send what is ready, then replay the dropped events from the database
+			if len(batch) > 0 {
+				if err := sub.send(batch); err != nil {
+					return err
+				}
+				batch = make([]*models.Event, 0, 1)
+			}
+			start := time.Now()
+			if err := sub.resync(ctx); err != nil {
+				return err
+			}
+			metrics.StreamCatchUpDuration.WithLabelValues("lagged").Observe(time.Since(start).Seconds())
+		}
+
+		if event.ID > sub.position {
+			sub.position = event.ID
+			if MatchesAny(sub.filters, event) {
+				batch = append(batch, event)
+			}
+		}
+		if len(batch) >= int(sub.batchSize) {
+			break
+		}
+
+		next, ok := sub.queued()
+		if !ok {
+			break
+		}
+		event = next
+	}
+	sub.listener.position.Store(sub.position)
+
+	if len(batch) == 0 {
+		return nil
+	}
+	return sub.send(batch)
+}
+
+// queued returns the next live event if one is waiting
+func (sub *Subscription) queued() (*models.Event, bool) {
+	select {
+	case event := <-sub.listener.C:
+		return event, true
+	default:
+		return nil, false
+	}
+}
+
+// Update removes the filters with the given IDs and adds new ones. The history
+// of added filters is replayed up to the current position, leaving out events
+// the previous filters already delivered.
+func (sub *Subscription) Update(ctx context.Context, remove []string, add []*SubjectFilter) error {
+	previous := sub.filters
+
+	filters := make([]*SubjectFilter, 0, len(previous)+len(add))
+	for _, f := range previous {
+		if !slices.Contains(remove, f.ID) {
+			filters = append(filters, f)
+		}
+	}
+	sub.filters = append(filters, add...)
+
+	if len(add) == 0 {
+		return nil
+	}
+
+	_, err := sub.server.CatchUp(ctx, add, sub.position, sub.batchSize, func(events []*models.Event) error {
+		fresh := make([]*models.Event, 0, len(events))
+		for _, event := range events {
+			if !coveredByAny(previous, event) {
+				fresh = append(fresh, event)
+			}
+		}
+		if len(fresh) == 0 {
+			return nil
+		}
+		return sub.send(fresh)
+	})
+	return err
+}
+
+// Close detaches the listener of the subscription
+func (sub *Subscription) Close() {
+	sub.server.DetachListener(sub.listener)
+}
+
+// resync replays the stored events after the current position. Everything up
+// to the head read beforehand is covered, so live events at or below the new
+// position have been dealt with.
+func (sub *Subscription) resync(ctx context.Context) error {
+	head, err := sub.server.queries.GetLastEventID(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range sub.filters {
+		f.position = max(f.position, sub.position)
+	}
+	lastID, err := sub.server.CatchUp(ctx, sub.filters, NoUpperBound, sub.batchSize, sub.send)
+	if err != nil {
+		return err
+	}
+
+	sub.position = max(sub.position, head, lastID)
+	sub.listener.position.Store(sub.position)
+	return nil
+}
+
+// streamGroup names the group a stream is aggregated under in metrics: the
+// first segment of its subjects, "$all" for every subject or "multi" when the
+// filters span several groups
+func streamGroup(filters []*SubjectFilter) string {
+	group := ""
+	for i, f := range filters {
+		g := "$all"
+		if f.Subject != "" {
+			g, _, _ = strings.Cut(strings.TrimPrefix(f.Subject, "/"), "/")
+		}
+		if i > 0 && g != group {
+			return "multi"
+		}
+		group = g
+	}
+	return group
+}
+
+// coveredByAny reports whether one of the filters has delivered the event
+func coveredByAny(filters []*SubjectFilter, event *models.Event) bool {
+	for _, f := range filters {
+		if f.Covers(event) {
+			return true
+		}
+	}
+	return false
+}
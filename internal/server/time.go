@@ -0,0 +1,47 @@
+package server
+
+import (
+	"database/sql"
+	"time"
+)
+
+// TimePrecision is the precision event times are stored with
+const TimePrecision = time.Microsecond
+
+// Now returns the current time as it is stored, in UTC at TimePrecision. The
+// time of an event is taken once and both stored and emitted, so live and
+// replayed events carry the same time.
+func Now() time.Time {
+	return time.Now().UTC().Truncate(TimePrecision)
+}
+
+// FormatTime formats an event time for the API
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// ParseOccurredAt parses the RFC 3339 occurrence time a producer gave an
+// event, the empty string for events without one
+func ParseOccurredAt(value string) (sql.NullTime, error) {
+	if value == "" {
+		return sql.NullTime{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return sql.NullTime{}, err
+	}
+	return OccurredAt(parsed), nil
+}
+
+// OccurredAt prepares the occurrence time of an event for storage
+func OccurredAt(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC().Truncate(TimePrecision), Valid: true}
+}
+
+// FormatOccurredAt formats a stored occurrence time, "" if there is none
+func FormatOccurredAt(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return FormatTime(t.Time)
+}
@@ -0,0 +1,121 @@
+package shredding
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KMS wraps data keys with a master key it holds
+type KMS interface {
+	// Wrap encrypts a data key and returns it with the ID of the master key used
+	Wrap(ctx context.Context, key []byte) (wrapped []byte, masterKeyID string, err error)
+	// Unwrap decrypts a data key wrapped with the given master key
+	Unwrap(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error)
+}
+
+// ErrUnknownMasterKey is returned when unwrapping with a master key the KMS doesn't hold
+var ErrUnknownMasterKey = errors.New("unknown master key")
+
+// FileKMS holds AES-256 master keys read from a local file
+type FileKMS struct {
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// NewFileKMS reads a key file with one "<id> <hex encoded 32 byte key>" per
+// line. New data keys are wrapped with the last key, the others remain
+// available for unwrapping so master keys can be rotated.
+func NewFileKMS(path string) (*FileKMS, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file: %w", err)
+	}
+	defer file.Close()
+
+	kms := &FileKMS{keys: make(map[string]cipher.AEAD)}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("key file line %d: expected \"<id> <key>\"", line)
+		}
+		key, err := hex.DecodeString(fields[1])
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("key file line %d: key must be 32 hex encoded bytes", line)
+		}
+		if _, ok := kms.keys[fields[0]]; ok {
+			return nil, fmt.Errorf("key file line %d: duplicate key ID %q", line, fields[0])
+		}
+
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		kms.keys[fields[0]] = aead
+		kms.current = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if kms.current == "" {
+		return nil, errors.New("key file contains no keys")
+	}
+
+	return kms, nil
+}
+
+func (k *FileKMS) Wrap(ctx context.Context, key []byte) ([]byte, string, error) {
+	wrapped, err := seal(k.keys[k.current], key)
+	if err != nil {
+		return nil, "", err
+	}
+	return wrapped, k.current, nil
+}
+
+func (k *FileKMS) Unwrap(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error) {
+	aead, ok := k.keys[masterKeyID]
+	if !ok {
+		return nil, ErrUnknownMasterKey
+	}
+	return open(aead, wrapped)
+}
+
+// newAEAD returns AES-GCM for a 32 byte key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext with a random nonce, which is prepended to the ciphertext
+func seal(aead cipher.AEAD, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts the output of seal
+func open(aead cipher.AEAD, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
@@ -0,0 +1,178 @@
+package shredding
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/metrics"
+)
+
+// cacheTTL is how long unwrapped data keys are kept in memory. Erasing a key
+// on another server instance takes effect here once the entry expired.
+const cacheTTL = 30 * time.Second
+
+// maxCacheEntries bounds the number of cached data keys
+const maxCacheEntries = 10000
+
+// ErrKeyErased is returned when encrypting with a data key that was erased
+var ErrKeyErased = errors.New("data key has been erased")
+
+type cachedKey struct {
+	// aead is nil for erased or missing keys
+	aead     cipher.AEAD
+	loadedAt time.Time
+}
+
+// Shredder encrypts event payloads with per-tenant named data keys, wrapped by
+// the KMS and stored in the database. Erasing a data key makes every payload
+// encrypted with it unreadable, including the copies in archives and backups.
+type Shredder struct {
+	queries *database.Queries
+	kms     KMS
+	mutex   sync.Mutex
+	keys    map[[2]string]cachedKey
+}
+
+func New(queries *database.Queries, kms KMS) *Shredder {
+	return &Shredder{
+		queries: queries,
+		kms:     kms,
+		keys:    make(map[[2]string]cachedKey),
+	}
+}
+
+// Encrypt encrypts data with a data key of the tenant, creating the key on first use
+func (s *Shredder) Encrypt(ctx context.Context, tenant string, name string, data []byte) ([]byte, error) {
+	aead, found, err := s.key(ctx, tenant, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !found {
+		if err := s.create(ctx, tenant, name); err != nil {
+			return nil, err
+		}
+		s.forget(tenant, name)
+		if aead, found, err = s.key(ctx, tenant, name); err != nil {
+			return nil, err
+		}
+	}
+	if aead == nil {
+		return nil, ErrKeyErased
+	}
+
+	return seal(aead, data)
+}
+
+// Decrypt decrypts data encrypted with a data key of the tenant. erased is set
+// instead if the key was erased.
+func (s *Shredder) Decrypt(ctx context.Context, tenant string, name string, data []byte) ([]byte, bool, error) {
+	aead, _, err := s.key(ctx, tenant, name)
+	if err != nil {
+		return nil, false, err
+	}
+	if aead == nil {
+		return nil, true, nil
+	}
+
+	plaintext, err := open(aead, data)
+	if err != nil {
+		return nil, false, err
+	}
+	return plaintext, false, nil
+}
+
+// Erase destroys a data key of the tenant. It returns false if the key
+// doesn't exist or was erased before.
+func (s *Shredder) Erase(ctx context.Context, tenant string, name string) (bool, error) {
+	count, err := s.queries.EraseDataKey(ctx, database.EraseDataKeyParams{
+		Tenant: tenant,
+		Name:   name,
+	})
+	if err != nil {
+		return false, err
+	}
+	s.forget(tenant, name)
+
+	if count > 0 {
+		metrics.ErasedDataKeys.Inc()
+	}
+	return count > 0, nil
+}
+
+// key returns the unwrapped data key, nil if it was erased. found is false if
+// the key doesn't exist.
+func (s *Shredder) key(ctx context.Context, tenant string, name string) (cipher.AEAD, bool, error) {
+	id := [2]string{tenant, name}
+
+	s.mutex.Lock()
+	entry, ok := s.keys[id]
+	s.mutex.Unlock()
+	if ok && time.Since(entry.loadedAt) < cacheTTL {
+		return entry.aead, true, nil
+	}
+
+	row, err := s.queries.GetDataKey(ctx, database.GetDataKeyParams{
+		Tenant: tenant,
+		Name:   name,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var aead cipher.AEAD
+	if !row.ErasedAt.Valid {
+		key, err := s.kms.Unwrap(ctx, row.MasterKeyID, row.WrappedKey)
+		if err != nil {
+			return nil, false, err
+		}
+		if aead, err = newAEAD(key); err != nil {
+			return nil, false, err
+		}
+	}
+
+	s.mutex.Lock()
+	if len(s.keys) >= maxCacheEntries {
+		s.keys = make(map[[2]string]cachedKey)
+	}
+	s.keys[id] = cachedKey{aead: aead, loadedAt: time.Now()}
+	s.mutex.Unlock()
+
+	return aead, true, nil
+}
+
+// create stores a new random data key unless another writer created it first
+func (s *Shredder) create(ctx context.Context, tenant string, name string) error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	wrapped, masterKeyID, err := s.kms.Wrap(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return s.queries.CreateDataKey(ctx, database.CreateDataKeyParams{
+		Tenant:      tenant,
+		Name:        name,
+		WrappedKey:  wrapped,
+		MasterKeyID: masterKeyID,
+	})
+}
+
+// forget drops a cached data key
+func (s *Shredder) forget(tenant string, name string) {
+	s.mutex.Lock()
+	delete(s.keys, [2]string{tenant, name})
+	s.mutex.Unlock()
+}
@@ -0,0 +1,366 @@
+package tenancy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/idot-digital/events-db/database"
+)
+
+// cacheTTL is how long resolved tokens and tenants are trusted. Changes made
+// on this instance drop the affected entries, other instances see tokens
+// revoked and tenants deleted there within this time.
+const cacheTTL = 5 * time.Second
+
+// maxCacheEntries bounds the caches so unknown tokens can't grow them without limit
+const maxCacheEntries = 10000
+
+// deleteBatchSize is the number of events removed per statement when a tenant is deleted
+const deleteBatchSize = 1000
+
+var (
+	// ErrInvalidToken is returned for credentials matching neither the admin token nor a tenant token
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrUnknownTenant is returned when the selected tenant doesn't exist
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// ErrTenantMismatch is returned when a tenant token is used to select another tenant
+	ErrTenantMismatch = errors.New("token is not valid for the selected tenant")
+	// ErrTenantExists is returned when creating a tenant that already exists
+	ErrTenantExists = errors.New("tenant already exists")
+	// ErrDefaultTenant is returned when deleting the default tenant
+	ErrDefaultTenant = errors.New("the default tenant cannot be deleted")
+	// ErrInvalidName is returned for tenant names not matching the allowed pattern
+	ErrInvalidName = errors.New("invalid tenant name")
+)
+
+type cacheEntry struct {
+	// tenant is empty for tokens known not to exist
+	tenant string
+	// tokenID is the ID of a tenant token
+	tokenID  int64
+	loadedAt time.Time
+}
+
+// BlobStore deletes the blobs of deleted tenants from the blob store
+type BlobStore interface {
+	DeleteTenant(ctx context.Context, tenant string) error
+}
+
+// Registry authenticates clients and manages tenants and their tokens
+type Registry struct {
+	queries    *database.Queries
+	adminToken string
+	blobs      BlobStore
+	mutex      sync.Mutex
+	tokens     map[string]cacheEntry
+	tenants    map[string]cacheEntry
+	// generation is increased whenever cache entries are dropped, so lookups
+	// running meanwhile don't cache what they read before
+	generation uint64
+}
+
+func NewRegistry(queries *database.Queries, adminToken string) *Registry {
+	return &Registry{
+		queries:    queries,
+		adminToken: adminToken,
+		tokens:     make(map[string]cacheEntry),
+		tenants:    make(map[string]cacheEntry),
+	}
+}
+
+// SetBlobs makes deleting a tenant also delete its blobs from the blob store
+func (r *Registry) SetBlobs(blobs BlobStore) {
+	r.blobs = blobs
+}
+
+// Resolve authenticates a token and returns the principal it acts as. The
+// admin token, or any caller when no admin token is configured, may select a
+// tenant by name. Tenant tokens are bound to their tenant.
+func (r *Registry) Resolve(ctx context.Context, token string, requested string) (Principal, error) {
+	if token != "" && token != r.adminToken {
+		tenant, tokenID, err := r.tokenTenant(ctx, token)
+		if err != nil {
+			return Principal{}, err
+		}
+		if tenant != "" {
+			if requested != "" && requested != tenant {
+				return Principal{}, ErrTenantMismatch
+			}
+			return Principal{Tenant: tenant, TokenID: tokenID}, nil
+		}
+		if r.adminToken != "" {
+			return Principal{}, ErrInvalidToken
+		}
+	} else if token == "" && r.adminToken != "" {
+		return Principal{}, ErrInvalidToken
+	}
+
+	if requested == "" {
+		requested = Default
+	}
+	exists, err := r.tenantExists(ctx, requested)
+	if err != nil {
+		return Principal{}, err
+	}
+	if !exists {
+		return Principal{}, ErrUnknownTenant
+	}
+
+	return Principal{Tenant: requested, Admin: true}, nil
+}
+
+// tokenTenant returns the tenant and ID of a tenant token, or "" if no such
+// token exists
+func (r *Registry) tokenTenant(ctx context.Context, token string) (string, int64, error) {
+	hash := hashToken(token)
+
+	r.mutex.Lock()
+	entry, ok := r.tokens[hash]
+	generation := r.generation
+	r.mutex.Unlock()
+	if ok && time.Since(entry.loadedAt) < cacheTTL {
+		return entry.tenant, entry.tokenID, nil
+	}
+
+	row, err := r.queries.GetTenantTokenByHash(ctx, hash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", 0, err
+	}
+
+	r.mutex.Lock()
+	if r.generation == generation {
+		r.tokens = put(r.tokens, hash, cacheEntry{tenant: row.Tenant, tokenID: row.ID, loadedAt: time.Now()})
+	}
+	r.mutex.Unlock()
+
+	return row.Tenant, row.ID, nil
+}
+
+// tenantExists reports whether a tenant has been provisioned
+func (r *Registry) tenantExists(ctx context.Context, name string) (bool, error) {
+	r.mutex.Lock()
+	entry, ok := r.tenants[name]
+	generation := r.generation
+	r.mutex.Unlock()
+	if ok && time.Since(entry.loadedAt) < cacheTTL {
+		return entry.tenant != "", nil
+	}
+
+	row, err := r.queries.GetTenant(ctx, name)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	r.mutex.Lock()
+	if r.generation == generation {
+		r.tenants = put(r.tenants, name, cacheEntry{tenant: row.Name, loadedAt: time.Now()})
+	}
+	r.mutex.Unlock()
+
+	return row.Name != "", nil
+}
+
+// put stores a cache entry, starting over once the cache is full
+func put(cache map[string]cacheEntry, key string, entry cacheEntry) map[string]cacheEntry {
+	if len(cache) >= maxCacheEntries {
+		cache = make(map[string]cacheEntry)
+	}
+	cache[key] = entry
+	return cache
+}
+
+// List returns all tenants
+func (r *Registry) List(ctx context.Context) ([]database.Tenant, error) {
+	return r.queries.ListTenants(ctx)
+}
+
+// Create provisions a new tenant
+func (r *Registry) Create(ctx context.Context, name string) error {
+	if !ValidName(name) {
+		return ErrInvalidName
+	}
+
+	exists, err := r.tenantExists(ctx, name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrTenantExists
+	}
+
+	if err := r.queries.CreateTenant(ctx, name); err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	delete(r.tenants, name)
+	r.generation++
+	r.mutex.Unlock()
+	return nil
+}
+
+// Delete removes a tenant together with its tokens and events. It returns
+// false if the tenant doesn't exist.
+func (r *Registry) Delete(ctx context.Context, name string) (bool, error) {
+	if name == Default {
+		return false, ErrDefaultTenant
+	}
+
+	// Revoke access first so no new events arrive while deleting
+	if err := r.queries.DeleteTenantTokens(ctx, name); err != nil {
+		return false, err
+	}
+	deleted, err := r.queries.DeleteTenant(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	r.forget(name)
+
+	// Archive segments are immutable, the tenant's archived events become unreachable
+	if err := r.queries.DeleteArchiveTenant(ctx, name); err != nil {
+		return false, err
+	}
+	if err := r.queries.DeleteArchiveTenantBlobs(ctx, name); err != nil {
+		return false, err
+	}
+	// Without their data keys encrypted payloads are unreadable, wherever copies remain
+	if err := r.queries.DeleteTenantDataKeys(ctx, name); err != nil {
+		return false, err
+	}
+	// Without a blob store only the index of the blobs is left to remove
+	if r.blobs != nil {
+		if err := r.blobs.DeleteTenant(ctx, name); err != nil {
+			return false, err
+		}
+	} else if err := r.queries.DeleteTenantBlobs(ctx, name); err != nil {
+		return false, err
+	}
+	if err := r.queries.DeleteTenantSchemas(ctx, name); err != nil {
+		return false, err
+	}
+	if err := r.queries.DeleteTenantUpcasters(ctx, name); err != nil {
+		return false, err
+	}
+	if err := r.queries.DeleteTenantImports(ctx, name); err != nil {
+		return false, err
+	}
+	if err := r.queries.DeleteTenantPayloadIndexes(ctx, name); err != nil {
+		return false, err
+	}
+	if err := r.queries.DeleteTenantPayloadIndexEntries(ctx, name); err != nil {
+		return false, err
+	}
+
+	for {
+		ids, err := r.queries.GetTenantEventIDs(ctx, database.GetTenantEventIDsParams{
+			Tenant: name,
+			Limit:  deleteBatchSize,
+		})
+		if err != nil {
+			return false, err
+		}
+		if len(ids) == 0 {
+			break
+		}
+		if _, err := r.queries.DeleteEventsByIDs(ctx, ids); err != nil {
+			return false, err
+		}
+		// Events indexed while the tenant was deleted left entries behind
+		if err := r.queries.DeletePayloadIndexEntriesByEvents(ctx, ids); err != nil {
+			return false, err
+		}
+		if len(ids) < deleteBatchSize {
+			break
+		}
+	}
+
+	return deleted > 0, nil
+}
+
+// forget drops the cached state of a tenant and its tokens
+func (r *Registry) forget(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.tenants, name)
+	for hash, entry := range r.tokens {
+		if entry.tenant == name {
+			delete(r.tokens, hash)
+		}
+	}
+	r.generation++
+}
+
+// Tokens returns the tokens of a tenant without their secrets
+func (r *Registry) Tokens(ctx context.Context, tenant string) ([]database.ListTenantTokensRow, error) {
+	return r.queries.ListTenantTokens(ctx, tenant)
+}
+
+// CreateToken issues a new token for a tenant. The token is only returned
+// here, the registry keeps its hash.
+func (r *Registry) CreateToken(ctx context.Context, tenant string, description string) (int64, string, error) {
+	exists, err := r.tenantExists(ctx, tenant)
+	if err != nil {
+		return 0, "", err
+	}
+	if !exists {
+		return 0, "", ErrUnknownTenant
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return 0, "", err
+	}
+	token := hex.EncodeToString(secret)
+
+	hash := hashToken(token)
+	id, err := r.queries.CreateTenantToken(ctx, database.CreateTenantTokenParams{
+		Tenant:      tenant,
+		TokenHash:   hash,
+		Description: description,
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	r.mutex.Lock()
+	delete(r.tokens, hash)
+	r.generation++
+	r.mutex.Unlock()
+
+	return id, token, nil
+}
+
+// DeleteToken revokes a tenant token. It returns false if the token doesn't exist.
+func (r *Registry) DeleteToken(ctx context.Context, id int64) (bool, error) {
+	row, err := r.queries.GetTenantToken(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := r.queries.DeleteTenantToken(ctx, id); err != nil {
+		return false, err
+	}
+
+	r.mutex.Lock()
+	delete(r.tokens, row.TokenHash)
+	r.generation++
+	r.mutex.Unlock()
+
+	return true, nil
+}
+
+// hashToken returns the hex encoded SHA-256 of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
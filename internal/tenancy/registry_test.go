@@ -0,0 +1,284 @@
+package tenancy
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/idot-digital/events-db/database"
+)
+
+// fakeDB is an in-memory stand-in for the tenant and token tables, answering
+// the queries of the registry by their sqlc name
+type fakeDB struct {
+	mutex   sync.Mutex
+	tenants map[string]bool
+	// tokens maps token hashes to their tenants
+	tokens map[string]string
+	ids    map[string]int64
+	nextID int64
+	// afterTokenLookup runs after a token was read, before it is returned
+	afterTokenLookup func()
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+// queryName returns the sqlc name of a query
+func queryName(query string) string {
+	name := strings.TrimPrefix(query, "-- name: ")
+	return name[:strings.IndexByte(name, ' ')]
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	db := c.db
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	switch queryName(query) {
+	case "CreateTenant":
+		db.tenants[args[0].Value.(string)] = true
+	case "CreateTenantToken":
+		db.nextID++
+		hash := args[1].Value.(string)
+		db.tokens[hash] = args[0].Value.(string)
+		db.ids[hash] = db.nextID
+		return fakeResult{id: db.nextID, rows: 1}, nil
+	case "DeleteTenantTokens":
+		for hash, tenant := range db.tokens {
+			if tenant == args[0].Value.(string) {
+				delete(db.tokens, hash)
+			}
+		}
+	case "DeleteTenantToken":
+		for hash, id := range db.ids {
+			if id == args[0].Value.(int64) {
+				delete(db.tokens, hash)
+			}
+		}
+	case "DeleteTenant":
+		name := args[0].Value.(string)
+		if !db.tenants[name] {
+			return fakeResult{}, nil
+		}
+		delete(db.tenants, name)
+		return fakeResult{rows: 1}, nil
+	}
+	return fakeResult{}, nil
+}
+
+type fakeResult struct {
+	id   int64
+	rows int64
+}
+
+func (r fakeResult) LastInsertId() (int64, error) {
+	return r.id, nil
+}
+
+func (r fakeResult) RowsAffected() (int64, error) {
+	return r.rows, nil
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	rows := c.db.query(queryName(query), args)
+	if hook := c.db.afterTokenLookup; hook != nil && queryName(query) == "GetTenantTokenByHash" {
+		c.db.afterTokenLookup = nil
+		hook()
+	}
+	return rows, nil
+}
+
+func (db *fakeDB) query(name string, args []driver.NamedValue) driver.Rows {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+
+	now := time.Now()
+	switch name {
+	case "GetTenantTokenByHash":
+		hash := args[0].Value.(string)
+		if tenant, ok := db.tokens[hash]; ok {
+			return &fakeRows{columns: 5, values: [][]driver.Value{{db.ids[hash], tenant, hash, "", now}}}
+		}
+		return &fakeRows{columns: 5}
+	case "GetTenantToken":
+		for hash, id := range db.ids {
+			if id == args[0].Value.(int64) {
+				if tenant, ok := db.tokens[hash]; ok {
+					return &fakeRows{columns: 5, values: [][]driver.Value{{id, tenant, hash, "", now}}}
+				}
+			}
+		}
+		return &fakeRows{columns: 5}
+	case "GetTenant":
+		if db.tenants[args[0].Value.(string)] {
+			return &fakeRows{columns: 2, values: [][]driver.Value{{args[0].Value, now}}}
+		}
+		return &fakeRows{columns: 2}
+	}
+	// The tenant's events, blobs and other lists are empty
+	return &fakeRows{columns: 1}
+}
+
+type fakeRows struct {
+	columns int
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return make([]string, r.columns)
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+// fakeDBs holds the fakeDB of each test by the data source name it is opened with
+type fakeDBs struct {
+	mutex sync.Mutex
+	dbs   map[string]*fakeDB
+}
+
+func (d *fakeDBs) Open(name string) (driver.Conn, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return &fakeConn{db: d.dbs[name]}, nil
+}
+
+var dbs = &fakeDBs{dbs: make(map[string]*fakeDB)}
+
+func init() {
+	sql.Register("tenancy-fake", dbs)
+}
+
+// newTestRegistry returns a registry with an admin token backed by a fakeDB
+// holding the default tenant and the tenant acme
+func newTestRegistry(t *testing.T) (*Registry, *fakeDB) {
+	db := &fakeDB{
+		tenants: map[string]bool{Default: true, "acme": true},
+		tokens:  make(map[string]string),
+		ids:     make(map[string]int64),
+	}
+	dbs.mutex.Lock()
+	dbs.dbs[t.Name()] = db
+	dbs.mutex.Unlock()
+
+	conn, err := sql.Open("tenancy-fake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return NewRegistry(database.New(conn), "admin-token"), db
+}
+
+func TestRevokedCredentials(t *testing.T) {
+	tests := []struct {
+		name   string
+		revoke func(ctx context.Context, r *Registry, tokenID int64) error
+		// deleted is set when the tenant is gone, not only its token
+		deleted bool
+	}{
+		{
+			name: "tenant deleted",
+			revoke: func(ctx context.Context, r *Registry, tokenID int64) error {
+				_, err := r.Delete(ctx, "acme")
+				return err
+			},
+			deleted: true,
+		},
+		{
+			name: "token revoked",
+			revoke: func(ctx context.Context, r *Registry, tokenID int64) error {
+				_, err := r.DeleteToken(ctx, tokenID)
+				return err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			registry, _ := newTestRegistry(t)
+
+			tokenID, token, err := registry.CreateToken(ctx, "acme", "test")
+			if err != nil {
+				t.Fatalf("CreateToken() failed: %v", err)
+			}
+			// The resolved token and tenant are cached now
+			principal, err := registry.Resolve(ctx, token, "")
+			if err != nil || principal.Tenant != "acme" {
+				t.Fatalf("Resolve() = %+v, %v, want tenant acme", principal, err)
+			}
+			if _, err := registry.Resolve(ctx, "admin-token", "acme"); err != nil {
+				t.Fatalf("Resolve() of the admin token failed: %v", err)
+			}
+
+			if err := tt.revoke(ctx, registry, tokenID); err != nil {
+				t.Fatalf("revoking failed: %v", err)
+			}
+
+			if _, err := registry.Resolve(ctx, token, ""); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("Resolve() of the revoked token = %v, want %v", err, ErrInvalidToken)
+			}
+			_, err = registry.Resolve(ctx, "admin-token", "acme")
+			if tt.deleted && !errors.Is(err, ErrUnknownTenant) {
+				t.Errorf("Resolve() of the deleted tenant = %v, want %v", err, ErrUnknownTenant)
+			}
+			if !tt.deleted && err != nil {
+				t.Errorf("Resolve() of the tenant = %v, want it kept", err)
+			}
+		})
+	}
+}
+
+func TestDeleteDuringTokenLookup(t *testing.T) {
+	ctx := context.Background()
+	registry, db := newTestRegistry(t)
+
+	_, token, err := registry.CreateToken(ctx, "acme", "test")
+	if err != nil {
+		t.Fatalf("CreateToken() failed: %v", err)
+	}
+
+	// The token is read before the tenant is deleted and cached after it
+	db.afterTokenLookup = func() {
+		if _, err := registry.Delete(ctx, "acme"); err != nil {
+			t.Errorf("Delete() failed: %v", err)
+		}
+	}
+	if _, err := registry.Resolve(ctx, token, ""); err != nil {
+		t.Fatalf("Resolve() during Delete() = %v, want the token read before", err)
+	}
+
+	if _, err := registry.Resolve(ctx, token, ""); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Resolve() after Delete() = %v, want %v", err, ErrInvalidToken)
+	}
+}
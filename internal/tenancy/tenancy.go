@@ -0,0 +1,63 @@
+package tenancy
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+)
+
+// Default is the tenant of clients that don't select one and of the events
+// stored before tenants were introduced
+const Default = "default"
+
+// Header selects the tenant of a REST request made with the admin token
+const Header = "X-Tenant"
+
+// MetadataKey selects the tenant of a gRPC call made with the admin token
+const MetadataKey = "x-tenant"
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ValidName reports whether name can be used as a tenant name
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Principal is the authenticated caller of an operation
+type Principal struct {
+	Tenant string
+	// Admin is set for callers using the admin token, who may select any
+	// tenant and manage tenants
+	Admin bool
+	// TokenID is the ID of the tenant token of the caller, 0 for the admin token
+	TokenID int64
+}
+
+// Name identifies the principal in the metadata of the events it creates
+func (p Principal) Name() string {
+	if p.TokenID != 0 {
+		return "token:" + strconv.FormatInt(p.TokenID, 10)
+	}
+	return "admin"
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the principal
+func NewContext(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, principal)
+}
+
+// FromContext returns the principal of the context, or the default tenant if
+// the context carries none
+func FromContext(ctx context.Context) Principal {
+	if principal, ok := ctx.Value(contextKey{}).(Principal); ok {
+		return principal
+	}
+	return Principal{Tenant: Default}
+}
+
+// Tenant returns the tenant the operation of the context is scoped to
+func Tenant(ctx context.Context) string {
+	return FromContext(ctx).Tenant
+}
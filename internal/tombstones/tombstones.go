@@ -0,0 +1,415 @@
+// Package tombstones removes events on request of an administrator, like
+// test data written to production or events covered by a legal request.
+// Removed events become tombstones: they keep their ID, source, type, subject
+// and times so streams keep their positions, but lose their payload and
+// metadata. Blobs no other event references are deleted with them. Every
+// removal is recorded in the append-only event_audit table. With a grace
+// period, the tombstones are deleted for good once it passed.
+package tombstones
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/tracing"
+)
+
+// The actions recorded in the audit trail
+const (
+	ActionEvent   = "tombstone_event"
+	ActionSubject = "tombstone_subject"
+	ActionSubtree = "tombstone_subtree"
+	ActionPurge   = "purge"
+)
+
+// purgeActor is the actor of the purges, which the server runs on its own
+const purgeActor = "eventsdb"
+
+const (
+	// batchSize is the number of events tombstoned or purged per statement
+	batchSize = 1000
+	// batchPause keeps the statements of large removals from piling up locks
+	batchPause = 50 * time.Millisecond
+	// purgeInterval is the time between two runs of the purge
+	purgeInterval = time.Minute
+	// purgePageSize is the number of tombstones purged per query for them
+	purgePageSize = 100
+	// maxReasonLength and maxActorLength are the sizes of their audit columns
+	maxReasonLength = 1024
+	maxActorLength  = 255
+	// blobReferenceAge keeps blobs referenced by events being appended right
+	// now, they are left to the blob collection
+	blobReferenceAge = time.Minute
+)
+
+var (
+	// ErrNoTarget is returned for requests naming neither an event nor a subject
+	ErrNoTarget = errors.New("a tombstone needs an event ID or a subject")
+	// ErrAmbiguousTarget is returned for requests naming an event and a subject
+	ErrAmbiguousTarget = errors.New("a tombstone takes either an event ID or a subject")
+	// ErrInvalidReason is returned for missing or too long reasons
+	ErrInvalidReason = errors.New("a tombstone needs a reason of at most 1024 bytes")
+	// ErrInvalidActor is returned for actors not fitting their column
+	ErrInvalidActor = errors.New("actors are limited to 255 bytes")
+	// ErrNotFound is returned for events that aren't stored, also when they
+	// were written to the archive, as archive segments are never rewritten.
+	// This includes archived events the archive purge didn't delete yet.
+	ErrNotFound = errors.New("event not found or archived, archived events can't be tombstoned")
+	// ErrAlreadyTombstoned is returned for events removed before
+	ErrAlreadyTombstoned = errors.New("event is already tombstoned")
+)
+
+// Request is a removal of events of a tenant and who asked for it
+type Request struct {
+	Tenant string
+	models.TombstoneRequest
+	// Principal and RemoteAddr identify the request that asked for the removal
+	Principal  string
+	RemoteAddr string
+}
+
+// BlobStore deletes the blobs of tombstoned events no other event references
+type BlobStore interface {
+	DeleteUnreferenced(ctx context.Context, tenant string, sha256 string, minAge time.Duration) (bool, error)
+}
+
+// Tombstoner replaces events by tombstones and purges them after the grace period
+type Tombstoner struct {
+	db          *sql.DB
+	queries     *database.Queries
+	gracePeriod time.Duration
+	blobs       BlobStore
+	logger      *slog.Logger
+}
+
+func New(db *sql.DB, queries *database.Queries, gracePeriod time.Duration, logger *slog.Logger) *Tombstoner {
+	return &Tombstoner{
+		db:          db,
+		queries:     queries,
+		gracePeriod: gracePeriod,
+		logger:      logger,
+	}
+}
+
+// SetBlobs makes tombstoning events delete the blobs only they referenced
+func (t *Tombstoner) SetBlobs(blobs BlobStore) {
+	t.blobs = blobs
+}
+
+// Tombstone replaces the events of a request by tombstones, the events
+// stored until then for subjects. The audit entry is written first, so no
+// event is removed without one. Archived events are not affected. Nothing is
+// emitted, streams that delivered the events before keep their payload.
+func (t *Tombstoner) Tombstone(ctx context.Context, req Request) (*models.TombstoneResponse, error) {
+	if err := validate(req); err != nil {
+		return nil, err
+	}
+	if req.Actor == "" {
+		req.Actor = req.Principal
+	}
+
+	action := ActionEvent
+	switch {
+	case req.Subject != "" && req.Recursive:
+		action = ActionSubtree
+	case req.Subject != "":
+		action = ActionSubject
+	}
+
+	// Events stored after this one aren't removed by subject tombstones
+	head, err := t.queries.GetLastEventID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Events up to this one are in archive segments, which keep their payload
+	archived, err := t.queries.GetArchiveWatermark(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if action == ActionEvent {
+		row, err := t.queries.GetEventByID(ctx, database.GetEventByIDParams{Tenant: req.Tenant, ID: req.EventID})
+		found := !errors.Is(err, sql.ErrNoRows)
+		if found && err != nil {
+			return nil, err
+		}
+		if err := checkEvent(req.EventID, found, row.TombstoneID, archived); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := t.queries.CreateAuditEntry(ctx, database.CreateAuditEntryParams{
+		Tenant:     req.Tenant,
+		Action:     action,
+		EventID:    req.EventID,
+		Subject:    req.Subject,
+		Actor:      req.Actor,
+		Principal:  req.Principal,
+		RemoteAddr: req.RemoteAddr,
+		Reason:     req.Reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if action == ActionEvent {
+		count, err = t.tombstone(ctx, req.Tenant, id, []int64{req.EventID})
+	} else {
+		count, err = t.tombstoneSubject(ctx, req.Tenant, id, req.Subject, req.Recursive, archived, head)
+	}
+	metrics.TombstonedEvents.WithLabelValues(req.Tenant).Add(float64(count))
+	if err != nil {
+		return nil, fmt.Errorf("tombstone %d after %d events: %w", id, count, err)
+	}
+	// The event was archived since it was checked
+	if action == ActionEvent && count == 0 {
+		return nil, ErrNotFound
+	}
+
+	row, err := t.queries.GetAuditEntry(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &models.TombstoneResponse{Tombstone: toModel(row), Events: count}, nil
+}
+
+// Audit returns the next page of at most limit audit entries of a tenant
+// after the entry afterID. done is set once no entries are left.
+func (t *Tombstoner) Audit(ctx context.Context, tenant string, afterID int64, limit int32) (entries []models.AuditEntry, done bool, err error) {
+	rows, err := t.queries.ListAuditEntries(ctx, database.ListAuditEntriesParams{
+		Tenant: tenant,
+		ID:     afterID,
+		Limit:  limit,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	entries = make([]models.AuditEntry, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, toModel(row))
+	}
+	return entries, len(rows) < int(limit), nil
+}
+
+// Run purges the tombstones older than the grace period every interval
+// until the context is done
+func (t *Tombstoner) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := t.Purge(ctx); err != nil && ctx.Err() == nil {
+			t.logger.Error("Failed to purge tombstones", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge deletes the events of the tombstones older than the grace period and
+// records a purge entry referencing each of them
+func (t *Tombstoner) Purge(ctx context.Context) error {
+	for {
+		rows, err := t.queries.GetPurgeableTombstones(ctx, database.GetPurgeableTombstonesParams{
+			GraceSeconds: int64(t.gracePeriod.Seconds()),
+			Limit:        purgePageSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			if err := t.purge(ctx, row); err != nil {
+				return fmt.Errorf("purge tombstone %d: %w", row.ID, err)
+			}
+		}
+		if len(rows) < purgePageSize {
+			return nil
+		}
+	}
+}
+
+// purge deletes the events of a tombstone in batches
+func (t *Tombstoner) purge(ctx context.Context, tombstone database.EventAudit) error {
+	total := int64(0)
+	for {
+		count, err := t.queries.PurgeTombstonedEvents(ctx, database.PurgeTombstonedEventsParams{
+			TombstoneID: tombstone.ID,
+			Limit:       batchSize,
+		})
+		total += count
+		metrics.PurgedEvents.WithLabelValues(tombstone.Tenant).Add(float64(count))
+		if err != nil {
+			return err
+		}
+		if count < batchSize {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(batchPause):
+		}
+	}
+
+	_, err := t.queries.CreateAuditEntry(ctx, database.CreateAuditEntryParams{
+		Tenant:      tombstone.Tenant,
+		Action:      ActionPurge,
+		EventID:     tombstone.EventID,
+		Subject:     tombstone.Subject,
+		TombstoneID: tombstone.ID,
+		EventCount:  total,
+		Actor:       purgeActor,
+		Reason:      fmt.Sprintf("grace period of %s passed", t.gracePeriod),
+	})
+	if err != nil {
+		return err
+	}
+	t.logger.Info("Purged tombstoned events", "tenant", tombstone.Tenant, "tombstone", tombstone.ID, "events", total)
+	return nil
+}
+
+// tombstoneSubject tombstones the events of a subject, or of a subject and
+// the subjects below it, after the event archived up to the event until
+func (t *Tombstoner) tombstoneSubject(ctx context.Context, tenant string, id int64, subject string, recursive bool, archived int64, until int64) (int64, error) {
+	total := int64(0)
+	after := int64(0)
+	for {
+		var ids []int64
+		var err error
+		if recursive {
+			ids, err = t.queries.GetTombstoneCandidatesBySubjectPrefix(ctx, database.GetTombstoneCandidatesBySubjectPrefixParams{
+				Tenant:     tenant,
+				Pattern:    server.LikePrefix(subject),
+				AfterID:    after,
+				ArchivedID: archived,
+				UntilID:    until,
+				Limit:      batchSize,
+			})
+		} else {
+			ids, err = t.queries.GetTombstoneCandidatesBySubject(ctx, database.GetTombstoneCandidatesBySubjectParams{
+				Tenant:     tenant,
+				Subject:    subject,
+				AfterID:    after,
+				ArchivedID: archived,
+				UntilID:    until,
+				Limit:      batchSize,
+			})
+		}
+		if err != nil || len(ids) == 0 {
+			return total, err
+		}
+
+		count, err := t.tombstone(ctx, tenant, id, ids)
+		total += count
+		if err != nil || len(ids) < batchSize {
+			return total, err
+		}
+		after = ids[len(ids)-1]
+
+		select {
+		case <-ctx.Done():
+			return total, ctx.Err()
+		case <-time.After(batchPause):
+		}
+	}
+}
+
+// tombstone replaces events by tombstones and removes them from the
+// secondary indexes in one transaction. The blobs of the events are deleted
+// afterwards unless another event still references them.
+func (t *Tombstoner) tombstone(ctx context.Context, tenant string, id int64, ids []int64) (int64, error) {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	queries := database.New(tracing.NewDB(tx))
+	var blobs []string
+	if t.blobs != nil {
+		blobs, err = queries.GetEventBlobs(ctx, database.GetEventBlobsParams{Tenant: tenant, Ids: ids})
+		if err != nil {
+			return 0, err
+		}
+	}
+	count, err := queries.TombstoneEvents(ctx, database.TombstoneEventsParams{
+		TombstoneID: id,
+		Tenant:      tenant,
+		Ids:         ids,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := queries.DeletePayloadIndexEntriesByEvents(ctx, ids); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	for _, sum := range blobs {
+		if _, err := t.blobs.DeleteUnreferenced(ctx, tenant, sum, blobReferenceAge); err != nil {
+			return count, fmt.Errorf("delete blob %s: %w", sum, err)
+		}
+	}
+	return count, nil
+}
+
+// checkEvent reports whether a single event can be tombstoned. Events up to
+// the archive watermark are rejected also while their rows are still stored
+// until the archive purge, segments would serve their payload afterwards.
+func checkEvent(id int64, found bool, tombstoneID int64, archived int64) error {
+	switch {
+	case !found || id <= archived:
+		return ErrNotFound
+	case tombstoneID != 0:
+		return ErrAlreadyTombstoned
+	}
+	return nil
+}
+
+func validate(req Request) error {
+	switch {
+	case req.EventID == 0 && req.Subject == "":
+		return ErrNoTarget
+	case req.EventID != 0 && req.Subject != "":
+		return ErrAmbiguousTarget
+	case strings.TrimSpace(req.Reason) == "" || len(req.Reason) > maxReasonLength:
+		return ErrInvalidReason
+	case len(req.Actor) > maxActorLength:
+		return ErrInvalidActor
+	}
+	return nil
+}
+
+func toModel(row database.EventAudit) models.AuditEntry {
+	return models.AuditEntry{
+		ID:          row.ID,
+		Action:      row.Action,
+		EventID:     row.EventID,
+		Subject:     row.Subject,
+		TombstoneID: row.TombstoneID,
+		Events:      row.EventCount,
+		Actor:       row.Actor,
+		Principal:   row.Principal,
+		RemoteAddr:  row.RemoteAddr,
+		Reason:      row.Reason,
+		CreatedAt:   server.FormatTime(row.CreatedAt),
+	}
+}
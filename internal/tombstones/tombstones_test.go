@@ -0,0 +1,61 @@
+package tombstones
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/idot-digital/events-db/internal/models"
+)
+
+func TestCheckEvent(t *testing.T) {
+	tests := []struct {
+		name        string
+		id          int64
+		found       bool
+		tombstoneID int64
+		archived    int64
+		want        error
+	}{
+		{name: "stored", id: 10, found: true, archived: 5},
+		{name: "nothing archived", id: 1, found: true, archived: 0},
+		{name: "not found", id: 10, found: false, archived: 5, want: ErrNotFound},
+		{name: "archived but not yet purged", id: 5, found: true, archived: 5, want: ErrNotFound},
+		{name: "archived and purged", id: 3, found: false, archived: 5, want: ErrNotFound},
+		{name: "archived tombstone", id: 3, found: true, tombstoneID: 1, archived: 5, want: ErrNotFound},
+		{name: "already tombstoned", id: 10, found: true, tombstoneID: 1, archived: 5, want: ErrAlreadyTombstoned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkEvent(tt.id, tt.found, tt.tombstoneID, tt.archived); !errors.Is(err, tt.want) {
+				t.Errorf("checkEvent() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		req  models.TombstoneRequest
+		want error
+	}{
+		{name: "event", req: models.TombstoneRequest{EventID: 1, Reason: "test data"}},
+		{name: "subject", req: models.TombstoneRequest{Subject: "/orders/42", Recursive: true, Reason: "GDPR request"}},
+		{name: "no target", req: models.TombstoneRequest{Reason: "test data"}, want: ErrNoTarget},
+		{name: "event and subject", req: models.TombstoneRequest{EventID: 1, Subject: "/orders/42", Reason: "test data"}, want: ErrAmbiguousTarget},
+		{name: "missing reason", req: models.TombstoneRequest{EventID: 1}, want: ErrInvalidReason},
+		{name: "blank reason", req: models.TombstoneRequest{EventID: 1, Reason: " \n"}, want: ErrInvalidReason},
+		{name: "long reason", req: models.TombstoneRequest{EventID: 1, Reason: strings.Repeat("x", maxReasonLength+1)}, want: ErrInvalidReason},
+		{name: "long actor", req: models.TombstoneRequest{EventID: 1, Reason: "test data", Actor: strings.Repeat("x", maxActorLength+1)}, want: ErrInvalidActor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validate(Request{Tenant: "acme", TombstoneRequest: tt.req}); !errors.Is(err, tt.want) {
+				t.Errorf("validate() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
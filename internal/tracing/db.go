@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/idot-digital/events-db/database"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DB wraps the connection used by the sqlc queries with a span per query
+type DB struct {
+	db database.DBTX
+}
+
+func NewDB(db database.DBTX) *DB {
+	return &DB{db: db}
+}
+
+// start begins a span named after the sqlc query, taken from its "-- name:" comment
+func (d *DB) start(ctx context.Context, query string) (context.Context, trace.Span) {
+	name := "query"
+	if rest, ok := strings.CutPrefix(query, "-- name: "); ok {
+		name, _, _ = strings.Cut(rest, " ")
+	}
+
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "mysql")),
+	)
+}
+
+func end(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (d *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := d.start(ctx, query)
+	res, err := d.db.ExecContext(ctx, query, args...)
+	end(span, err)
+	return res, err
+}
+
+func (d *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, span := d.start(ctx, query)
+	stmt, err := d.db.PrepareContext(ctx, query)
+	end(span, err)
+	return stmt, err
+}
+
+func (d *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := d.start(ctx, query)
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	end(span, err)
+	return rows, err
+}
+
+func (d *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, span := d.start(ctx, query)
+	row := d.db.QueryRowContext(ctx, query, args...)
+	end(span, row.Err())
+	return row
+}
@@ -0,0 +1,104 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/idot-digital/events-db/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/idot-digital/events-db")
+
+// Setup installs the global tracer provider and W3C trace context propagation.
+// exporter is "otlp" for an OTLP collector configured through the standard
+// OTEL_EXPORTER_OTLP_* variables, "stdout" to print spans, or "none".
+// The returned function flushes and stops the exporter.
+func Setup(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		spanExporter, err = otlptracegrpc.New(ctx)
+	case "stdout", "console":
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "eventsdb")),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Carrier returns the W3C traceparent and tracestate of the span in ctx, as
+// stored with events following the CloudEvents distributed tracing extension
+func Carrier(ctx context.Context) (traceparent string, tracestate string) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier.Get("traceparent"), carrier.Get("tracestate")
+}
+
+// spanContext restores the span context stored with an event
+func spanContext(event *models.Event) trace.SpanContext {
+	carrier := propagation.MapCarrier{
+		"traceparent": event.TraceParent,
+		"tracestate":  event.TraceState,
+	}
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+	return trace.SpanContextFromContext(ctx)
+}
+
+// Deliver wraps sending events to a consumer in a span linked to the traces
+// the events were created in
+func Deliver(ctx context.Context, events []*models.Event, send func([]*models.Event) error) error {
+	links := make([]trace.Link, 0, len(events))
+	for _, event := range events {
+		if sc := spanContext(event); sc.IsValid() {
+			links = append(links, trace.Link{
+				SpanContext: sc,
+				Attributes:  []attribute.KeyValue{attribute.Int64("eventsdb.event.id", event.ID)},
+			})
+		}
+	}
+
+	_, span := tracer.Start(ctx, "deliver events",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(attribute.Int("eventsdb.events.count", len(events))),
+	)
+	defer span.End()
+
+	err := send(events)
+	if err != nil {
+		span.RecordError(err)
+	}
+	return err
+}
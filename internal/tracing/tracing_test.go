@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/idot-digital/events-db/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup(t *testing.T) {
+	tests := []struct {
+		exporter string
+		wantErr  bool
+	}{
+		{exporter: ""},
+		{exporter: "none"},
+		{exporter: "jaeger", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.exporter, func(t *testing.T) {
+			shutdown, err := Setup(context.Background(), tt.exporter)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Setup(%q) succeeded, want error", tt.exporter)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Setup(%q) failed: %v", tt.exporter, err)
+			}
+			if err := shutdown(context.Background()); err != nil {
+				t.Errorf("shutdown failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestCarrierRoundTrip(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	state, _ := trace.ParseTraceState("vendor=value")
+
+	tests := []struct {
+		name        string
+		sc          trace.SpanContext
+		traceparent string
+		tracestate  string
+	}{
+		{
+			name:        "sampled",
+			sc:          trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled}),
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		},
+		{
+			name:        "with trace state",
+			sc:          trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceState: state}),
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			tracestate:  "vendor=value",
+		},
+		{
+			name: "without span",
+			sc:   trace.SpanContext{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := trace.ContextWithRemoteSpanContext(context.Background(), tt.sc)
+			traceparent, tracestate := Carrier(ctx)
+			if traceparent != tt.traceparent || tracestate != tt.tracestate {
+				t.Fatalf("Carrier() = %q, %q, want %q, %q", traceparent, tracestate, tt.traceparent, tt.tracestate)
+			}
+
+			restored := spanContext(&models.Event{TraceParent: traceparent, TraceState: tracestate})
+			if restored.IsValid() != tt.sc.IsValid() {
+				t.Fatalf("restored span context valid = %v, want %v", restored.IsValid(), tt.sc.IsValid())
+			}
+			if !tt.sc.IsValid() {
+				return
+			}
+			if restored.TraceID() != tt.sc.TraceID() || restored.SpanID() != tt.sc.SpanID() {
+				t.Errorf("restored %s/%s, want %s/%s", restored.TraceID(), restored.SpanID(), tt.sc.TraceID(), tt.sc.SpanID())
+			}
+			if restored.TraceFlags() != tt.sc.TraceFlags() {
+				t.Errorf("restored flags %s, want %s", restored.TraceFlags(), tt.sc.TraceFlags())
+			}
+			if restored.TraceState().String() != tt.sc.TraceState().String() {
+				t.Errorf("restored state %q, want %q", restored.TraceState(), tt.sc.TraceState())
+			}
+		})
+	}
+}
+
+func TestSpanContextInvalid(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	tests := []struct {
+		name        string
+		traceparent string
+	}{
+		{name: "empty", traceparent: ""},
+		{name: "malformed", traceparent: "not-a-traceparent"},
+		{name: "zero trace ID", traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if sc := spanContext(&models.Event{TraceParent: tt.traceparent}); sc.IsValid() {
+				t.Errorf("spanContext(%q) is valid, want invalid", tt.traceparent)
+			}
+		})
+	}
+}
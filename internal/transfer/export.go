@@ -0,0 +1,73 @@
+package transfer
+
+import (
+	"context"
+	"time"
+
+	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/server"
+)
+
+// exportFilterID identifies the filter reading the events of an export
+const exportFilterID = "export"
+
+// Range selects the events of a tenant to export
+type Range struct {
+	// Subject restricts the export to a subject, all subjects if empty
+	Subject string
+	// Recursive includes the subjects below Subject
+	Recursive bool
+	// Since and Until restrict the export to events created in [Since, Until),
+	// zero times don't restrict it
+	Since time.Time
+	Until time.Time
+	// After exports the events after this ID, to continue an interrupted export
+	After int64
+}
+
+// Export reads the stored and archived events of a tenant in the range in
+// ID order and passes them to write in batches of at most batchSize events.
+// Payloads are decrypted, so exports hold them in plain text.
+func Export(ctx context.Context, s *server.Server, tenant string, r Range, batchSize int32, write func([]*models.Event) error) error {
+	filter := server.NewAllFilter(tenant, nil, nil, r.After)
+	if r.Subject != "" {
+		filter = server.NewSubjectFilter(tenant, exportFilterID, r.Subject, r.Recursive, nil, r.After)
+	}
+
+	for {
+		events, done, err := s.Read(ctx, filter, batchSize)
+		if err != nil {
+			return err
+		}
+
+		selected := events[:0:0]
+		for _, event := range events {
+			if r.covers(event) {
+				selected = append(selected, event)
+			}
+		}
+		if len(selected) > 0 {
+			if err := write(selected); err != nil {
+				return err
+			}
+			metrics.ExportedEvents.WithLabelValues(tenant).Add(float64(len(selected)))
+		}
+
+		if done {
+			return nil
+		}
+	}
+}
+
+// covers reports whether an event was created in the time range
+func (r Range) covers(event *models.Event) bool {
+	if r.Since.IsZero() && r.Until.IsZero() {
+		return true
+	}
+	created, err := time.Parse(time.RFC3339Nano, event.Time)
+	if err != nil {
+		return false
+	}
+	return (r.Since.IsZero() || !created.Before(r.Since)) && (r.Until.IsZero() || created.Before(r.Until))
+}
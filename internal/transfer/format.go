@@ -0,0 +1,285 @@
+// Package transfer exports events into files and imports them again, to
+// move events between deployments. Files hold CloudEvents in the JSON
+// format, either one per line or as a batch, optionally gzip compressed.
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/idot-digital/events-db/internal/models"
+)
+
+const (
+	// FormatNDJSON writes one CloudEvent per line
+	FormatNDJSON = "ndjson"
+	// FormatCloudEvents writes a CloudEvents JSON batch
+	FormatCloudEvents = "cloudevents"
+)
+
+// specVersion is the CloudEvents version of exported events
+const specVersion = "1.0"
+
+// ValidFormat reports whether events can be exported in a format
+func ValidFormat(format string) bool {
+	return format == FormatNDJSON || format == FormatCloudEvents
+}
+
+// ContentType returns the media type of files in a format
+func ContentType(format string) string {
+	if format == FormatCloudEvents {
+		return "application/cloudevents-batch+json"
+	}
+	return "application/x-ndjson"
+}
+
+// Record is an event in the CloudEvents JSON format. JSON payloads are held
+// in Data, others in DataBase64. The attributes specific to events-db are
+// CloudEvents extensions.
+type Record struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	DataSchema      string          `json:"dataschema,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+	TraceParent     string          `json:"traceparent,omitempty"`
+	TraceState      string          `json:"tracestate,omitempty"`
+	// DataKey is the key the payload is encrypted with in storage
+	DataKey string `json:"datakey,omitempty"`
+	// Redacted is set for events whose data key was erased, without payload
+	Redacted bool `json:"redacted,omitempty"`
+	// BlobSHA256 and BlobSize reference a payload held in the blob store
+	BlobSHA256 string `json:"blobsha256,omitempty"`
+	BlobSize   int64  `json:"blobsize,omitempty"`
+	// CorrelationID and CausationID track the workflow the event belongs to
+	CorrelationID string `json:"correlationid,omitempty"`
+	CausationID   string `json:"causationid,omitempty"`
+	// Metadata holds the producer and server attributes of the event
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// OccurredAt is when the event occurred according to its producer, Time
+	// is when it was stored
+	OccurredAt string `json:"occurredat,omitempty"`
+	// TombstonedAt is set for events an administrator removed, without
+	// payload and metadata
+	TombstonedAt string `json:"tombstonedat,omitempty"`
+}
+
+// FromEvent converts a stored event with an opened payload into a record
+func FromEvent(event *models.Event) Record {
+	record := Record{
+		SpecVersion:     specVersion,
+		ID:              strconv.FormatInt(event.ID, 10),
+		Source:          event.Source,
+		Type:            event.Type,
+		Subject:         event.Subject,
+		Time:            event.Time,
+		DataContentType: event.DataContentType,
+		DataSchema:      event.DataSchema,
+		TraceParent:     event.TraceParent,
+		TraceState:      event.TraceState,
+		DataKey:         event.DataKey,
+		Redacted:        event.Redacted,
+		CorrelationID:   event.CorrelationID,
+		CausationID:     event.CausationID,
+		Metadata:        event.Metadata,
+		OccurredAt:      event.OccurredAt,
+		TombstonedAt:    event.TombstonedAt,
+	}
+	if event.Blob != nil {
+		record.BlobSHA256 = event.Blob.SHA256
+		record.BlobSize = event.Blob.Size
+	}
+
+	if len(event.Data) > 0 {
+		if jsonContent(event.DataContentType) && json.Valid(event.Data) {
+			record.Data = event.Data
+		} else {
+			record.DataBase64 = event.Data
+		}
+	}
+	return record
+}
+
+// Payload returns the payload of a record. Data of content types other than
+// JSON holds a JSON string with the payload.
+func (r Record) Payload() ([]byte, error) {
+	if len(r.Data) > 0 && len(r.DataBase64) > 0 {
+		return nil, errors.New("data and data_base64 are mutually exclusive")
+	}
+	if len(r.Data) == 0 || jsonContent(r.DataContentType) {
+		if len(r.Data) == 0 {
+			return r.DataBase64, nil
+		}
+		return r.Data, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(r.Data, &text); err != nil {
+		return nil, fmt.Errorf("data of content type %s must be a string", r.DataContentType)
+	}
+	return []byte(text), nil
+}
+
+// jsonContent reports whether a content type is JSON, events without one are
+// treated as JSON like CloudEvents do
+func jsonContent(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// Encoder writes records in one of the formats
+type Encoder struct {
+	w       *bufio.Writer
+	format  string
+	written int
+}
+
+func NewEncoder(w io.Writer, format string) *Encoder {
+	return &Encoder{w: bufio.NewWriter(w), format: format}
+}
+
+// Encode writes a record, buffered until Flush or Close
+func (e *Encoder) Encode(record Record) error {
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if e.format == FormatCloudEvents {
+		separator := ",\n"
+		if e.written == 0 {
+			separator = "[\n"
+		}
+		if _, err := e.w.WriteString(separator); err != nil {
+			return err
+		}
+	}
+	e.written++
+
+	if _, err := e.w.Write(encoded); err != nil {
+		return err
+	}
+	if e.format == FormatNDJSON {
+		return e.w.WriteByte('\n')
+	}
+	return nil
+}
+
+// Flush writes the buffered records
+func (e *Encoder) Flush() error {
+	return e.w.Flush()
+}
+
+// Close ends a batch and writes the buffered records
+func (e *Encoder) Close() error {
+	if e.format == FormatCloudEvents {
+		end := "\n]\n"
+		if e.written == 0 {
+			end = "[]\n"
+		}
+		if _, err := e.w.WriteString(end); err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
+
+// Decoder reads records in either format, gzip compressed or not
+type Decoder struct {
+	decoder *json.Decoder
+	// batch is set for CloudEvents batches, which end with a closing bracket
+	batch bool
+	done  bool
+}
+
+// NewDecoder detects the format of r from its first bytes
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	buffered := bufio.NewReader(r)
+	magic, err := buffered.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	var reader io.Reader = buffered
+	if bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		if reader, err = gzip.NewReader(buffered); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &Decoder{decoder: json.NewDecoder(reader)}
+	first, err := firstByte(d.decoder)
+	if errors.Is(err, io.EOF) {
+		d.done = true
+		return d, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if first == '[' {
+		if _, err := d.decoder.Token(); err != nil {
+			return nil, err
+		}
+		d.batch = true
+	}
+	return d, nil
+}
+
+// firstByte returns the first byte of the next JSON value
+func firstByte(decoder *json.Decoder) (byte, error) {
+	// More reads ahead up to the first byte that isn't whitespace
+	if !decoder.More() {
+		return 0, io.EOF
+	}
+	for _, b := range readAll(decoder.Buffered()) {
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b, nil
+	}
+	return 0, io.EOF
+}
+
+// readAll returns the bytes buffered by a decoder
+func readAll(r io.Reader) []byte {
+	buffered, _ := io.ReadAll(r)
+	return buffered
+}
+
+// Next returns the next record, io.EOF after the last one
+func (d *Decoder) Next() (Record, error) {
+	if d.done || !d.decoder.More() {
+		if d.batch && !d.done {
+			if _, err := d.decoder.Token(); err != nil {
+				return Record{}, err
+			}
+		}
+		d.done = true
+		return Record{}, io.EOF
+	}
+
+	var record Record
+	if err := d.decoder.Decode(&record); err != nil {
+		return Record{}, err
+	}
+	return record, nil
+}
@@ -0,0 +1,357 @@
+package transfer
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/idot-digital/events-db/database"
+	"github.com/idot-digital/events-db/internal/limits"
+	"github.com/idot-digital/events-db/internal/metrics"
+	"github.com/idot-digital/events-db/internal/models"
+	"github.com/idot-digital/events-db/internal/schemas"
+	"github.com/idot-digital/events-db/internal/server"
+	"github.com/idot-digital/events-db/internal/tracing"
+)
+
+const (
+	// ModeRemap stores imported events under new IDs
+	ModeRemap = "remap"
+	// ModePreserve stores imported events under their IDs in the file
+	ModePreserve = "preserve"
+)
+
+// progressInterval is how often a running import reports its progress
+const progressInterval = time.Second
+
+// mysqlDuplicateEntry is the MySQL error number of primary key violations
+const mysqlDuplicateEntry = 1062
+
+// ValidMode reports whether events can be imported in a mode
+func ValidMode(mode string) bool {
+	return mode == ModeRemap || mode == ModePreserve
+}
+
+// InvalidRecordError is returned for import files that can't be imported as
+// they are
+type InvalidRecordError struct {
+	// ID is the ID of the event in the file, empty if the file isn't readable
+	ID     string
+	Reason string
+}
+
+func (e *InvalidRecordError) Error() string {
+	if e.ID == "" {
+		return "invalid import file: " + e.Reason
+	}
+	return fmt.Sprintf("event %q: %s", e.ID, e.Reason)
+}
+
+// Importer stores the events of import files. Every import has an ID, and
+// the events of the file are recorded under it once stored, so repeating an
+// interrupted import skips the events stored before.
+type Importer struct {
+	server  *server.Server
+	db      *sql.DB
+	limiter *limits.Limiter
+	logger  *slog.Logger
+}
+
+func NewImporter(s *server.Server, db *sql.DB, limiter *limits.Limiter, logger *slog.Logger) *Importer {
+	return &Importer{
+		server:  s,
+		db:      db,
+		limiter: limiter,
+		logger:  logger,
+	}
+}
+
+// Import stores the events read by decoder for the tenant of client, waiting
+// for the rate limits of the client and checking its storage quotas. Payloads
+// are encrypted and compressed like new events, but not validated against
+// schemas as they were when they were first created. Redacted and
+// tombstoned events are skipped. report is called with the progress every
+// progressInterval.
+func (i *Importer) Import(ctx context.Context, client limits.Client, importID string, mode string, decoder *Decoder, report func(models.ImportProgress)) (models.ImportProgress, error) {
+	progress := models.ImportProgress{ImportID: importID, Mode: mode}
+	reported := time.Now()
+
+	for {
+		record, err := decoder.Next()
+		if errors.Is(err, io.EOF) {
+			progress.Done = true
+			return progress, nil
+		}
+		if err != nil {
+			return progress, &InvalidRecordError{Reason: err.Error()}
+		}
+		progress.Read++
+		progress.LastID = record.ID
+
+		imported, err := i.importRecord(ctx, client, importID, mode, record)
+		if err != nil {
+			return progress, err
+		}
+		if imported {
+			progress.Imported++
+		} else {
+			progress.Skipped++
+		}
+
+		if time.Since(reported) >= progressInterval {
+			report(progress)
+			reported = time.Now()
+		}
+	}
+}
+
+// importRecord stores an event of an import file. It returns false if the
+// event was skipped.
+func (i *Importer) importRecord(ctx context.Context, client limits.Client, importID string, mode string, record Record) (bool, error) {
+	tenant := client.Tenant
+	if err := validate(record); err != nil {
+		return false, err
+	}
+
+	_, err := i.server.GetQueries().GetImportedEvent(ctx, database.GetImportedEventParams{
+		Tenant:        tenant,
+		ImportID:      importID,
+		SourceEventID: record.ID,
+	})
+	if err == nil || record.Redacted || record.TombstonedAt != "" {
+		return false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	var id int64
+	if mode == ModePreserve {
+		if id, err = i.preservedID(ctx, record.ID); err != nil {
+			return false, err
+		}
+	}
+
+	created := server.Now()
+	if record.Time != "" {
+		if created, err = time.Parse(time.RFC3339Nano, record.Time); err != nil {
+			return false, &InvalidRecordError{ID: record.ID, Reason: "time is not an RFC 3339 timestamp"}
+		}
+		created = created.UTC().Truncate(server.TimePrecision)
+	}
+	occurredAt, err := server.ParseOccurredAt(record.OccurredAt)
+	if err != nil {
+		return false, &InvalidRecordError{ID: record.ID, Reason: "occurredat is not an RFC 3339 timestamp"}
+	}
+
+	data, err := record.Payload()
+	if err != nil {
+		return false, &InvalidRecordError{ID: record.ID, Reason: err.Error()}
+	}
+	metadata, err := server.EncodeMetadata(record.Metadata)
+	if err != nil {
+		return false, &InvalidRecordError{ID: record.ID, Reason: err.Error()}
+	}
+	if err := server.ValidateTrackingIDs(record.CorrelationID, record.CausationID); err != nil {
+		return false, &InvalidRecordError{ID: record.ID, Reason: err.Error()}
+	}
+	if err := i.allowWrite(ctx, client, record.Subject); err != nil {
+		return false, err
+	}
+	payload, err := i.server.SealData(ctx, tenant, record.Subject, record.DataKey, record.BlobSHA256, data)
+	if err != nil {
+		return false, err
+	}
+	if err := i.limiter.AllowStorage(ctx, tenant, record.Subject, payload.Size()); err != nil {
+		return false, err
+	}
+
+	event := &models.Event{
+		ID:              id,
+		Tenant:          tenant,
+		Source:          record.Source,
+		Type:            record.Type,
+		Subject:         record.Subject,
+		Time:            server.FormatTime(created),
+		OccurredAt:      server.FormatOccurredAt(occurredAt),
+		Data:            data,
+		TraceParent:     record.TraceParent,
+		TraceState:      record.TraceState,
+		DataKey:         payload.DataKey,
+		Blob:            payload.Blob,
+		DataContentType: record.DataContentType,
+		SchemaVersion:   schemas.VersionFromURI(record.DataSchema),
+		Metadata:        metadata.Metadata,
+		CorrelationID:   record.CorrelationID,
+		CausationID:     record.CausationID,
+	}
+	event.DataSchema = schemas.URI(event.Type, event.SchemaVersion)
+	if payload.Blob != nil {
+		event.Data = nil
+	}
+
+	if event.ID, err = i.store(ctx, importID, record.ID, event, payload, metadata.Encoded, created, occurredAt); err != nil {
+		i.limiter.ReleaseStorage(tenant, record.Subject, payload.Size())
+		return false, err
+	}
+	i.limiter.RecordWrite(tenant, record.Subject, payload.Size())
+	metrics.ImportedEvents.WithLabelValues(tenant, mode).Inc()
+
+	i.server.IndexEvent(ctx, event)
+	i.server.GetEmitterChan() <- event
+	return true, nil
+}
+
+// store inserts an imported event and records it as imported in one
+// transaction. Events with an ID are stored under it, the others under a new
+// one, which is returned.
+func (i *Importer) store(ctx context.Context, importID string, sourceID string, event *models.Event, payload server.StoredPayload, metadata json.RawMessage, created time.Time, occurredAt sql.NullTime) (int64, error) {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	queries := database.New(tracing.NewDB(tx))
+	id := event.ID
+	if id == 0 {
+		id, err = queries.ImportEvent(ctx, database.ImportEventParams{
+			Tenant:          event.Tenant,
+			Source:          event.Source,
+			Type:            event.Type,
+			Subject:         event.Subject,
+			Time:            created,
+			OccurredAt:      occurredAt,
+			Data:            payload.Data,
+			Traceparent:     event.TraceParent,
+			Tracestate:      event.TraceState,
+			DataKey:         payload.DataKey,
+			DataCodec:       payload.Codec,
+			BlobSha256:      blobSHA256(payload.Blob),
+			BlobSize:        blobSize(payload.Blob),
+			Datacontenttype: event.DataContentType,
+			SchemaVersion:   event.SchemaVersion,
+			Metadata:        metadata,
+			CorrelationID:   event.CorrelationID,
+			CausationID:     event.CausationID,
+		})
+	} else {
+		err = queries.ImportEventWithID(ctx, database.ImportEventWithIDParams{
+			ID:              id,
+			Tenant:          event.Tenant,
+			Source:          event.Source,
+			Type:            event.Type,
+			Subject:         event.Subject,
+			Time:            created,
+			OccurredAt:      occurredAt,
+			Data:            payload.Data,
+			Traceparent:     event.TraceParent,
+			Tracestate:      event.TraceState,
+			DataKey:         payload.DataKey,
+			DataCodec:       payload.Codec,
+			BlobSha256:      blobSHA256(payload.Blob),
+			BlobSize:        blobSize(payload.Blob),
+			Datacontenttype: event.DataContentType,
+			SchemaVersion:   event.SchemaVersion,
+			Metadata:        metadata,
+			CorrelationID:   event.CorrelationID,
+			CausationID:     event.CausationID,
+		})
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mysqlDuplicateEntry {
+			return 0, &InvalidRecordError{ID: sourceID, Reason: "id is taken by another event, import with mode remap instead"}
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	err = queries.CreateImportedEvent(ctx, database.CreateImportedEventParams{
+		Tenant:        event.Tenant,
+		ImportID:      importID,
+		SourceEventID: sourceID,
+		EventID:       id,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// preservedID returns the ID of an event of the file to store it under. Only
+// IDs above every stored and archived event are accepted: lower ones would
+// be skipped by live streams, which deliver events above their position, and
+// hidden by reads, which take events up to the watermark from the archive.
+func (i *Importer) preservedID(ctx context.Context, sourceID string) (int64, error) {
+	id, err := strconv.ParseInt(sourceID, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, &InvalidRecordError{ID: sourceID, Reason: "id must be a positive integer to preserve it"}
+	}
+
+	queries := i.server.GetQueries()
+	last, err := queries.GetLastEventID(ctx)
+	if err != nil {
+		return 0, err
+	}
+	watermark, err := queries.GetArchiveWatermark(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if floor := max(last, watermark); id <= floor {
+		return 0, &InvalidRecordError{ID: sourceID, Reason: fmt.Sprintf("id must be above the last stored or archived event %d to preserve it, import with mode remap instead", floor)}
+	}
+	return id, nil
+}
+
+// allowWrite waits until the rate limits allow a write
+func (i *Importer) allowWrite(ctx context.Context, client limits.Client, subject string) error {
+	for {
+		err := i.limiter.AllowWrite(client, subject)
+		var rateLimited *limits.RateLimitedError
+		if !errors.As(err, &rateLimited) {
+			return err
+		}
+
+		timer := time.NewTimer(rateLimited.RetryAfter)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// validate checks the attributes every imported event needs
+func validate(record Record) error {
+	switch {
+	case record.ID == "":
+		return &InvalidRecordError{Reason: "event without id"}
+	case record.SpecVersion != "" && record.SpecVersion != specVersion:
+		return &InvalidRecordError{ID: record.ID, Reason: fmt.Sprintf("unsupported specversion %q", record.SpecVersion)}
+	case record.Source == "" || record.Type == "" || record.Subject == "":
+		return &InvalidRecordError{ID: record.ID, Reason: "source, type and subject are required"}
+	}
+	return nil
+}
+
+func blobSHA256(blob *models.Blob) string {
+	if blob == nil {
+		return ""
+	}
+	return blob.SHA256
+}
+
+func blobSize(blob *models.Blob) int64 {
+	if blob == nil {
+		return 0
+	}
+	return blob.Size
+}
@@ -1,8 +1,8 @@
 -- name: CreateEvent :execlastid
 INSERT INTO
-  events (`source`, `type`, `subject`, `data`)
+  events (`tenant`, `source`, `type`, `subject`, `time`, `occurred_at`, `data`, `traceparent`, `tracestate`, `data_key`, `data_codec`, `blob_sha256`, `blob_size`, `datacontenttype`, `schema_version`, `metadata`, `correlation_id`, `causation_id`)
 VALUES
-  (?, ?, ?, ?);
+  (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
 
 -- name: GetEventByID :one
 SELECT
@@ -10,7 +10,8 @@ SELECT
 FROM
   events
 WHERE
-  id = ?
+  `tenant` = ?
+  AND `id` = ?
 LIMIT 1;
 
 -- name: GetEventsBySubject :many
@@ -19,8 +20,11 @@ SELECT
 FROM
   events
 WHERE
-  `id` > ?
+  `tenant` = ?
+  AND `id` > ?
   AND `subject` = ?
+ORDER BY
+  `id`
 LIMIT
   ?;
 
@@ -30,9 +34,13 @@ SELECT
 FROM
   events
 WHERE
-  `id` > ?
-  AND MATCH(`subject`) AGAINST (? IN BOOLEAN MODE)
-LIMIT 50;
+  `tenant` = sqlc.arg(tenant)
+  AND `id` > sqlc.arg(id)
+  AND `subject` LIKE sqlc.arg(pattern)
+ORDER BY
+  `id`
+LIMIT
+  ?;
 
 -- name: GetEventsBySubjectAndType :many
 SELECT
@@ -40,7 +48,8 @@ SELECT
 FROM
   events
 WHERE
-  `id` > ?
+  `tenant` = ?
+  AND `id` > ?
   AND `subject` = ?
   AND `type` = ?
 LIMIT 50;
@@ -51,14 +60,928 @@ SELECT
 FROM
   events
 WHERE
-  `id` > ?
-  AND MATCH(`subject`) AGAINST ($2 IN BOOLEAN MODE)
-  AND `type` = $3
+  `tenant` = sqlc.arg(tenant)
+  AND `id` > sqlc.arg(id)
+  AND `subject` LIKE sqlc.arg(pattern)
+  AND `type` = sqlc.arg(type)
 LIMIT 50;
 
+-- name: GetEventsByCorrelationID :many
+SELECT
+  *
+FROM
+  events
+WHERE
+  `tenant` = ?
+  AND `correlation_id` = ?
+  AND `id` > ?
+ORDER BY
+  `id`
+LIMIT
+  ?;
 
 -- name: GetAvailableSubjects :many
 SELECT
-  DISTINCT `subject`
+  `subject`
+FROM
+  events
+WHERE
+  events.`tenant` = sqlc.arg(tenant)
+UNION
+SELECT
+  `subject`
+FROM
+  archive_segment_subjects
+WHERE
+  archive_segment_subjects.`tenant` = sqlc.arg(tenant);
+
+-- name: GetLastEventID :one
+SELECT
+  CAST(COALESCE(MAX(`id`), 0) AS SIGNED) AS `id`
+FROM
+  events;
+
+-- name: GetEvents :many
+SELECT
+  *
+FROM
+  events
+WHERE
+  `tenant` = ?
+  AND `id` > ?
+ORDER BY
+  `id`
+LIMIT
+  ?;
+
+-- name: GetSubjectPrefixUsage :one
+SELECT
+  CAST(
+    (
+      SELECT
+        COUNT(*)
+      FROM
+        events e
+      WHERE
+        e.`tenant` = sqlc.arg(tenant)
+        AND e.`subject` LIKE sqlc.arg(pattern)
+        AND e.`id` > (
+          SELECT
+            COALESCE(MAX(s.`last_id`), 0)
+          FROM
+            archive_segments s
+        )
+    ) + (
+      SELECT
+        COALESCE(SUM(x.`event_count`), 0)
+      FROM
+        archive_segment_subjects x
+      WHERE
+        x.`tenant` = sqlc.arg(tenant)
+        AND x.`subject` LIKE sqlc.arg(pattern)
+    ) AS SIGNED
+  ) AS `events`,
+  CAST(
+    (
+      SELECT
+        COALESCE(SUM(LENGTH(e.`data`) + e.`blob_size`), 0)
+      FROM
+        events e
+      WHERE
+        e.`tenant` = sqlc.arg(tenant)
+        AND e.`subject` LIKE sqlc.arg(pattern)
+        AND e.`id` > (
+          SELECT
+            COALESCE(MAX(s.`last_id`), 0)
+          FROM
+            archive_segments s
+        )
+    ) + (
+      SELECT
+        COALESCE(SUM(x.`size_bytes`), 0)
+      FROM
+        archive_segment_subjects x
+      WHERE
+        x.`tenant` = sqlc.arg(tenant)
+        AND x.`subject` LIKE sqlc.arg(pattern)
+    ) AS SIGNED
+  ) AS `bytes`;
+
+-- name: GetTenantEventIDs :many
+SELECT
+  `id`
+FROM
+  events
+WHERE
+  `tenant` = ?
+ORDER BY
+  `id`
+LIMIT
+  ?;
+
+-- name: DeleteEventsByIDs :execrows
+DELETE FROM
+  events
+WHERE
+  `id` IN (sqlc.slice(ids));
+
+-- name: CreateTenant :exec
+INSERT INTO
+  tenants (`name`)
+VALUES
+  (?);
+
+-- name: GetTenant :one
+SELECT
+  *
+FROM
+  tenants
+WHERE
+  `name` = ?
+LIMIT 1;
+
+-- name: ListTenants :many
+SELECT
+  *
+FROM
+  tenants
+ORDER BY
+  `name`;
+
+-- name: DeleteTenant :execrows
+DELETE FROM
+  tenants
+WHERE
+  `name` = ?;
+
+-- name: CreateTenantToken :execlastid
+INSERT INTO
+  tenant_tokens (`tenant`, `token_hash`, `description`)
+VALUES
+  (?, ?, ?);
+
+-- name: GetTenantTokenByHash :one
+SELECT
+  *
+FROM
+  tenant_tokens
+WHERE
+  `token_hash` = ?
+LIMIT 1;
+
+-- name: ListTenantTokens :many
+SELECT
+  `id`,
+  `tenant`,
+  `description`,
+  `created_at`
+FROM
+  tenant_tokens
+WHERE
+  `tenant` = ?
+ORDER BY
+  `id`;
+
+-- name: GetTenantToken :one
+SELECT
+  *
+FROM
+  tenant_tokens
+WHERE
+  `id` = ?
+LIMIT 1;
+
+-- name: DeleteTenantToken :execrows
+DELETE FROM
+  tenant_tokens
+WHERE
+  `id` = ?;
+
+-- name: DeleteTenantTokens :exec
+DELETE FROM
+  tenant_tokens
+WHERE
+  `tenant` = ?;
+
+-- name: GetRetentionSubjects :many
+SELECT DISTINCT
+  `tenant`,
+  `subject`
+FROM
+  events
+WHERE
+  `tenant` LIKE sqlc.arg(tenant_pattern)
+  AND `subject` LIKE sqlc.arg(subject_pattern);
+
+-- name: GetLatestEventIDBySubjectAndType :one
+SELECT
+  CAST(COALESCE(MAX(`id`), 0) AS SIGNED) AS `id`
+FROM
+  events
+WHERE
+  `tenant` = ?
+  AND `subject` = ?
+  AND `type` = ?;
+
+-- name: GetEventIDBySubjectOffset :one
+SELECT
+  `id`
+FROM
+  events
+WHERE
+  `tenant` = sqlc.arg(tenant)
+  AND `subject` = sqlc.arg(subject)
+  AND `type` LIKE sqlc.arg(type_pattern)
+ORDER BY
+  `id` DESC
+LIMIT
+  1 OFFSET ?;
+
+-- name: GetFirstEventIDSince :one
+SELECT
+  CAST(COALESCE(MIN(`id`), 0) AS SIGNED) AS `id`
+FROM
+  events
+WHERE
+  `time` >= ?;
+
+-- name: CountRetainedEvents :one
+SELECT
+  COUNT(*)
+FROM
+  events
+WHERE
+  `tenant` LIKE sqlc.arg(tenant_pattern)
+  AND `subject` LIKE sqlc.arg(subject_pattern)
+  AND `type` LIKE sqlc.arg(type_pattern)
+  AND `id` < sqlc.arg(before_id);
+
+-- name: GetRetainedEventIDs :many
+SELECT
+  `id`
+FROM
+  events
+WHERE
+  `tenant` LIKE sqlc.arg(tenant_pattern)
+  AND `subject` LIKE sqlc.arg(subject_pattern)
+  AND `type` LIKE sqlc.arg(type_pattern)
+  AND `id` < sqlc.arg(before_id)
+ORDER BY
+  `id`
+LIMIT
+  ?;
+
+-- name: GetEventsForArchive :many
+SELECT
+  *
+FROM
+  events
+WHERE
+  `id` > sqlc.arg(after_id)
+  AND `id` < sqlc.arg(before_id)
+ORDER BY
+  `id`
+LIMIT
+  ?;
+
+-- name: CountTombstonedEventsForArchive :one
+SELECT
+  COUNT(*)
+FROM
+  events
+WHERE
+  `id` BETWEEN sqlc.arg(first_id) AND sqlc.arg(last_id)
+  AND `tombstone_id` <> 0
+FOR SHARE;
+
+-- name: CreateArchiveSegment :execlastid
+INSERT INTO
+  archive_segments (`segment_key`, `first_id`, `last_id`, `event_count`, `size_bytes`, `blobs_recorded`)
+VALUES
+  (?, ?, ?, ?, ?, TRUE);
+
+-- name: CreateArchiveSegmentSubject :exec
+INSERT INTO
+  archive_segment_subjects (`segment_id`, `tenant`, `subject`, `event_count`, `size_bytes`)
+SELECT
+  sqlc.arg(segment_id),
+  `name`,
+  sqlc.arg(subject),
+  sqlc.arg(event_count),
+  sqlc.arg(size_bytes)
+FROM
+  tenants
+WHERE
+  `name` = sqlc.arg(tenant);
+
+-- name: CreateArchiveSegmentBlob :exec
+INSERT IGNORE INTO
+  archive_segment_blobs (`segment_id`, `tenant`, `sha256`)
+VALUES
+  (?, ?, ?);
+
+-- name: GetArchiveWatermark :one
+SELECT
+  CAST(COALESCE(MAX(`last_id`), 0) AS SIGNED) AS `id`
+FROM
+  archive_segments;
+
+-- name: GetArchivedBefore :one
+SELECT
+  CAST(COALESCE(MAX(`last_id`), 0) AS SIGNED) AS `id`
+FROM
+  archive_segments
+WHERE
+  `created_at` < ?;
+
+-- name: DeleteArchivedEvents :execrows
+DELETE FROM
+  events
+WHERE
+  `id` <= ?
+ORDER BY
+  `id`
+LIMIT
+  ?;
+
+-- name: DeleteArchivedPayloadIndexEntries :execrows
+DELETE FROM
+  payload_index_entries
+WHERE
+  `event_id` <= ?
+LIMIT
+  ?;
+
+-- name: GetArchiveSegmentsBySubject :many
+SELECT DISTINCT
+  s.`id`,
+  s.`segment_key`,
+  s.`first_id`,
+  s.`last_id`
+FROM
+  archive_segments s
+  JOIN archive_segment_subjects x ON x.`segment_id` = s.`id`
+WHERE
+  x.`tenant` = sqlc.arg(tenant)
+  AND x.`subject` LIKE sqlc.arg(pattern)
+  AND s.`last_id` > sqlc.arg(after_id)
+  AND s.`first_id` <= sqlc.arg(until_id)
+ORDER BY
+  s.`first_id`
+LIMIT
+  ?;
+
+-- name: HasArchiveTenantSegment :one
+SELECT
+  EXISTS (
+    SELECT
+      1
+    FROM
+      archive_segment_subjects
+    WHERE
+      `tenant` = ?
+      AND `segment_id` = ?
+  ) AS `found`;
+
+-- name: GetArchiveSegmentByEventID :one
+SELECT
+  *
+FROM
+  archive_segments
+WHERE
+  `first_id` <= sqlc.arg(id)
+  AND `last_id` >= sqlc.arg(id)
+LIMIT 1;
+
+-- name: DeleteArchiveTenant :exec
+DELETE FROM
+  archive_segment_subjects
+WHERE
+  `tenant` = ?;
+
+-- name: DeleteArchiveTenantBlobs :exec
+DELETE FROM
+  archive_segment_blobs
+WHERE
+  `tenant` = ?;
+
+-- name: CreateDataKey :exec
+INSERT IGNORE INTO
+  data_keys (`tenant`, `name`, `wrapped_key`, `master_key_id`)
+VALUES
+  (?, ?, ?, ?);
+
+-- name: GetDataKey :one
+SELECT
+  *
+FROM
+  data_keys
+WHERE
+  `tenant` = ?
+  AND `name` = ?
+LIMIT 1;
+
+-- name: EraseDataKey :execrows
+UPDATE
+  data_keys
+SET
+  `wrapped_key` = '',
+  `erased_at` = CURRENT_TIMESTAMP
+WHERE
+  `tenant` = ?
+  AND `name` = ?
+  AND `erased_at` IS NULL;
+
+-- name: DeleteTenantDataKeys :exec
+DELETE FROM
+  data_keys
+WHERE
+  `tenant` = ?;
+
+-- name: CreateBlob :exec
+INSERT INTO
+  blobs (`tenant`, `sha256`, `size`)
+VALUES
+  (?, ?, ?)
+ON DUPLICATE KEY UPDATE
+  `uploaded_at` = CURRENT_TIMESTAMP;
+
+-- name: GetBlob :one
+SELECT
+  *
+FROM
+  blobs
+WHERE
+  `tenant` = ?
+  AND `sha256` = ?
+LIMIT 1;
+
+-- name: TouchBlob :exec
+UPDATE
+  blobs
+SET
+  `uploaded_at` = CURRENT_TIMESTAMP
+WHERE
+  `tenant` = ?
+  AND `sha256` = ?;
+
+-- name: GetCollectableBlobs :many
+SELECT
+  `tenant`,
+  `sha256`
+FROM
+  blobs
+WHERE
+  (
+    `tenant` > sqlc.arg(after_tenant)
+    OR (
+      `tenant` = sqlc.arg(after_tenant)
+      AND `sha256` > sqlc.arg(after_sha256)
+    )
+  )
+  AND `uploaded_at` <= NOW() - INTERVAL sqlc.arg(age_seconds) SECOND
+ORDER BY
+  `tenant`,
+  `sha256`
+LIMIT
+  ?;
+
+-- name: DeleteUnreferencedBlob :execrows
+DELETE b FROM
+  blobs b
+WHERE
+  b.`tenant` = sqlc.arg(tenant)
+  AND b.`sha256` = sqlc.arg(sha256)
+  AND b.`uploaded_at` <= NOW() - INTERVAL sqlc.arg(age_seconds) SECOND
+  AND b.`created_at` >= (
+    SELECT
+      COALESCE(MAX(s.`created_at`), CAST('1000-01-01' AS DATETIME))
+    FROM
+      archive_segments s
+    WHERE
+      s.`blobs_recorded` = FALSE
+  )
+  AND NOT EXISTS (
+    SELECT
+      1
+    FROM
+      events e
+    WHERE
+      e.`tenant` = sqlc.arg(tenant)
+      AND e.`blob_sha256` = sqlc.arg(sha256)
+  )
+  AND NOT EXISTS (
+    SELECT
+      1
+    FROM
+      archive_segment_blobs a
+    WHERE
+      a.`tenant` = sqlc.arg(tenant)
+      AND a.`sha256` = sqlc.arg(sha256)
+  );
+
+-- name: GetTenantBlobs :many
+SELECT
+  `sha256`
+FROM
+  blobs
+WHERE
+  `tenant` = ?
+  AND `sha256` > ?
+ORDER BY
+  `sha256`
+LIMIT
+  ?;
+
+-- name: DeleteTenantBlob :exec
+DELETE FROM
+  blobs
+WHERE
+  `tenant` = ?
+  AND `sha256` = ?;
+
+-- name: DeleteTenantBlobs :exec
+DELETE FROM
+  blobs
+WHERE
+  `tenant` = ?;
+
+-- name: CreateSchema :exec
+INSERT INTO
+  event_schemas (`tenant`, `type`, `version`, `format`, `message`, `definition`)
+VALUES
+  (?, ?, ?, ?, ?, ?);
+
+-- name: GetSchema :one
+SELECT
+  *
+FROM
+  event_schemas
+WHERE
+  `tenant` = ?
+  AND `type` = ?
+  AND `version` = ?
+LIMIT 1;
+
+-- name: GetLatestSchema :one
+SELECT
+  *
+FROM
+  event_schemas
+WHERE
+  `tenant` = ?
+  AND `type` = ?
+ORDER BY
+  `version` DESC
+LIMIT 1;
+
+-- name: ListSchemas :many
+SELECT
+  *
+FROM
+  event_schemas
+WHERE
+  `tenant` = ?
+  AND `type` = ?
+ORDER BY
+  `version` ASC;
+
+-- name: DeleteTenantSchemas :exec
+DELETE FROM
+  event_schemas
+WHERE
+  `tenant` = ?;
+
+-- name: PutUpcaster :exec
+INSERT INTO
+  event_upcasters (`tenant`, `type`, `from_version`, `operations`)
+VALUES
+  (?, ?, ?, ?) ON DUPLICATE KEY
+UPDATE
+  `operations` = VALUES(`operations`);
+
+-- name: ListUpcasters :many
+SELECT
+  *
+FROM
+  event_upcasters
+WHERE
+  `tenant` = ?
+  AND `type` = ?
+ORDER BY
+  `from_version`;
+
+-- name: DeleteUpcaster :execrows
+DELETE FROM
+  event_upcasters
+WHERE
+  `tenant` = ?
+  AND `type` = ?
+  AND `from_version` = ?;
+
+-- name: DeleteTenantUpcasters :exec
+DELETE FROM
+  event_upcasters
+WHERE
+  `tenant` = ?;
+
+-- name: ImportEvent :execlastid
+INSERT INTO
+  events (`tenant`, `source`, `type`, `subject`, `time`, `occurred_at`, `data`, `traceparent`, `tracestate`, `data_key`, `data_codec`, `blob_sha256`, `blob_size`, `datacontenttype`, `schema_version`, `metadata`, `correlation_id`, `causation_id`)
+VALUES
+  (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: ImportEventWithID :exec
+INSERT INTO
+  events (`id`, `tenant`, `source`, `type`, `subject`, `time`, `occurred_at`, `data`, `traceparent`, `tracestate`, `data_key`, `data_codec`, `blob_sha256`, `blob_size`, `datacontenttype`, `schema_version`, `metadata`, `correlation_id`, `causation_id`)
+VALUES
+  (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: CreateImportedEvent :exec
+INSERT INTO
+  imported_events (`tenant`, `import_id`, `source_event_id`, `event_id`)
+VALUES
+  (?, ?, ?, ?);
+
+-- name: GetImportedEvent :one
+SELECT
+  `event_id`
 FROM
-  events;
\ No newline at end of file
+  imported_events
+WHERE
+  `tenant` = ?
+  AND `import_id` = ?
+  AND `source_event_id` = ?;
+
+-- name: DeleteTenantImports :exec
+DELETE FROM
+  imported_events
+WHERE
+  `tenant` = ?;
+
+-- name: CreatePayloadIndex :execlastid
+INSERT INTO
+  payload_indexes (`tenant`, `name`, `type`, `path`)
+VALUES
+  (?, ?, ?, ?);
+
+-- name: GetPayloadIndex :one
+SELECT
+  *
+FROM
+  payload_indexes
+WHERE
+  `tenant` = ?
+  AND `name` = ?;
+
+-- name: ListPayloadIndexes :many
+SELECT
+  *
+FROM
+  payload_indexes
+WHERE
+  `tenant` = ?
+ORDER BY
+  `name`;
+
+-- name: ListPayloadIndexesByType :many
+SELECT
+  *
+FROM
+  payload_indexes
+WHERE
+  `tenant` = ?
+  AND `type` = ?;
+
+-- name: ListPendingPayloadIndexes :many
+SELECT
+  *
+FROM
+  payload_indexes
+WHERE
+  `ready` = FALSE
+ORDER BY
+  `id`;
+
+-- name: AdvancePayloadIndex :execrows
+UPDATE
+  payload_indexes
+SET
+  `position` = sqlc.arg(position)
+WHERE
+  `id` = sqlc.arg(id)
+  AND `position` = sqlc.arg(previous_position);
+
+-- name: MarkPayloadIndexReady :execrows
+UPDATE
+  payload_indexes
+SET
+  `ready` = TRUE
+WHERE
+  `id` = sqlc.arg(id)
+  AND `position` >= sqlc.arg(head)
+  AND `created_at` <= NOW() - INTERVAL sqlc.arg(settle_seconds) SECOND;
+
+-- name: RewindPayloadIndex :exec
+UPDATE
+  payload_indexes
+SET
+  `ready` = FALSE,
+  `position` = LEAST(`position`, sqlc.arg(position))
+WHERE
+  `id` = sqlc.arg(id);
+
+-- name: DeletePayloadIndex :exec
+DELETE FROM
+  payload_indexes
+WHERE
+  `id` = ?;
+
+-- name: DeleteTenantPayloadIndexes :exec
+DELETE FROM
+  payload_indexes
+WHERE
+  `tenant` = ?;
+
+-- name: CreatePayloadIndexEntry :exec
+INSERT IGNORE INTO
+  payload_index_entries (`index_id`, `value`, `event_id`, `tenant`)
+VALUES
+  (?, ?, ?, ?);
+
+-- name: DeletePayloadIndexEntries :execrows
+DELETE FROM
+  payload_index_entries
+WHERE
+  `index_id` = ?
+LIMIT
+  ?;
+
+-- name: DeleteTenantPayloadIndexEntries :exec
+DELETE FROM
+  payload_index_entries
+WHERE
+  `tenant` = ?;
+
+-- name: GetIndexedEvents :many
+SELECT
+  sqlc.embed(events)
+FROM
+  payload_index_entries
+  JOIN events ON events.`id` = payload_index_entries.`event_id`
+WHERE
+  payload_index_entries.`index_id` = sqlc.arg(index_id)
+  AND payload_index_entries.`value` = sqlc.arg(value)
+  AND payload_index_entries.`event_id` > sqlc.arg(id)
+  AND events.`tenant` = sqlc.arg(tenant)
+  AND events.`tombstone_id` = 0
+ORDER BY
+  payload_index_entries.`event_id`
+LIMIT
+  ?;
+
+-- name: GetEventsByTypeInRange :many
+SELECT
+  *
+FROM
+  events
+WHERE
+  `tenant` = sqlc.arg(tenant)
+  AND `type` = sqlc.arg(type)
+  AND `id` > sqlc.arg(after_id)
+  AND `id` <= sqlc.arg(until_id)
+ORDER BY
+  `id`;
+
+-- name: GetTombstoneCandidatesBySubject :many
+SELECT
+  `id`
+FROM
+  events
+WHERE
+  `tenant` = sqlc.arg(tenant)
+  AND `subject` = sqlc.arg(subject)
+  AND `id` > sqlc.arg(after_id)
+  AND `id` > sqlc.arg(archived_id)
+  AND `id` <= sqlc.arg(until_id)
+  AND `tombstone_id` = 0
+ORDER BY
+  `id`
+LIMIT
+  ?;
+
+-- name: GetTombstoneCandidatesBySubjectPrefix :many
+SELECT
+  `id`
+FROM
+  events
+WHERE
+  `tenant` = sqlc.arg(tenant)
+  AND `subject` LIKE sqlc.arg(pattern)
+  AND `id` > sqlc.arg(after_id)
+  AND `id` > sqlc.arg(archived_id)
+  AND `id` <= sqlc.arg(until_id)
+  AND `tombstone_id` = 0
+ORDER BY
+  `id`
+LIMIT
+  ?;
+
+-- name: GetEventBlobs :many
+SELECT DISTINCT
+  `blob_sha256`
+FROM
+  events
+WHERE
+  `tenant` = sqlc.arg(tenant)
+  AND `id` IN (sqlc.slice(ids))
+  AND `tombstone_id` = 0
+  AND `blob_sha256` != '';
+
+-- name: TombstoneEvents :execrows
+UPDATE
+  events e
+SET
+  e.`data` = '',
+  e.`data_key` = '',
+  e.`data_codec` = '',
+  e.`blob_sha256` = '',
+  e.`blob_size` = 0,
+  e.`metadata` = JSON_OBJECT(),
+  e.`tombstone_id` = sqlc.arg(tombstone_id),
+  e.`tombstoned_at` = NOW(6)
+WHERE
+  e.`tenant` = sqlc.arg(tenant)
+  AND e.`id` IN (sqlc.slice(ids))
+  AND e.`id` > (
+    SELECT
+      COALESCE(MAX(s.`last_id`), 0)
+    FROM
+      archive_segments s
+  )
+  AND e.`tombstone_id` = 0;
+
+-- name: DeletePayloadIndexEntriesByEvents :exec
+DELETE FROM
+  payload_index_entries
+WHERE
+  `event_id` IN (sqlc.slice(ids));
+
+-- name: PurgeTombstonedEvents :execrows
+DELETE FROM
+  events
+WHERE
+  `tombstone_id` = ?
+ORDER BY
+  `id`
+LIMIT
+  ?;
+
+-- name: CreateAuditEntry :execlastid
+INSERT INTO
+  event_audit (`tenant`, `action`, `event_id`, `subject`, `tombstone_id`, `event_count`, `actor`, `principal`, `remote_addr`, `reason`)
+VALUES
+  (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: GetAuditEntry :one
+SELECT
+  *
+FROM
+  event_audit
+WHERE
+  `id` = ?;
+
+-- name: ListAuditEntries :many
+SELECT
+  *
+FROM
+  event_audit
+WHERE
+  `tenant` = ?
+  AND `id` > ?
+ORDER BY
+  `id`
+LIMIT
+  ?;
+
+-- name: GetPurgeableTombstones :many
+SELECT
+  t.*
+FROM
+  event_audit t
+WHERE
+  t.`action` IN ('tombstone_event', 'tombstone_subject', 'tombstone_subtree')
+  AND t.`created_at` <= NOW(6) - INTERVAL sqlc.arg(grace_seconds) SECOND
+  AND NOT EXISTS (
+    SELECT
+      1
+    FROM
+      event_audit p
+    WHERE
+      p.`action` = 'purge'
+      AND p.`tombstone_id` = t.`id`
+  )
+ORDER BY
+  t.`id`
+LIMIT
+  ?;
@@ -1,11 +1,190 @@
 -- Create events table if it doesn't exist
 CREATE TABLE IF NOT EXISTS events (
     id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(64) NOT NULL DEFAULT 'default',
     source VARCHAR(255) NOT NULL,
     type VARCHAR(255) NOT NULL,
     subject VARCHAR(255) NOT NULL,
-    time DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    time DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
     data VARBINARY(60000) NOT NULL,
+    traceparent VARCHAR(55) NOT NULL DEFAULT '',
+    tracestate VARCHAR(512) NOT NULL DEFAULT '',
+    data_key VARCHAR(255) NOT NULL DEFAULT '',
+    data_codec VARCHAR(16) NOT NULL DEFAULT '',
+    blob_sha256 CHAR(64) NOT NULL DEFAULT '',
+    blob_size BIGINT NOT NULL DEFAULT 0,
+    datacontenttype VARCHAR(255) NOT NULL DEFAULT '',
+    schema_version INT NOT NULL DEFAULT 0,
+    metadata JSON NOT NULL DEFAULT (JSON_OBJECT()),
+    correlation_id VARCHAR(255) NOT NULL DEFAULT '',
+    causation_id VARCHAR(255) NOT NULL DEFAULT '',
+    occurred_at DATETIME(6) NULL,
+    tombstone_id BIGINT NOT NULL DEFAULT 0,
+    tombstoned_at DATETIME(6) NULL,
     INDEX idx_subject (subject),
-    INDEX idx_time (time)
-) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
\ No newline at end of file
+    INDEX idx_time (time),
+    INDEX idx_tenant_subject (tenant, subject, id),
+    INDEX idx_tenant_correlation (tenant, correlation_id, id),
+    INDEX idx_tombstone (tombstone_id),
+    INDEX idx_tenant_blob (tenant, blob_sha256)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Tenants isolate the events of the teams sharing a deployment
+CREATE TABLE IF NOT EXISTS tenants (
+    name VARCHAR(64) PRIMARY KEY,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+INSERT IGNORE INTO tenants (name) VALUES ('default');
+
+-- Tenant tokens authenticate clients of a single tenant, only their SHA-256 is stored
+CREATE TABLE IF NOT EXISTS tenant_tokens (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(64) NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX idx_token_hash (token_hash),
+    INDEX idx_tenant (tenant)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Archive segments hold events moved out of the events table, in ID order
+CREATE TABLE IF NOT EXISTS archive_segments (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    segment_key VARCHAR(255) NOT NULL,
+    first_id BIGINT NOT NULL,
+    last_id BIGINT NOT NULL,
+    event_count INT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    blobs_recorded BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX idx_segment_key (segment_key),
+    INDEX idx_last_id (last_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- The subjects stored in each archive segment, with the number and stored
+-- size of their events there, which count against the storage quotas.
+-- Segments written before the counts were added record zero.
+CREATE TABLE IF NOT EXISTS archive_segment_subjects (
+    segment_id BIGINT NOT NULL,
+    tenant VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    event_count BIGINT NOT NULL DEFAULT 0,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (tenant, subject, segment_id),
+    INDEX idx_segment (segment_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- The blobs referenced by the events of each archive segment, which keep
+-- them from being collected. Segments written before blobs_recorded was
+-- added have no rows here.
+CREATE TABLE IF NOT EXISTS archive_segment_blobs (
+    segment_id BIGINT NOT NULL,
+    tenant VARCHAR(64) NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    PRIMARY KEY (tenant, sha256, segment_id),
+    INDEX idx_segment (segment_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Data keys encrypt event payloads, wrapped by a master key. Erasing a key
+-- makes the payloads encrypted with it unreadable.
+CREATE TABLE IF NOT EXISTS data_keys (
+    tenant VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    wrapped_key VARBINARY(512) NOT NULL,
+    master_key_id VARCHAR(255) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    erased_at DATETIME NULL,
+    PRIMARY KEY (tenant, name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Blobs hold payloads too large for the data column, stored in the blob
+-- store under their SHA-256
+CREATE TABLE IF NOT EXISTS blobs (
+    tenant VARCHAR(64) NOT NULL,
+    sha256 CHAR(64) NOT NULL,
+    size BIGINT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    uploaded_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant, sha256)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Schemas holds the versioned payload schemas of each event type
+CREATE TABLE IF NOT EXISTS event_schemas (
+    tenant VARCHAR(64) NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    version INT NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    message VARCHAR(255) NOT NULL DEFAULT '',
+    definition MEDIUMBLOB NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant, type, version)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS event_upcasters (
+    tenant VARCHAR(64) NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    from_version INT NOT NULL,
+    operations BLOB NOT NULL,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant, type, from_version)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Imported events map the IDs of events in an import file to the stored
+-- events, so an interrupted import can be repeated without duplicates
+CREATE TABLE IF NOT EXISTS imported_events (
+    tenant VARCHAR(64) NOT NULL,
+    import_id VARCHAR(255) NOT NULL,
+    source_event_id VARCHAR(255) NOT NULL,
+    event_id BIGINT NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (tenant, import_id, source_event_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Payload indexes are secondary indexes on a JSON path of the payloads of
+-- one event type. Existing events are indexed in the background up to
+-- position, ready is set once they all are.
+CREATE TABLE IF NOT EXISTS payload_indexes (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(64) NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    type VARCHAR(255) NOT NULL,
+    path VARCHAR(255) NOT NULL,
+    position BIGINT NOT NULL DEFAULT 0,
+    ready BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE INDEX idx_tenant_name (tenant, name),
+    INDEX idx_tenant_type (tenant, type)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- The values of the indexed paths and the events holding them
+CREATE TABLE IF NOT EXISTS payload_index_entries (
+    index_id BIGINT NOT NULL,
+    value VARCHAR(255) NOT NULL,
+    event_id BIGINT NOT NULL,
+    tenant VARCHAR(64) NOT NULL,
+    PRIMARY KEY (index_id, value, event_id),
+    INDEX idx_tenant (tenant),
+    INDEX idx_event (event_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+-- The audit trail of removed events. Rows are only ever inserted, also when
+-- a tenant is deleted: tombstones record who removed which events and why,
+-- purges reference the tombstone whose events were deleted for good.
+CREATE TABLE IF NOT EXISTS event_audit (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    tenant VARCHAR(64) NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    event_id BIGINT NOT NULL DEFAULT 0,
+    subject VARCHAR(255) NOT NULL DEFAULT '',
+    tombstone_id BIGINT NOT NULL DEFAULT 0,
+    event_count BIGINT NOT NULL DEFAULT 0,
+    actor VARCHAR(255) NOT NULL DEFAULT '',
+    principal VARCHAR(255) NOT NULL DEFAULT '',
+    remote_addr VARCHAR(64) NOT NULL DEFAULT '',
+    reason VARCHAR(1024) NOT NULL DEFAULT '',
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_tenant (tenant, id),
+    INDEX idx_action (action, created_at),
+    INDEX idx_tombstone (tombstone_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;